@@ -0,0 +1,97 @@
+package eval
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/prashantgupta17/nlpromql/prometheus"
+)
+
+// valueTolerance is the relative difference below which two sample values
+// are equal, so that floating point noise, e.g. from summing in another
+// order, does not make equivalent queries differ.
+const valueTolerance = 1e-9
+
+// Sample is a series of an instant query result with its value.
+type Sample struct {
+	Labels map[string]string
+	Value  float64
+}
+
+// NewSamples reads the values of an instant query result.
+func NewSamples(metrics []prometheus.Metric) ([]Sample, error) {
+	samples := make([]Sample, len(metrics))
+	for i, metric := range metrics {
+		if len(metric.Value) != 2 {
+			return nil, fmt.Errorf("unexpected sample value %v", metric.Value)
+		}
+		text, ok := metric.Value[1].(string)
+		if !ok {
+			return nil, fmt.Errorf("unexpected sample value %v", metric.Value)
+		}
+		value, err := strconv.ParseFloat(text, 64)
+		if err != nil {
+			return nil, fmt.Errorf("error parsing sample value: %v", err)
+		}
+		samples[i] = Sample{Labels: metric.Metric, Value: value}
+	}
+	return samples, nil
+}
+
+// EqualResults reports whether two instant query results have the same
+// series with the same values, in any order. The metric name is ignored, as
+// Prometheus drops it from the results of most functions and operators
+// whether or not the queries mean the same; timestamps are ignored too.
+func EqualResults(a, b []Sample) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	a, b = sortedSamples(a), sortedSamples(b)
+	for i := range a {
+		if seriesKey(a[i].Labels) != seriesKey(b[i].Labels) || !equalValues(a[i].Value, b[i].Value) {
+			return false
+		}
+	}
+	return true
+}
+
+func sortedSamples(samples []Sample) []Sample {
+	sorted := append([]Sample(nil), samples...)
+	sort.Slice(sorted, func(i, j int) bool {
+		ki, kj := seriesKey(sorted[i].Labels), seriesKey(sorted[j].Labels)
+		if ki != kj {
+			return ki < kj
+		}
+		return sorted[i].Value < sorted[j].Value
+	})
+	return sorted
+}
+
+// seriesKey identifies a series by its labels other than the metric name.
+func seriesKey(labels map[string]string) string {
+	names := make([]string, 0, len(labels))
+	for name := range labels {
+		if name != "__name__" {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	var b strings.Builder
+	for _, name := range names {
+		b.WriteString(name + "=" + strconv.Quote(labels[name]) + ",")
+	}
+	return b.String()
+}
+
+func equalValues(a, b float64) bool {
+	if math.IsNaN(a) || math.IsNaN(b) {
+		return math.IsNaN(a) && math.IsNaN(b)
+	}
+	if a == b {
+		return true
+	}
+	return math.Abs(a-b) <= valueTolerance*math.Max(math.Abs(a), math.Abs(b))
+}
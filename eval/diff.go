@@ -0,0 +1,148 @@
+package eval
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// Statuses of a QuestionChange.
+const (
+	StatusImproved  = "improved"
+	StatusRegressed = "regressed"
+	StatusChanged   = "changed" // Better on some metrics and worse on others, or only the PromQL changed
+	StatusAdded     = "added"   // Not in the previous run
+	StatusRemoved   = "removed" // Not in the current run
+)
+
+// Diff compares an evaluation run with a previous one.
+type Diff struct {
+	Previous Summary          `json:"previous"`
+	Current  Summary          `json:"current"`
+	Changes  []QuestionChange `json:"changes"` // Questions whose results changed, in the order of the current run
+}
+
+// QuestionChange is how the result of a question changed between two runs.
+type QuestionChange struct {
+	ID       string          `json:"id"`
+	Query    string          `json:"query"`
+	Status   string          `json:"status"`
+	Changes  []string        `json:"changes,omitempty"` // Each field that changed, e.g. "valid: false -> true"
+	Previous *QuestionResult `json:"previous,omitempty"`
+	Current  *QuestionResult `json:"current,omitempty"`
+}
+
+// Compare returns the differences between the previous and current runs,
+// matching questions by ID.
+func Compare(previous, current *Report) *Diff {
+	diff := &Diff{Previous: previous.Summary, Current: current.Summary}
+	before := make(map[string]*QuestionResult, len(previous.Questions))
+	for i := range previous.Questions {
+		before[previous.Questions[i].ID] = &previous.Questions[i]
+	}
+	seen := make(map[string]bool, len(current.Questions))
+	for i := range current.Questions {
+		now := &current.Questions[i]
+		seen[now.ID] = true
+		was, ok := before[now.ID]
+		if !ok {
+			diff.Changes = append(diff.Changes, QuestionChange{ID: now.ID, Query: now.Query, Status: StatusAdded, Current: now})
+			continue
+		}
+		if change, changed := compareResults(was, now); changed {
+			diff.Changes = append(diff.Changes, change)
+		}
+	}
+	for i := range previous.Questions {
+		was := &previous.Questions[i]
+		if !seen[was.ID] {
+			diff.Changes = append(diff.Changes, QuestionChange{ID: was.ID, Query: was.Query, Status: StatusRemoved, Previous: was})
+		}
+	}
+	return diff
+}
+
+// compareResults describes the changes from was to now, and whether there
+// are any.
+func compareResults(was, now *QuestionResult) (QuestionChange, bool) {
+	change := QuestionChange{ID: now.ID, Query: now.Query, Previous: was, Current: now}
+	better, worse := 0, 0
+	compare := func(name string, before, after float64, beforeText, afterText string) {
+		if before == after {
+			return
+		}
+		change.Changes = append(change.Changes, fmt.Sprintf("%s: %s -> %s", name, beforeText, afterText))
+		if after > before {
+			better++
+		} else {
+			worse++
+		}
+	}
+	compareRecall := func(name string, before, after float64) {
+		compare(name, before, after, fmt.Sprintf("%.2f", before), fmt.Sprintf("%.2f", after))
+	}
+	compareBool := func(name string, before, after bool) {
+		compare(name, boolScore(before), boolScore(after), strconv.FormatBool(before), strconv.FormatBool(after))
+	}
+	compareRecall("metric_recall", was.MetricRecall, now.MetricRecall)
+	compareRecall("retrieval_recall", was.RetrievalRecall, now.RetrievalRecall)
+	compareBool("exact_match", was.ExactMatch, now.ExactMatch)
+	compareBool("valid", was.Valid, now.Valid)
+	if was.Equivalent != nil && now.Equivalent != nil {
+		compareBool("equivalent", *was.Equivalent, *now.Equivalent)
+	}
+	if was.PromQL != now.PromQL {
+		change.Changes = append(change.Changes, fmt.Sprintf("promql: %q -> %q", was.PromQL, now.PromQL))
+	}
+
+	switch {
+	case len(change.Changes) == 0:
+		return change, false
+	case better > 0 && worse == 0:
+		change.Status = StatusImproved
+	case worse > 0 && better == 0:
+		change.Status = StatusRegressed
+	default:
+		change.Status = StatusChanged
+	}
+	return change, true
+}
+
+func boolScore(b bool) float64 {
+	if b {
+		return 1
+	}
+	return 0
+}
+
+// Save writes the diff to path as JSON.
+func (d *Diff) Save(path string) error {
+	return saveJSON(path, d)
+}
+
+// String summarizes the diff: the aggregate metrics before and after, and
+// the questions that improved or regressed.
+func (d *Diff) String() string {
+	var b strings.Builder
+	row := func(name string, before, after float64) {
+		fmt.Fprintf(&b, "  %-17s %6.3f -> %6.3f (%+.3f)\n", name, before, after, after-before)
+	}
+	fmt.Fprintf(&b, "  %-17s %6d -> %6d\n", "questions", d.Previous.Questions, d.Current.Questions)
+	row("metric_recall", d.Previous.MetricRecall, d.Current.MetricRecall)
+	row("retrieval_recall", d.Previous.RetrievalRecall, d.Current.RetrievalRecall)
+	row("exact_match", d.Previous.ExactMatch, d.Current.ExactMatch)
+	row("valid", d.Previous.Valid, d.Current.Valid)
+	row("equivalent", d.Previous.Equivalent, d.Current.Equivalent)
+	counts := make(map[string]int)
+	for _, change := range d.Changes {
+		counts[change.Status]++
+	}
+	fmt.Fprintf(&b, "  %d improved, %d regressed, %d changed, %d added, %d removed\n", counts[StatusImproved],
+		counts[StatusRegressed], counts[StatusChanged], counts[StatusAdded], counts[StatusRemoved])
+	for _, change := range d.Changes {
+		if change.Status == StatusImproved || change.Status == StatusRegressed {
+			fmt.Fprintf(&b, "  %s %s %q: %s\n", change.Status, change.ID, change.Query, strings.Join(change.Changes, ", "))
+		}
+	}
+	return b.String()
+}
@@ -0,0 +1,59 @@
+package eval_test
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/prashantgupta17/nlpromql/eval"
+)
+
+func TestCompare(t *testing.T) {
+	yes, no := true, false
+	previous := &eval.Report{
+		Summary: eval.Summary{Questions: 4, Valid: 0.5},
+		Questions: []eval.QuestionResult{
+			{ID: "a", Query: "qa", PromQL: "up", MetricRecall: 1, Valid: true, Equivalent: &no},
+			{ID: "b", Query: "qb", PromQL: "up", MetricRecall: 1, Valid: true},
+			{ID: "c", Query: "qc", PromQL: "x", MetricRecall: 0.5, Valid: true},
+			{ID: "gone", Query: "qg"},
+		},
+	}
+	current := &eval.Report{
+		Summary: eval.Summary{Questions: 4, Valid: 0.75},
+		Questions: []eval.QuestionResult{
+			{ID: "a", Query: "qa", PromQL: "up", MetricRecall: 1, Valid: true, Equivalent: &yes},
+			{ID: "b", Query: "qb", PromQL: "up", MetricRecall: 1, Valid: true},
+			{ID: "c", Query: "qc", PromQL: "y", MetricRecall: 1, Valid: false, Error: "invalid"},
+			{ID: "new", Query: "qn"},
+		},
+	}
+
+	diff := eval.Compare(previous, current)
+	type change struct {
+		ID, Status string
+		Changes    []string
+	}
+	var got []change
+	for _, c := range diff.Changes {
+		got = append(got, change{c.ID, c.Status, c.Changes})
+	}
+	expected := []change{
+		{"a", eval.StatusImproved, []string{"equivalent: false -> true"}},
+		{"c", eval.StatusChanged, []string{"metric_recall: 0.50 -> 1.00", "valid: true -> false", `promql: "x" -> "y"`}},
+		{"new", eval.StatusAdded, nil},
+		{"gone", eval.StatusRemoved, nil},
+	}
+	if !reflect.DeepEqual(got, expected) {
+		t.Errorf("changes %+v, expected %+v", got, expected)
+	}
+	if diff.Previous.Valid != 0.5 || diff.Current.Valid != 0.75 {
+		t.Errorf("expected both summaries in the diff, got %+v", diff)
+	}
+	text := diff.String()
+	for _, want := range []string{"valid              0.500 ->  0.750 (+0.250)", "1 improved, 0 regressed, 1 changed, 1 added, 1 removed", `improved a "qa"`} {
+		if !strings.Contains(text, want) {
+			t.Errorf("expected %q in the diff text:\n%s", want, text)
+		}
+	}
+}
@@ -0,0 +1,298 @@
+package eval
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"sort"
+	"time"
+
+	"github.com/prashantgupta17/nlpromql/info_structure"
+	"github.com/prashantgupta17/nlpromql/promql"
+	"github.com/prashantgupta17/nlpromql/query_processing"
+)
+
+// Runner runs the pipeline for a question; *query_processing.Pipeline
+// implements it.
+type Runner interface {
+	Run(ctx context.Context, userQuery string, progress query_processing.ProgressFunc) (*query_processing.Result, error)
+}
+
+// Evaluator runs questions through a Runner and scores the top candidate of
+// each against the expected answer.
+type Evaluator struct {
+	Runner Runner
+	// QueryEngine, if not nil, is a fixture Prometheus with a known dataset.
+	// Generated queries must run on it to be valid, and their results are
+	// compared with those of the expected queries.
+	QueryEngine info_structure.QueryEngine
+	// Progress, if not nil, is called with each question's result as soon as
+	// it is scored.
+	Progress func(result QuestionResult)
+}
+
+// QuestionResult is how well the pipeline answered a question.
+type QuestionResult struct {
+	ID              string   `json:"id"`
+	Query           string   `json:"query"`
+	Expected        string   `json:"expected,omitempty"` // Expected PromQL
+	ExpectedMetrics []string `json:"expected_metrics"`
+	PromQL          string   `json:"promql"` // Top candidate; empty if there is none
+	Metrics         []string `json:"metrics"`
+	Retrieved       []string `json:"retrieved"` // Metrics retrieved for generation, most relevant first
+
+	// MetricRecall and RetrievalRecall are the fractions of the expected
+	// metrics that the top candidate uses, and that were retrieved.
+	MetricRecall    float64 `json:"metric_recall"`
+	RetrievalRecall float64 `json:"retrieval_recall"`
+	// ExactMatch is set if the top candidate is the expected PromQL, once
+	// both are normalized (see promql.Normalize). Otherwise Diff lists where
+	// the expected PromQL (a) and the top candidate (b) differ.
+	ExactMatch bool                `json:"exact_match"`
+	Diff       []promql.Difference `json:"diff,omitempty"`
+	// Valid is set if the top candidate parses and, with a QueryEngine, runs.
+	Valid bool `json:"valid"`
+	// Equivalent is set if the top candidate returns the same result as the
+	// expected PromQL on the QueryEngine. It is nil when they were not
+	// compared: without an expected PromQL or a QueryEngine, or if the
+	// expected PromQL failed.
+	Equivalent *bool `json:"equivalent,omitempty"`
+
+	Failed bool   `json:"failed,omitempty"` // The pipeline returned an error
+	Error  string `json:"error,omitempty"`  // Why the pipeline, the top candidate or the comparison failed
+}
+
+// Summary aggregates the results of all questions. Recalls are means over
+// the questions, and the other metrics fractions of them; Equivalent is over
+// the Compared questions only. A question the pipeline failed on scores 0.
+type Summary struct {
+	Questions       int     `json:"questions"`
+	Failed          int     `json:"failed"` // Questions the pipeline returned an error for
+	MetricRecall    float64 `json:"metric_recall"`
+	RetrievalRecall float64 `json:"retrieval_recall"`
+	ExactMatch      float64 `json:"exact_match"`
+	Valid           float64 `json:"valid"`
+	Compared        int     `json:"compared"`
+	Equivalent      float64 `json:"equivalent"`
+}
+
+// Report is the outcome of an evaluation run.
+type Report struct {
+	StartTime time.Time        `json:"start_time"`
+	Duration  time.Duration    `json:"duration"`
+	Summary   Summary          `json:"summary"`
+	Questions []QuestionResult `json:"questions"`
+}
+
+// Evaluate runs every question, in order, and reports the results. A
+// question the pipeline fails on is reported with its error; only a canceled
+// ctx stops the run.
+func (e *Evaluator) Evaluate(ctx context.Context, questions []Question) (*Report, error) {
+	report := &Report{StartTime: time.Now()}
+	for _, question := range questions {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		result := e.evaluate(ctx, question)
+		if e.Progress != nil {
+			e.Progress(result)
+		}
+		report.Questions = append(report.Questions, result)
+	}
+	report.Duration = time.Since(report.StartTime)
+	report.Summary = summarize(report.Questions)
+	return report, nil
+}
+
+// evaluate runs and scores one question.
+func (e *Evaluator) evaluate(ctx context.Context, question Question) QuestionResult {
+	result := QuestionResult{
+		ID:              question.ID,
+		Query:           question.Query,
+		Expected:        question.PromQL,
+		ExpectedMetrics: question.Metrics,
+	}
+	run, err := e.Runner.Run(ctx, question.Query, nil)
+	if err != nil {
+		result.Failed = true
+		result.Error = fmt.Sprintf("error running pipeline: %v", err)
+		e.unanswered(question, &result)
+		return result
+	}
+	for _, item := range run.Ranking.Metrics {
+		result.Retrieved = append(result.Retrieved, item.Name)
+	}
+	result.RetrievalRecall = recall(question.Metrics, result.Retrieved)
+	if queries := run.PromQL.Queries(); len(queries) > 0 {
+		result.PromQL = queries[0]
+	}
+	if result.PromQL == "" {
+		result.Error = "no candidates generated"
+		e.unanswered(question, &result)
+		return result
+	}
+
+	expr, err := promql.Parse(result.PromQL)
+	if err != nil {
+		result.Error = fmt.Sprintf("invalid candidate: %v", err)
+	} else {
+		result.Metrics = sortedKeys(promql.MetricLabels(expr))
+		result.MetricRecall = recall(question.Metrics, result.Metrics)
+		if question.PromQL != "" {
+			result.Diff = diffExpected(expr, question.PromQL)
+			result.ExactMatch = len(result.Diff) == 0
+		}
+		result.Valid = true
+	}
+	if e.QueryEngine == nil {
+		return result
+	}
+
+	var got []Sample
+	if result.Valid {
+		got, err = e.query(result.PromQL)
+		if err != nil {
+			result.Valid = false
+			result.Error = fmt.Sprintf("invalid candidate: %v", err)
+		}
+	}
+	if question.PromQL == "" {
+		return result
+	}
+	want, err := e.query(question.PromQL)
+	if err != nil {
+		if result.Error == "" {
+			result.Error = fmt.Sprintf("error running expected promql: %v", err)
+		}
+		return result
+	}
+	equivalent := result.Valid && EqualResults(got, want)
+	result.Equivalent = &equivalent
+	return result
+}
+
+// unanswered marks a question without a candidate as not equivalent, if it
+// would have been compared.
+func (e *Evaluator) unanswered(question Question, result *QuestionResult) {
+	if question.PromQL != "" && e.QueryEngine != nil {
+		result.Equivalent = new(bool)
+	}
+}
+
+// query runs query on the QueryEngine.
+func (e *Evaluator) query(query string) ([]Sample, error) {
+	metrics, err := e.QueryEngine.CustomQuery(query)
+	if err != nil {
+		return nil, err
+	}
+	return NewSamples(metrics)
+}
+
+// diffExpected returns where expr differs from the expected PromQL, which
+// LoadQuestions has checked parses.
+func diffExpected(expr promql.Expr, expected string) []promql.Difference {
+	expectedExpr, err := promql.Parse(expected)
+	if err != nil {
+		return []promql.Difference{{A: expected, B: expr.String()}}
+	}
+	return promql.Diff(expectedExpr, expr)
+}
+
+// recall returns the fraction of expected that is in got; 1 if nothing is
+// expected.
+func recall(expected, got []string) float64 {
+	if len(expected) == 0 {
+		return 1
+	}
+	found := 0
+	for _, metric := range expected {
+		for _, g := range got {
+			if g == metric {
+				found++
+				break
+			}
+		}
+	}
+	return float64(found) / float64(len(expected))
+}
+
+func summarize(results []QuestionResult) Summary {
+	summary := Summary{Questions: len(results)}
+	if len(results) == 0 {
+		return summary
+	}
+	equivalent := 0
+	for _, r := range results {
+		if r.Failed {
+			summary.Failed++
+		}
+		summary.MetricRecall += r.MetricRecall
+		summary.RetrievalRecall += r.RetrievalRecall
+		if r.ExactMatch {
+			summary.ExactMatch++
+		}
+		if r.Valid {
+			summary.Valid++
+		}
+		if r.Equivalent != nil {
+			summary.Compared++
+			if *r.Equivalent {
+				equivalent++
+			}
+		}
+	}
+	n := float64(len(results))
+	summary.MetricRecall /= n
+	summary.RetrievalRecall /= n
+	summary.ExactMatch /= n
+	summary.Valid /= n
+	if summary.Compared > 0 {
+		summary.Equivalent = float64(equivalent) / float64(summary.Compared)
+	}
+	return summary
+}
+
+func (s Summary) String() string {
+	return fmt.Sprintf("%d questions (%d failed): metric recall %.3f, retrieval recall %.3f, exact match %.3f, valid %.3f, equivalent %.3f of %d compared",
+		s.Questions, s.Failed, s.MetricRecall, s.RetrievalRecall, s.ExactMatch, s.Valid, s.Equivalent, s.Compared)
+}
+
+// LoadReport reads a report saved by Save.
+func LoadReport(path string) (*Report, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("error reading report: %v", err)
+	}
+	var report Report
+	if err := json.Unmarshal(data, &report); err != nil {
+		return nil, fmt.Errorf("error decoding report %s: %v", path, err)
+	}
+	return &report, nil
+}
+
+// Save writes the report to path as JSON.
+func (r *Report) Save(path string) error {
+	return saveJSON(path, r)
+}
+
+func saveJSON(path string, data interface{}) error {
+	encoded, err := json.MarshalIndent(data, "", "  ")
+	if err != nil {
+		return fmt.Errorf("error marshalling %s: %v", path, err)
+	}
+	if err := os.WriteFile(path, encoded, 0644); err != nil {
+		return fmt.Errorf("error writing %s: %v", path, err)
+	}
+	return nil
+}
+
+// sortedKeys returns the keys of m in order.
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
@@ -0,0 +1,232 @@
+package eval_test
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/prashantgupta17/nlpromql/eval"
+	"github.com/prashantgupta17/nlpromql/llm"
+	"github.com/prashantgupta17/nlpromql/prometheus"
+	"github.com/prashantgupta17/nlpromql/query_processing"
+)
+
+// MockRunner_EvalTest answers each query with its candidates, after
+// retrieving its metrics.
+type MockRunner_EvalTest struct {
+	Candidates map[string][]string
+	Retrieved  map[string][]string
+}
+
+func (m *MockRunner_EvalTest) Run(ctx context.Context, userQuery string, progress query_processing.ProgressFunc) (*query_processing.Result, error) {
+	candidates, ok := m.Candidates[userQuery]
+	if !ok {
+		return nil, errors.New("rate limited")
+	}
+	result := &query_processing.Result{PromQL: &llm.PromQLResult{}}
+	for _, metric := range m.Retrieved[userQuery] {
+		result.Ranking.Metrics = append(result.Ranking.Metrics, query_processing.RankedItem{Name: metric})
+	}
+	for _, candidate := range candidates {
+		result.PromQL.Candidates = append(result.PromQL.Candidates, llm.PromQLCandidate{PromQL: candidate})
+	}
+	return result, nil
+}
+
+// MockQueryEngine_EvalTest is a fixture Prometheus answering known queries.
+type MockQueryEngine_EvalTest struct {
+	Results map[string][]prometheus.Metric
+}
+
+func (m *MockQueryEngine_EvalTest) AllMetrics() ([]string, error)           { return nil, nil }
+func (m *MockQueryEngine_EvalTest) AllLabels() ([]string, error)            { return nil, nil }
+func (m *MockQueryEngine_EvalTest) AllMetadata() (map[string]string, error) { return nil, nil }
+
+func (m *MockQueryEngine_EvalTest) CustomQuery(query string) ([]prometheus.Metric, error) {
+	result, ok := m.Results[query]
+	if !ok {
+		return nil, &prometheus.APIError{Type: "bad_data", Message: "unknown query " + query}
+	}
+	return result, nil
+}
+
+func sample(value string, labels ...string) prometheus.Metric {
+	metric := prometheus.Metric{Metric: map[string]string{}, Value: []interface{}{1700000000.0, value}}
+	for i := 0; i+1 < len(labels); i += 2 {
+		metric.Metric[labels[i]] = labels[i+1]
+	}
+	return metric
+}
+
+func TestLoadQuestions(t *testing.T) {
+	tests := []struct {
+		name     string
+		content  string
+		expected []eval.Question
+		err      string
+	}{
+		{
+			name: "Expected metrics from PromQL",
+			content: `{"id": "rate", "query": "request rate by job", "promql": "sum by (job) (rate(http_requests_total[5m]))"}
+
+{"query": "memory", "metrics": ["node_memory_bytes"]}`,
+			expected: []eval.Question{
+				{ID: "rate", Query: "request rate by job", PromQL: "sum by (job) (rate(http_requests_total[5m]))", Metrics: []string{"http_requests_total"}},
+				{ID: "3", Query: "memory", Metrics: []string{"node_memory_bytes"}},
+			},
+		},
+		{name: "No expectation", content: `{"query": "memory"}`, err: "line 1"},
+		{name: "Invalid PromQL", content: `{"query": "memory", "promql": "sum("}`, err: "expected promql"},
+		{name: "Repeated ID", content: "{\"id\": \"a\", \"query\": \"x\", \"metrics\": [\"up\"]}\n{\"id\": \"a\", \"query\": \"y\", \"metrics\": [\"up\"]}", err: "already used on line 1"},
+		{name: "Not JSON", content: `query: memory`, err: "decoding"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "questions.jsonl")
+			if err := os.WriteFile(path, []byte(tt.content), 0644); err != nil {
+				t.Fatal(err)
+			}
+			questions, err := eval.LoadQuestions(path)
+			if tt.err != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.err) {
+					t.Errorf("expected an error containing %q, got %v", tt.err, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("LoadQuestions returned an unexpected error: %v", err)
+			}
+			if !reflect.DeepEqual(questions, tt.expected) {
+				t.Errorf("LoadQuestions = %+v, expected %+v", questions, tt.expected)
+			}
+		})
+	}
+}
+
+func TestEvaluator_Evaluate(t *testing.T) {
+	expected := "sum by (job) (rate(http_requests_total[5m]))"
+	questions := []eval.Question{
+		{ID: "exact", Query: "q1", PromQL: expected, Metrics: []string{"http_requests_total"}},
+		{ID: "equivalent", Query: "q2", PromQL: expected, Metrics: []string{"http_requests_total"}},
+		{ID: "wrong", Query: "q3", PromQL: expected, Metrics: []string{"http_requests_total"}},
+		{ID: "invalid", Query: "q4", Metrics: []string{"http_requests_total", "up"}},
+		{ID: "failed", Query: "q5", PromQL: expected, Metrics: []string{"http_requests_total"}},
+	}
+	runner := &MockRunner_EvalTest{
+		Candidates: map[string][]string{
+			"q1": {"sum((rate(http_requests_total[5m]))) by (job)", "up"},
+			"q2": {"sum without (instance) (rate(http_requests_total[5m]))"},
+			"q3": {"sum by (job) (http_requests_total)"},
+			"q4": {"sum(up) by (job"},
+		},
+		Retrieved: map[string][]string{"q1": {"http_requests_total"}, "q4": {"http_requests_total", "node_load1"}},
+	}
+	byJob := []prometheus.Metric{sample("2", "job", "api"), sample("1.5", "job", "web")}
+	engine := &MockQueryEngine_EvalTest{Results: map[string][]prometheus.Metric{
+		expected: byJob,
+		"sum((rate(http_requests_total[5m]))) by (job)":          byJob,
+		"sum without (instance) (rate(http_requests_total[5m]))": {byJob[1], byJob[0]},
+		"sum by (job) (http_requests_total)":                     {sample("200", "job", "api"), sample("150", "job", "web")},
+	}}
+	var progress []string
+	evaluator := &eval.Evaluator{Runner: runner, QueryEngine: engine, Progress: func(r eval.QuestionResult) {
+		progress = append(progress, r.ID)
+	}}
+
+	report, err := evaluator.Evaluate(context.Background(), questions)
+	if err != nil {
+		t.Fatalf("Evaluate returned an unexpected error: %v", err)
+	}
+	yes, no := true, false
+	tests := []struct {
+		id              string
+		metricRecall    float64
+		retrievalRecall float64
+		exact, valid    bool
+		equivalent      *bool
+		err             string
+		diff            []string
+	}{
+		{"exact", 1, 1, true, true, &yes, "", nil},
+		{"equivalent", 1, 0, false, true, &yes, "", []string{"grouping: by (job) -> without (instance)"}},
+		{"wrong", 1, 0, false, true, &no, "", []string{"expr: rate(http_requests_total[5m]) -> http_requests_total"}},
+		{"invalid", 0, 0.5, false, false, nil, "invalid candidate", nil},
+		{"failed", 0, 0, false, false, &no, "rate limited", nil},
+	}
+	for i, tt := range tests {
+		t.Run(tt.id, func(t *testing.T) {
+			got := report.Questions[i]
+			if got.ID != tt.id || got.MetricRecall != tt.metricRecall || got.RetrievalRecall != tt.retrievalRecall ||
+				got.ExactMatch != tt.exact || got.Valid != tt.valid || !reflect.DeepEqual(got.Equivalent, tt.equivalent) {
+				t.Errorf("unexpected result %+v", got)
+			}
+			if !strings.Contains(got.Error, tt.err) || (tt.err == "") != (got.Error == "") {
+				t.Errorf("expected an error containing %q, got %q", tt.err, got.Error)
+			}
+			var diff []string
+			for _, d := range got.Diff {
+				diff = append(diff, d.String())
+			}
+			if !reflect.DeepEqual(diff, tt.diff) {
+				t.Errorf("diff %q, expected %q", diff, tt.diff)
+			}
+		})
+	}
+
+	expectedSummary := eval.Summary{
+		Questions: 5, Failed: 1, MetricRecall: 0.6, RetrievalRecall: 0.3, ExactMatch: 0.2, Valid: 0.6, Compared: 4, Equivalent: 0.5,
+	}
+	if report.Summary != expectedSummary {
+		t.Errorf("summary %+v, expected %+v", report.Summary, expectedSummary)
+	}
+	if !reflect.DeepEqual(progress, []string{"exact", "equivalent", "wrong", "invalid", "failed"}) {
+		t.Errorf("expected progress for each question in order, got %v", progress)
+	}
+
+	path := filepath.Join(t.TempDir(), "report.json")
+	if err := report.Save(path); err != nil {
+		t.Fatalf("Save returned an unexpected error: %v", err)
+	}
+	loaded, err := eval.LoadReport(path)
+	if err != nil {
+		t.Fatalf("LoadReport returned an unexpected error: %v", err)
+	}
+	if !reflect.DeepEqual(loaded.Questions, report.Questions) || loaded.Summary != report.Summary {
+		t.Errorf("loaded report %+v, expected %+v", loaded, report)
+	}
+}
+
+func TestEqualResults(t *testing.T) {
+	tests := []struct {
+		name     string
+		a, b     []prometheus.Metric
+		expected bool
+	}{
+		{"Any order", []prometheus.Metric{sample("1", "job", "a"), sample("2", "job", "b")}, []prometheus.Metric{sample("2", "job", "b"), sample("1", "job", "a")}, true},
+		{"Metric name ignored", []prometheus.Metric{sample("1", "__name__", "up", "job", "a")}, []prometheus.Metric{sample("1", "job", "a")}, true},
+		{"Rounding", []prometheus.Metric{sample("0.30000000000000004")}, []prometheus.Metric{sample("0.3")}, true},
+		{"NaN", []prometheus.Metric{sample("NaN")}, []prometheus.Metric{sample("NaN")}, true},
+		{"Different value", []prometheus.Metric{sample("1", "job", "a")}, []prometheus.Metric{sample("1.1", "job", "a")}, false},
+		{"Different labels", []prometheus.Metric{sample("1", "job", "a")}, []prometheus.Metric{sample("1", "job", "a", "instance", "x")}, false},
+		{"Different series count", []prometheus.Metric{sample("1")}, nil, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			a, err := eval.NewSamples(tt.a)
+			if err != nil {
+				t.Fatal(err)
+			}
+			b, err := eval.NewSamples(tt.b)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := eval.EqualResults(a, b); got != tt.expected {
+				t.Errorf("EqualResults() = %v, expected %v", got, tt.expected)
+			}
+		})
+	}
+}
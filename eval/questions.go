@@ -0,0 +1,87 @@
+// Package eval measures how well the pipeline answers a labeled set of
+// questions, so that prompt, model and retrieval changes can be compared run
+// against run.
+package eval
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/prashantgupta17/nlpromql/promql"
+)
+
+// Question is a natural language question with the answer expected from the
+// pipeline: the PromQL, or only the metrics it should use.
+type Question struct {
+	ID      string   `json:"id,omitempty"`      // Identifies the question across runs; its line number when empty
+	Query   string   `json:"query"`             // The natural language question
+	PromQL  string   `json:"promql,omitempty"`  // Expected PromQL
+	Metrics []string `json:"metrics,omitempty"` // Expected metrics; those of PromQL when empty
+}
+
+// LoadQuestions reads a JSONL file of questions, one JSON object per line.
+// Blank lines are skipped. Every question needs a query and an expected
+// PromQL or metrics, and IDs must not repeat.
+func LoadQuestions(path string) ([]Question, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("error opening questions file: %v", err)
+	}
+	defer file.Close()
+
+	var questions []Question
+	ids := make(map[string]int)
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(make([]byte, 0, 64*1024), 1024*1024)
+	for line := 1; scanner.Scan(); line++ {
+		text := strings.TrimSpace(scanner.Text())
+		if text == "" {
+			continue
+		}
+		var question Question
+		if err := json.Unmarshal([]byte(text), &question); err != nil {
+			return nil, fmt.Errorf("error decoding question on line %d: %v", line, err)
+		}
+		if question.ID == "" {
+			question.ID = strconv.Itoa(line)
+		}
+		if err := question.expect(); err != nil {
+			return nil, fmt.Errorf("invalid question on line %d: %v", line, err)
+		}
+		if previous, ok := ids[question.ID]; ok {
+			return nil, fmt.Errorf("invalid question on line %d: id %q is already used on line %d", line, question.ID, previous)
+		}
+		ids[question.ID] = line
+		questions = append(questions, question)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("error reading questions file: %v", err)
+	}
+	return questions, nil
+}
+
+// expect validates q and fills in its expected metrics from its PromQL.
+func (q *Question) expect() error {
+	if strings.TrimSpace(q.Query) == "" {
+		return errors.New("query is empty")
+	}
+	if q.PromQL == "" {
+		if len(q.Metrics) == 0 {
+			return errors.New("neither promql nor metrics is set")
+		}
+		return nil
+	}
+	expr, err := promql.Parse(q.PromQL)
+	if err != nil {
+		return fmt.Errorf("error parsing expected promql: %v", err)
+	}
+	if len(q.Metrics) == 0 {
+		q.Metrics = sortedKeys(promql.MetricLabels(expr))
+	}
+	return nil
+}
@@ -0,0 +1,201 @@
+// Package examples keeps curated pairs of natural language questions and the
+// PromQL that answers them. The most similar pairs are shown to the LLM as
+// few-shot examples when generating PromQL.
+package examples
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"unicode"
+)
+
+// addedFile is the file of the store directory that examples added through
+// Add are written to.
+const addedFile = "added.json"
+
+// Example is a natural language question and the PromQL that answers it.
+type Example struct {
+	Query  string `json:"query"`
+	PromQL string `json:"promql"`
+}
+
+// Store holds examples loaded from a directory of JSON files, each containing
+// an array of examples. It is safe for concurrent use.
+type Store struct {
+	dir string // Empty for an in-memory store
+
+	mu       sync.RWMutex
+	examples []Example
+	tokens   []map[string]bool // Tokens of examples[i].Query
+	added    []Example         // Examples persisted in addedFile
+}
+
+// NewStore loads every *.json file in dir. Examples added later are written
+// to dir/added.json. An empty dir creates a store that is only kept in memory.
+func NewStore(dir string) (*Store, error) {
+	s := &Store{dir: dir}
+	if dir == "" {
+		return s, nil
+	}
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, fmt.Errorf("error creating examples directory: %v", err)
+	}
+
+	paths, err := filepath.Glob(filepath.Join(dir, "*.json"))
+	if err != nil {
+		return nil, fmt.Errorf("error listing example files: %v", err)
+	}
+	sort.Strings(paths)
+	for _, path := range paths {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return nil, fmt.Errorf("error reading example file: %v", err)
+		}
+		var examples []Example
+		if err := json.Unmarshal(data, &examples); err != nil {
+			return nil, fmt.Errorf("error decoding example file %s: %v", path, err)
+		}
+		for _, example := range examples {
+			if err := validate(example); err != nil {
+				return nil, fmt.Errorf("invalid example in %s: %v", path, err)
+			}
+			s.insert(example)
+		}
+		if filepath.Base(path) == addedFile {
+			s.added = examples
+		}
+	}
+	return s, nil
+}
+
+func validate(example Example) error {
+	if strings.TrimSpace(example.Query) == "" {
+		return errors.New("query is empty")
+	}
+	if strings.TrimSpace(example.PromQL) == "" {
+		return errors.New("promql is empty")
+	}
+	return nil
+}
+
+// insert adds example unless an identical one exists, and reports whether it was added.
+func (s *Store) insert(example Example) bool {
+	for _, existing := range s.examples {
+		if existing == example {
+			return false
+		}
+	}
+	s.examples = append(s.examples, example)
+	s.tokens = append(s.tokens, tokenSet(example.Query))
+	return true
+}
+
+// Add adds an example and persists it to the store directory. Adding an
+// example that already exists has no effect.
+func (s *Store) Add(example Example) error {
+	example.Query = strings.TrimSpace(example.Query)
+	example.PromQL = strings.TrimSpace(example.PromQL)
+	if err := validate(example); err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if !s.insert(example) {
+		return nil
+	}
+	if s.dir == "" {
+		return nil
+	}
+
+	added := append(append([]Example{}, s.added...), example)
+	data, err := json.MarshalIndent(added, "", "  ")
+	if err != nil {
+		return fmt.Errorf("error marshalling examples: %v", err)
+	}
+	path := filepath.Join(s.dir, addedFile)
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0644); err != nil {
+		return fmt.Errorf("error writing examples: %v", err)
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		return fmt.Errorf("error writing examples: %v", err)
+	}
+	s.added = added
+	return nil
+}
+
+// All returns a copy of every example in the store.
+func (s *Store) All() []Example {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return append([]Example{}, s.examples...)
+}
+
+// Similar returns up to k examples whose questions share the most words with
+// query, most similar first. Similarity is the Jaccard index of the word sets;
+// examples sharing no words are never returned.
+func (s *Store) Similar(query string, k int) []Example {
+	queryTokens := tokenSet(query)
+	if k <= 0 || len(queryTokens) == 0 {
+		return nil
+	}
+
+	type scored struct {
+		index int
+		score float64
+	}
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	var matches []scored
+	for i, tokens := range s.tokens {
+		common := 0
+		for token := range queryTokens {
+			if tokens[token] {
+				common++
+			}
+		}
+		if common == 0 {
+			continue
+		}
+		union := len(queryTokens) + len(tokens) - common
+		matches = append(matches, scored{i, float64(common) / float64(union)})
+	}
+	sort.SliceStable(matches, func(i, j int) bool { return matches[i].score > matches[j].score })
+
+	if len(matches) > k {
+		matches = matches[:k]
+	}
+	similar := make([]Example, len(matches))
+	for i, match := range matches {
+		similar[i] = s.examples[match.index]
+	}
+	return similar
+}
+
+// stopWords are left out of similarity, as nearly every question contains some.
+var stopWords = map[string]bool{
+	"a": true, "an": true, "and": true, "are": true, "by": true, "for": true, "from": true,
+	"how": true, "in": true, "is": true, "it": true, "me": true, "of": true, "on": true,
+	"per": true, "show": true, "the": true, "to": true, "what": true, "which": true, "with": true,
+}
+
+// tokenSet splits text into lower-case words on anything that is not a letter
+// or digit, so "http_requests" and "HTTP requests" share both words.
+func tokenSet(text string) map[string]bool {
+	tokens := make(map[string]bool)
+	for _, word := range strings.FieldsFunc(strings.ToLower(text), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	}) {
+		if !stopWords[word] {
+			tokens[word] = true
+		}
+	}
+	return tokens
+}
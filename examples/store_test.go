@@ -0,0 +1,83 @@
+package examples_test
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/prashantgupta17/nlpromql/examples"
+)
+
+func TestStore_Similar(t *testing.T) {
+	dir := t.TempDir()
+	data := `[
+  {"query": "HTTP request rate by job", "promql": "sum by (job) (rate(http_requests_total[5m]))"},
+  {"query": "memory usage of each pod", "promql": "sum by (pod) (container_memory_working_set_bytes)"},
+  {"query": "95th percentile request latency", "promql": "histogram_quantile(0.95, sum by (le) (rate(http_request_duration_seconds_bucket[5m])))"}
+]`
+	if err := os.WriteFile(filepath.Join(dir, "curated.json"), []byte(data), 0644); err != nil {
+		t.Fatalf("error writing examples: %v", err)
+	}
+	store, err := examples.NewStore(dir)
+	if err != nil {
+		t.Fatalf("NewStore returned an unexpected error: %v", err)
+	}
+
+	tests := []struct {
+		name     string
+		query    string
+		k        int
+		expected []string
+	}{
+		{"best match first", "what is the request rate per job", 2, []string{"HTTP request rate by job", "95th percentile request latency"}},
+		{"limited to k", "what is the request rate per job", 1, []string{"HTTP request rate by job"}},
+		{"punctuation and case ignored", "Memory usage, per POD?", 3, []string{"memory usage of each pod"}},
+		{"no shared words", "disk io", 3, nil},
+		{"stop words only", "what is the", 3, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var got []string
+			for _, example := range store.Similar(tt.query, tt.k) {
+				got = append(got, example.Query)
+			}
+			if !reflect.DeepEqual(got, tt.expected) {
+				t.Errorf("Similar(%q, %d) = %v, expected %v", tt.query, tt.k, got, tt.expected)
+			}
+		})
+	}
+}
+
+func TestStore_Add(t *testing.T) {
+	dir := t.TempDir()
+	store, err := examples.NewStore(dir)
+	if err != nil {
+		t.Fatalf("NewStore returned an unexpected error: %v", err)
+	}
+
+	example := examples.Example{Query: "disk io per device", PromQL: "sum by (device) (rate(node_disk_io_time_seconds_total[5m]))"}
+	for i := 0; i < 2; i++ {
+		if err := store.Add(example); err != nil {
+			t.Fatalf("Add returned an unexpected error: %v", err)
+		}
+	}
+	if err := store.Add(examples.Example{Query: "no promql"}); err == nil {
+		t.Errorf("expected an error adding an example without PromQL")
+	}
+	if all := store.All(); len(all) != 1 {
+		t.Errorf("expected duplicates to be ignored, got %v", all)
+	}
+
+	// Added examples survive a restart.
+	reloaded, err := examples.NewStore(dir)
+	if err != nil {
+		t.Fatalf("NewStore returned an unexpected error: %v", err)
+	}
+	if all := reloaded.All(); !reflect.DeepEqual(all, []examples.Example{example}) {
+		t.Errorf("expected the added example after reload, got %v", all)
+	}
+	if similar := reloaded.Similar("disk io", 1); len(similar) != 1 {
+		t.Errorf("expected the added example to be retrievable, got %v", similar)
+	}
+}
@@ -1,6 +1,7 @@
 package info_structure
 
 import (
+	"context"
 	"fmt"
 	"log"
 	"os"
@@ -9,10 +10,12 @@ import (
 	"time"
 
 	"github.com/prashantgupta17/nlpromql/llm"
+	"github.com/prashantgupta17/nlpromql/vectorindex"
 )
 
-// NewInfoBuilder creates a new InfoBuilder struct.
-func NewInfoBuilder(queryEngine QueryEngine, llmClient llm.LLMClient,
+// NewInfoBuilder creates a new InfoBuilder struct. synonyms is usually the
+// LLM client, or a synonyms.Generator to build without an LLM.
+func NewInfoBuilder(queryEngine QueryEngine, synonyms llm.SynonymGenerator,
 	loaderSaver InfoLoaderSaver) (*InfoStructure, error) {
 	if loaderSaver == nil {
 		defaultLoaderSaver, err := getDefaultInfoLoaderSaver()
@@ -23,7 +26,7 @@ func NewInfoBuilder(queryEngine QueryEngine, llmClient llm.LLMClient,
 	}
 	return &InfoStructure{
 		QueryEngine:     queryEngine,
-		llmClient:       llmClient,
+		synonyms:        synonyms,
 		InfoLoaderSaver: loaderSaver,
 	}, nil
 }
@@ -39,23 +42,30 @@ func getDefaultInfoLoaderSaver() (InfoLoaderSaver, error) {
 		return nil, fmt.Errorf("error creating info directory: %v", err)
 	}
 	return &InfoStructureManager{
-		PathToMetricMap:      filepath.Join(dir, "metric_map.json"),
-		PathToLabelMap:       filepath.Join(dir, "label_map.json"),
-		PathToMetricLabelMap: filepath.Join(dir, "metric_label_map.json"),
-		PathToLabelValueMap:  filepath.Join(dir, "label_value_map.json"),
-		PathToNlpToMetricMap: filepath.Join(dir, "nlp_to_metric_map.json"),
+		PathToMetricMap:       filepath.Join(dir, "metric_map.json"),
+		PathToLabelMap:        filepath.Join(dir, "label_map.json"),
+		PathToMetricLabelMap:  filepath.Join(dir, "metric_label_map.json"),
+		PathToLabelValueMap:   filepath.Join(dir, "label_value_map.json"),
+		PathToNlpToMetricMap:  filepath.Join(dir, "nlp_to_metric_map.json"),
+		PathToVectorIndex:     filepath.Join(dir, "vector_index.json"),
+		PathToValueIndex:      filepath.Join(dir, "value_index.json"),
+		PathToHistory:         filepath.Join(dir, "query_history.json"),
+		PathToLearnedSynonyms: filepath.Join(dir, "learned_synonyms.json"),
 	}, nil
 }
 
 // BuildInformationStructure builds or updates the information structure from Prometheus data.
 func (is *InfoStructure) BuildInformationStructure() error {
+	usage := llm.NewUsageRecorder(is.Prices)
 	is.buildStatusLock.Lock()
 	is.buildStatus = BuildStatus{
 		IsRunning:     true,
 		StartTime:     time.Now(),
 		ProgressStage: "Initializing",
 	}
+	is.buildUsage = usage
 	is.buildStatusLock.Unlock()
+	ctx := llm.WithUsageRecorder(context.Background(), usage)
 
 	defer func() {
 		is.buildStatusLock.Lock()
@@ -77,6 +87,31 @@ func (is *InfoStructure) BuildInformationStructure() error {
 	is.MetricLabelMap = &metricLabelMap
 	is.LabelValueMap = &labelValueMap
 	is.NlpToMetricMap = &nlpToMetricMap
+	valueIndex := NewValueIndex()
+	if loader, ok := is.InfoLoaderSaver.(ValueIndexLoaderSaver); ok {
+		valueIndex, err = loader.LoadValueIndex()
+		if err != nil {
+			is.updateErrorStatus(err)
+			return fmt.Errorf("error loading value index: %v", err)
+		}
+	}
+	is.ValueIndex = &valueIndex
+	is.History = NewQueryHistory()
+	if loader, ok := is.InfoLoaderSaver.(HistoryLoaderSaver); ok {
+		is.History, err = loader.LoadHistory()
+		if err != nil {
+			is.updateErrorStatus(err)
+			return fmt.Errorf("error loading query history: %v", err)
+		}
+	}
+	is.LearnedSynonyms = NewLearnedSynonyms()
+	if loader, ok := is.InfoLoaderSaver.(LearnedSynonymsLoaderSaver); ok {
+		is.LearnedSynonyms, err = loader.LoadLearnedSynonyms()
+		if err != nil {
+			is.updateErrorStatus(err)
+			return fmt.Errorf("error loading learned synonyms: %v", err)
+		}
+	}
 
 	// Fetch all metric names from Prometheus
 	is.updateProgressStage("Fetching existing metric names")
@@ -93,10 +128,11 @@ func (is *InfoStructure) BuildInformationStructure() error {
 		is.updateErrorStatus(err)
 		return fmt.Errorf("error fetching all metric descriptions: %v", err)
 	}
+	is.MetricHelp = allMetricDescriptions
 
 	// Update metricMap and get new metric synonyms
 	is.updateProgressStage("Updating existing metric map")
-	err = is.UpdateMetricMap(allMetricNames, allMetricDescriptions)
+	err = is.UpdateMetricMap(ctx, allMetricNames, allMetricDescriptions)
 	if err != nil {
 		is.updateErrorStatus(err)
 		return fmt.Errorf("error updating metric map: %v", err)
@@ -112,12 +148,20 @@ func (is *InfoStructure) BuildInformationStructure() error {
 
 	// Update labelMap and get new label synonyms
 	is.updateProgressStage("Fetching existing label map")
-	err = is.UpdateLabelMap(allLabelNames)
+	err = is.UpdateLabelMap(ctx, allLabelNames)
 	if err != nil {
 		is.updateErrorStatus(err)
 		return fmt.Errorf("error updating label map: %v", err)
 	}
 
+	if is.Embedder != nil {
+		is.updateProgressStage("Updating vector index")
+		if err := is.updateVectorIndex(ctx, allMetricNames, allMetricDescriptions, allLabelNames); err != nil {
+			is.updateErrorStatus(err)
+			return fmt.Errorf("error updating vector index: %v", err)
+		}
+	}
+
 	// Batch query Prometheus for metric and label details
 	is.updateProgressStage("Updating existing metric label combinations map")
 	err = is.updateMetricLabelMapAndLabelValueMap(allMetricNames)
@@ -125,6 +169,8 @@ func (is *InfoStructure) BuildInformationStructure() error {
 		is.updateErrorStatus(err)
 		return fmt.Errorf("error updating metric-label and label-value maps: %v", err)
 	}
+	// An index saved before the value index existed is empty, and gets every metric.
+	is.ValueIndex.Update(*is.MetricLabelMap)
 
 	// Save the updated information structure
 	is.updateProgressStage("Saving new info structure")
@@ -133,6 +179,20 @@ func (is *InfoStructure) BuildInformationStructure() error {
 		is.updateErrorStatus(err)
 		return fmt.Errorf("error saving information structure: %v", err)
 	}
+	if is.Embedder != nil {
+		if saver, ok := is.InfoLoaderSaver.(VectorIndexLoaderSaver); ok {
+			if err := saver.SaveVectorIndex(is.VectorIndex); err != nil {
+				is.updateErrorStatus(err)
+				return fmt.Errorf("error saving vector index: %v", err)
+			}
+		}
+	}
+	if saver, ok := is.InfoLoaderSaver.(ValueIndexLoaderSaver); ok {
+		if err := saver.SaveValueIndex(*is.ValueIndex); err != nil {
+			is.updateErrorStatus(err)
+			return fmt.Errorf("error saving value index: %v", err)
+		}
+	}
 
 	return nil
 }
@@ -156,7 +216,11 @@ func (is *InfoStructure) updateErrorStatus(err error) {
 func (is *InfoStructure) GetBuildStatus() BuildStatus {
 	is.buildStatusLock.RLock()
 	defer is.buildStatusLock.RUnlock()
-	return is.buildStatus
+	status := is.buildStatus
+	if is.buildUsage != nil {
+		status.Usage = is.buildUsage.Report()
+	}
+	return status
 }
 
 func (is *InfoStructure) IsBuilding() bool {
@@ -167,7 +231,7 @@ func (is *InfoStructure) IsBuilding() bool {
 
 // UpdateMetricMap updates the metricMap with new metric names and their synonyms.
 // Exported for testing purposes.
-func (is *InfoStructure) UpdateMetricMap(allMetricNames []string,
+func (is *InfoStructure) UpdateMetricMap(ctx context.Context, allMetricNames []string,
 	allMetricDescriptions map[string]string) error {
 	newMetricNames := make([]string, 0) // Using a slice for newMetricNames
 	// Determine new metric names that are not already in the MetricMap
@@ -219,7 +283,7 @@ func (is *InfoStructure) UpdateMetricMap(allMetricNames []string,
 	}
 
 	if len(metricBatches) > 0 {
-		newMetricSynonyms, err := is.llmClient.GetMetricSynonyms(metricBatches)
+		newMetricSynonyms, err := is.synonyms.GetMetricSynonyms(ctx, metricBatches)
 		if err != nil {
 			return fmt.Errorf("error getting metric synonyms: %w", err)
 		}
@@ -245,7 +309,7 @@ func (is *InfoStructure) UpdateMetricMap(allMetricNames []string,
 
 // UpdateLabelMap updates the labelMap with new label names and their synonyms.
 // Exported for testing purposes.
-func (is *InfoStructure) UpdateLabelMap(allLabelNames []string) error {
+func (is *InfoStructure) UpdateLabelMap(ctx context.Context, allLabelNames []string) error {
 	newLabelNames := make([]string, 0) // Using a slice for newLabelNames
 	// Determine new label names that are not already in the LabelMap
 	for _, label := range allLabelNames {
@@ -283,7 +347,7 @@ func (is *InfoStructure) UpdateLabelMap(allLabelNames []string) error {
 	}
 
 	if len(labelBatches) > 0 {
-		newLabelSynonyms, err := is.llmClient.GetLabelSynonyms(labelBatches)
+		newLabelSynonyms, err := is.synonyms.GetLabelSynonyms(ctx, labelBatches)
 		if err != nil {
 			return fmt.Errorf("error getting label synonyms: %w", err)
 		}
@@ -307,6 +371,79 @@ func (is *InfoStructure) UpdateLabelMap(allLabelNames []string) error {
 	return nil
 }
 
+// embeddingBatchSize is the number of texts sent to the Embedder at once.
+const embeddingBatchSize = 100
+
+// updateVectorIndex embeds the metrics and labels that are not yet in the
+// vector index. The stored index is loaded first; it is discarded if it was
+// computed with a different embedding model.
+func (is *InfoStructure) updateVectorIndex(ctx context.Context, allMetricNames []string,
+	allMetricDescriptions map[string]string, allLabelNames []string) error {
+	if is.VectorIndex == nil {
+		is.VectorIndex = vectorindex.New(is.EmbeddingModel)
+		if loader, ok := is.InfoLoaderSaver.(VectorIndexLoaderSaver); ok {
+			index, err := loader.LoadVectorIndex()
+			if err != nil {
+				return err
+			}
+			is.VectorIndex = index
+		}
+	}
+	if model := is.VectorIndex.Model(); model != is.EmbeddingModel {
+		if model != "" {
+			log.Printf("Vector index was built with %q, rebuilding it with %q\n", model, is.EmbeddingModel)
+		}
+		is.VectorIndex.Reset(is.EmbeddingModel)
+	}
+
+	var pending []vectorindex.Entry
+	for _, metric := range allMetricNames {
+		if !is.VectorIndex.Has(vectorindex.KindMetric, metric) {
+			pending = append(pending, vectorindex.Entry{Kind: vectorindex.KindMetric, Name: metric,
+				Text: embeddingText(metric, allMetricDescriptions[metric])})
+		}
+	}
+	for _, label := range allLabelNames {
+		if !is.VectorIndex.Has(vectorindex.KindLabel, label) {
+			pending = append(pending, vectorindex.Entry{Kind: vectorindex.KindLabel, Name: label, Text: embeddingText(label, "")})
+		}
+	}
+	if len(pending) == 0 {
+		return nil
+	}
+	fmt.Printf("Found %d new metrics and labels to embed\n", len(pending))
+
+	for start := 0; start < len(pending); start += embeddingBatchSize {
+		batch := pending[start:min(start+embeddingBatchSize, len(pending))]
+		texts := make([]string, len(batch))
+		for i, entry := range batch {
+			texts[i] = entry.Text
+		}
+		vectors, err := is.Embedder.EmbedDocuments(ctx, texts)
+		if err != nil {
+			return fmt.Errorf("error embedding metrics and labels: %w", err)
+		}
+		if len(vectors) != len(batch) {
+			return fmt.Errorf("embedder returned %d vectors for %d texts", len(vectors), len(batch))
+		}
+		for i := range batch {
+			batch[i].Vector = vectors[i]
+		}
+		is.VectorIndex.Add(batch...)
+	}
+	return nil
+}
+
+// embeddingText is the text embedded for a metric or label: its name with
+// separators replaced by spaces, followed by its description if it has one.
+func embeddingText(name, description string) string {
+	text := strings.NewReplacer("_", " ", ":", " ").Replace(name)
+	if description != "" {
+		text += ": " + description
+	}
+	return text
+}
+
 // updateMetricLabelMapAndLabelValueMap updates the metricLabelMap and labelValueMap from Prometheus data.
 func (is *InfoStructure) updateMetricLabelMapAndLabelValueMap(allMetricNames []string) error {
 	metricsToQuery := make([]string, 0) // Use a slice instead of a list
@@ -1,13 +1,19 @@
 package info_structure_test
 
 import (
+	"context"
 	"fmt"
 	"reflect"
+	"sort"
 	"testing"
+	"time"
 
 	"github.com/prashantgupta17/nlpromql/info_structure"
 	"github.com/prashantgupta17/nlpromql/llm"
 	"github.com/prashantgupta17/nlpromql/prometheus" // Added for prometheus.Metric type
+	"github.com/prashantgupta17/nlpromql/promtest"
+	"github.com/prashantgupta17/nlpromql/synonyms"
+	"github.com/prashantgupta17/nlpromql/vectorindex"
 )
 
 // --- Mocks ---
@@ -22,7 +28,7 @@ type MockLLMClient_BuilderTest struct {
 	ReceivedLabelBatches  [][]string
 }
 
-func (m *MockLLMClient_BuilderTest) GetMetricSynonyms(metricBatches []map[string]string) (map[string][]string, error) {
+func (m *MockLLMClient_BuilderTest) GetMetricSynonyms(ctx context.Context, metricBatches []map[string]string) (map[string][]string, error) {
 	m.ReceivedMetricBatches = metricBatches
 	if m.GetMetricSynonymsFunc != nil {
 		return m.GetMetricSynonymsFunc(metricBatches)
@@ -30,7 +36,7 @@ func (m *MockLLMClient_BuilderTest) GetMetricSynonyms(metricBatches []map[string
 	return make(map[string][]string), nil // Default happy path response
 }
 
-func (m *MockLLMClient_BuilderTest) GetLabelSynonyms(labelBatches [][]string) (map[string][]string, error) {
+func (m *MockLLMClient_BuilderTest) GetLabelSynonyms(ctx context.Context, labelBatches [][]string) (map[string][]string, error) {
 	m.ReceivedLabelBatches = labelBatches
 	if m.GetLabelSynonymsFunc != nil {
 		return m.GetLabelSynonymsFunc(labelBatches)
@@ -39,14 +45,18 @@ func (m *MockLLMClient_BuilderTest) GetLabelSynonyms(labelBatches [][]string) (m
 }
 
 // Implement other llm.LLMClient methods if needed by the code paths being tested, otherwise panic or return defaults.
-func (m *MockLLMClient_BuilderTest) ProcessUserQuery(userQuery string) (map[string]interface{}, error) {
+func (m *MockLLMClient_BuilderTest) ProcessUserQuery(ctx context.Context, userQuery string) (*llm.QueryAnalysis, error) {
 	panic("ProcessUserQuery not implemented in MockLLMClient_BuilderTest")
 }
 
-func (m *MockLLMClient_BuilderTest) GetPromQLFromLLM(userQuery string, relevantMetrics llm.RelevantMetricsMap, relevantLabels llm.RelevantLabelsMap, relevantHistory map[string]interface{}) ([]string, error) {
+func (m *MockLLMClient_BuilderTest) GetPromQLFromLLM(ctx context.Context, userQuery string, relevantMetrics llm.RelevantMetricsMap, relevantLabels llm.RelevantLabelsMap, relevantHistory map[string]interface{}, intent *llm.QueryIntent, metricScores llm.MetricScores) (*llm.PromQLResult, error) {
 	panic("GetPromQLFromLLM not implemented in MockLLMClient_BuilderTest")
 }
 
+func (m *MockLLMClient_BuilderTest) RepairPromQL(ctx context.Context, userQuery, promql, validationError string) (string, error) {
+	panic("RepairPromQL not implemented in MockLLMClient_BuilderTest")
+}
+
 func (m *MockLLMClient_BuilderTest) Reset() {
 	m.ReceivedMetricBatches = nil
 	m.ReceivedLabelBatches = nil
@@ -56,10 +66,10 @@ var _ llm.LLMClient = (*MockLLMClient_BuilderTest)(nil)
 
 // MockQueryEngine for builder tests
 type MockQueryEngine_BuilderTest struct {
-	AllMetricsFunc    func() ([]string, error)
-	AllMetadataFunc   func() (map[string]string, error)
-	AllLabelsFunc     func() ([]string, error)
-	CustomQueryFunc   func(query string) ([]prometheus.Metric, error)
+	AllMetricsFunc  func() ([]string, error)
+	AllMetadataFunc func() (map[string]string, error)
+	AllLabelsFunc   func() ([]string, error)
+	CustomQueryFunc func(query string) ([]prometheus.Metric, error)
 }
 
 func (m *MockQueryEngine_BuilderTest) AllMetrics() ([]string, error) {
@@ -183,7 +193,7 @@ func TestUpdateMetricMap_Batching(t *testing.T) {
 			name:                "some new, some existing metrics",
 			existingMetricNames: map[string]struct{}{"metric_existing_0": {}}, // metric_existing_0 exists
 			allMetricNamesFromProm: append(
-				[]string{"metric_existing_0", "metric_new_1"}, // metric_new_1 is new
+				[]string{"metric_existing_0", "metric_new_1"},           // metric_new_1 is new
 				generateMetrics(metricBatchSize-1, 2, "metric_new_")..., // metric_new_2, ..., metric_new_BATCHSIZE are new
 			),
 			allMetricDescriptions: func() map[string]string {
@@ -250,7 +260,7 @@ func TestUpdateMetricMap_Batching(t *testing.T) {
 			is.MetricMap.AllNames = tt.existingMetricNames
 			// is.MetricMap.Map is initialized by the mockLoaderSaver.LoadInfoStructure
 
-			err = is.UpdateMetricMap(tt.allMetricNamesFromProm, tt.allMetricDescriptions)
+			err = is.UpdateMetricMap(context.Background(), tt.allMetricNamesFromProm, tt.allMetricDescriptions)
 			if err != nil {
 				t.Fatalf("UpdateMetricMap returned an unexpected error: %v", err)
 			}
@@ -373,7 +383,7 @@ func TestUpdateLabelMap_Batching(t *testing.T) {
 			is.LabelMap.AllNames = tt.existingLabelNames
 			// is.LabelMap.Map is initialized by the mockLoaderSaver.LoadInfoStructure
 
-			err = is.UpdateLabelMap(tt.allLabelNamesFromProm)
+			err = is.UpdateLabelMap(context.Background(), tt.allLabelNamesFromProm)
 			if err != nil {
 				t.Fatalf("UpdateLabelMap returned an unexpected error: %v", err)
 			}
@@ -437,6 +447,141 @@ func mergeMaps(maps ...map[string]string) map[string]string {
 	return result
 }
 
+// MockEmbedder_BuilderTest returns a vector derived from each text's length
+// and records the texts it embedded.
+type MockEmbedder_BuilderTest struct {
+	EmbeddedTexts []string
+}
+
+func (m *MockEmbedder_BuilderTest) EmbedDocuments(ctx context.Context, texts []string) ([][]float32, error) {
+	m.EmbeddedTexts = append(m.EmbeddedTexts, texts...)
+	vectors := make([][]float32, len(texts))
+	for i, text := range texts {
+		vectors[i] = []float32{float32(len(text)), 1}
+	}
+	return vectors, nil
+}
+
+func (m *MockEmbedder_BuilderTest) EmbedQuery(ctx context.Context, text string) ([]float32, error) {
+	return []float32{float32(len(text)), 1}, nil
+}
+
+// MockVectorIndexLoaderSaver_BuilderTest also stores the vector index, in memory.
+type MockVectorIndexLoaderSaver_BuilderTest struct {
+	MockInfoLoaderSaver_BuilderTest
+	Stored *vectorindex.Index
+}
+
+func (m *MockVectorIndexLoaderSaver_BuilderTest) LoadVectorIndex() (*vectorindex.Index, error) {
+	if m.Stored == nil {
+		return vectorindex.New(""), nil
+	}
+	return m.Stored, nil
+}
+
+func (m *MockVectorIndexLoaderSaver_BuilderTest) SaveVectorIndex(index *vectorindex.Index) error {
+	m.Stored = index
+	return nil
+}
+
+var _ info_structure.VectorIndexLoaderSaver = (*MockVectorIndexLoaderSaver_BuilderTest)(nil)
+
+func TestBuildInformationStructure_VectorIndex(t *testing.T) {
+	mockQueryEngine := &MockQueryEngine_BuilderTest{
+		AllMetricsFunc: func() ([]string, error) { return []string{"http_requests_total", "node_memory_bytes"}, nil },
+		AllMetadataFunc: func() (map[string]string, error) {
+			return map[string]string{"http_requests_total": "Total HTTP requests."}, nil
+		},
+		AllLabelsFunc: func() ([]string, error) { return []string{"job"}, nil },
+	}
+	loaderSaver := &MockVectorIndexLoaderSaver_BuilderTest{}
+
+	tests := []struct {
+		name          string
+		model         string
+		expectedTexts []string
+	}{
+		{"First build embeds everything", "model-a", []string{"http requests total: Total HTTP requests.", "node memory bytes", "job"}},
+		{"Rebuild embeds nothing new", "model-a", nil},
+		{"Model change re-embeds everything", "model-b", []string{"http requests total: Total HTTP requests.", "node memory bytes", "job"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			embedder := &MockEmbedder_BuilderTest{}
+			builder, err := info_structure.NewInfoBuilder(mockQueryEngine, &MockLLMClient_BuilderTest{}, loaderSaver)
+			if err != nil {
+				t.Fatalf("NewInfoBuilder() error = %v", err)
+			}
+			builder.Embedder = embedder
+			builder.EmbeddingModel = tt.model
+
+			if err := builder.BuildInformationStructure(); err != nil {
+				t.Fatalf("BuildInformationStructure() error = %v", err)
+			}
+			if !reflect.DeepEqual(embedder.EmbeddedTexts, tt.expectedTexts) {
+				t.Errorf("embedded texts = %v, expected %v", embedder.EmbeddedTexts, tt.expectedTexts)
+			}
+			if loaderSaver.Stored == nil || loaderSaver.Stored.Len() != 3 || loaderSaver.Stored.Model() != tt.model {
+				t.Errorf("expected the saved index to hold 3 entries of %q", tt.model)
+			}
+			if !builder.VectorIndex.Has(vectorindex.KindMetric, "node_memory_bytes") || !builder.VectorIndex.Has(vectorindex.KindLabel, "job") {
+				t.Errorf("expected the builder's index to hold the metrics and labels")
+			}
+		})
+	}
+}
+
+func TestBuildInformationStructure_FakePrometheus(t *testing.T) {
+	server := promtest.NewServer(promtest.DefaultDataset(time.Now()))
+	defer server.Close()
+	var saved info_structure.MetricLabelMap
+	loaderSaver := &MockInfoLoaderSaver_BuilderTest{
+		SaveInfoStructureFunc: func(metricMap info_structure.MetricMap, labelMap info_structure.LabelMap, metricLabelMap info_structure.MetricLabelMap, labelValueMap info_structure.LabelValueMap, nlpToMetricMap info_structure.NlpToMetricMap) error {
+			saved = metricLabelMap
+			return nil
+		},
+	}
+	builder, err := info_structure.NewInfoBuilder(prometheus.NewPrometheusConnect(server.URL, "", ""), synonyms.NewGenerator(), loaderSaver)
+	if err != nil {
+		t.Fatalf("NewInfoBuilder() error = %v", err)
+	}
+
+	if err := builder.BuildInformationStructure(); err != nil {
+		t.Fatalf("BuildInformationStructure() error = %v", err)
+	}
+	if len(builder.MetricMap.AllNames) != 4 || len(builder.LabelMap.AllNames) != 5 {
+		t.Errorf("expected 4 metrics and 5 labels, got %v and %v", builder.MetricMap.AllNames, builder.LabelMap.AllNames)
+	}
+	if help := builder.MetricHelp["up"]; help != "Whether the target was scraped successfully." {
+		t.Errorf("unexpected HELP text of up: %q", help)
+	}
+	if _, ok := builder.MetricMap.Map["memory"]["node_memory_MemTotal_bytes"]; !ok {
+		t.Errorf("expected memory to be a synonym of node_memory_MemTotal_bytes")
+	}
+
+	labelValues := func(info info_structure.LabelInfo) []string {
+		var values []string
+		for value := range info.Values {
+			values = append(values, value)
+		}
+		sort.Strings(values)
+		return values
+	}
+	if len(saved) != 4 {
+		t.Fatalf("expected the labels of 4 metrics to be saved, got %v", saved)
+	}
+	if got, expected := labelValues(saved["http_requests_total"].Labels["code"]), []string{"200", "500"}; !reflect.DeepEqual(got, expected) {
+		t.Errorf("code values of http_requests_total = %v, expected %v", got, expected)
+	}
+	if got, expected := labelValues((*builder.LabelValueMap)["job"]), []string{"api", "node", "web"}; !reflect.DeepEqual(got, expected) {
+		t.Errorf("job values = %v, expected %v", got, expected)
+	}
+	if labels := builder.ValueIndex.Lookup("node"); len(labels) == 0 {
+		t.Errorf("expected the value index to know the labels with value node")
+	}
+}
+
 // Expose internal methods for testing - this would ideally not be needed if
 // info_structure.BuildInformationStructure() was more easily testable in units,
 // or if these were public utility methods.
@@ -0,0 +1,305 @@
+package info_structure
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Sources of history records.
+const (
+	HistorySourceFeedback = "feedback"          // Recorded by user feedback
+	HistorySourceMigrated = "nlp_to_metric_map" // Migrated from an NlpToMetricMap
+)
+
+// historyVersion is the version of the saved query history format.
+const historyVersion = 1
+
+// HistoryRecord is what the queries that found a metric through the same
+// tokens taught about it: the labels used with it and how often queries
+// using it were accepted and rejected.
+type HistoryRecord struct {
+	Tokens    []string          `json:"tokens"` // Query tokens that found the metric and its labels; sorted
+	Metric    string            `json:"metric"`
+	Labels    map[string]string `json:"labels,omitempty"` // Labels used with the metric, with their value or ""
+	PromQL    string            `json:"promql,omitempty"` // Last accepted query
+	Accepted  int               `json:"accepted"`
+	Rejected  int               `json:"rejected"`
+	FirstUsed time.Time         `json:"first_used"`
+	LastUsed  time.Time         `json:"last_used"`
+	Source    string            `json:"source"` // HistorySourceFeedback or HistorySourceMigrated
+}
+
+// Score is how many more times the metric was accepted than rejected.
+func (r HistoryRecord) Score() int {
+	return r.Accepted - r.Rejected
+}
+
+// key identifies the record of a metric found through tokens.
+func (r HistoryRecord) key() string {
+	return strings.Join(r.Tokens, "\x00") + "\x00\x00" + r.Metric
+}
+
+// QueryHistory holds the history records, indexed by token. It is safe for
+// concurrent use.
+type QueryHistory struct {
+	mu      sync.RWMutex
+	records []*HistoryRecord
+	byKey   map[string]*HistoryRecord
+	byToken map[string][]*HistoryRecord
+}
+
+// QueryHistoryJson is the saved form of a QueryHistory.
+type QueryHistoryJson struct {
+	Version int             `json:"version"`
+	Records []HistoryRecord `json:"records"`
+}
+
+// NewQueryHistory returns an empty QueryHistory.
+func NewQueryHistory() *QueryHistory {
+	return &QueryHistory{
+		byKey:   make(map[string]*HistoryRecord),
+		byToken: make(map[string][]*HistoryRecord),
+	}
+}
+
+// normalizeTokens returns the distinct non-empty tokens, sorted.
+func normalizeTokens(tokens []string) []string {
+	set := make(map[string]struct{}, len(tokens))
+	for _, token := range tokens {
+		if token != "" {
+			set[token] = struct{}{}
+		}
+	}
+	normalized := make([]string, 0, len(set))
+	for token := range set {
+		normalized = append(normalized, token)
+	}
+	sort.Strings(normalized)
+	return normalized
+}
+
+// add indexes record, which must not be in h yet. h.mu must be held.
+func (h *QueryHistory) add(record *HistoryRecord) {
+	h.records = append(h.records, record)
+	h.byKey[record.key()] = record
+	for _, token := range record.Tokens {
+		h.byToken[token] = append(h.byToken[token], record)
+	}
+}
+
+// Record records that a query found metric through tokens, and was accepted
+// or rejected, at now. An acceptance also records the labels used with the
+// metric, keeping known values over empty ones, and promql. It returns a
+// copy of the updated record.
+func (h *QueryHistory) Record(tokens []string, metric string, labels map[string]string, promql string, accepted bool, now time.Time) HistoryRecord {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	probe := HistoryRecord{Tokens: normalizeTokens(tokens), Metric: metric}
+	record, ok := h.byKey[probe.key()]
+	if !ok {
+		record = &probe
+		record.FirstUsed = now
+		record.Source = HistorySourceFeedback
+		h.add(record)
+	}
+	record.LastUsed = now
+	if !accepted {
+		record.Rejected++
+		return copyRecord(record)
+	}
+	record.Accepted++
+	record.PromQL = promql
+	for label, value := range labels {
+		if record.Labels == nil {
+			record.Labels = make(map[string]string)
+		}
+		if value != "" || record.Labels[label] == "" {
+			record.Labels[label] = value
+		}
+	}
+	return copyRecord(record)
+}
+
+// Lookup returns copies of the records with a positive score whose tokens are
+// all among tokens, highest score first.
+func (h *QueryHistory) Lookup(tokens []string) []HistoryRecord {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	query := make(map[string]bool, len(tokens))
+	for _, token := range tokens {
+		query[token] = true
+	}
+	seen := make(map[*HistoryRecord]bool)
+	var found []HistoryRecord
+	for _, token := range normalizeTokens(tokens) {
+		for _, record := range h.byToken[token] {
+			if seen[record] {
+				continue
+			}
+			seen[record] = true
+			if record.Score() <= 0 {
+				continue
+			}
+			matches := true
+			for _, t := range record.Tokens {
+				if !query[t] {
+					matches = false
+					break
+				}
+			}
+			if matches {
+				found = append(found, copyRecord(record))
+			}
+		}
+	}
+	sort.SliceStable(found, func(i, j int) bool {
+		if found[i].Score() != found[j].Score() {
+			return found[i].Score() > found[j].Score()
+		}
+		return found[i].key() < found[j].key()
+	})
+	return found
+}
+
+// Records returns copies of all records, in the order they were added.
+func (h *QueryHistory) Records() []HistoryRecord {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	records := make([]HistoryRecord, len(h.records))
+	for i, record := range h.records {
+		records[i] = copyRecord(record)
+	}
+	return records
+}
+
+// Len returns the number of records.
+func (h *QueryHistory) Len() int {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	return len(h.records)
+}
+
+func copyRecord(record *HistoryRecord) HistoryRecord {
+	c := *record
+	c.Tokens = append([]string(nil), record.Tokens...)
+	if record.Labels != nil {
+		c.Labels = make(map[string]string, len(record.Labels))
+		for label, value := range record.Labels {
+			c.Labels[label] = value
+		}
+	}
+	return c
+}
+
+// convertHistoryToJSON returns the saved form of h.
+func convertHistoryToJSON(h *QueryHistory) QueryHistoryJson {
+	return QueryHistoryJson{Version: historyVersion, Records: h.Records()}
+}
+
+// convertJSONToHistory indexes the records of a saved history. Records of the
+// same metric and tokens are merged.
+func convertJSONToHistory(historyJSON QueryHistoryJson) (*QueryHistory, error) {
+	if historyJSON.Version > historyVersion {
+		return nil, fmt.Errorf("unsupported query history version %d", historyJSON.Version)
+	}
+	h := NewQueryHistory()
+	for i := range historyJSON.Records {
+		record := copyRecord(&historyJSON.Records[i])
+		record.Tokens = normalizeTokens(record.Tokens)
+		h.merge(&record)
+	}
+	return h, nil
+}
+
+// merge adds record to h, or its counts and labels to the record of the same
+// metric and tokens. h.mu must be held or h not shared yet.
+func (h *QueryHistory) merge(record *HistoryRecord) {
+	existing, ok := h.byKey[record.key()]
+	if !ok {
+		h.add(record)
+		return
+	}
+	existing.Accepted += record.Accepted
+	existing.Rejected += record.Rejected
+	if record.FirstUsed.Before(existing.FirstUsed) {
+		existing.FirstUsed = record.FirstUsed
+	}
+	if record.LastUsed.After(existing.LastUsed) {
+		existing.LastUsed = record.LastUsed
+		if record.PromQL != "" {
+			existing.PromQL = record.PromQL
+		}
+	}
+	for label, value := range record.Labels {
+		if existing.Labels == nil {
+			existing.Labels = make(map[string]string)
+		}
+		if value != "" || existing.Labels[label] == "" {
+			existing.Labels[label] = value
+		}
+	}
+}
+
+// MigrateNlpToMetricMap converts the entries of an NlpToMetricMap into
+// history records used at usedAt, as the map does not say when. The tokens of
+// a record are those of the [metric token, label token] key of its entry, and
+// its score, rounded up to at least 1, becomes its acceptance count, so that
+// no stored metric is dropped from the history. Entries that cannot be read
+// are skipped and returned as errors, so one bad entry does not lose the
+// others.
+func MigrateNlpToMetricMap(nlpToMetricMap NlpToMetricMap, usedAt time.Time) (*QueryHistory, []error) {
+	h := NewQueryHistory()
+	var errs []error
+	keys := make([]string, 0, len(nlpToMetricMap))
+	for key := range nlpToMetricMap {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		var tokens []string
+		if err := json.Unmarshal([]byte(key), &tokens); err != nil {
+			errs = append(errs, fmt.Errorf("error unmarshaling nlpToMetricMap key %s: %v", key, err))
+			continue
+		}
+		var entries map[string]struct {
+			Score  *float64               `json:"score"`
+			Labels map[string]interface{} `json:"labels"`
+		}
+		if err := json.Unmarshal([]byte(nlpToMetricMap[key]), &entries); err != nil {
+			errs = append(errs, fmt.Errorf("error unmarshaling nlpToMetricMap value of %s: %v", key, err))
+			continue
+		}
+		metrics := make([]string, 0, len(entries))
+		for metric := range entries {
+			metrics = append(metrics, metric)
+		}
+		sort.Strings(metrics)
+		for _, metric := range metrics {
+			entry := entries[metric]
+			record := &HistoryRecord{
+				Tokens:    normalizeTokens(tokens),
+				Metric:    metric,
+				Accepted:  1,
+				FirstUsed: usedAt,
+				LastUsed:  usedAt,
+				Source:    HistorySourceMigrated,
+			}
+			if entry.Score != nil {
+				record.Accepted = max(1, int(math.Ceil(*entry.Score)))
+			}
+			for label, value := range entry.Labels {
+				if record.Labels == nil {
+					record.Labels = make(map[string]string)
+				}
+				record.Labels[label], _ = value.(string)
+			}
+			h.merge(record)
+		}
+	}
+	return h, errs
+}
@@ -0,0 +1,139 @@
+package info_structure_test
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/prashantgupta17/nlpromql/info_structure"
+)
+
+func TestQueryHistory(t *testing.T) {
+	history := info_structure.NewQueryHistory()
+	first := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	later := first.Add(time.Hour)
+
+	history.Record([]string{"service", "latency", ""}, "api_latency_seconds", map[string]string{"job": "api"}, "a", true, first)
+	history.Record([]string{"latency", "service"}, "api_latency_seconds", map[string]string{"job": "", "pod": ""}, "b", true, later)
+	record := history.Record([]string{"latency"}, "node_latency_seconds", nil, "c", false, later)
+	if record.Score() != -1 || record.PromQL != "" {
+		t.Errorf("expected a rejection to count against the metric without recording the query, got %+v", record)
+	}
+
+	expected := info_structure.HistoryRecord{
+		Tokens:    []string{"latency", "service"},
+		Metric:    "api_latency_seconds",
+		Labels:    map[string]string{"job": "api", "pod": ""},
+		PromQL:    "b",
+		Accepted:  2,
+		FirstUsed: first,
+		LastUsed:  later,
+		Source:    info_structure.HistorySourceFeedback,
+	}
+	tests := []struct {
+		name     string
+		tokens   []string
+		expected []info_structure.HistoryRecord
+	}{
+		{"All tokens", []string{"latency", "service", "p99"}, []info_structure.HistoryRecord{expected}},
+		{"Missing token", []string{"latency"}, nil},
+		{"Rejected metric", []string{"latency", "cpu"}, nil},
+		{"No tokens", nil, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := history.Lookup(tt.tokens); !reflect.DeepEqual(got, tt.expected) {
+				t.Errorf("Lookup(%v) = %+v, expected %+v", tt.tokens, got, tt.expected)
+			}
+		})
+	}
+	if history.Len() != 2 {
+		t.Errorf("expected 2 records, got %d", history.Len())
+	}
+}
+
+func TestMigrateNlpToMetricMap(t *testing.T) {
+	usedAt := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	history, errs := info_structure.MigrateNlpToMetricMap(info_structure.NlpToMetricMap{
+		`["latency","service"]`: `{"api_latency_seconds": {"score": 3, "labels": {"job": "api", "code": 200}}}`,
+		`["requests",""]`:       `{"http_requests_total": {}}`,
+		`["memory"]`:            `{"node_memory_bytes": {"score": 0.3}}`,
+		`latency`:               `{}`,
+		`["cpu","pod"]`:         `not json`,
+	}, usedAt)
+
+	if len(errs) != 2 {
+		t.Errorf("expected the 2 bad entries to be reported, got %v", errs)
+	}
+	expected := []info_structure.HistoryRecord{
+		{
+			Tokens: []string{"latency", "service"}, Metric: "api_latency_seconds", Labels: map[string]string{"code": "", "job": "api"},
+			Accepted: 3, FirstUsed: usedAt, LastUsed: usedAt, Source: info_structure.HistorySourceMigrated,
+		},
+		{
+			Tokens: []string{"memory"}, Metric: "node_memory_bytes",
+			Accepted: 1, FirstUsed: usedAt, LastUsed: usedAt, Source: info_structure.HistorySourceMigrated,
+		},
+		{
+			Tokens: []string{"requests"}, Metric: "http_requests_total",
+			Accepted: 1, FirstUsed: usedAt, LastUsed: usedAt, Source: info_structure.HistorySourceMigrated,
+		},
+	}
+	if got := history.Records(); !reflect.DeepEqual(got, expected) {
+		t.Errorf("migrated records %+v, expected %+v", got, expected)
+	}
+}
+
+func TestInfoStructureManager_History(t *testing.T) {
+	dir := t.TempDir()
+	manager := &info_structure.InfoStructureManager{
+		PathToNlpToMetricMap: filepath.Join(dir, "nlp_to_metric_map.json"),
+		PathToHistory:        filepath.Join(dir, "query_history.json"),
+	}
+
+	empty, err := manager.LoadHistory()
+	if err != nil {
+		t.Fatalf("LoadHistory() without files error = %v", err)
+	}
+	if empty.Len() != 0 {
+		t.Errorf("expected an empty history without files, got %+v", empty.Records())
+	}
+
+	// The old map is migrated, and the history saved, on first load.
+	old, _ := json.Marshal(info_structure.NlpToMetricMap{`["latency","service"]`: `{"api_latency_seconds": {"score": 2}}`})
+	if err := os.WriteFile(manager.PathToNlpToMetricMap, old, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	migrated, err := manager.LoadHistory()
+	if err != nil {
+		t.Fatalf("LoadHistory() error = %v", err)
+	}
+	records := migrated.Records()
+	if len(records) != 1 || records[0].Accepted != 2 || records[0].LastUsed.IsZero() {
+		t.Fatalf("expected the old entry migrated with its file's time, got %+v", records)
+	}
+	if _, err := os.Stat(manager.PathToHistory); err != nil {
+		t.Errorf("expected the migrated history to be saved: %v", err)
+	}
+
+	migrated.Record([]string{"cpu"}, "node_cpu_seconds_total", map[string]string{"mode": "idle"}, "x", true, time.Now())
+	if err := manager.SaveHistory(migrated); err != nil {
+		t.Fatalf("SaveHistory() error = %v", err)
+	}
+	loaded, err := manager.LoadHistory()
+	if err != nil {
+		t.Fatalf("LoadHistory() error = %v", err)
+	}
+	// Times lose their monotonic clock reading and location in JSON.
+	got, _ := json.Marshal(loaded.Records())
+	want, _ := json.Marshal(migrated.Records())
+	if string(got) != string(want) {
+		t.Errorf("loaded history %s, expected %s", got, want)
+	}
+	if len(loaded.Lookup([]string{"cpu"})) != 1 {
+		t.Errorf("expected the loaded history to be indexed by token")
+	}
+}
@@ -0,0 +1,219 @@
+package info_structure
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"sync"
+	"time"
+)
+
+// Kinds of learned synonyms.
+const (
+	SynonymKindMetric = "metric" // A synonym of MetricMap
+	SynonymKindLabel  = "label"  // A synonym of LabelMap
+)
+
+// Defaults of LearnedSynonyms.
+const (
+	DefaultSynonymHalfLife  = 30 * 24 * time.Hour
+	DefaultMinSynonymWeight = 0.1
+)
+
+// maxSynonymQueries is how many of the queries that taught a synonym are kept.
+const maxSynonymQueries = 5
+
+// LearnedSynonym is a query token learned as a synonym of a metric or label
+// from accepted feedback. Its weight grows by 1 each time it is learned and
+// halves every half-life of its LearnedSynonyms since.
+type LearnedSynonym struct {
+	Kind         string    `json:"kind"` // SynonymKindMetric or SynonymKindLabel
+	Token        string    `json:"token"`
+	Name         string    `json:"name"`
+	Weight       float64   `json:"weight"`            // As of LastLearned
+	Count        int       `json:"count"`             // Times learned
+	Queries      []string  `json:"queries,omitempty"` // Latest queries that taught it
+	PromQL       string    `json:"promql,omitempty"`  // Latest accepted query that taught it
+	FirstLearned time.Time `json:"first_learned"`
+	LastLearned  time.Time `json:"last_learned"`
+	// Reverted synonyms are not used, nor learned again.
+	Reverted bool `json:"reverted,omitempty"`
+}
+
+func (s LearnedSynonym) key() string {
+	return s.Kind + "\x00" + s.Token + "\x00" + s.Name
+}
+
+// LearnedSynonyms holds the synonyms learned from feedback. It is safe for
+// concurrent use.
+type LearnedSynonyms struct {
+	HalfLife  time.Duration // DefaultSynonymHalfLife when 0
+	MinWeight float64       // Synonyms that decayed below it are not used; DefaultMinSynonymWeight when 0
+
+	mu       sync.RWMutex
+	synonyms map[string]*LearnedSynonym
+	byToken  map[string][]*LearnedSynonym // Kind and token -> synonyms
+}
+
+// LearnedSynonymsJson is the saved form of LearnedSynonyms.
+type LearnedSynonymsJson struct {
+	Synonyms []LearnedSynonym `json:"synonyms"`
+}
+
+// NewLearnedSynonyms returns an empty LearnedSynonyms.
+func NewLearnedSynonyms() *LearnedSynonyms {
+	return &LearnedSynonyms{
+		synonyms: make(map[string]*LearnedSynonym),
+		byToken:  make(map[string][]*LearnedSynonym),
+	}
+}
+
+// WeightAt returns the weight of s at now, decayed over halfLife.
+func (s LearnedSynonym) WeightAt(now time.Time, halfLife time.Duration) float64 {
+	age := now.Sub(s.LastLearned)
+	if age <= 0 {
+		return s.Weight
+	}
+	return s.Weight * math.Pow(0.5, float64(age)/float64(halfLife))
+}
+
+func (l *LearnedSynonyms) halfLife() time.Duration {
+	if l.HalfLife == 0 {
+		return DefaultSynonymHalfLife
+	}
+	return l.HalfLife
+}
+
+func (l *LearnedSynonyms) minWeight() float64 {
+	if l.MinWeight == 0 {
+		return DefaultMinSynonymWeight
+	}
+	return l.MinWeight
+}
+
+// add indexes synonym, which must not be in l yet. l.mu must be held.
+func (l *LearnedSynonyms) add(synonym *LearnedSynonym) {
+	l.synonyms[synonym.key()] = synonym
+	tokenKey := synonym.Kind + "\x00" + synonym.Token
+	l.byToken[tokenKey] = append(l.byToken[tokenKey], synonym)
+}
+
+// Learn records that query, answered by promql, used token for the metric or
+// label name, at now. It returns a copy of the synonym and false if the
+// synonym was reverted, in which case it is left as it is.
+func (l *LearnedSynonyms) Learn(kind, token, name, query, promql string, now time.Time) (LearnedSynonym, bool) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	probe := LearnedSynonym{Kind: kind, Token: token, Name: name}
+	synonym, ok := l.synonyms[probe.key()]
+	if !ok {
+		synonym = &probe
+		synonym.FirstLearned = now
+		l.add(synonym)
+	}
+	if synonym.Reverted {
+		return copySynonym(synonym), false
+	}
+	synonym.Weight = synonym.WeightAt(now, l.halfLife()) + 1
+	synonym.Count++
+	synonym.LastLearned = now
+	synonym.PromQL = promql
+	if query != "" {
+		synonym.Queries = append(synonym.Queries, query)
+		if len(synonym.Queries) > maxSynonymQueries {
+			synonym.Queries = synonym.Queries[len(synonym.Queries)-maxSynonymQueries:]
+		}
+	}
+	return copySynonym(synonym), true
+}
+
+// Lookup returns the names token is a learned synonym of, with their weights
+// at now, capped at 1. Reverted synonyms and those that decayed below the
+// minimum weight are left out.
+func (l *LearnedSynonyms) Lookup(kind, token string, now time.Time) map[string]float64 {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+	var names map[string]float64
+	for _, synonym := range l.byToken[kind+"\x00"+token] {
+		if synonym.Reverted {
+			continue
+		}
+		if weight := synonym.WeightAt(now, l.halfLife()); weight >= l.minWeight() {
+			if names == nil {
+				names = make(map[string]float64)
+			}
+			names[synonym.Name] = min(weight, 1)
+		}
+	}
+	return names
+}
+
+// List returns copies of all synonyms, reverted ones included, with their
+// weights at now, for review: by kind, token and name.
+func (l *LearnedSynonyms) List(now time.Time) []LearnedSynonym {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+	list := make([]LearnedSynonym, 0, len(l.synonyms))
+	for _, synonym := range l.synonyms {
+		s := copySynonym(synonym)
+		s.Weight = s.WeightAt(now, l.halfLife())
+		list = append(list, s)
+	}
+	sortSynonyms(list)
+	return list
+}
+
+// Revert stops using a learned synonym, and learning it again. It returns an
+// error if the synonym was never learned.
+func (l *LearnedSynonyms) Revert(kind, token, name string) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	synonym, ok := l.synonyms[LearnedSynonym{Kind: kind, Token: token, Name: name}.key()]
+	if !ok {
+		return fmt.Errorf("no learned %s synonym %q of %q", kind, token, name)
+	}
+	synonym.Reverted = true
+	return nil
+}
+
+// Len returns the number of synonyms, reverted ones included.
+func (l *LearnedSynonyms) Len() int {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+	return len(l.synonyms)
+}
+
+func copySynonym(synonym *LearnedSynonym) LearnedSynonym {
+	c := *synonym
+	c.Queries = append([]string(nil), synonym.Queries...)
+	return c
+}
+
+func sortSynonyms(synonyms []LearnedSynonym) {
+	sort.Slice(synonyms, func(i, j int) bool {
+		return synonyms[i].key() < synonyms[j].key()
+	})
+}
+
+// convertLearnedSynonymsToJSON returns the saved form of l, with the weights
+// as of the last time each synonym was learned.
+func convertLearnedSynonymsToJSON(l *LearnedSynonyms) LearnedSynonymsJson {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+	synonyms := make([]LearnedSynonym, 0, len(l.synonyms))
+	for _, synonym := range l.synonyms {
+		synonyms = append(synonyms, copySynonym(synonym))
+	}
+	sortSynonyms(synonyms)
+	return LearnedSynonymsJson{Synonyms: synonyms}
+}
+
+// convertJSONToLearnedSynonyms indexes saved synonyms.
+func convertJSONToLearnedSynonyms(synonymsJSON LearnedSynonymsJson) *LearnedSynonyms {
+	l := NewLearnedSynonyms()
+	for i := range synonymsJSON.Synonyms {
+		synonym := copySynonym(&synonymsJSON.Synonyms[i])
+		l.add(&synonym)
+	}
+	return l
+}
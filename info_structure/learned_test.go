@@ -0,0 +1,106 @@
+package info_structure_test
+
+import (
+	"encoding/json"
+	"math"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/prashantgupta17/nlpromql/info_structure"
+)
+
+func TestLearnedSynonyms(t *testing.T) {
+	synonyms := info_structure.NewLearnedSynonyms()
+	synonyms.HalfLife = 24 * time.Hour
+	first := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	metric := info_structure.SynonymKindMetric
+
+	synonyms.Learn(metric, "checkout", "checkout_duration_seconds", "checkout latency", "a", first)
+	// A day later the first lesson is worth half.
+	learned, ok := synonyms.Learn(metric, "checkout", "checkout_duration_seconds", "slow checkout", "b", first.Add(24*time.Hour))
+	if !ok || learned.Weight != 1.5 || learned.Count != 2 || learned.PromQL != "b" ||
+		!reflect.DeepEqual(learned.Queries, []string{"checkout latency", "slow checkout"}) || !learned.FirstLearned.Equal(first) {
+		t.Errorf("expected the synonym to be reinforced with its provenance, got %+v", learned)
+	}
+	synonyms.Learn(metric, "checkout", "orders_total", "checkout count", "c", first)
+	synonyms.Learn(info_structure.SynonymKindLabel, "checkout", "service", "checkout errors", "d", first)
+
+	tests := []struct {
+		name     string
+		kind     string
+		at       time.Time
+		expected map[string]float64
+	}{
+		{"Capped at 1", metric, first.Add(24 * time.Hour), map[string]float64{"checkout_duration_seconds": 1, "orders_total": 0.5}},
+		{"Decayed", metric, first.Add(72 * time.Hour), map[string]float64{"checkout_duration_seconds": 0.375, "orders_total": 0.125}},
+		{"Below the minimum weight", metric, first.Add(120 * time.Hour), nil},
+		{"Other kind", info_structure.SynonymKindLabel, first, map[string]float64{"service": 1}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := synonyms.Lookup(tt.kind, "checkout", tt.at)
+			if len(got) != len(tt.expected) {
+				t.Fatalf("Lookup() = %v, expected %v", got, tt.expected)
+			}
+			for name, weight := range tt.expected {
+				if math.Abs(got[name]-weight) > 1e-9 {
+					t.Errorf("Lookup()[%s] = %v, expected %v", name, got[name], weight)
+				}
+			}
+		})
+	}
+
+	if err := synonyms.Revert(metric, "checkout", "orders_total"); err != nil {
+		t.Fatalf("Revert() error = %v", err)
+	}
+	if err := synonyms.Revert(metric, "checkout", "unknown"); err == nil {
+		t.Errorf("expected an error reverting an unknown synonym")
+	}
+	if _, ok := synonyms.Learn(metric, "checkout", "orders_total", "checkout count", "c", first); ok {
+		t.Errorf("expected a reverted synonym not to be learned again")
+	}
+	if _, ok := synonyms.Lookup(metric, "checkout", first)["orders_total"]; ok {
+		t.Errorf("expected a reverted synonym not to be looked up")
+	}
+	list := synonyms.List(first.Add(24 * time.Hour))
+	if len(list) != 3 || list[0].Name != "service" || list[1].Name != "checkout_duration_seconds" || !list[2].Reverted {
+		t.Errorf("expected every synonym in order for review, reverted ones included, got %+v", list)
+	}
+}
+
+func TestInfoStructureManager_LearnedSynonyms(t *testing.T) {
+	manager := &info_structure.InfoStructureManager{PathToLearnedSynonyms: filepath.Join(t.TempDir(), "learned_synonyms.json")}
+
+	empty, err := manager.LoadLearnedSynonyms()
+	if err != nil {
+		t.Fatalf("LoadLearnedSynonyms() without a file error = %v", err)
+	}
+	if empty.Len() != 0 {
+		t.Errorf("expected no synonyms without a file, got %d", empty.Len())
+	}
+
+	now := time.Now()
+	empty.Learn(info_structure.SynonymKindMetric, "checkout", "checkout_duration_seconds", "checkout latency", "a", now)
+	empty.Learn(info_structure.SynonymKindLabel, "env", "namespace", "checkout latency in prod env", "b", now)
+	if err := empty.Revert(info_structure.SynonymKindLabel, "env", "namespace"); err != nil {
+		t.Fatal(err)
+	}
+	if err := manager.SaveLearnedSynonyms(empty); err != nil {
+		t.Fatalf("SaveLearnedSynonyms() error = %v", err)
+	}
+	loaded, err := manager.LoadLearnedSynonyms()
+	if err != nil {
+		t.Fatalf("LoadLearnedSynonyms() error = %v", err)
+	}
+	// Times lose their monotonic clock reading and location in JSON.
+	got, _ := json.Marshal(loaded.List(now))
+	want, _ := json.Marshal(empty.List(now))
+	if string(got) != string(want) {
+		t.Errorf("loaded synonyms %s, expected %s", got, want)
+	}
+	if len(loaded.Lookup(info_structure.SynonymKindMetric, "checkout", now)) != 1 {
+		t.Errorf("expected the loaded synonyms to be indexed by token")
+	}
+}
@@ -3,7 +3,10 @@ package info_structure
 import (
 	"encoding/json"
 	"fmt"
+	"log"
 	"os"
+
+	"github.com/prashantgupta17/nlpromql/vectorindex"
 )
 
 // LoadInformationStructure loads all information structures from JSON files.
@@ -41,6 +44,88 @@ func (im *InfoStructureManager) LoadInfoStructure() (MetricMap, LabelMap,
 	return metricMap, labelMap, metricLabelMap, labelValueMap, nlpToMetricMap, nil
 }
 
+// LoadVectorIndex loads the vector index, or returns an empty index if it has
+// not been saved yet or PathToVectorIndex is not set.
+func (im *InfoStructureManager) LoadVectorIndex() (*vectorindex.Index, error) {
+	if im.PathToVectorIndex == "" {
+		return vectorindex.New(""), nil
+	}
+	fmt.Println("Loading:", im.PathToVectorIndex)
+	return vectorindex.Load(im.PathToVectorIndex)
+}
+
+// LoadValueIndex loads the value index, or returns an empty index if it has
+// not been saved yet or PathToValueIndex is not set.
+func (im *InfoStructureManager) LoadValueIndex() (ValueIndex, error) {
+	if im.PathToValueIndex == "" {
+		return NewValueIndex(), nil
+	}
+	var valueIndexJSON ValueIndexJson
+	if err := loadMapFromFile(im.PathToValueIndex, &valueIndexJSON); err != nil {
+		return ValueIndex{}, err
+	}
+	return convertJSONToValueIndex(valueIndexJSON), nil
+}
+
+// LoadHistory loads the query history. If it has not been saved yet, the
+// NlpToMetricMap at PathToNlpToMetricMap is migrated to it, skipping and
+// logging the entries that cannot be read; the old file is left as it is.
+// The history is empty if neither file exists or PathToHistory is not set.
+func (im *InfoStructureManager) LoadHistory() (*QueryHistory, error) {
+	if im.PathToHistory == "" {
+		return NewQueryHistory(), nil
+	}
+	if _, err := os.Stat(im.PathToHistory); err == nil {
+		var historyJSON QueryHistoryJson
+		if err := loadMapFromFile(im.PathToHistory, &historyJSON); err != nil {
+			return nil, err
+		}
+		return convertJSONToHistory(historyJSON)
+	} else if !os.IsNotExist(err) {
+		return nil, fmt.Errorf("error opening file: %v", err)
+	}
+
+	info, err := os.Stat(im.PathToNlpToMetricMap)
+	if os.IsNotExist(err) {
+		return NewQueryHistory(), nil
+	} else if err != nil {
+		return nil, fmt.Errorf("error opening file: %v", err)
+	}
+	var nlpToMetricMap NlpToMetricMap
+	if err := loadMapFromFile(im.PathToNlpToMetricMap, &nlpToMetricMap); err != nil {
+		return nil, err
+	}
+	// The map does not say when its entries were used; its last change is
+	// the best guess.
+	history, errs := MigrateNlpToMetricMap(nlpToMetricMap, info.ModTime())
+	for _, err := range errs {
+		log.Printf("Skipping query history entry: %v\n", err)
+	}
+	if history.Len() > 0 {
+		fmt.Printf("Migrated %d query history records from %s\n", history.Len(), im.PathToNlpToMetricMap)
+		if err := im.SaveHistory(history); err != nil {
+			return nil, err
+		}
+	}
+	return history, nil
+}
+
+// LoadLearnedSynonyms loads the synonyms learned from feedback. There are none
+// if the file does not exist or PathToLearnedSynonyms is not set.
+func (im *InfoStructureManager) LoadLearnedSynonyms() (*LearnedSynonyms, error) {
+	if im.PathToLearnedSynonyms == "" {
+		return NewLearnedSynonyms(), nil
+	}
+	if _, err := os.Stat(im.PathToLearnedSynonyms); os.IsNotExist(err) {
+		return NewLearnedSynonyms(), nil
+	}
+	var synonymsJSON LearnedSynonymsJson
+	if err := loadMapFromFile(im.PathToLearnedSynonyms, &synonymsJSON); err != nil {
+		return nil, err
+	}
+	return convertJSONToLearnedSynonyms(synonymsJSON), nil
+}
+
 // loadMapFromFile loads a map from a JSON file.
 func loadMapFromFile(filePath string, data interface{}) error {
 	fmt.Println("Loading:", filePath)
@@ -146,3 +231,15 @@ func convertJSONToLabelValueMap(data MapForJSON) LabelValueMap {
 	}
 	return result
 }
+
+// convertJSONToValueIndex converts the JSON representation of ValueIndex back to the original type.
+func convertJSONToValueIndex(data ValueIndexJson) ValueIndex {
+	result := NewValueIndex()
+	for norm, refs := range data.Values {
+		result.Values[norm] = refs
+	}
+	for _, metric := range data.Metrics {
+		result.Metrics[metric] = struct{}{}
+	}
+	return result
+}
@@ -4,6 +4,8 @@ import (
 	"encoding/json"
 	"fmt"
 	"os"
+
+	"github.com/prashantgupta17/nlpromql/vectorindex"
 )
 
 // SaveInfoStructure saves all information structures to JSON files.
@@ -31,6 +33,42 @@ func (im *InfoStructureManager) SaveInfoStructure(metricMap MetricMap, labelMap
 	return nil
 }
 
+// SaveHistory saves the query history, unless PathToHistory is not set. It is
+// also called between builds, as feedback updates the history.
+func (im *InfoStructureManager) SaveHistory(history *QueryHistory) error {
+	if im.PathToHistory == "" {
+		return nil
+	}
+	return saveMapToFile(im.PathToHistory, convertHistoryToJSON(history))
+}
+
+// SaveLearnedSynonyms saves the synonyms learned from feedback, unless
+// PathToLearnedSynonyms is not set. Like the history, it is saved between
+// builds.
+func (im *InfoStructureManager) SaveLearnedSynonyms(synonyms *LearnedSynonyms) error {
+	if im.PathToLearnedSynonyms == "" {
+		return nil
+	}
+	return saveMapToFile(im.PathToLearnedSynonyms, convertLearnedSynonymsToJSON(synonyms))
+}
+
+// SaveVectorIndex saves the vector index, unless PathToVectorIndex is not set.
+func (im *InfoStructureManager) SaveVectorIndex(index *vectorindex.Index) error {
+	if im.PathToVectorIndex == "" {
+		return nil
+	}
+	fmt.Println("Saving:", im.PathToVectorIndex)
+	return index.Save(im.PathToVectorIndex)
+}
+
+// SaveValueIndex saves the value index, unless PathToValueIndex is not set.
+func (im *InfoStructureManager) SaveValueIndex(index ValueIndex) error {
+	if im.PathToValueIndex == "" {
+		return nil
+	}
+	return saveMapToFile(im.PathToValueIndex, convertValueIndexToLists(index))
+}
+
 // saveMapToFile saves a map to a JSON file.
 func saveMapToFile(filePath string, data interface{}) error {
 	fmt.Println("Saving:", filePath)
@@ -114,3 +152,15 @@ func convertLabelValueMapToLists(labelValueMap LabelValueMap) MapForJSON {
 	}
 	return result
 }
+
+// convertValueIndexToLists converts the sets in a ValueIndex to lists for saving.
+func convertValueIndexToLists(index ValueIndex) ValueIndexJson {
+	result := ValueIndexJson{
+		Values:  index.Values,
+		Metrics: make([]string, 0, len(index.Metrics)),
+	}
+	for metric := range index.Metrics {
+		result.Metrics = append(result.Metrics, metric)
+	}
+	return result
+}
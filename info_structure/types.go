@@ -6,6 +6,8 @@ import (
 
 	"github.com/prashantgupta17/nlpromql/llm" // Added llm import
 	"github.com/prashantgupta17/nlpromql/prometheus"
+	"github.com/prashantgupta17/nlpromql/vectorindex"
+	"github.com/tmc/langchaingo/embeddings"
 )
 
 // MapForJSON represents a map that can be directly serialized to JSON.
@@ -18,11 +20,22 @@ type InfoStructure struct {
 	MetricLabelMap  *MetricLabelMap
 	LabelValueMap   *LabelValueMap
 	NlpToMetricMap  *NlpToMetricMap
+	ValueIndex      *ValueIndex       // Labels of each value, updated with MetricLabelMap
+	History         *QueryHistory     // Metrics of accepted queries, recorded by feedback
+	LearnedSynonyms *LearnedSynonyms  // Query tokens of accepted queries, learned as synonyms by feedback
+	MetricHelp      map[string]string // HELP text of each metric, fetched by the last build
 	QueryEngine     QueryEngine
-	llmClient       llm.LLMClient // This was already changed, ensure it's correct
+	synonyms        llm.SynonymGenerator
 	InfoLoaderSaver InfoLoaderSaver
+	Prices          llm.PriceTable // Used to estimate the cost of builds; may be nil
+	// Embedder, if not nil, embeds each metric (name and HELP text) and label
+	// into VectorIndex during the build, for semantic retrieval.
+	Embedder       embeddings.Embedder
+	EmbeddingModel string // Identifies Embedder's model; the index is rebuilt when it changes
+	VectorIndex    *vectorindex.Index
 
 	buildStatus     BuildStatus
+	buildUsage      *llm.UsageRecorder // LLM usage of the current or last build
 	buildStatusLock sync.RWMutex
 }
 
@@ -32,6 +45,7 @@ type BuildStatus struct {
 	EndTime       time.Time
 	Error         error
 	ProgressStage string
+	Usage         llm.UsageReport // LLM calls made by the build so far
 }
 
 // MetricMap represents a map of metric tokens to metric names.
@@ -71,10 +85,26 @@ type LabelInfo struct {
 // MetricLabelMap represents a map of metric names to their labels and values (sets).
 type MetricLabelMap map[string]MetricInfo // Nested map: metric -> label -> value set
 
+// HasMetric reports whether metric is in the map. With HasLabel it makes the
+// map a promql.Schema.
+func (m MetricLabelMap) HasMetric(metric string) bool {
+	_, ok := m[metric]
+	return ok
+}
+
+// HasLabel reports whether metric has label.
+func (m MetricLabelMap) HasLabel(metric, label string) bool {
+	_, ok := m[metric].Labels[label]
+	return ok
+}
+
 // LabelValueMap represents a map of label names to their values (sets).
 type LabelValueMap map[string]LabelInfo // Nested map: label -> value set
 
 // NlpToMetricMap represents a map of natural language queries to relevant metric-label pairs.
+//
+// Deprecated: the query history is kept in a QueryHistory. An NlpToMetricMap
+// is only read to migrate it (see MigrateNlpToMetricMap).
 type NlpToMetricMap map[string]string // Map: natural language query -> metric-label pair
 
 // QueryInterface defines the operations for querying metrics and labels.
@@ -94,11 +124,15 @@ type QueryEngine interface {
 
 // InfoStructureManager represents the manager for InfoStructure and its maps.
 type InfoStructureManager struct {
-	PathToMetricMap      string
-	PathToLabelMap       string
-	PathToMetricLabelMap string
-	PathToLabelValueMap  string
-	PathToNlpToMetricMap string
+	PathToMetricMap       string
+	PathToLabelMap        string
+	PathToMetricLabelMap  string
+	PathToLabelValueMap   string
+	PathToNlpToMetricMap  string
+	PathToVectorIndex     string // Optional; the vector index is not stored when empty
+	PathToValueIndex      string // Optional; the value index is not stored when empty
+	PathToHistory         string // Optional; the query history is not stored when empty
+	PathToLearnedSynonyms string // Optional; the learned synonyms are not stored when empty
 }
 
 // InfoLoaderSaver defines the operations for loading and saving the InfoStructure maps.
@@ -109,3 +143,41 @@ type InfoLoaderSaver interface {
 	// SaveInfoStructure saves all the maps in the InfoStructureManager.
 	SaveInfoStructure(metricMap MetricMap, labelMap LabelMap, metricLabelMap MetricLabelMap, labelValueMap LabelValueMap, nlpToMetricMap NlpToMetricMap) error
 }
+
+// HistorySaver saves the query history.
+type HistorySaver interface {
+	SaveHistory(history *QueryHistory) error
+}
+
+// HistoryLoaderSaver is implemented by InfoLoaderSavers that can also store
+// the query history.
+type HistoryLoaderSaver interface {
+	LoadHistory() (*QueryHistory, error)
+	HistorySaver
+}
+
+// LearnedSynonymsSaver saves the synonyms learned from feedback.
+type LearnedSynonymsSaver interface {
+	SaveLearnedSynonyms(synonyms *LearnedSynonyms) error
+}
+
+// LearnedSynonymsLoaderSaver is implemented by InfoLoaderSavers that can also
+// store the synonyms learned from feedback.
+type LearnedSynonymsLoaderSaver interface {
+	LoadLearnedSynonyms() (*LearnedSynonyms, error)
+	LearnedSynonymsSaver
+}
+
+// VectorIndexLoaderSaver is implemented by InfoLoaderSavers that can also
+// store the vector index of metric and label embeddings.
+type VectorIndexLoaderSaver interface {
+	LoadVectorIndex() (*vectorindex.Index, error)
+	SaveVectorIndex(index *vectorindex.Index) error
+}
+
+// ValueIndexLoaderSaver is implemented by InfoLoaderSavers that can also
+// store the value index.
+type ValueIndexLoaderSaver interface {
+	LoadValueIndex() (ValueIndex, error)
+	SaveValueIndex(index ValueIndex) error
+}
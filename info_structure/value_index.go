@@ -0,0 +1,83 @@
+package info_structure
+
+import (
+	"sort"
+
+	"github.com/prashantgupta17/nlpromql/matching"
+)
+
+// ValueRef is a label of a metric that has a value.
+type ValueRef struct {
+	Metric string `json:"metric"`
+	Label  string `json:"label"`
+	Value  string `json:"value"`
+}
+
+// ValueIndex is the reverse of MetricLabelMap: it maps normalized label
+// values (see matching.Normalize) to the labels of the metrics that have
+// them, so that the labels of a value are found without scanning every label.
+type ValueIndex struct {
+	Values  map[string][]ValueRef // Normalized value -> labels that have it
+	Metrics map[string]struct{}   // Indexed metrics
+}
+
+// ValueIndexJson is ValueIndex with its sets as lists, for saving.
+type ValueIndexJson struct {
+	Values  map[string][]ValueRef `json:"values"`
+	Metrics []string              `json:"metrics"`
+}
+
+// NewValueIndex returns an empty ValueIndex.
+func NewValueIndex() ValueIndex {
+	return ValueIndex{
+		Values:  make(map[string][]ValueRef),
+		Metrics: make(map[string]struct{}),
+	}
+}
+
+// BuildValueIndex indexes every metric of metricLabelMap.
+func BuildValueIndex(metricLabelMap MetricLabelMap) ValueIndex {
+	index := NewValueIndex()
+	index.Update(metricLabelMap)
+	return index
+}
+
+// Update indexes the metrics of metricLabelMap that are not indexed yet. The
+// builder only fetches the labels of new metrics, so indexed metrics do not
+// change.
+func (x ValueIndex) Update(metricLabelMap MetricLabelMap) {
+	metrics := make([]string, 0)
+	for metric := range metricLabelMap {
+		if _, ok := x.Metrics[metric]; !ok {
+			metrics = append(metrics, metric)
+		}
+	}
+	// Sorted, so that refs are in the same order whatever the map order.
+	sort.Strings(metrics)
+	for _, metric := range metrics {
+		x.Metrics[metric] = struct{}{}
+		for label, info := range metricLabelMap[metric].Labels {
+			for value := range info.Values {
+				norm := matching.Normalize(value)
+				x.Values[norm] = append(x.Values[norm], ValueRef{Metric: metric, Label: label, Value: value})
+			}
+		}
+	}
+}
+
+// Lookup returns the labels that have value itself.
+func (x ValueIndex) Lookup(value string) []ValueRef {
+	var refs []ValueRef
+	for _, ref := range x.Values[matching.Normalize(value)] {
+		if ref.Value == value {
+			refs = append(refs, ref)
+		}
+	}
+	return refs
+}
+
+// LookupNormalized returns the labels that have a value that normalizes like
+// value, e.g. "Production" for "production".
+func (x ValueIndex) LookupNormalized(value string) []ValueRef {
+	return x.Values[matching.Normalize(value)]
+}
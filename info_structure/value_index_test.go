@@ -0,0 +1,214 @@
+package info_structure_test
+
+import (
+	"fmt"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"testing"
+
+	"github.com/prashantgupta17/nlpromql/info_structure"
+	"github.com/prashantgupta17/nlpromql/prometheus"
+)
+
+func labels(values map[string][]string) info_structure.MetricInfo {
+	info := info_structure.MetricInfo{Labels: make(map[string]info_structure.LabelInfo)}
+	for label, list := range values {
+		set := make(map[string]struct{}, len(list))
+		for _, value := range list {
+			set[value] = struct{}{}
+		}
+		info.Labels[label] = info_structure.LabelInfo{Values: set}
+	}
+	return info
+}
+
+func sortedRefs(refs []info_structure.ValueRef) []info_structure.ValueRef {
+	sorted := append([]info_structure.ValueRef(nil), refs...)
+	sort.Slice(sorted, func(i, j int) bool {
+		return fmt.Sprint(sorted[i]) < fmt.Sprint(sorted[j])
+	})
+	return sorted
+}
+
+func TestValueIndex(t *testing.T) {
+	metricLabelMap := info_structure.MetricLabelMap{
+		"http_requests_total": labels(map[string][]string{"job": {"api", "web"}, "env": {"Production"}}),
+		"up":                  labels(map[string][]string{"job": {"api"}, "instance": {"host1"}}),
+	}
+	index := info_structure.BuildValueIndex(metricLabelMap)
+
+	tests := []struct {
+		name     string
+		lookup   func(string) []info_structure.ValueRef
+		value    string
+		expected []info_structure.ValueRef
+	}{
+		{"Value of several metrics", index.Lookup, "api", []info_structure.ValueRef{
+			{Metric: "http_requests_total", Label: "job", Value: "api"},
+			{Metric: "up", Label: "job", Value: "api"},
+		}},
+		{"Exact lookup is case sensitive", index.Lookup, "production", nil},
+		{"Normalized lookup", index.LookupNormalized, "production", []info_structure.ValueRef{
+			{Metric: "http_requests_total", Label: "env", Value: "Production"},
+		}},
+		{"Unknown value", index.Lookup, "db", nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := sortedRefs(tt.lookup(tt.value)); !reflect.DeepEqual(got, tt.expected) {
+				t.Errorf("lookup of %q = %v, expected %v", tt.value, got, tt.expected)
+			}
+		})
+	}
+
+	// Update only adds metrics that are not indexed yet.
+	metricLabelMap["node_load1"] = labels(map[string][]string{"instance": {"host1"}})
+	index.Update(metricLabelMap)
+	if got := index.Lookup("host1"); len(got) != 2 {
+		t.Errorf("expected host1 on 2 metrics after the update, got %v", got)
+	}
+	if got := index.Lookup("api"); len(got) != 2 {
+		t.Errorf("expected indexed metrics not to be indexed again, got %v", got)
+	}
+}
+
+func TestInfoStructureManager_ValueIndex(t *testing.T) {
+	manager := &info_structure.InfoStructureManager{PathToValueIndex: filepath.Join(t.TempDir(), "value_index.json")}
+
+	empty, err := manager.LoadValueIndex()
+	if err != nil {
+		t.Fatalf("LoadValueIndex() of a missing file error = %v", err)
+	}
+	if len(empty.Metrics) != 0 || empty.Values == nil {
+		t.Errorf("expected an empty, usable index from a missing file, got %+v", empty)
+	}
+
+	index := info_structure.BuildValueIndex(info_structure.MetricLabelMap{
+		"up": labels(map[string][]string{"job": {"api"}, "instance": {"host1"}}),
+	})
+	if err := manager.SaveValueIndex(index); err != nil {
+		t.Fatalf("SaveValueIndex() error = %v", err)
+	}
+	loaded, err := manager.LoadValueIndex()
+	if err != nil {
+		t.Fatalf("LoadValueIndex() error = %v", err)
+	}
+	if !reflect.DeepEqual(loaded, index) {
+		t.Errorf("loaded index %+v, expected %+v", loaded, index)
+	}
+}
+
+// MockValueIndexLoaderSaver_BuilderTest also stores the value index, in memory.
+type MockValueIndexLoaderSaver_BuilderTest struct {
+	MockInfoLoaderSaver_BuilderTest
+	Stored *info_structure.ValueIndex
+}
+
+func (m *MockValueIndexLoaderSaver_BuilderTest) LoadValueIndex() (info_structure.ValueIndex, error) {
+	if m.Stored == nil {
+		return info_structure.NewValueIndex(), nil
+	}
+	return *m.Stored, nil
+}
+
+func (m *MockValueIndexLoaderSaver_BuilderTest) SaveValueIndex(index info_structure.ValueIndex) error {
+	m.Stored = &index
+	return nil
+}
+
+var _ info_structure.ValueIndexLoaderSaver = (*MockValueIndexLoaderSaver_BuilderTest)(nil)
+
+func TestBuildInformationStructure_ValueIndex(t *testing.T) {
+	mockQueryEngine := &MockQueryEngine_BuilderTest{
+		AllMetricsFunc: func() ([]string, error) { return []string{"up"}, nil },
+		CustomQueryFunc: func(query string) ([]prometheus.Metric, error) {
+			return []prometheus.Metric{
+				{Metric: map[string]string{"__name__": "up", "job": "api", "instance": "host1"}},
+				{Metric: map[string]string{"__name__": "up", "job": "web", "instance": "host1"}},
+			}, nil
+		},
+	}
+	loaderSaver := &MockValueIndexLoaderSaver_BuilderTest{}
+	builder, err := info_structure.NewInfoBuilder(mockQueryEngine, &MockLLMClient_BuilderTest{}, loaderSaver)
+	if err != nil {
+		t.Fatalf("NewInfoBuilder() error = %v", err)
+	}
+	if err := builder.BuildInformationStructure(); err != nil {
+		t.Fatalf("BuildInformationStructure() error = %v", err)
+	}
+
+	if loaderSaver.Stored == nil {
+		t.Fatalf("expected the value index to be saved")
+	}
+	if got := loaderSaver.Stored.Lookup("web"); len(got) != 1 || got[0] != (info_structure.ValueRef{Metric: "up", Label: "job", Value: "web"}) {
+		t.Errorf("expected web to be a job of up, got %v", got)
+	}
+	if got := builder.ValueIndex.Lookup("host1"); len(got) != 1 {
+		t.Errorf("expected the builder's index to hold host1 once, got %v", got)
+	}
+}
+
+// benchmarkMaps returns a MetricLabelMap of 10 metrics per label, each label
+// with 20 values, and the matching LabelValueMap.
+func benchmarkMaps(numLabels int) (info_structure.MetricLabelMap, info_structure.LabelValueMap) {
+	metricLabelMap := make(info_structure.MetricLabelMap)
+	labelValueMap := make(info_structure.LabelValueMap)
+	for l := 0; l < numLabels; l++ {
+		label := fmt.Sprintf("label_%d", l)
+		values := make(map[string]struct{})
+		for v := 0; v < 20; v++ {
+			values[fmt.Sprintf("value_%d_%d", l, v)] = struct{}{}
+		}
+		labelValueMap[label] = info_structure.LabelInfo{Values: values}
+		for m := 0; m < 10; m++ {
+			metric := fmt.Sprintf("metric_%d", (l*10+m)%(numLabels*2))
+			if _, ok := metricLabelMap[metric]; !ok {
+				metricLabelMap[metric] = info_structure.MetricInfo{Labels: make(map[string]info_structure.LabelInfo)}
+			}
+			metricLabelMap[metric].Labels[label] = info_structure.LabelInfo{Values: values}
+		}
+	}
+	return metricLabelMap, labelValueMap
+}
+
+// BenchmarkValueLookup finds the labels of 30 values, with the value index
+// and by scanning every label as retrieval used to.
+func BenchmarkValueLookup(b *testing.B) {
+	for _, numLabels := range []int{500, 5000} {
+		metricLabelMap, labelValueMap := benchmarkMaps(numLabels)
+		index := info_structure.BuildValueIndex(metricLabelMap)
+		values := make([]string, 30)
+		for i := range values {
+			values[i] = fmt.Sprintf("value_%d_%d", i*numLabels/30, i%20)
+		}
+
+		b.Run(fmt.Sprintf("index/labels=%d", numLabels), func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				for _, value := range values {
+					index.Lookup(value)
+				}
+			}
+		})
+		b.Run(fmt.Sprintf("scan/labels=%d", numLabels), func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				for _, value := range values {
+					for _, info := range labelValueMap {
+						_, _ = info.Values[value]
+					}
+				}
+			}
+		})
+	}
+}
+
+func BenchmarkBuildValueIndex(b *testing.B) {
+	for _, numLabels := range []int{500, 5000} {
+		metricLabelMap, _ := benchmarkMaps(numLabels)
+		b.Run(fmt.Sprintf("labels=%d", numLabels), func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				info_structure.BuildValueIndex(metricLabelMap)
+			}
+		})
+	}
+}
@@ -0,0 +1,287 @@
+package langchain
+
+import (
+	"encoding/json"
+	"sort"
+	"strings"
+
+	"github.com/prashantgupta17/nlpromql/llm"
+	"github.com/tmc/langchaingo/llms"
+)
+
+// TokenCounter returns the number of tokens text occupies for a model.
+type TokenCounter func(text string) int
+
+// completionReserve is the number of tokens kept free for the model's answer
+// when a budget is derived from the model's context size.
+const completionReserve = 4096
+
+// modelContextSizes lists the context window of models that
+// llms.GetModelContextSize does not know, or only knows an older size of,
+// keyed by the model identifier without its provider prefix.
+var modelContextSizes = map[string]int{
+	"gpt-3.5-turbo": 16385, // llms has the original 4096
+	"gpt-4-turbo":   128000,
+	"gpt-4o":        128000,
+	"gpt-4o-mini":   128000,
+	"claude-2":      100000,
+	"claude-2.1":    200000,
+	"claude-3":      200000,
+}
+
+// DefaultTokenBudget returns a prompt budget for modelName (e.g.
+// "openai/gpt-4"): its context size minus room for the completion.
+// It returns 0, meaning no budget, for unknown models.
+func DefaultTokenBudget(modelName string) int {
+	modelID := modelName
+	if idx := strings.Index(modelName, "/"); idx >= 0 {
+		modelID = modelName[idx+1:]
+	}
+	size := contextSize(modelID)
+	if size <= completionReserve {
+		return 0
+	}
+	return size - completionReserve
+}
+
+// contextSize returns the context window of modelID, from modelContextSizes
+// or else from llms.GetModelContextSize.
+func contextSize(modelID string) int {
+	// Use the longest known prefix, so "gpt-4o-mini-2024-07-18" matches
+	// "gpt-4o-mini" and "claude-3-opus-20240229" matches "claude-3".
+	size, matched := 0, ""
+	for prefix, s := range modelContextSizes {
+		if strings.HasPrefix(modelID, prefix) && len(prefix) > len(matched) {
+			size, matched = s, prefix
+		}
+	}
+	if matched != "" {
+		return size
+	}
+	// llms.GetModelContextSize only knows exact identifiers, so drop suffixes
+	// such as "-0613" until it knows one. Its default of 2048 for unknown
+	// models is below completionReserve, so it never makes a budget.
+	for id := modelID; ; {
+		if s := llms.GetModelContextSize(id); s > completionReserve {
+			return s
+		}
+		idx := strings.LastIndex(id, "-")
+		if idx < 0 {
+			return 0
+		}
+		id = id[:idx]
+	}
+}
+
+// promptContext holds the relevance data that goes into the PromQL prompt.
+// It is a deep copy, so trimming it never modifies the caller's maps.
+type promptContext struct {
+	metrics      llm.RelevantMetricsMap
+	labels       llm.RelevantLabelsMap
+	history      map[string]interface{}
+	metricScores llm.MetricScores // Retrieval ranking of metrics; may be nil
+}
+
+func newPromptContext(metrics llm.RelevantMetricsMap, labels llm.RelevantLabelsMap, history map[string]interface{}, metricScores llm.MetricScores) *promptContext {
+	pc := &promptContext{
+		metrics:      make(llm.RelevantMetricsMap, len(metrics)),
+		labels:       make(llm.RelevantLabelsMap, len(labels)),
+		history:      history,
+		metricScores: metricScores,
+	}
+	for metric, metricLabels := range metrics {
+		pc.metrics[metric] = make(map[string]llm.LabelContextDetail, len(metricLabels))
+		for label, detail := range metricLabels {
+			pc.metrics[metric][label] = copyDetail(detail)
+		}
+	}
+	for label, detail := range labels {
+		pc.labels[label] = copyDetail(detail)
+	}
+	return pc
+}
+
+func copyDetail(detail llm.LabelContextDetail) llm.LabelContextDetail {
+	detail.Values = append([]string(nil), detail.Values...)
+	return detail
+}
+
+type unitKind int
+
+// Unit kinds in the order they are dropped when their scores are equal.
+const (
+	unitValue unitKind = iota
+	unitMetricLabel
+	unitLabel
+	unitMetric
+)
+
+// contextUnit is a piece of relevance data that can be dropped from the prompt.
+type contextUnit struct {
+	kind   unitKind
+	score  float64
+	metric string // Empty for entries of Relevant Labels
+	label  string
+	value  string
+	index  int // Position of value in its list; later values are dropped first
+}
+
+// units lists everything that can be dropped, lowest score first.
+// A metric takes its score from the retrieval ranking. Metrics the ranking
+// does not cover score 1 for having matched plus the scores of their labels.
+// Values take the score of the label they belong to.
+func (pc *promptContext) units() []contextUnit {
+	var units []contextUnit
+	for metric, metricLabels := range pc.metrics {
+		labelScore := 1.0
+		for label, detail := range metricLabels {
+			labelScore += detail.MatchScore
+			units = append(units, contextUnit{kind: unitMetricLabel, score: detail.MatchScore, metric: metric, label: label})
+			for i, value := range detail.Values {
+				units = append(units, contextUnit{kind: unitValue, score: detail.MatchScore, metric: metric, label: label, value: value, index: i})
+			}
+		}
+		metricScore, ok := pc.metricScores[metric]
+		if !ok {
+			metricScore = labelScore
+		}
+		units = append(units, contextUnit{kind: unitMetric, score: metricScore, metric: metric})
+	}
+	for label, detail := range pc.labels {
+		units = append(units, contextUnit{kind: unitLabel, score: detail.MatchScore, label: label})
+		for i, value := range detail.Values {
+			units = append(units, contextUnit{kind: unitValue, score: detail.MatchScore, label: label, value: value, index: i})
+		}
+	}
+	sort.Slice(units, func(i, j int) bool {
+		a, b := units[i], units[j]
+		if a.score != b.score {
+			return a.score < b.score
+		}
+		if a.kind != b.kind {
+			return a.kind < b.kind
+		}
+		if a.index != b.index {
+			return a.index > b.index
+		}
+		if a.metric != b.metric {
+			return a.metric < b.metric
+		}
+		return a.label < b.label
+	})
+	return units
+}
+
+// drop removes u from pc, records it in dropped and returns an estimate of
+// the tokens saved. It returns 0 if u was already removed with its parent.
+func (pc *promptContext) drop(u contextUnit, dropped *llm.DroppedContext, count TokenCounter) int {
+	switch u.kind {
+	case unitValue:
+		var detail llm.LabelContextDetail
+		var ok bool
+		key := u.label
+		if u.metric != "" {
+			key = u.metric + "/" + u.label
+			detail, ok = pc.metrics[u.metric][u.label]
+		} else {
+			detail, ok = pc.labels[u.label]
+		}
+		if !ok {
+			return 0
+		}
+		remaining := detail.Values[:0]
+		found := false
+		for _, v := range detail.Values {
+			if v == u.value && !found {
+				found = true
+				continue
+			}
+			remaining = append(remaining, v)
+		}
+		if !found {
+			return 0
+		}
+		detail.Values = remaining
+		if u.metric != "" {
+			pc.metrics[u.metric][u.label] = detail
+		} else {
+			pc.labels[u.label] = detail
+		}
+		if dropped.Values == nil {
+			dropped.Values = make(map[string][]string)
+		}
+		dropped.Values[key] = append(dropped.Values[key], u.value)
+		return count(`"` + u.value + `",`)
+	case unitMetricLabel:
+		detail, ok := pc.metrics[u.metric][u.label]
+		if !ok {
+			return 0
+		}
+		delete(pc.metrics[u.metric], u.label)
+		if dropped.MetricLabels == nil {
+			dropped.MetricLabels = make(map[string][]string)
+		}
+		dropped.MetricLabels[u.metric] = append(dropped.MetricLabels[u.metric], u.label)
+		return count(u.label) + estimateJSON(detail, count)
+	case unitLabel:
+		detail, ok := pc.labels[u.label]
+		if !ok {
+			return 0
+		}
+		delete(pc.labels, u.label)
+		dropped.Labels = append(dropped.Labels, u.label)
+		return count(u.label) + estimateJSON(detail, count)
+	case unitMetric:
+		metricLabels, ok := pc.metrics[u.metric]
+		if !ok {
+			return 0
+		}
+		delete(pc.metrics, u.metric)
+		dropped.Metrics = append(dropped.Metrics, u.metric)
+		return count(u.metric) + estimateJSON(metricLabels, count)
+	}
+	return 0
+}
+
+func estimateJSON(v interface{}, count TokenCounter) int {
+	data, err := json.MarshalIndent(v, "", "  ")
+	if err != nil {
+		return 0
+	}
+	return count(string(data))
+}
+
+// fitToBudget drops the lowest-scored metrics, labels and values from pc
+// until the prompt produced by render fits within budget tokens. It returns
+// nil if nothing had to be dropped. History is never dropped; if the prompt
+// is still too large once everything else is gone, it is sent as is.
+func fitToBudget(pc *promptContext, budget int, count TokenCounter, render func(*promptContext) (string, error)) (*llm.DroppedContext, error) {
+	if budget <= 0 {
+		return nil, nil
+	}
+	prompt, err := render(pc)
+	if err != nil {
+		return nil, err
+	}
+	tokens := count(prompt)
+	if tokens <= budget {
+		return nil, nil
+	}
+
+	dropped := &llm.DroppedContext{Budget: budget}
+	units := pc.units()
+	for i := 0; tokens > budget && i < len(units); {
+		// Drop units until their estimated size covers the overflow, then
+		// measure the real prompt again: estimates ignore JSON overhead.
+		overflow := tokens - budget
+		for saved := 0; saved < overflow && i < len(units); i++ {
+			saved += pc.drop(units[i], dropped, count)
+		}
+		if prompt, err = render(pc); err != nil {
+			return nil, err
+		}
+		tokens = count(prompt)
+	}
+	dropped.PromptTokens = tokens
+	return dropped, nil
+}
@@ -0,0 +1,159 @@
+package langchain_test
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/prashantgupta17/nlpromql/langchain"
+	"github.com/prashantgupta17/nlpromql/llm"
+	"github.com/prashantgupta17/nlpromql/prompts"
+	"github.com/tmc/langchaingo/llms"
+)
+
+func TestLangChainClient_GetPromQLFromLLM_TokenBudget(t *testing.T) {
+	metrics := llm.RelevantMetricsMap{
+		"http_requests_total": {
+			"job":    llm.LabelContextDetail{MatchScore: 3.0, Values: []string{"api", "web"}},
+			"status": llm.LabelContextDetail{MatchScore: 1.0, Values: []string{"200", "500"}},
+		},
+		"process_cpu_seconds_total": {
+			"instance": llm.LabelContextDetail{MatchScore: 0.2, Values: []string{"host-a", "host-b", "host-c"}},
+		},
+	}
+	labels := llm.RelevantLabelsMap{
+		"environment": llm.LabelContextDetail{MatchScore: 0.5, Values: []string{"dev", "prod"}},
+	}
+
+	var userPrompt string
+	mock := &mockLLM{
+		GenerateContentFunc: func(ctx context.Context, messages []llms.MessageContent, options ...llms.CallOption) (*llms.ContentResponse, error) {
+			userPrompt = messages[1].Parts[0].(llms.TextContent).Text
+			return &llms.ContentResponse{Choices: []*llms.ContentChoice{{Content: `[{"promql": "rate(http_requests_total[5m])", "score": 1}]`}}}, nil
+		},
+	}
+
+	systemPrompt, err := prompts.Default().Render(prompts.System, nil)
+	if err != nil {
+		t.Fatalf("unexpected error rendering system prompt: %v", err)
+	}
+	// mockLLM counts one token per byte. Leave room for the system prompt and
+	// roughly half of the relevance data.
+	budget := len(systemPrompt) + 550
+	client := langchain.NewLangChainClient(mock, langchain.WithTokenBudget(budget))
+
+	result, err := client.GetPromQLFromLLM(context.Background(), "requests per job", metrics, labels, map[string]interface{}{}, nil, nil)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if result.Dropped == nil {
+		t.Fatalf("expected context to be dropped, got nil")
+	}
+	if result.Dropped.PromptTokens > budget {
+		t.Errorf("prompt of %d tokens exceeds budget %d", result.Dropped.PromptTokens, budget)
+	}
+	if len(systemPrompt)+1+len(userPrompt) != result.Dropped.PromptTokens {
+		t.Errorf("reported prompt size %d does not match the prompt sent", result.Dropped.PromptTokens)
+	}
+	if !strings.Contains(userPrompt, `"job"`) {
+		t.Errorf("highest-scored label was dropped. Prompt:\n%s", userPrompt)
+	}
+	if strings.Contains(userPrompt, "host-c") {
+		t.Errorf("lowest-scored values were kept. Prompt:\n%s", userPrompt)
+	}
+	if len(result.Dropped.Values["process_cpu_seconds_total/instance"]) == 0 {
+		t.Errorf("expected dropped values of the lowest-scored label to be reported, got %+v", result.Dropped)
+	}
+
+	// The caller's maps must not be modified.
+	if len(metrics["process_cpu_seconds_total"]["instance"].Values) != 3 || len(labels) != 1 {
+		t.Errorf("GetPromQLFromLLM modified its input maps")
+	}
+
+	// Without a budget nothing is dropped.
+	unlimited := langchain.NewLangChainClient(mock)
+	result, err = unlimited.GetPromQLFromLLM(context.Background(), "requests per job", metrics, labels, map[string]interface{}{}, nil, nil)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if result.Dropped != nil {
+		t.Errorf("expected nothing to be dropped without a budget, got %+v", result.Dropped)
+	}
+}
+
+func TestLangChainClient_GetPromQLFromLLM_TokenBudgetRanking(t *testing.T) {
+	// node_load1 has more matching labels, but http_requests_total ranks
+	// higher, so it is the one kept.
+	metrics := llm.RelevantMetricsMap{
+		"http_requests_total": {
+			"job": llm.LabelContextDetail{MatchScore: 0.1},
+		},
+		"node_load1": {
+			"instance": llm.LabelContextDetail{MatchScore: 2.0},
+			"job":      llm.LabelContextDetail{MatchScore: 2.0},
+			"cpu":      llm.LabelContextDetail{MatchScore: 2.0},
+		},
+	}
+	scores := llm.MetricScores{"http_requests_total": 8.0, "node_load1": 0.5}
+
+	var userPrompt string
+	mock := &mockLLM{
+		GenerateContentFunc: func(ctx context.Context, messages []llms.MessageContent, options ...llms.CallOption) (*llms.ContentResponse, error) {
+			userPrompt = messages[1].Parts[0].(llms.TextContent).Text
+			return &llms.ContentResponse{Choices: []*llms.ContentChoice{{Content: `[{"promql": "rate(http_requests_total[5m])", "score": 1}]`}}}, nil
+		},
+	}
+	systemPrompt, err := prompts.Default().Render(prompts.System, nil)
+	if err != nil {
+		t.Fatalf("unexpected error rendering system prompt: %v", err)
+	}
+	if _, err := langchain.NewLangChainClient(mock).GetPromQLFromLLM(context.Background(), "requests", metrics, llm.RelevantLabelsMap{}, map[string]interface{}{}, nil, nil); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	// Too small for both metrics, whichever labels are dropped first.
+	budget := len(systemPrompt) + 1 + len(userPrompt) - 100
+
+	tests := []struct {
+		name    string
+		scores  llm.MetricScores
+		dropped string
+	}{
+		{"Ranking", scores, "node_load1"},
+		{"Label scores", nil, "http_requests_total"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			client := langchain.NewLangChainClient(mock, langchain.WithTokenBudget(budget))
+			result, err := client.GetPromQLFromLLM(context.Background(), "requests", metrics, llm.RelevantLabelsMap{}, map[string]interface{}{}, nil, tt.scores)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if result.Dropped == nil || len(result.Dropped.Metrics) != 1 || result.Dropped.Metrics[0] != tt.dropped {
+				t.Fatalf("expected %s to be dropped, got %+v", tt.dropped, result.Dropped)
+			}
+			if strings.Contains(userPrompt, `"`+tt.dropped+`"`) {
+				t.Errorf("dropped metric %s is still in the prompt:\n%s", tt.dropped, userPrompt)
+			}
+		})
+	}
+}
+
+func TestDefaultTokenBudget(t *testing.T) {
+	tests := []struct {
+		model    string
+		expected int
+	}{
+		{"openai/gpt-4", 8192 - 4096},
+		{"openai/gpt-4-32k", 32768 - 4096},
+		{"openai/gpt-4-0613", 8192 - 4096},
+		{"openai/gpt-3.5-turbo-0125", 16385 - 4096},
+		{"openai/gpt-4o-mini-2024-07-18", 128000 - 4096},
+		{"anthropic/claude-3-opus-20240229", 200000 - 4096},
+		{"openai/unknown-model", 0},
+	}
+	for _, tt := range tests {
+		if got := langchain.DefaultTokenBudget(tt.model); got != tt.expected {
+			t.Errorf("DefaultTokenBudget(%q) = %d, expected %d", tt.model, got, tt.expected)
+		}
+	}
+}
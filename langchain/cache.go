@@ -0,0 +1,192 @@
+package langchain
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/tmc/langchaingo/llms"
+)
+
+// ResponseCache is a disk-backed cache of LLM responses. Each entry is stored
+// as a JSON file named after the hash of the model, the messages and the call
+// options that produced it.
+type ResponseCache struct {
+	dir        string
+	ttl        time.Duration // Zero means entries never expire
+	maxEntries int           // Zero means no limit
+
+	mu        sync.Mutex
+	hits      int64
+	misses    int64
+	evictions int64
+}
+
+// CacheStats holds counters describing how the cache has been used since it was created.
+type CacheStats struct {
+	Hits      int64 `json:"hits"`
+	Misses    int64 `json:"misses"`
+	Evictions int64 `json:"evictions"`
+	Entries   int   `json:"entries"`
+}
+
+// cacheEntry is the on-disk representation of a cached response.
+type cacheEntry struct {
+	Model     string                `json:"model"`
+	CreatedAt time.Time             `json:"created_at"`
+	Response  *llms.ContentResponse `json:"response"`
+}
+
+// NewResponseCache creates a ResponseCache that stores its entries in dir,
+// creating the directory if needed.
+func NewResponseCache(dir string, ttl time.Duration, maxEntries int) (*ResponseCache, error) {
+	if err := os.MkdirAll(dir, os.ModePerm); err != nil {
+		return nil, fmt.Errorf("error creating cache directory: %v", err)
+	}
+	return &ResponseCache{
+		dir:        dir,
+		ttl:        ttl,
+		maxEntries: maxEntries,
+	}, nil
+}
+
+// Key returns the cache key for a request: a SHA-256 hash of the model name,
+// the messages and the resolved call options.
+func (rc *ResponseCache) Key(model string, messages []llms.MessageContent, options ...llms.CallOption) (string, error) {
+	var callOptions llms.CallOptions
+	for _, opt := range options {
+		opt(&callOptions)
+	}
+	payload, err := json.Marshal(struct {
+		Model    string                `json:"model"`
+		Messages []llms.MessageContent `json:"messages"`
+		Options  llms.CallOptions      `json:"options"`
+	}{model, messages, callOptions})
+	if err != nil {
+		return "", fmt.Errorf("error marshalling cache key: %v", err)
+	}
+	sum := sha256.Sum256(payload)
+	return hex.EncodeToString(sum[:]), nil
+}
+
+// Get returns the cached response for key, if present and not expired.
+func (rc *ResponseCache) Get(key string) (*llms.ContentResponse, bool) {
+	rc.mu.Lock()
+	defer rc.mu.Unlock()
+
+	data, err := os.ReadFile(rc.entryPath(key))
+	if err != nil {
+		rc.misses++
+		return nil, false
+	}
+	var entry cacheEntry
+	if err := json.Unmarshal(data, &entry); err != nil || entry.Response == nil {
+		// A corrupt entry is treated as a miss and removed.
+		os.Remove(rc.entryPath(key))
+		rc.misses++
+		return nil, false
+	}
+	if rc.ttl > 0 && time.Since(entry.CreatedAt) > rc.ttl {
+		os.Remove(rc.entryPath(key))
+		rc.misses++
+		return nil, false
+	}
+	rc.hits++
+	return entry.Response, true
+}
+
+// Put stores response under key, evicting the oldest entries if the cache
+// grows beyond its size limit.
+func (rc *ResponseCache) Put(key, model string, response *llms.ContentResponse) error {
+	rc.mu.Lock()
+	defer rc.mu.Unlock()
+
+	data, err := json.Marshal(cacheEntry{Model: model, CreatedAt: time.Now(), Response: response})
+	if err != nil {
+		return fmt.Errorf("error marshalling cache entry: %v", err)
+	}
+	// Write to a temporary file first so a concurrent reader never sees a partial entry.
+	tmpPath := rc.entryPath(key) + ".tmp"
+	if err := os.WriteFile(tmpPath, data, 0o644); err != nil {
+		return fmt.Errorf("error writing cache entry: %v", err)
+	}
+	if err := os.Rename(tmpPath, rc.entryPath(key)); err != nil {
+		return fmt.Errorf("error writing cache entry: %v", err)
+	}
+	return rc.evictLocked()
+}
+
+// Stats returns the hit/miss counters and the current number of entries.
+func (rc *ResponseCache) Stats() CacheStats {
+	rc.mu.Lock()
+	defer rc.mu.Unlock()
+	entries, _ := rc.listEntriesLocked()
+	return CacheStats{
+		Hits:      rc.hits,
+		Misses:    rc.misses,
+		Evictions: rc.evictions,
+		Entries:   len(entries),
+	}
+}
+
+// evictLocked removes the oldest entries until the cache is within maxEntries.
+// The caller must hold rc.mu.
+func (rc *ResponseCache) evictLocked() error {
+	if rc.maxEntries <= 0 {
+		return nil
+	}
+	entries, err := rc.listEntriesLocked()
+	if err != nil {
+		return err
+	}
+	if len(entries) <= rc.maxEntries {
+		return nil
+	}
+	sort.Slice(entries, func(i, j int) bool {
+		return entries[i].modTime.Before(entries[j].modTime)
+	})
+	for _, e := range entries[:len(entries)-rc.maxEntries] {
+		if err := os.Remove(filepath.Join(rc.dir, e.name)); err != nil && !os.IsNotExist(err) {
+			return fmt.Errorf("error evicting cache entry: %v", err)
+		}
+		rc.evictions++
+	}
+	return nil
+}
+
+type cacheFile struct {
+	name    string
+	modTime time.Time
+}
+
+// listEntriesLocked lists the entry files in the cache directory.
+// The caller must hold rc.mu.
+func (rc *ResponseCache) listEntriesLocked() ([]cacheFile, error) {
+	dirEntries, err := os.ReadDir(rc.dir)
+	if err != nil {
+		return nil, fmt.Errorf("error reading cache directory: %v", err)
+	}
+	files := make([]cacheFile, 0, len(dirEntries))
+	for _, de := range dirEntries {
+		if de.IsDir() || !strings.HasSuffix(de.Name(), ".json") {
+			continue
+		}
+		info, err := de.Info()
+		if err != nil {
+			continue
+		}
+		files = append(files, cacheFile{name: de.Name(), modTime: info.ModTime()})
+	}
+	return files, nil
+}
+
+func (rc *ResponseCache) entryPath(key string) string {
+	return filepath.Join(rc.dir, key+".json")
+}
@@ -0,0 +1,126 @@
+package langchain_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/prashantgupta17/nlpromql/langchain"
+	"github.com/prashantgupta17/nlpromql/llm"
+	"github.com/tmc/langchaingo/llms"
+)
+
+func TestLangChainClient_ResponseCache(t *testing.T) {
+	cache, err := langchain.NewResponseCache(t.TempDir(), 0, 0)
+	if err != nil {
+		t.Fatalf("NewResponseCache returned an unexpected error: %v", err)
+	}
+
+	calls := 0
+	mock := &mockLLM{
+		CallFunc: func(ctx context.Context, prompt string, options ...llms.CallOption) (string, error) {
+			calls++
+			return `{"possible_metric_names": ["cpu"]}`, nil
+		},
+	}
+	client := langchain.NewLangChainClient(mock, langchain.WithModelName("mock/model"), langchain.WithCache(cache))
+
+	ctx := context.Background()
+	for i := 0; i < 2; i++ {
+		if _, err := client.ProcessUserQuery(ctx, "cpu usage"); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	}
+	if calls != 1 {
+		t.Errorf("expected 1 LLM call for two identical queries, got %d", calls)
+	}
+
+	if _, err := client.ProcessUserQuery(ctx, "memory usage"); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if calls != 2 {
+		t.Errorf("expected a different prompt to miss the cache, got %d calls", calls)
+	}
+
+	if _, err := client.ProcessUserQuery(llm.WithCacheBypass(ctx), "cpu usage"); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if calls != 3 {
+		t.Errorf("expected bypass to call the LLM, got %d calls", calls)
+	}
+
+	stats := cache.Stats()
+	if stats.Hits != 1 || stats.Misses != 2 || stats.Entries != 2 {
+		t.Errorf("unexpected cache stats: %+v", stats)
+	}
+
+	// A client for a different model must not share entries.
+	otherClient := langchain.NewLangChainClient(mock, langchain.WithModelName("mock/other"), langchain.WithCache(cache))
+	if _, err := otherClient.ProcessUserQuery(ctx, "cpu usage"); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if calls != 4 {
+		t.Errorf("expected a different model to miss the cache, got %d calls", calls)
+	}
+}
+
+func TestResponseCache_TTLAndEviction(t *testing.T) {
+	response := &llms.ContentResponse{Choices: []*llms.ContentChoice{{Content: "cached"}}}
+	messages := []llms.MessageContent{llms.TextParts(llms.ChatMessageTypeHuman, "prompt")}
+
+	t.Run("expired entries are misses", func(t *testing.T) {
+		cache, err := langchain.NewResponseCache(t.TempDir(), time.Nanosecond, 0)
+		if err != nil {
+			t.Fatalf("NewResponseCache returned an unexpected error: %v", err)
+		}
+		key, err := cache.Key("m", messages)
+		if err != nil {
+			t.Fatalf("Key returned an unexpected error: %v", err)
+		}
+		if err := cache.Put(key, "m", response); err != nil {
+			t.Fatalf("Put returned an unexpected error: %v", err)
+		}
+		time.Sleep(time.Millisecond)
+		if _, ok := cache.Get(key); ok {
+			t.Errorf("expected expired entry to be a miss")
+		}
+	})
+
+	t.Run("options are part of the key", func(t *testing.T) {
+		cache, err := langchain.NewResponseCache(t.TempDir(), 0, 0)
+		if err != nil {
+			t.Fatalf("NewResponseCache returned an unexpected error: %v", err)
+		}
+		k1, _ := cache.Key("m", messages, llms.WithTemperature(0.1))
+		k2, _ := cache.Key("m", messages, llms.WithTemperature(0.7))
+		if k1 == k2 {
+			t.Errorf("expected different temperatures to produce different keys")
+		}
+	})
+
+	t.Run("oldest entries are evicted", func(t *testing.T) {
+		cache, err := langchain.NewResponseCache(t.TempDir(), 0, 2)
+		if err != nil {
+			t.Fatalf("NewResponseCache returned an unexpected error: %v", err)
+		}
+		var keys []string
+		for _, prompt := range []string{"a", "b", "c"} {
+			key, _ := cache.Key("m", []llms.MessageContent{llms.TextParts(llms.ChatMessageTypeHuman, prompt)})
+			if err := cache.Put(key, "m", response); err != nil {
+				t.Fatalf("Put returned an unexpected error: %v", err)
+			}
+			keys = append(keys, key)
+			time.Sleep(10 * time.Millisecond) // Distinct modification times
+		}
+		if _, ok := cache.Get(keys[0]); ok {
+			t.Errorf("expected oldest entry to be evicted")
+		}
+		got, ok := cache.Get(keys[2])
+		if !ok || got.Choices[0].Content != "cached" {
+			t.Errorf("expected newest entry to be served from cache, got %v", got)
+		}
+		if stats := cache.Stats(); stats.Entries != 2 || stats.Evictions != 1 {
+			t.Errorf("unexpected cache stats: %+v", stats)
+		}
+	})
+}
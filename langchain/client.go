@@ -5,8 +5,11 @@ import (
 	"encoding/json"
 	"errors"
 	"fmt"
+	"log"
+	"strings"
 	"sync"
 
+	"github.com/prashantgupta17/nlpromql/examples"
 	"github.com/prashantgupta17/nlpromql/llm"
 	"github.com/prashantgupta17/nlpromql/prompts"
 	"github.com/tmc/langchaingo/llms"
@@ -15,19 +18,241 @@ import (
 
 // LangChainClient implements the llm.LLMClient interface using LangChainGo.
 type LangChainClient struct {
-	llmModel llms.Model // Generic LangChainGo LLM model
+	llmModel  llms.Model     // Generic LangChainGo LLM model
+	modelName string         // Identifier of llmModel, e.g. "openai/gpt-4"; part of cache keys
+	cache     *ResponseCache // Optional response cache; nil disables caching
+
+	tokenBudget int          // Maximum prompt tokens for GetPromQLFromLLM; 0 disables trimming
+	countTokens TokenCounter // Optional; see numTokens
+
+	prompts *prompts.Set // Prompt templates; the embedded defaults unless set with WithPrompts
+
+	examples    *examples.Store // Optional few-shot examples for GetPromQLFromLLM
+	numExamples int             // Maximum number of examples per prompt
+}
+
+// Option configures optional behaviour of a LangChainClient.
+type Option func(*LangChainClient)
+
+// WithModelName records the identifier of the model (e.g. "openai/gpt-4").
+// It keeps cache entries of different models apart.
+func WithModelName(name string) Option {
+	return func(c *LangChainClient) {
+		c.modelName = name
+	}
+}
+
+// WithCache makes the client serve repeated identical LLM calls from cache.
+func WithCache(cache *ResponseCache) Option {
+	return func(c *LangChainClient) {
+		c.cache = cache
+	}
+}
+
+// WithTokenBudget limits the size of the GetPromQLFromLLM prompt to budget
+// tokens. See DefaultTokenBudget for a budget derived from the model.
+func WithTokenBudget(budget int) Option {
+	return func(c *LangChainClient) {
+		c.tokenBudget = budget
+	}
+}
+
+// WithTokenCounter sets the function used to measure prompts against the
+// token budget.
+func WithTokenCounter(counter TokenCounter) Option {
+	return func(c *LangChainClient) {
+		c.countTokens = counter
+	}
+}
+
+// WithPrompts sets the prompt templates, e.g. loaded from a directory with
+// prompts.Load.
+func WithPrompts(set *prompts.Set) Option {
+	return func(c *LangChainClient) {
+		c.prompts = set
+	}
+}
+
+// WithExamples adds up to k examples from store to the PromQL prompt, chosen
+// by their similarity to the user query.
+func WithExamples(store *examples.Store, k int) Option {
+	return func(c *LangChainClient) {
+		c.examples = store
+		c.numExamples = k
+	}
 }
 
 // NewLangChainClient creates a new LangChainClient.
 // The specific model (e.g., OpenAI, Anthropic) should be initialized and passed here.
-func NewLangChainClient(model llms.Model) *LangChainClient {
-	return &LangChainClient{
+func NewLangChainClient(model llms.Model, opts ...Option) *LangChainClient {
+	c := &LangChainClient{
 		llmModel: model,
+		prompts:  prompts.Default(),
+	}
+	for _, opt := range opts {
+		opt(c)
+	}
+	return c
+}
+
+// numTokens measures text with the configured TokenCounter, falling back to
+// the model's own GetNumTokens if it has one, and to a tiktoken count otherwise.
+func (c *LangChainClient) numTokens(text string) int {
+	if c.countTokens != nil {
+		return c.countTokens(text)
+	}
+	if counter, ok := c.llmModel.(interface{ GetNumTokens(string) int }); ok {
+		return counter.GetNumTokens(text)
+	}
+	modelID := c.modelName
+	if idx := strings.Index(modelID, "/"); idx >= 0 {
+		modelID = modelID[idx+1:]
+	}
+	return llms.CountTokens(modelID, text)
+}
+
+// generateContent sends messages to the model, serving the response from the
+// cache when one is configured and the context does not ask to bypass it.
+// All LLM calls made by the client go through this method, which records
+// their token usage under op in the recorders of ctx. promptVersion names the
+// prompt templates the messages were rendered from and is logged with the call.
+func (c *LangChainClient) generateContent(ctx context.Context, op llm.Operation, promptVersion string, messages []llms.MessageContent, options ...llms.CallOption) (*llms.ContentResponse, error) {
+	if c.cache == nil {
+		return c.generateFromModel(ctx, op, promptVersion, messages, options...)
+	}
+
+	key, err := c.cache.Key(c.modelName, messages, options...)
+	if err != nil {
+		return nil, err
 	}
+	if !llm.CacheBypassed(ctx) {
+		if cached, ok := c.cache.Get(key); ok {
+			// A streaming caller still expects the content through its callback.
+			var callOptions llms.CallOptions
+			for _, opt := range options {
+				opt(&callOptions)
+			}
+			if callOptions.StreamingFunc != nil && len(cached.Choices) > 0 {
+				if err := callOptions.StreamingFunc(ctx, []byte(cached.Choices[0].Content)); err != nil {
+					return nil, err
+				}
+			}
+			llm.RecordUsage(ctx, op, c.modelName, 0, 0, true)
+			c.recordPrompt(ctx, op, promptVersion, messages, cached, true)
+			return cached, nil
+		}
+	}
+
+	response, err := c.generateFromModel(ctx, op, promptVersion, messages, options...)
+	if err != nil {
+		return nil, err
+	}
+	if err := c.cache.Put(key, c.modelName, response); err != nil {
+		// A failing cache must not fail the request.
+		log.Printf("Error caching LLM response: %v\n", err)
+	}
+	return response, nil
+}
+
+// generateFromModel calls the model and records the token counts it reports.
+func (c *LangChainClient) generateFromModel(ctx context.Context, op llm.Operation, promptVersion string, messages []llms.MessageContent, options ...llms.CallOption) (*llms.ContentResponse, error) {
+	response, err := c.llmModel.GenerateContent(ctx, messages, options...)
+	if err != nil {
+		return nil, err
+	}
+	promptTokens, completionTokens := reportedTokens(response)
+	log.Printf("LLM call %s (%s, prompt %s): %d prompt tokens, %d completion tokens\n", op, c.modelName, promptVersion, promptTokens, completionTokens)
+	llm.RecordUsage(ctx, op, c.modelName, promptTokens, completionTokens, false)
+	c.recordPrompt(ctx, op, promptVersion, messages, response, false)
+	return response, nil
+}
+
+// recordPrompt records the text of messages and of the first choice of
+// response in the prompt recorder of ctx.
+func (c *LangChainClient) recordPrompt(ctx context.Context, op llm.Operation, promptVersion string, messages []llms.MessageContent, response *llms.ContentResponse, cached bool) {
+	record := llm.PromptRecord{
+		Operation:     op,
+		Model:         c.modelName,
+		PromptVersion: promptVersion,
+		Messages:      make([]llm.PromptMessage, 0, len(messages)),
+		Cached:        cached,
+	}
+	for _, message := range messages {
+		var text strings.Builder
+		for _, part := range message.Parts {
+			if content, ok := part.(llms.TextContent); ok {
+				text.WriteString(content.Text)
+			}
+		}
+		record.Messages = append(record.Messages, llm.PromptMessage{Role: string(message.Role), Content: text.String()})
+	}
+	if response != nil && len(response.Choices) > 0 {
+		record.Response = response.Choices[0].Content
+	}
+	llm.RecordPrompt(ctx, record)
+}
+
+// reportedTokens extracts the prompt and completion token counts a provider
+// reports in GenerationInfo. OpenAI uses PromptTokens/CompletionTokens,
+// Anthropic InputTokens/OutputTokens.
+func reportedTokens(response *llms.ContentResponse) (promptTokens, completionTokens int) {
+	if response == nil || len(response.Choices) == 0 {
+		return 0, 0
+	}
+	info := response.Choices[0].GenerationInfo
+	for _, key := range []string{"PromptTokens", "InputTokens"} {
+		if n, ok := toInt(info[key]); ok {
+			promptTokens = n
+			break
+		}
+	}
+	for _, key := range []string{"CompletionTokens", "OutputTokens"} {
+		if n, ok := toInt(info[key]); ok {
+			completionTokens = n
+			break
+		}
+	}
+	return promptTokens, completionTokens
+}
+
+// toInt converts the numeric types found in GenerationInfo to int. Values read
+// back from the response cache are float64.
+func toInt(v interface{}) (int, bool) {
+	switch n := v.(type) {
+	case int:
+		return n, true
+	case int32:
+		return int(n), true
+	case int64:
+		return int(n), true
+	case float64:
+		return int(n), true
+	}
+	return 0, false
+}
+
+// call renders the prompt called name with data, sends it as a single human
+// message and returns the text of the first choice, like
+// llms.GenerateFromSinglePrompt but through generateContent.
+func (c *LangChainClient) call(ctx context.Context, op llm.Operation, name prompts.Name, data interface{}, options ...llms.CallOption) (string, error) {
+	prompt := c.prompts.Get(name)
+	text, err := prompt.Render(data)
+	if err != nil {
+		return "", err
+	}
+	messages := []llms.MessageContent{llms.TextParts(llms.ChatMessageTypeHuman, text)}
+	response, err := c.generateContent(ctx, op, promptVersionString(prompt), messages, options...)
+	if err != nil {
+		return "", err
+	}
+	if len(response.Choices) == 0 {
+		return "", errors.New("empty response from model")
+	}
+	return response.Choices[0].Content, nil
 }
 
 // GetMetricSynonyms gets synonyms for the given metrics from the LLM in batches.
-func (c *LangChainClient) GetMetricSynonyms(metricBatches []map[string]string) (map[string][]string, error) {
+func (c *LangChainClient) GetMetricSynonyms(ctx context.Context, metricBatches []map[string]string) (map[string][]string, error) {
 	if c.llmModel == nil {
 		return nil, errors.New("LangChain LLM model is not initialized")
 	}
@@ -52,8 +277,8 @@ func (c *LangChainClient) GetMetricSynonyms(metricBatches []map[string]string) (
 				return
 			}
 
-			prompt := fmt.Sprintf(prompts.MetricSynonymPrompt, string(metricMapJSON))
-			response, err := c.llmModel.Call(context.Background(), prompt)
+			response, err := c.call(ctx, llm.OperationMetricSynonyms, prompts.MetricSynonyms,
+				prompts.MetricSynonymsData{MetricData: string(metricMapJSON)})
 			if err != nil {
 				resultsChan <- result{nil, fmt.Errorf("LangChain LLM call failed: %w", err)}
 				return
@@ -111,7 +336,7 @@ func (c *LangChainClient) GetMetricSynonyms(metricBatches []map[string]string) (
 }
 
 // GetLabelSynonyms gets synonyms for the given labels from the LLM in batches.
-func (c *LangChainClient) GetLabelSynonyms(labelBatches [][]string) (map[string][]string, error) {
+func (c *LangChainClient) GetLabelSynonyms(ctx context.Context, labelBatches [][]string) (map[string][]string, error) {
 	if c.llmModel == nil {
 		return nil, errors.New("LangChain LLM model is not initialized")
 	}
@@ -135,8 +360,8 @@ func (c *LangChainClient) GetLabelSynonyms(labelBatches [][]string) (map[string]
 				return
 			}
 
-			prompt := fmt.Sprintf(prompts.LabelSynonymPrompt, string(labelNamesJSON))
-			response, err := c.llmModel.Call(context.Background(), prompt)
+			response, err := c.call(ctx, llm.OperationLabelSynonyms, prompts.LabelSynonyms,
+				prompts.LabelSynonymsData{LabelData: string(labelNamesJSON)})
 			if err != nil {
 				resultsChan <- result{nil, fmt.Errorf("LangChain LLM call failed: %w", err)}
 				return
@@ -191,55 +416,134 @@ func (c *LangChainClient) GetLabelSynonyms(labelBatches [][]string) (map[string]
 }
 
 // ProcessUserQuery processes the user query and returns relevant information.
-func (c *LangChainClient) ProcessUserQuery(userQuery string) (map[string]interface{}, error) {
+func (c *LangChainClient) ProcessUserQuery(ctx context.Context, userQuery string) (*llm.QueryAnalysis, error) {
 	if c.llmModel == nil {
 		return nil, errors.New("LangChain LLM model is not initialized")
 	}
 
-	prompt := fmt.Sprintf(prompts.ProcessQueryPrompt, userQuery)
-	response, err := c.llmModel.Call(context.Background(), prompt)
+	response, err := c.call(ctx, llm.OperationQueryAnalysis, prompts.ProcessQuery, prompts.ProcessQueryData{UserQuery: userQuery})
 	if err != nil {
 		return nil, fmt.Errorf("LangChain LLM call failed: %w", err)
 	}
 
-	// Expecting output: {"possible_metric_names": [...], "possible_label_names": [...], "possible_label_values": [...]}
-	var result map[string]interface{}
-	if err := json.Unmarshal([]byte(response), &result); err == nil {
-		return result, nil
+	// Expecting output: {"possible_metric_names": [...], "possible_label_names": [...], "possible_label_values": [...], "intent": {...}}
+	analysis, err := llm.ParseQueryAnalysis([]byte(response))
+	if err != nil {
+		return nil, fmt.Errorf("error unmarshalling LLM response: %w. Raw response: %s", err, response)
 	}
-	// Fallback: try legacy parsing (for backward compatibility)
-	return nil, fmt.Errorf("error unmarshalling LLM response: %w. Raw response: %s", err, response)
+	return analysis, nil
+}
+
+// promptVersionString identifies prompts in logs, e.g. "system@1-1a2b3c4d".
+func promptVersionString(templates ...*prompts.Prompt) string {
+	versions := make([]string, len(templates))
+	for i, prompt := range templates {
+		versions[i] = fmt.Sprintf("%s@%s", prompt.Name, prompt.Version)
+	}
+	return strings.Join(versions, ",")
+}
+
+// buildPromQLUserPrompt renders the user message of the PromQL prompt from pc.
+// examplesJSON and intentJSON are the JSON of the few-shot examples and of the
+// query intent, or empty if there are none.
+func (c *LangChainClient) buildPromQLUserPrompt(userQuery string, pc *promptContext, examplesJSON, intentJSON string) (string, error) {
+	relevantMetricsJSON, err := json.MarshalIndent(pc.metrics, "", "  ")
+	if err != nil {
+		return "", fmt.Errorf("error marshalling relevantMetrics: %w", err)
+	}
+
+	relevantLabelsJSON, err := json.MarshalIndent(pc.labels, "", "  ")
+	if err != nil {
+		return "", fmt.Errorf("error marshalling relevantLabels: %w", err)
+	}
+
+	relevantHistoryJSON, err := json.MarshalIndent(pc.history, "", "  ")
+	if err != nil {
+		return "", fmt.Errorf("error marshalling relevantHistory: %w", err)
+	}
+
+	return c.prompts.Render(prompts.PromQLUser, prompts.PromQLUserData{
+		RelevantMetrics: string(relevantMetricsJSON),
+		RelevantLabels:  string(relevantLabelsJSON),
+		RelevantHistory: string(relevantHistoryJSON),
+		Examples:        examplesJSON,
+		Intent:          intentJSON,
+		UserQuery:       userQuery,
+	})
+}
+
+// similarExamples returns the JSON of the examples most similar to userQuery,
+// or an empty string if no example store is configured or none match.
+func (c *LangChainClient) similarExamples(userQuery string) (string, error) {
+	if c.examples == nil {
+		return "", nil
+	}
+	similar := c.examples.Similar(userQuery, c.numExamples)
+	if len(similar) == 0 {
+		return "", nil
+	}
+	examplesJSON, err := json.MarshalIndent(similar, "", "  ")
+	if err != nil {
+		return "", fmt.Errorf("error marshalling examples: %w", err)
+	}
+	return string(examplesJSON), nil
 }
 
 // GetPromQLFromLLM gets PromQL queries from the LLM based on the user query and relevant context.
-func (c *LangChainClient) GetPromQLFromLLM(userQuery string, relevantMetrics llm.RelevantMetricsMap, relevantLabels llm.RelevantLabelsMap, relevantHistory map[string]interface{}) ([]string, error) {
+// intent, if not nil, is included in the prompt.
+// If a token budget is configured, the lowest-scored context is dropped until
+// the prompt fits, and the result reports what was dropped. metricScores, if
+// not nil, decides which metrics are dropped first.
+func (c *LangChainClient) GetPromQLFromLLM(ctx context.Context, userQuery string, relevantMetrics llm.RelevantMetricsMap, relevantLabels llm.RelevantLabelsMap, relevantHistory map[string]interface{}, intent *llm.QueryIntent, metricScores llm.MetricScores) (*llm.PromQLResult, error) {
 	if c.llmModel == nil {
 		return nil, errors.New("LangChain LLM model is not initialized")
 	}
 
-	relevantMetricsJSON, err := json.MarshalIndent(relevantMetrics, "", "  ")
+	// With spec generation the system prompt asks for query specs instead of
+	// PromQL; the user prompt is the same.
+	systemPromptName := prompts.System
+	if llm.SpecGeneration(ctx) {
+		systemPromptName = prompts.SpecSystem
+	}
+	systemPromptTemplate := c.prompts.Get(systemPromptName)
+	systemPrompt, err := systemPromptTemplate.Render(nil)
 	if err != nil {
-		return nil, fmt.Errorf("error marshalling relevantMetrics: %w", err)
+		return nil, err
 	}
 
-	relevantLabelsJSON, err := json.MarshalIndent(relevantLabels, "", "  ")
+	examplesJSON, err := c.similarExamples(userQuery)
 	if err != nil {
-		return nil, fmt.Errorf("error marshalling relevantLabels: %w", err)
+		return nil, err
+	}
+	intentJSON := ""
+	if intent != nil {
+		data, err := json.MarshalIndent(intent, "", "  ")
+		if err != nil {
+			return nil, fmt.Errorf("error marshalling query intent: %w", err)
+		}
+		intentJSON = string(data)
 	}
 
-	relevantHistoryJSON, err := json.MarshalIndent(relevantHistory, "", "  ")
+	pc := newPromptContext(relevantMetrics, relevantLabels, relevantHistory, metricScores)
+	dropped, err := fitToBudget(pc, c.tokenBudget, c.numTokens, func(pc *promptContext) (string, error) {
+		userPrompt, err := c.buildPromQLUserPrompt(userQuery, pc, examplesJSON, intentJSON)
+		if err != nil {
+			return "", err
+		}
+		return systemPrompt + "\n" + userPrompt, nil
+	})
 	if err != nil {
-		return nil, fmt.Errorf("error marshalling relevantHistory: %w", err)
+		return nil, err
+	}
+	if dropped != nil {
+		log.Printf("PromQL prompt trimmed to %d tokens (budget %d): dropped %d metrics, %d labels\n",
+			dropped.PromptTokens, dropped.Budget, len(dropped.Metrics), len(dropped.Labels))
 	}
 
-	// Construct the user prompt part for GetPromQLFromLLM
-	// This follows the structure observed in openai/client.go's newFunction
-	userPromptForPromQL := fmt.Sprintf("#Relevant Metrics:\n%s\n\n#Relevant Labels:\n%s\n\n#Relevant History:\n%s\n\n#User Query:\n%s",
-		string(relevantMetricsJSON),
-		string(relevantLabelsJSON),
-		string(relevantHistoryJSON),
-		userQuery,
-	)
+	userPromptForPromQL, err := c.buildPromQLUserPrompt(userQuery, pc, examplesJSON, intentJSON)
+	if err != nil {
+		return nil, err
+	}
 
 	// For LangChainGo, the system prompt is often handled as part of the model's initialization
 	// or via specific options in the Call/GenerateContent methods.
@@ -249,20 +553,29 @@ func (c *LangChainClient) GetPromQLFromLLM(userQuery string, relevantMetrics llm
 	// A more sophisticated implementation would use llms.GenerateContent with specific message types.
 
 	messages := []llms.MessageContent{
-		llms.TextParts(llms.ChatMessageTypeSystem, prompts.SystemPrompt),
+		llms.TextParts(llms.ChatMessageTypeSystem, systemPrompt),
 		llms.TextParts(llms.ChatMessageTypeHuman, userPromptForPromQL),
 	}
 
 	options := []llms.CallOption{
 		llms.WithTemperature(0.7), // Add temperature for more varied responses
 	} // Add max tokens etc. here if needed.
+	if streamingFunc := llm.StreamingFuncFromContext(ctx); streamingFunc != nil {
+		options = append(options, llms.WithStreamingFunc(streamingFunc))
+	}
 
 	// Using GenerateContent for better control over message types (system vs user)
 	// Note: Not all models in LangchainGo might support the System message type in the same way.
 	// This part might need adjustment based on the specific llms.Model being used.
 	// For example, some models might expect the system prompt as a specific field during initialization or call.
 	// Corrected: llms.GenerateContent is a method on the model instance: c.llmModel.GenerateContent
-	contentResponse, err := c.llmModel.GenerateContent(context.Background(), messages, options...) // Removed c.llmModel from args
+	userPromptTemplate := c.prompts.Get(prompts.PromQLUser)
+	promptVersions := map[string]string{
+		string(systemPromptName):   systemPromptTemplate.Version,
+		string(prompts.PromQLUser): userPromptTemplate.Version,
+	}
+	contentResponse, err := c.generateContent(ctx, llm.OperationPromQLGeneration,
+		promptVersionString(systemPromptTemplate, userPromptTemplate), messages, options...)
 	if err != nil {
 		return nil, fmt.Errorf("LangChain LLM GenerateContent call failed: %w", err)
 	}
@@ -273,18 +586,11 @@ func (c *LangChainClient) GetPromQLFromLLM(userQuery string, relevantMetrics llm
 
 	response := contentResponse.Choices[0].Content
 
-	// Expecting output: a JSON array of objects with promql, score, and metric_label_pairs fields
-	var promqlOptions []struct {
-		PromQL            string                 `json:"promql"`
-		Score             float64                `json:"score"`
-		MetricLabelPairs  map[string]interface{} `json:"metric_label_pairs"`
-	}
+	// Expecting output: a JSON array of objects with promql, score, and metric_label_pairs fields,
+	// or with spec and score fields when generating specs
+	var promqlOptions []llm.PromQLCandidate
 	if err := json.Unmarshal([]byte(response), &promqlOptions); err == nil && len(promqlOptions) > 0 {
-		var sortedPromqlStrings []string
-		for _, option := range promqlOptions {
-			sortedPromqlStrings = append(sortedPromqlStrings, option.PromQL)
-		}
-		return sortedPromqlStrings, nil
+		return &llm.PromQLResult{Candidates: promqlOptions, Dropped: dropped, PromptVersions: promptVersions}, nil
 	}
 
 	// Fallback: try legacy parsing (for backward compatibility)
@@ -292,13 +598,46 @@ func (c *LangChainClient) GetPromQLFromLLM(userQuery string, relevantMetrics llm
 	if err := json.Unmarshal([]byte(response), &fallback); err != nil {
 		return nil, fmt.Errorf("error unmarshalling LLM response for PromQL: %w. Raw response: %s", err, response)
 	}
-	var sortedPromqlStrings []string
+	result := &llm.PromQLResult{Candidates: []llm.PromQLCandidate{}, Dropped: dropped, PromptVersions: promptVersions}
 	for _, option := range fallback {
 		if promql, ok := option["promql"].(string); ok {
-			sortedPromqlStrings = append(sortedPromqlStrings, promql)
+			candidate := llm.PromQLCandidate{PromQL: promql}
+			if score, ok := option["score"].(float64); ok {
+				candidate.Score = score
+			}
+			result.Candidates = append(result.Candidates, candidate)
 		}
 	}
-	return sortedPromqlStrings, nil
+	return result, nil
+}
+
+// RepairPromQL sends promql and the error it failed validation with back to
+// the LLM and returns the corrected query.
+func (c *LangChainClient) RepairPromQL(ctx context.Context, userQuery, promql, validationError string) (string, error) {
+	if c.llmModel == nil {
+		return "", errors.New("LangChain LLM model is not initialized")
+	}
+
+	response, err := c.call(ctx, llm.OperationPromQLRepair, prompts.PromQLRepair, prompts.PromQLRepairData{
+		UserQuery: userQuery,
+		PromQL:    promql,
+		Error:     validationError,
+	})
+	if err != nil {
+		return "", fmt.Errorf("LangChain LLM call failed: %w", err)
+	}
+
+	// Expecting output: {"promql": "..."}
+	var result struct {
+		PromQL string `json:"promql"`
+	}
+	if err := json.Unmarshal([]byte(response), &result); err != nil {
+		return "", fmt.Errorf("error unmarshalling LLM response for PromQL repair: %w. Raw response: %s", err, response)
+	}
+	if strings.TrimSpace(result.PromQL) == "" {
+		return "", fmt.Errorf("LLM returned an empty repaired query. Raw response: %s", response)
+	}
+	return strings.TrimSpace(result.PromQL), nil
 }
 
 // Ensure LangChainClient implements the llm.LLMClient interface.
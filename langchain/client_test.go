@@ -5,16 +5,18 @@ import (
 	"errors"
 	"testing"
 	"strings" // Added for strings.Contains
-	"fmt"     // Added for fmt.Sprintf in ProcessUserQuery test
 
+	"github.com/prashantgupta17/nlpromql/examples"
 	"github.com/prashantgupta17/nlpromql/langchain" // Package to be tested
 	"github.com/tmc/langchaingo/llms"
 	// "github.com/tmc/langchaingo/schema" // Removed unused import
 	"encoding/json" // Added for GetPromQLFromLLM test
 	"github.com/prashantgupta17/nlpromql/llm" // Added for GetPromQLFromLLM test (llm.RelevantMetricsMap etc.)
-	"github.com/prashantgupta17/nlpromql/prompts" // Added for GetPromQLFromLLM test (prompts.SystemPrompt)
+	"github.com/prashantgupta17/nlpromql/prompts"
 	"reflect" // Added for DeepEqual
 	"sync"    // Added for mutex in mock
+	"os"
+	"path/filepath"
 )
 
 // mockLLM is a mock implementation of the llms.Model interface for testing.
@@ -57,7 +59,17 @@ func (m *mockLLM) GenerateContent(ctx context.Context, messages []llms.MessageCo
 	if m.GenerateContentFunc != nil {
 		return m.GenerateContentFunc(ctx, messages, options...)
 	}
-	// To satisfy the interface if only Call is being tested
+	// Single-prompt requests (as sent by the client for synonyms and query
+	// processing) are routed to Call so the prompt-based mocks above apply.
+	if len(messages) == 1 && len(messages[0].Parts) == 1 {
+		if text, ok := messages[0].Parts[0].(llms.TextContent); ok {
+			response, err := m.Call(ctx, text.Text, options...)
+			if err != nil {
+				return nil, err
+			}
+			return &llms.ContentResponse{Choices: []*llms.ContentChoice{{Content: response}}}, nil
+		}
+	}
 	return nil, errors.New("GenerateContentFunc not implemented in mockLLM")
 }
 
@@ -97,19 +109,62 @@ func TestLangChainClient_ProcessUserQuery(t *testing.T) {
 	client := langchain.NewLangChainClient(mock)
 
 	tests := []struct {
-		name          string
-		userQuery     string
-		mockResponse  string
-		mockError     error
-		expectedMap   map[string]interface{}
-		expectedError string
+		name             string
+		userQuery        string
+		mockResponse     string
+		mockError        error
+		expectedAnalysis *llm.QueryAnalysis
+		expectedError    string
 	}{
 		{
 			name:         "successful response",
 			userQuery:    "show me cpu usage",
 			mockResponse: `{"possible_metric_names": ["cpu_usage", "system_cpu_usage"], "possible_label_names": ["instance", "host"]}`,
 			mockError:    nil,
-			expectedMap:  map[string]interface{}{"possible_metric_names": []interface{}{"cpu_usage", "system_cpu_usage"}, "possible_label_names": []interface{}{"instance", "host"}},
+			expectedAnalysis: &llm.QueryAnalysis{
+				MetricNames: []string{"cpu_usage", "system_cpu_usage"},
+				LabelNames:  []string{"instance", "host"},
+				LabelValues: []string{},
+			},
+		},
+		{
+			name:      "response with intent",
+			userQuery: "p99 latency by pod in prod above 0.5s",
+			mockResponse: `{"possible_metric_names": ["latency"], "intent": {"time_range": "5m", "aggregation": "sum", "group_by": ["pod"],
+				"functions": ["rate", "histogram_quantile"], "quantile": 0.99, "comparison": {"operator": ">", "value": 0.5},
+				"label_filters": [{"label": "namespace", "operator": "=", "value": "prod"}]}}`,
+			expectedAnalysis: &llm.QueryAnalysis{
+				MetricNames: []string{"latency"},
+				LabelNames:  []string{},
+				LabelValues: []string{},
+				Intent: &llm.QueryIntent{
+					TimeRange:    "5m",
+					Aggregation:  "sum",
+					GroupBy:      []string{"pod"},
+					Functions:    []string{"rate", "histogram_quantile"},
+					Quantile:     0.99,
+					Comparison:   &llm.Comparison{Operator: ">", Value: 0.5},
+					LabelFilters: []llm.LabelFilter{{Label: "namespace", Operator: "=", Value: "prod"}},
+				},
+			},
+		},
+		{
+			name:             "invalid intent ignored",
+			userQuery:        "p99 latency",
+			mockResponse:     `{"possible_metric_names": ["latency"], "intent": {"quantile": 99}}`,
+			expectedAnalysis: &llm.QueryAnalysis{MetricNames: []string{"latency"}, LabelNames: []string{}, LabelValues: []string{}},
+		},
+		{
+			name:             "unknown operator ignored",
+			userQuery:        "requests of api",
+			mockResponse:     `{"intent": {"label_filters": [{"label": "job", "operator": "~", "value": "api"}]}}`,
+			expectedAnalysis: &llm.QueryAnalysis{MetricNames: []string{}, LabelNames: []string{}, LabelValues: []string{}},
+		},
+		{
+			name:             "tokens that are not strings dropped",
+			userQuery:        "top 5 pods",
+			mockResponse:     `{"possible_label_names": ["pod", 5], "possible_label_values": "none"}`,
+			expectedAnalysis: &llm.QueryAnalysis{MetricNames: []string{}, LabelNames: []string{"pod"}, LabelValues: []string{}},
 		},
 		{
 			name:          "llm returns error",
@@ -133,7 +188,7 @@ func TestLangChainClient_ProcessUserQuery(t *testing.T) {
 				return tt.mockResponse, tt.mockError
 			}
 
-			resultMap, err := client.ProcessUserQuery(tt.userQuery)
+			analysis, err := client.ProcessUserQuery(context.Background(), tt.userQuery)
 
 			if tt.expectedError != "" {
 				if err == nil {
@@ -141,35 +196,14 @@ func TestLangChainClient_ProcessUserQuery(t *testing.T) {
 				} else if !strings.Contains(err.Error(), tt.expectedError) {
 					t.Errorf("expected error containing '%s', got '%v'", tt.expectedError, err)
 				}
-				return // Don't check map if error is expected
+				return // Don't check the analysis if error is expected
 			}
 
 			if err != nil {
 				t.Fatalf("unexpected error: %v", err)
 			}
-
-			// Remove strict map length check, just check that all expected keys are present and correct
-			for key, expectedValue := range tt.expectedMap {
-				actualValue, ok := resultMap[key]
-				if !ok {
-					t.Errorf("expected key '%s' not found in result map", key)
-					continue
-				}
-				expectedValSlice, eok := expectedValue.([]interface{})
-				actualValSlice, aok := actualValue.([]interface{})
-				if eok && aok {
-					if len(expectedValSlice) != len(actualValSlice) {
-						t.Errorf("for key '%s', expected slice length %d, got %d", key, len(expectedValSlice), len(actualValSlice))
-						continue
-					}
-					for i_val := range expectedValSlice {
-						if expectedValSlice[i_val] != actualValSlice[i_val] {
-							t.Errorf("for key '%s' at slice index %d, expected '%v', got '%v'", key, i_val, expectedValSlice[i_val], actualValSlice[i_val])
-						}
-					}
-				} else if fmt.Sprintf("%v", actualValue) != fmt.Sprintf("%v", expectedValue) {
-					t.Errorf("for key '%s', expected value '%v', got '%v'", key, expectedValue, actualValue)
-				}
+			if !reflect.DeepEqual(analysis, tt.expectedAnalysis) {
+				t.Errorf("expected analysis %+v, got %+v", tt.expectedAnalysis, analysis)
 			}
 		})
 	}
@@ -182,7 +216,8 @@ func TestLangChainClient_GetLabelSynonyms_Batching(t *testing.T) {
 	// Helper to create expected prompt string
 	makePrompt := func(data interface{}) string {
 		jsonData, _ := json.MarshalIndent(data, "", "  ")
-		return fmt.Sprintf(prompts.LabelSynonymPrompt, string(jsonData))
+		prompt, _ := prompts.Default().Render(prompts.LabelSynonyms, prompts.LabelSynonymsData{LabelData: string(jsonData)})
+		return prompt
 	}
 
 	batch1 := []string{"label1", "label2"}
@@ -266,7 +301,7 @@ func TestLangChainClient_GetLabelSynonyms_Batching(t *testing.T) {
 			mock.CallResponses = tt.mockResponses
 			mock.CallErrors = tt.mockErrors
 
-			resultMap, err := client.GetLabelSynonyms(tt.labelBatches)
+			resultMap, err := client.GetLabelSynonyms(context.Background(), tt.labelBatches)
 
 			if tt.expectedError != "" {
 				if err == nil {
@@ -388,7 +423,7 @@ func TestLangChainClient_GetPromQLFromLLM(t *testing.T) {
 				return tt.mockResponse, tt.mockError
 			}
 
-			resultPromQLs, err := client.GetPromQLFromLLM(tt.userQuery, tt.relevantMetrics, tt.relevantLabels, tt.relevantHistory)
+			result, err := client.GetPromQLFromLLM(context.Background(), tt.userQuery, tt.relevantMetrics, tt.relevantLabels, tt.relevantHistory, nil, nil)
 
 			if tt.expectedError != "" {
 				if err == nil {
@@ -416,8 +451,9 @@ func TestLangChainClient_GetPromQLFromLLM(t *testing.T) {
 				if !okSys {
 					t.Fatalf("system message part is not TextContent")
 				}
-				if sysTextPart.Text != prompts.SystemPrompt {
-					t.Errorf("system prompt mismatch. Expected:\n%s\nGot:\n%s", prompts.SystemPrompt, sysTextPart.Text)
+				systemPrompt, _ := prompts.Default().Render(prompts.System, nil)
+				if sysTextPart.Text != systemPrompt {
+					t.Errorf("system prompt mismatch. Expected:\n%s\nGot:\n%s", systemPrompt, sysTextPart.Text)
 				}
 
 				// Check user prompt for key elements
@@ -446,6 +482,7 @@ func TestLangChainClient_GetPromQLFromLLM(t *testing.T) {
 				}
 			}
 
+			resultPromQLs := result.Queries()
 			if len(resultPromQLs) != len(tt.expectedPromQLs) {
 				t.Errorf("expected %d PromQL queries, got %d. Result: %v", len(tt.expectedPromQLs), len(resultPromQLs), resultPromQLs)
 			}
@@ -466,7 +503,8 @@ func TestLangChainClient_GetMetricSynonyms_Batching(t *testing.T) {
 	// Helper to create expected prompt string
 	makePrompt := func(data interface{}) string {
 		jsonData, _ := json.MarshalIndent(data, "", "  ")
-		return fmt.Sprintf(prompts.MetricSynonymPrompt, string(jsonData))
+		prompt, _ := prompts.Default().Render(prompts.MetricSynonyms, prompts.MetricSynonymsData{MetricData: string(jsonData)})
+		return prompt
 	}
 
 	batch1 := map[string]string{"metric1": "desc1", "metric2": "desc2"}
@@ -550,7 +588,7 @@ func TestLangChainClient_GetMetricSynonyms_Batching(t *testing.T) {
 			mock.CallResponses = tt.mockResponses
 			mock.CallErrors = tt.mockErrors
 
-			resultMap, err := client.GetMetricSynonyms(tt.metricBatches)
+			resultMap, err := client.GetMetricSynonyms(context.Background(), tt.metricBatches)
 
 			if tt.expectedError != "" {
 				if err == nil {
@@ -587,3 +625,189 @@ func TestLangChainClient_GetMetricSynonyms_Batching(t *testing.T) {
 		})
 	}
 }
+
+func TestLangChainClient_GetPromQLFromLLM_Streaming(t *testing.T) {
+	const response = `[{"promql": "up", "score": 1.0}]`
+	mock := &mockLLM{
+		GenerateContentFunc: func(ctx context.Context, messages []llms.MessageContent, options ...llms.CallOption) (*llms.ContentResponse, error) {
+			var opts llms.CallOptions
+			for _, opt := range options {
+				opt(&opts)
+			}
+			if opts.StreamingFunc == nil {
+				return nil, errors.New("expected a streaming function")
+			}
+			// Stream the response in two chunks, as a real model would.
+			for _, chunk := range []string{response[:10], response[10:]} {
+				if err := opts.StreamingFunc(ctx, []byte(chunk)); err != nil {
+					return nil, err
+				}
+			}
+			return &llms.ContentResponse{Choices: []*llms.ContentChoice{{Content: response}}}, nil
+		},
+	}
+	cache, err := langchain.NewResponseCache(t.TempDir(), 0, 0)
+	if err != nil {
+		t.Fatalf("NewResponseCache returned an unexpected error: %v", err)
+	}
+	client := langchain.NewLangChainClient(mock, langchain.WithCache(cache))
+
+	// The second request is a cache hit and must still reach the stream.
+	for i := 0; i < 2; i++ {
+		var streamed strings.Builder
+		ctx := llm.WithStreamingFunc(context.Background(), func(ctx context.Context, chunk []byte) error {
+			streamed.Write(chunk)
+			return nil
+		})
+		result, err := client.GetPromQLFromLLM(ctx, "is it up", llm.RelevantMetricsMap{}, llm.RelevantLabelsMap{}, map[string]interface{}{}, nil, nil)
+		if err != nil {
+			t.Fatalf("request %d: unexpected error: %v", i, err)
+		}
+		if streamed.String() != response {
+			t.Errorf("request %d: expected streamed content %q, got %q", i, response, streamed.String())
+		}
+		if queries := result.Queries(); len(queries) != 1 || queries[0] != "up" {
+			t.Errorf("request %d: unexpected queries %v", i, queries)
+		}
+	}
+}
+
+func TestLangChainClient_WithPrompts(t *testing.T) {
+	dir := t.TempDir()
+	templates := map[string]string{
+		"system.tmpl":      "{{/* version: 2 */ -}}\nYou write PromQL.",
+		"promql_user.tmpl": "Q: {{.UserQuery}}",
+	}
+	for name, content := range templates {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(content), 0644); err != nil {
+			t.Fatalf("error writing template: %v", err)
+		}
+	}
+	set, err := prompts.Load(dir)
+	if err != nil {
+		t.Fatalf("prompts.Load returned an unexpected error: %v", err)
+	}
+
+	var captured []llms.MessageContent
+	mock := &mockLLM{
+		GenerateContentFunc: func(ctx context.Context, messages []llms.MessageContent, options ...llms.CallOption) (*llms.ContentResponse, error) {
+			captured = messages
+			return &llms.ContentResponse{Choices: []*llms.ContentChoice{{Content: `[{"promql": "up", "score": 1.0}]`}}}, nil
+		},
+	}
+	client := langchain.NewLangChainClient(mock, langchain.WithPrompts(set))
+
+	result, err := client.GetPromQLFromLLM(context.Background(), "is it up", llm.RelevantMetricsMap{}, llm.RelevantLabelsMap{}, map[string]interface{}{}, nil, nil)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if text := captured[0].Parts[0].(llms.TextContent).Text; text != "You write PromQL." {
+		t.Errorf("unexpected system prompt %q", text)
+	}
+	if text := captured[1].Parts[0].(llms.TextContent).Text; text != "Q: is it up" {
+		t.Errorf("unexpected user prompt %q", text)
+	}
+	if version := result.PromptVersions["system"]; version != set.Get(prompts.System).Version || !strings.HasPrefix(version, "2-") {
+		t.Errorf("unexpected system prompt version %q", version)
+	}
+	if version := result.PromptVersions["promql_user"]; version != set.Get(prompts.PromQLUser).Version {
+		t.Errorf("unexpected user prompt version %q", version)
+	}
+}
+
+func TestLangChainClient_WithExamples(t *testing.T) {
+	store, err := examples.NewStore("")
+	if err != nil {
+		t.Fatalf("NewStore returned an unexpected error: %v", err)
+	}
+	for _, example := range []examples.Example{
+		{Query: "request rate by job", PromQL: "sum by (job) (rate(http_requests_total[5m]))"},
+		{Query: "memory usage of each pod", PromQL: "sum by (pod) (container_memory_working_set_bytes)"},
+	} {
+		if err := store.Add(example); err != nil {
+			t.Fatalf("Add returned an unexpected error: %v", err)
+		}
+	}
+
+	var userPrompt string
+	mock := &mockLLM{
+		GenerateContentFunc: func(ctx context.Context, messages []llms.MessageContent, options ...llms.CallOption) (*llms.ContentResponse, error) {
+			userPrompt = messages[1].Parts[0].(llms.TextContent).Text
+			return &llms.ContentResponse{Choices: []*llms.ContentChoice{{Content: `[{"promql": "up", "score": 1.0}]`}}}, nil
+		},
+	}
+	client := langchain.NewLangChainClient(mock, langchain.WithExamples(store, 1))
+
+	if _, err := client.GetPromQLFromLLM(context.Background(), "http request rate per job", llm.RelevantMetricsMap{}, llm.RelevantLabelsMap{}, map[string]interface{}{}, nil, nil); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !strings.Contains(userPrompt, "#Examples:") || !strings.Contains(userPrompt, "sum by (job) (rate(http_requests_total[5m]))") {
+		t.Errorf("expected the most similar example in the prompt:\n%s", userPrompt)
+	}
+	if strings.Contains(userPrompt, "container_memory_working_set_bytes") {
+		t.Errorf("expected only 1 example in the prompt:\n%s", userPrompt)
+	}
+
+	// Without a matching example the section is left out.
+	if _, err := client.GetPromQLFromLLM(context.Background(), "disk io", llm.RelevantMetricsMap{}, llm.RelevantLabelsMap{}, map[string]interface{}{}, nil, nil); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if strings.Contains(userPrompt, "#Examples:") {
+		t.Errorf("expected no examples section:\n%s", userPrompt)
+	}
+}
+
+func TestLangChainClient_WithIntent(t *testing.T) {
+	var userPrompt string
+	mock := &mockLLM{
+		GenerateContentFunc: func(ctx context.Context, messages []llms.MessageContent, options ...llms.CallOption) (*llms.ContentResponse, error) {
+			userPrompt = messages[1].Parts[0].(llms.TextContent).Text
+			return &llms.ContentResponse{Choices: []*llms.ContentChoice{{Content: `[{"promql": "up", "score": 1.0}]`}}}, nil
+		},
+	}
+	client := langchain.NewLangChainClient(mock)
+
+	intent := &llm.QueryIntent{TimeRange: "5m", Aggregation: "sum", GroupBy: []string{"pod"}}
+	if _, err := client.GetPromQLFromLLM(context.Background(), "request rate by pod", llm.RelevantMetricsMap{}, llm.RelevantLabelsMap{}, map[string]interface{}{}, intent, nil); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !strings.Contains(userPrompt, "#Query Intent:") || !strings.Contains(userPrompt, `"group_by": [`) {
+		t.Errorf("expected the intent in the prompt:\n%s", userPrompt)
+	}
+
+	// Without an intent the section is left out.
+	if _, err := client.GetPromQLFromLLM(context.Background(), "request rate by pod", llm.RelevantMetricsMap{}, llm.RelevantLabelsMap{}, map[string]interface{}{}, nil, nil); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if strings.Contains(userPrompt, "#Query Intent:") {
+		t.Errorf("expected no intent section:\n%s", userPrompt)
+	}
+}
+
+func TestLangChainClient_SpecGeneration(t *testing.T) {
+	var systemPrompt string
+	mock := &mockLLM{
+		GenerateContentFunc: func(ctx context.Context, messages []llms.MessageContent, options ...llms.CallOption) (*llms.ContentResponse, error) {
+			systemPrompt = messages[0].Parts[0].(llms.TextContent).Text
+			return &llms.ContentResponse{Choices: []*llms.ContentChoice{{Content: `[{"spec": {"metric": "up", "aggregation": "count"}, "score": 0.8}]`}}}, nil
+		},
+	}
+	client := langchain.NewLangChainClient(mock)
+
+	ctx := llm.WithSpecGeneration(context.Background())
+	result, err := client.GetPromQLFromLLM(ctx, "how many targets", llm.RelevantMetricsMap{}, llm.RelevantLabelsMap{}, map[string]interface{}{}, nil, nil)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	expectedPrompt, _ := prompts.Default().Render(prompts.SpecSystem, nil)
+	if systemPrompt != expectedPrompt {
+		t.Errorf("expected the spec system prompt, got:\n%s", systemPrompt)
+	}
+	if _, ok := result.PromptVersions[string(prompts.SpecSystem)]; !ok {
+		t.Errorf("expected the spec system prompt version to be reported, got %v", result.PromptVersions)
+	}
+	if len(result.Candidates) != 1 || result.Candidates[0].Spec == nil || result.Candidates[0].Spec.Metric != "up" ||
+		result.Candidates[0].Spec.Aggregation != "count" || result.Candidates[0].Score != 0.8 {
+		t.Errorf("expected the spec to be parsed, got %+v", result.Candidates)
+	}
+}
@@ -0,0 +1,64 @@
+package langchain_test
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/prashantgupta17/nlpromql/langchain"
+	"github.com/prashantgupta17/nlpromql/llm"
+	"github.com/tmc/langchaingo/llms"
+)
+
+func TestLangChainClient_PromptRecording(t *testing.T) {
+	mock := &mockLLM{
+		GenerateContentFunc: func(ctx context.Context, messages []llms.MessageContent, options ...llms.CallOption) (*llms.ContentResponse, error) {
+			if len(messages) == 1 {
+				return &llms.ContentResponse{Choices: []*llms.ContentChoice{{Content: `{"possible_metric_names": ["cpu"]}`}}}, nil
+			}
+			return &llms.ContentResponse{Choices: []*llms.ContentChoice{{Content: `[{"promql": "up", "score": 1.0}]`}}}, nil
+		},
+	}
+	cache, err := langchain.NewResponseCache(t.TempDir(), 0, 0)
+	if err != nil {
+		t.Fatalf("NewResponseCache returned an unexpected error: %v", err)
+	}
+	client := langchain.NewLangChainClient(mock, langchain.WithModelName("openai/gpt-4o"), langchain.WithCache(cache))
+
+	// Calls made without a recorder are not recorded anywhere.
+	if _, err := client.ProcessUserQuery(context.Background(), "cpu usage"); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	recorder := llm.NewPromptRecorder()
+	ctx := llm.WithPromptRecorder(context.Background(), recorder)
+	if _, err := client.ProcessUserQuery(ctx, "cpu usage"); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	metrics := llm.RelevantMetricsMap{"node_cpu_seconds_total": {}}
+	if _, err := client.GetPromQLFromLLM(ctx, "cpu usage", metrics, llm.RelevantLabelsMap{}, map[string]interface{}{}, nil, nil); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	records := recorder.Records()
+	if len(records) != 2 {
+		t.Fatalf("expected 2 recorded prompts, got %d", len(records))
+	}
+	analysis, generation := records[0], records[1]
+	if analysis.Operation != llm.OperationQueryAnalysis || !analysis.Cached || analysis.Model != "openai/gpt-4o" {
+		t.Errorf("expected a cached query analysis prompt, got %+v", analysis)
+	}
+	if len(analysis.Messages) != 1 || !strings.Contains(analysis.Messages[0].Content, "cpu usage") {
+		t.Errorf("expected the rendered query analysis prompt, got %+v", analysis.Messages)
+	}
+	if analysis.Response != `{"possible_metric_names": ["cpu"]}` {
+		t.Errorf("expected the cached response to be recorded, got %q", analysis.Response)
+	}
+	if generation.Operation != llm.OperationPromQLGeneration || generation.Cached || generation.PromptVersion == "" {
+		t.Errorf("expected a fresh, versioned generation prompt, got %+v", generation)
+	}
+	if len(generation.Messages) != 2 || generation.Messages[0].Role != string(llms.ChatMessageTypeSystem) ||
+		!strings.Contains(generation.Messages[1].Content, "node_cpu_seconds_total") {
+		t.Errorf("expected the system and user messages of generation, got %+v", generation.Messages)
+	}
+}
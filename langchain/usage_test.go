@@ -0,0 +1,76 @@
+package langchain_test
+
+import (
+	"context"
+	"math"
+	"testing"
+
+	"github.com/prashantgupta17/nlpromql/langchain"
+	"github.com/prashantgupta17/nlpromql/llm"
+	"github.com/tmc/langchaingo/llms"
+)
+
+func TestLangChainClient_UsageRecording(t *testing.T) {
+	mock := &mockLLM{
+		GenerateContentFunc: func(ctx context.Context, messages []llms.MessageContent, options ...llms.CallOption) (*llms.ContentResponse, error) {
+			if len(messages) == 1 {
+				// Query analysis, reported the way the Anthropic client does.
+				return &llms.ContentResponse{Choices: []*llms.ContentChoice{{
+					Content:        `{"possible_metric_names": ["cpu"]}`,
+					GenerationInfo: map[string]any{"InputTokens": 100, "OutputTokens": 20},
+				}}}, nil
+			}
+			// PromQL generation, reported the way the OpenAI client does.
+			return &llms.ContentResponse{Choices: []*llms.ContentChoice{{
+				Content:        `[{"promql": "up", "score": 1.0}]`,
+				GenerationInfo: map[string]any{"PromptTokens": 1000, "CompletionTokens": 50},
+			}}}, nil
+		},
+	}
+	cache, err := langchain.NewResponseCache(t.TempDir(), 0, 0)
+	if err != nil {
+		t.Fatalf("NewResponseCache returned an unexpected error: %v", err)
+	}
+	client := langchain.NewLangChainClient(mock, langchain.WithModelName("openai/gpt-4o"), langchain.WithCache(cache))
+
+	prices := llm.PriceTable{"gpt-4o": {PromptPer1K: 1.0, CompletionPer1K: 2.0}}
+	session := llm.NewUsageRecorder(prices)
+	request := llm.NewUsageRecorder(prices)
+	ctx := llm.WithUsageRecorder(llm.WithUsageRecorder(context.Background(), session), request)
+
+	if _, err := client.ProcessUserQuery(ctx, "cpu"); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if _, err := client.GetPromQLFromLLM(ctx, "cpu", llm.RelevantMetricsMap{}, llm.RelevantLabelsMap{}, map[string]interface{}{}, nil, nil); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	// Served from cache: counted, but free.
+	if _, err := client.ProcessUserQuery(ctx, "cpu"); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	for name, recorder := range map[string]*llm.UsageRecorder{"session": session, "request": request} {
+		report := recorder.Report()
+		if report.Total.Calls != 3 || report.Total.CachedCalls != 1 {
+			t.Errorf("%s: expected 3 calls with 1 cached, got %+v", name, report.Total)
+		}
+		if report.Total.PromptTokens != 1100 || report.Total.CompletionTokens != 70 {
+			t.Errorf("%s: unexpected token totals %+v", name, report.Total)
+		}
+		analysis := report.ByOperation[llm.OperationQueryAnalysis]
+		if analysis.Calls != 2 || analysis.PromptTokens != 100 || analysis.CompletionTokens != 20 {
+			t.Errorf("%s: unexpected query analysis usage %+v", name, analysis)
+		}
+		generation := report.ByOperation[llm.OperationPromQLGeneration]
+		if generation.Calls != 1 || generation.PromptTokens != 1000 {
+			t.Errorf("%s: unexpected generation usage %+v", name, generation)
+		}
+		if model := report.ByModel["openai/gpt-4o"]; model != report.Total {
+			t.Errorf("%s: expected all usage under the client's model, got %+v", name, report.ByModel)
+		}
+		// (100+1000)/1000*1.0 + (20+50)/1000*2.0
+		if expected := 1.1 + 0.14; math.Abs(report.Total.EstimatedCost-expected) > 1e-9 {
+			t.Errorf("%s: expected cost %.4f, got %.4f", name, expected, report.Total.EstimatedCost)
+		}
+	}
+}
@@ -1,5 +1,11 @@
 package llm
 
+import (
+	"context"
+
+	"github.com/prashantgupta17/nlpromql/promql"
+)
+
 // LabelContextDetail holds match score and example values for a label.
 type LabelContextDetail struct {
 	MatchScore float64  `json:"match_score"`
@@ -15,11 +21,95 @@ type RelevantMetricsMap map[string]map[string]LabelContextDetail
 // Example: {"labelA": {"match_score": 0.9, "values": ["val1", "val2", "val3"]}}
 type RelevantLabelsMap map[string]LabelContextDetail
 
+// MetricScores maps relevant metric names to their retrieval scores, higher
+// being more relevant.
+// Example: {"metric1": 4.2, "metric2": 1.5}
+type MetricScores map[string]float64
+
+// SynonymGenerator generates the synonyms the information structure maps
+// metric and label names from. Every LLMClient is one; synonyms.Generator is
+// one that needs no LLM.
+type SynonymGenerator interface {
+	GetMetricSynonyms(ctx context.Context, metricBatches []map[string]string) (map[string][]string, error)
+	GetLabelSynonyms(ctx context.Context, labelBatches [][]string) (map[string][]string, error)
+}
+
 // LLMClient defines the interface for interacting with an LLM.
-// The GetPromQLFromLLM method will now use the new map types.
+// The GetPromQLFromLLM method will now use the new map types. metricScores,
+// if not nil, ranks relevantMetrics for when not all of them fit the prompt.
+// Every method takes a context so that per-request options (see context.go)
+// reach the implementation.
 type LLMClient interface {
-	GetMetricSynonyms(metricBatches []map[string]string) (map[string][]string, error)
-	GetLabelSynonyms(labelBatches [][]string) (map[string][]string, error)
-	ProcessUserQuery(userQuery string) (map[string]interface{}, error)
-	GetPromQLFromLLM(userQuery string, relevantMetrics RelevantMetricsMap, relevantLabels RelevantLabelsMap, relevantHistory map[string]interface{}) ([]string, error)
+	SynonymGenerator
+	ProcessUserQuery(ctx context.Context, userQuery string) (*QueryAnalysis, error)
+	GetPromQLFromLLM(ctx context.Context, userQuery string, relevantMetrics RelevantMetricsMap, relevantLabels RelevantLabelsMap, relevantHistory map[string]interface{}, intent *QueryIntent, metricScores MetricScores) (*PromQLResult, error)
+	// RepairPromQL asks for a corrected version of promql, which was
+	// generated for userQuery and failed with validationError.
+	RepairPromQL(ctx context.Context, userQuery, promql, validationError string) (string, error)
+}
+
+// PromQLCandidate is a single PromQL query proposed by the LLM.
+type PromQLCandidate struct {
+	PromQL           string                 `json:"promql"`
+	Score            float64                `json:"score"`
+	MetricLabelPairs map[string]interface{} `json:"metric_label_pairs,omitempty"`
+	Models           []string               `json:"models,omitempty"` // Models that proposed the query, when several were asked
+	// Contributors details, for each model in Models, its own score, the
+	// provider that served it and the tokens it used.
+	Contributors []Contributor `json:"contributors,omitempty"`
+	// Spec is the structured description the query was compiled from, when
+	// the LLM was asked for specs rather than PromQL (see WithSpecGeneration).
+	Spec *promql.QuerySpec `json:"spec,omitempty"`
+	// Attempts lists the earlier versions of PromQL that failed validation,
+	// oldest first, when the candidate was repaired.
+	Attempts []RepairAttempt `json:"attempts,omitempty"`
+	// ValidationError is the error of PromQL itself if it is still invalid
+	// after all repair attempts.
+	ValidationError string `json:"validation_error,omitempty"`
+}
+
+// Contributor is a model that proposed a candidate when several were asked.
+type Contributor struct {
+	Model    string     `json:"model"`
+	Provider string     `json:"provider,omitempty"` // Provider that served the model, when it is a fallback chain
+	Score    float64    `json:"score"`              // Score the model gave the candidate
+	Usage    TokenUsage `json:"usage"`              // Tokens the model used for the whole generation
+}
+
+// RepairAttempt is a version of a candidate that failed validation.
+type RepairAttempt struct {
+	PromQL string `json:"promql"`
+	Error  string `json:"error"`
+}
+
+// DroppedContext describes the relevance data that was left out of the
+// prompt to keep it within the model's token budget.
+type DroppedContext struct {
+	Budget       int                 `json:"budget"`
+	PromptTokens int                 `json:"prompt_tokens"`           // Size of the prompt actually sent
+	Metrics      []string            `json:"metrics,omitempty"`       // Metrics removed entirely
+	MetricLabels map[string][]string `json:"metric_labels,omitempty"` // metric -> labels removed from it
+	Labels       []string            `json:"labels,omitempty"`        // Entries removed from Relevant Labels
+	Values       map[string][]string `json:"values,omitempty"`        // "metric/label" or "label" -> values removed
+}
+
+// PromQLResult is the outcome of GetPromQLFromLLM.
+type PromQLResult struct {
+	Candidates []PromQLCandidate `json:"candidates"`
+	Dropped    *DroppedContext   `json:"dropped,omitempty"` // nil when nothing had to be dropped
+	// PromptVersions maps the names of the prompt templates used for
+	// generation to their versions.
+	PromptVersions map[string]string `json:"prompt_versions,omitempty"`
+	// Provider is the model that served the request when a fallback chain
+	// was used.
+	Provider string `json:"provider,omitempty"`
+}
+
+// Queries returns the PromQL strings of the candidates, in order.
+func (r *PromQLResult) Queries() []string {
+	queries := make([]string, 0, len(r.Candidates))
+	for _, candidate := range r.Candidates {
+		queries = append(queries, candidate.PromQL)
+	}
+	return queries
 }
@@ -0,0 +1,75 @@
+package llm
+
+import "context"
+
+// contextKey is the type of the keys this package stores in a context.Context.
+type contextKey int
+
+const (
+	cacheBypassKey contextKey = iota
+	streamingFuncKey
+	streamResetFuncKey
+	usageRecordersKey
+	specGenerationKey
+	promptRecorderKey
+)
+
+// StreamingFunc receives chunks of an LLM response as they are generated.
+// Returning an error stops the stream.
+type StreamingFunc func(ctx context.Context, chunk []byte) error
+
+// WithCacheBypass returns a copy of ctx that tells caching LLMClient
+// implementations to skip the cache lookup for calls made with it.
+// The fresh response is still written back so later calls can use it.
+func WithCacheBypass(ctx context.Context) context.Context {
+	return context.WithValue(ctx, cacheBypassKey, true)
+}
+
+// CacheBypassed reports whether ctx was created by WithCacheBypass.
+func CacheBypassed(ctx context.Context) bool {
+	bypass, _ := ctx.Value(cacheBypassKey).(bool)
+	return bypass
+}
+
+// WithStreamingFunc returns a copy of ctx that asks LLMClient implementations
+// to stream the PromQL generation response to fn as it is produced.
+func WithStreamingFunc(ctx context.Context, fn StreamingFunc) context.Context {
+	return context.WithValue(ctx, streamingFuncKey, fn)
+}
+
+// StreamingFuncFromContext returns the function set by WithStreamingFunc, or nil.
+func StreamingFuncFromContext(ctx context.Context) StreamingFunc {
+	fn, _ := ctx.Value(streamingFuncKey).(StreamingFunc)
+	return fn
+}
+
+// StreamResetFunc is told that the chunks streamed so far are to be
+// discarded, because the response they belong to was abandoned for another
+// one, e.g. from the next provider of a fallback chain. reason says why.
+type StreamResetFunc func(ctx context.Context, reason string)
+
+// WithStreamResetFunc returns a copy of ctx that asks LLMClient
+// implementations to call fn before streaming a new response in place of one
+// that failed partway through.
+func WithStreamResetFunc(ctx context.Context, fn StreamResetFunc) context.Context {
+	return context.WithValue(ctx, streamResetFuncKey, fn)
+}
+
+// StreamResetFuncFromContext returns the function set by WithStreamResetFunc, or nil.
+func StreamResetFuncFromContext(ctx context.Context) StreamResetFunc {
+	fn, _ := ctx.Value(streamResetFuncKey).(StreamResetFunc)
+	return fn
+}
+
+// WithSpecGeneration returns a copy of ctx that asks LLMClient implementations
+// to describe the generated queries as promql.QuerySpec in
+// PromQLCandidate.Spec rather than to write PromQL. The caller compiles them.
+func WithSpecGeneration(ctx context.Context) context.Context {
+	return context.WithValue(ctx, specGenerationKey, true)
+}
+
+// SpecGeneration reports whether ctx was created by WithSpecGeneration.
+func SpecGeneration(ctx context.Context) bool {
+	spec, _ := ctx.Value(specGenerationKey).(bool)
+	return spec
+}
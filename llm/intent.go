@@ -0,0 +1,125 @@
+package llm
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+)
+
+// QueryIntent is the structured meaning of a user query: what to compute
+// rather than which metrics to use. It is extracted during query analysis
+// and passed to PromQL generation.
+type QueryIntent struct {
+	TimeRange    string        `json:"time_range,omitempty"`  // Window such as "5m" or "1h"
+	Aggregation  string        `json:"aggregation,omitempty"` // sum, avg, min, max, count, ...
+	GroupBy      []string      `json:"group_by,omitempty"`    // Labels to aggregate by
+	Functions    []string      `json:"functions,omitempty"`   // rate, increase, histogram_quantile, ...
+	Quantile     float64       `json:"quantile,omitempty"`    // 0.99 for "p99" or "99th percentile"
+	TopK         int           `json:"top_k,omitempty"`
+	BottomK      int           `json:"bottom_k,omitempty"`
+	Comparison   *Comparison   `json:"comparison,omitempty"` // Threshold, e.g. "above 90%"
+	LabelFilters []LabelFilter `json:"label_filters,omitempty"`
+}
+
+// Comparison is a threshold the result is filtered by.
+type Comparison struct {
+	Operator string  `json:"operator"` // ==, !=, >, <, >= or <=
+	Value    float64 `json:"value"`
+}
+
+// LabelFilter restricts the series to those whose label matches value.
+type LabelFilter struct {
+	Label    string `json:"label"`
+	Operator string `json:"operator"` // =, !=, =~ or !~
+	Value    string `json:"value"`
+}
+
+var (
+	comparisonOperators  = map[string]bool{"==": true, "!=": true, ">": true, "<": true, ">=": true, "<=": true}
+	labelFilterOperators = map[string]bool{"=": true, "!=": true, "=~": true, "!~": true}
+)
+
+// Validate checks the operators and numbers of the intent.
+func (i *QueryIntent) Validate() error {
+	if i.Quantile < 0 || i.Quantile > 1 {
+		return fmt.Errorf("quantile %v is not between 0 and 1", i.Quantile)
+	}
+	if i.TopK < 0 || i.BottomK < 0 {
+		return fmt.Errorf("top_k and bottom_k must not be negative")
+	}
+	if i.Comparison != nil && !comparisonOperators[i.Comparison.Operator] {
+		return fmt.Errorf("unknown comparison operator %q", i.Comparison.Operator)
+	}
+	for _, filter := range i.LabelFilters {
+		if filter.Label == "" {
+			return fmt.Errorf("label filter without label")
+		}
+		if !labelFilterOperators[filter.Operator] {
+			return fmt.Errorf("unknown label filter operator %q for label %s", filter.Operator, filter.Label)
+		}
+	}
+	return nil
+}
+
+// QueryAnalysis is the result of ProcessUserQuery: the words of a user query
+// that may name metrics, labels and label values, and its intent.
+type QueryAnalysis struct {
+	MetricNames []string     `json:"possible_metric_names"`
+	LabelNames  []string     `json:"possible_label_names"`
+	LabelValues []string     `json:"possible_label_values"`
+	Intent      *QueryIntent `json:"intent,omitempty"` // nil if the LLM returned no valid intent
+}
+
+// ParseQueryAnalysis decodes the JSON object the LLM returns for query
+// analysis. Tokens that are not strings are dropped, and an invalid intent is
+// logged and left out: it only refines generation.
+func ParseQueryAnalysis(data []byte) (*QueryAnalysis, error) {
+	var raw struct {
+		MetricNames interface{}     `json:"possible_metric_names"`
+		LabelNames  interface{}     `json:"possible_label_names"`
+		LabelValues interface{}     `json:"possible_label_values"`
+		Intent      json.RawMessage `json:"intent"`
+	}
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return nil, err
+	}
+	analysis := &QueryAnalysis{
+		MetricNames: stringTokens(raw.MetricNames),
+		LabelNames:  stringTokens(raw.LabelNames),
+		LabelValues: stringTokens(raw.LabelValues),
+	}
+	intent, err := parseQueryIntent(raw.Intent)
+	if err != nil {
+		log.Printf("Ignoring query intent: %v\n", err)
+	}
+	analysis.Intent = intent
+	return analysis, nil
+}
+
+// stringTokens returns the strings of a token list.
+func stringTokens(list interface{}) []string {
+	items, _ := list.([]interface{})
+	tokens := make([]string, 0, len(items))
+	for _, item := range items {
+		if token, ok := item.(string); ok {
+			tokens = append(tokens, token)
+		}
+	}
+	return tokens
+}
+
+// parseQueryIntent decodes and validates the "intent" object of a query
+// analysis. It returns nil without error if there is no intent.
+func parseQueryIntent(raw json.RawMessage) (*QueryIntent, error) {
+	if len(raw) == 0 || string(raw) == "null" {
+		return nil, nil
+	}
+	var intent QueryIntent
+	if err := json.Unmarshal(raw, &intent); err != nil {
+		return nil, fmt.Errorf("error unmarshalling query intent: %w", err)
+	}
+	if err := intent.Validate(); err != nil {
+		return nil, fmt.Errorf("invalid query intent: %w", err)
+	}
+	return &intent, nil
+}
@@ -0,0 +1,63 @@
+package llm
+
+import (
+	"context"
+	"sync"
+)
+
+// PromptMessage is one message of a prompt sent to an LLM.
+type PromptMessage struct {
+	Role    string `json:"role"`
+	Content string `json:"content"`
+}
+
+// PromptRecord is a prompt exactly as it was sent to an LLM, with the
+// response it got.
+type PromptRecord struct {
+	Operation     Operation       `json:"operation"`
+	Model         string          `json:"model"`
+	PromptVersion string          `json:"prompt_version,omitempty"`
+	Messages      []PromptMessage `json:"messages"`
+	Response      string          `json:"response"`
+	Cached        bool            `json:"cached"` // Served from the response cache
+}
+
+// PromptRecorder collects the prompts of LLM calls, in the order they
+// complete. It is safe for concurrent use.
+type PromptRecorder struct {
+	mu      sync.Mutex
+	records []PromptRecord
+}
+
+// NewPromptRecorder creates an empty PromptRecorder.
+func NewPromptRecorder() *PromptRecorder {
+	return &PromptRecorder{}
+}
+
+// Record adds a prompt.
+func (r *PromptRecorder) Record(record PromptRecord) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.records = append(r.records, record)
+}
+
+// Records returns a copy of the prompts recorded so far.
+func (r *PromptRecorder) Records() []PromptRecord {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return append([]PromptRecord(nil), r.records...)
+}
+
+// WithPromptRecorder returns a copy of ctx that records the prompts of LLM
+// calls made with it in r, replacing any recorder already in ctx.
+func WithPromptRecorder(ctx context.Context, r *PromptRecorder) context.Context {
+	return context.WithValue(ctx, promptRecorderKey, r)
+}
+
+// RecordPrompt records a prompt in the recorder of ctx, if any. LLMClient
+// implementations call it once per LLM call.
+func RecordPrompt(ctx context.Context, record PromptRecord) {
+	if r, _ := ctx.Value(promptRecorderKey).(*PromptRecorder); r != nil {
+		r.Record(record)
+	}
+}
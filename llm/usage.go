@@ -0,0 +1,199 @@
+package llm
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+)
+
+// Operation identifies what an LLM call was made for.
+type Operation string
+
+const (
+	OperationMetricSynonyms   Operation = "metric_synonyms"
+	OperationLabelSynonyms    Operation = "label_synonyms"
+	OperationQueryAnalysis    Operation = "query_analysis"
+	OperationPromQLGeneration Operation = "promql_generation"
+	OperationPromQLRepair     Operation = "promql_repair"
+)
+
+// TokenUsage counts LLM calls and the tokens the provider reported for them.
+// Calls served from cache are counted in CachedCalls and cost nothing.
+type TokenUsage struct {
+	Calls            int     `json:"calls"`
+	CachedCalls      int     `json:"cached_calls"`
+	PromptTokens     int     `json:"prompt_tokens"`
+	CompletionTokens int     `json:"completion_tokens"`
+	EstimatedCost    float64 `json:"estimated_cost"`
+}
+
+func (u *TokenUsage) add(other TokenUsage) {
+	u.Calls += other.Calls
+	u.CachedCalls += other.CachedCalls
+	u.PromptTokens += other.PromptTokens
+	u.CompletionTokens += other.CompletionTokens
+	u.EstimatedCost += other.EstimatedCost
+}
+
+// UsageReport is the token usage of a request or a build, in total, per
+// operation and per model. ByModel shows which providers served the calls.
+type UsageReport struct {
+	Total       TokenUsage               `json:"total"`
+	ByOperation map[Operation]TokenUsage `json:"by_operation,omitempty"`
+	ByModel     map[string]TokenUsage    `json:"by_model,omitempty"`
+}
+
+// String summarizes the report on one line.
+func (r UsageReport) String() string {
+	return fmt.Sprintf("%d LLM calls (%d cached), %d prompt + %d completion tokens, estimated cost $%.4f",
+		r.Total.Calls, r.Total.CachedCalls, r.Total.PromptTokens, r.Total.CompletionTokens, r.Total.EstimatedCost)
+}
+
+// ModelPrice is the price of a model in currency units per 1000 tokens.
+type ModelPrice struct {
+	PromptPer1K     float64 `json:"prompt_per_1k"`
+	CompletionPer1K float64 `json:"completion_per_1k"`
+}
+
+// PriceTable maps model identifiers, without provider prefix, to their prices.
+// Lookups use the longest matching prefix, so "gpt-4o" covers dated versions.
+type PriceTable map[string]ModelPrice
+
+// DefaultPriceTable holds list prices in USD of commonly used models.
+var DefaultPriceTable = PriceTable{
+	"gpt-3.5-turbo":   {PromptPer1K: 0.0005, CompletionPer1K: 0.0015},
+	"gpt-4":           {PromptPer1K: 0.03, CompletionPer1K: 0.06},
+	"gpt-4-32k":       {PromptPer1K: 0.06, CompletionPer1K: 0.12},
+	"gpt-4-turbo":     {PromptPer1K: 0.01, CompletionPer1K: 0.03},
+	"gpt-4o":          {PromptPer1K: 0.0025, CompletionPer1K: 0.01},
+	"gpt-4o-mini":     {PromptPer1K: 0.00015, CompletionPer1K: 0.0006},
+	"claude-2":        {PromptPer1K: 0.008, CompletionPer1K: 0.024},
+	"claude-3-opus":   {PromptPer1K: 0.015, CompletionPer1K: 0.075},
+	"claude-3-sonnet": {PromptPer1K: 0.003, CompletionPer1K: 0.015},
+	"claude-3-haiku":  {PromptPer1K: 0.00025, CompletionPer1K: 0.00125},
+}
+
+// LoadPriceTable reads a price table from a JSON file of the form
+// {"gpt-4o": {"prompt_per_1k": 0.0025, "completion_per_1k": 0.01}}.
+func LoadPriceTable(path string) (PriceTable, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("error reading price table: %v", err)
+	}
+	var table PriceTable
+	if err := json.Unmarshal(data, &table); err != nil {
+		return nil, fmt.Errorf("error decoding price table: %v", err)
+	}
+	return table, nil
+}
+
+// Cost estimates the cost of a call to model (e.g. "openai/gpt-4o"). Unknown
+// models cost 0.
+func (pt PriceTable) Cost(model string, promptTokens, completionTokens int) float64 {
+	if idx := strings.Index(model, "/"); idx >= 0 {
+		model = model[idx+1:]
+	}
+	var price ModelPrice
+	matched := ""
+	for prefix, p := range pt {
+		if strings.HasPrefix(model, prefix) && len(prefix) > len(matched) {
+			price, matched = p, prefix
+		}
+	}
+	return float64(promptTokens)/1000*price.PromptPer1K + float64(completionTokens)/1000*price.CompletionPer1K
+}
+
+// UsageRecorder accumulates the token usage of LLM calls. It is safe for
+// concurrent use, as synonym batches are processed in parallel.
+type UsageRecorder struct {
+	prices PriceTable
+
+	mu     sync.Mutex
+	report UsageReport
+}
+
+// NewUsageRecorder creates a UsageRecorder that estimates costs with prices.
+func NewUsageRecorder(prices PriceTable) *UsageRecorder {
+	return &UsageRecorder{
+		prices: prices,
+		report: UsageReport{ByOperation: make(map[Operation]TokenUsage), ByModel: make(map[string]TokenUsage)},
+	}
+}
+
+// Record adds a call to model for op.
+func (r *UsageRecorder) Record(op Operation, model string, promptTokens, completionTokens int, cached bool) {
+	usage := TokenUsage{Calls: 1}
+	if cached {
+		usage.CachedCalls = 1
+	} else {
+		usage.PromptTokens = promptTokens
+		usage.CompletionTokens = completionTokens
+		usage.EstimatedCost = r.prices.Cost(model, promptTokens, completionTokens)
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.report.Total.add(usage)
+	opUsage := r.report.ByOperation[op]
+	opUsage.add(usage)
+	r.report.ByOperation[op] = opUsage
+	modelUsage := r.report.ByModel[model]
+	modelUsage.add(usage)
+	r.report.ByModel[model] = modelUsage
+}
+
+// Report returns a copy of the usage recorded so far.
+func (r *UsageRecorder) Report() UsageReport {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	report := UsageReport{
+		Total:       r.report.Total,
+		ByOperation: make(map[Operation]TokenUsage, len(r.report.ByOperation)),
+		ByModel:     make(map[string]TokenUsage, len(r.report.ByModel)),
+	}
+	for op, usage := range r.report.ByOperation {
+		report.ByOperation[op] = usage
+	}
+	for model, usage := range r.report.ByModel {
+		report.ByModel[model] = usage
+	}
+	return report
+}
+
+// WithUsageRecorder returns a copy of ctx that records the usage of LLM calls
+// made with it in r, in addition to any recorders already in ctx. This lets a
+// chat session total and a per-request total see the same calls.
+func WithUsageRecorder(ctx context.Context, r *UsageRecorder) context.Context {
+	existing := usageRecorders(ctx)
+	recorders := make([]*UsageRecorder, 0, len(existing)+1)
+	recorders = append(append(recorders, existing...), r)
+	return context.WithValue(ctx, usageRecordersKey, recorders)
+}
+
+// WithScopedUsage returns a copy of ctx that also records the usage of LLM
+// calls made with it in a new recorder, priced like the first recorder of ctx,
+// so that part of a request can report its own usage.
+func WithScopedUsage(ctx context.Context) (context.Context, *UsageRecorder) {
+	var prices PriceTable
+	if existing := usageRecorders(ctx); len(existing) > 0 {
+		prices = existing[0].prices
+	}
+	r := NewUsageRecorder(prices)
+	return WithUsageRecorder(ctx, r), r
+}
+
+// RecordUsage records a call in every recorder of ctx. LLMClient
+// implementations call it once per LLM call.
+func RecordUsage(ctx context.Context, op Operation, model string, promptTokens, completionTokens int, cached bool) {
+	for _, r := range usageRecorders(ctx) {
+		r.Record(op, model, promptTokens, completionTokens, cached)
+	}
+}
+
+func usageRecorders(ctx context.Context) []*UsageRecorder {
+	recorders, _ := ctx.Value(usageRecordersKey).([]*UsageRecorder)
+	return recorders
+}
@@ -2,17 +2,27 @@ package main
 
 import (
 	"bufio"
+	"context"
+	"encoding/json"
 	"flag"
 	"fmt"
 	"os"
+	"strconv"
 	"strings"
+	"time"
 
+	"github.com/prashantgupta17/nlpromql/eval"
+	"github.com/prashantgupta17/nlpromql/examples"
 	"github.com/prashantgupta17/nlpromql/info_structure"
 	"github.com/prashantgupta17/nlpromql/langchain"
 	"github.com/prashantgupta17/nlpromql/llm"
+	"github.com/prashantgupta17/nlpromql/multillm"
 	"github.com/prashantgupta17/nlpromql/prometheus"
+	"github.com/prashantgupta17/nlpromql/prompts"
 	"github.com/prashantgupta17/nlpromql/query_processing"
 	"github.com/prashantgupta17/nlpromql/server"
+	"github.com/prashantgupta17/nlpromql/synonyms"
+	"github.com/tmc/langchaingo/embeddings"
 	"github.com/tmc/langchaingo/llms"
 	"github.com/tmc/langchaingo/llms/anthropic"
 	lcOpenai "github.com/tmc/langchaingo/llms/openai"
@@ -20,15 +30,70 @@ import (
 
 // TODO: Update README.md to document -llm_model_name, API key flags (-openai_api_key, -anthropic_api_key, -cohere_api_key), and their corresponding environment variables.
 func main() {
-	mode := flag.String("mode", "server", "Mode of operation: 'server' or 'chat'")
+	mode := flag.String("mode", "server", "Mode of operation: 'server', 'chat', 'build', 'eval' or 'validate-prompts'")
 	port := flag.String("port", "8080", "Port for the HTTP server (server mode only)")
 	llmModelNameFlag := flag.String("llm_model_name", "openai/gpt-3.5-turbo", "The identifier for the LangChainGo LLM model to use (e.g., 'openai/gpt-3.5-turbo', 'anthropic/claude-2').")
 	openaiAPIKeyFlag := flag.String("openai_api_key", "", "OpenAI API key. Overrides OPENAI_API_KEY environment variable.")
 	anthropicAPIKeyFlag := flag.String("anthropic_api_key", "", "Anthropic API key. Overrides ANTHROPIC_API_KEY environment variable.")
 	_ = flag.String("cohere_api_key", "", "Cohere API key. Overrides COHERE_API_KEY environment variable.") // Defined, not used yet - assigned to blank identifier
+	llmCacheDir := flag.String("llm_cache_dir", "", "Directory for the on-disk LLM response cache. Caching is disabled when empty.")
+	llmCacheTTL := flag.Duration("llm_cache_ttl", 7*24*time.Hour, "How long cached LLM responses stay valid. 0 keeps them forever.")
+	llmContextBudget := flag.Int("llm_context_budget", -1, "Maximum prompt tokens for PromQL generation; the lowest-scored context is dropped to fit. -1 derives it from the model's context size, 0 disables trimming.")
+	llmPriceTable := flag.String("llm_price_table", "", "JSON file with per-model token prices used for cost estimates. Built-in list prices are used when empty.")
+	llmCacheMaxEntries := flag.Int("llm_cache_max_entries", 10000, "Maximum number of cached LLM responses. 0 means no limit.")
+	examplesDir := flag.String("examples_dir", "", "Directory of JSON files with few-shot PromQL examples. Examples added through the API are saved here; they are kept in memory only when empty.")
+	numExamples := flag.Int("num_examples", 3, "Maximum number of similar examples added to the PromQL prompt. 0 disables examples.")
+	ensembleModels := flag.String("ensemble_models", "", "Comma-separated models that generate PromQL together, each optionally followed by ;weight=<float> and ;timeout=<duration>, e.g. 'openai/gpt-4o;weight=2,anthropic/claude-3-haiku-20240307'. -llm_model_name still handles the other LLM calls.")
+	ensembleTimeout := flag.Duration("ensemble_timeout", 60*time.Second, "Default time after which an ensemble model's answer is abandoned. 0 means no limit.")
+	fallbackModels := flag.String("fallback_models", "", "Comma-separated models tried in order when -llm_model_name fails, e.g. 'anthropic/claude-3-haiku-20240307'.")
+	breakerFailures := flag.Int("breaker_failures", 3, "Consecutive failures after which a fallback chain skips a model. 0 never skips.")
+	breakerCooldown := flag.Duration("breaker_cooldown", time.Minute, "How long a fallback chain skips a failing model before trying it again.")
+	promptsDir := flag.String("prompts_dir", "", "Directory of prompt templates (<name>.tmpl) overriding the embedded defaults.")
+	maxRepairAttempts := flag.Int("max_repair_attempts", 0, "How many times a generated query that fails validation is sent back to the LLM with the error for a fix. 0 disables validation.")
+	embeddingModel := flag.String("embedding_model", "", "Embedding model used to find metrics and labels semantically similar to the query, e.g. 'openai/text-embedding-3-small'. Semantic retrieval is disabled when empty.")
+	semanticTopK := flag.Int("semantic_top_k", query_processing.DefaultSemanticTopK, "Number of metrics and of labels added by semantic retrieval.")
+	semanticMinScore := flag.Float64("semantic_min_score", query_processing.DefaultSemanticMinScore, "Minimum cosine similarity of a metric or label added by semantic retrieval.")
+	synonymSource := flag.String("synonym_generator", "llm", "How metric and label synonyms are generated: 'llm', or 'offline' to derive them from the names without an LLM. With -mode=build, 'offline' needs no LLM at all.")
+	generationMode := flag.String("generation_mode", "promql", "How the LLM generates queries: 'promql' to write PromQL, or 'spec' to fill in structured query specs that are compiled to PromQL and checked against the known metrics and labels.")
+	labelValueLimit := flag.Int("label_value_limit", query_processing.DefaultValueLimit, "Number of values of each retrieved label sent to the LLM, most relevant to the query first. Values named in the query are always sent on top.")
+	labelValueLimits := flag.String("label_value_limits", "", "Comma-separated per-label overrides of -label_value_limit, e.g. 'instance=10,le=20'.")
+	validateWithPrometheus := flag.Bool("validate_with_prometheus", true, "Also run generated queries against Prometheus when validating them, not just the local parser.")
+	evalQuestionsFile := flag.String("eval_questions", "", "JSONL file of questions with their expected PromQL or metrics, evaluated with -mode=eval.")
+	evalReportFile := flag.String("eval_report", "eval_report.json", "File the evaluation report is written to.")
+	evalBaselineFile := flag.String("eval_baseline", "", "Evaluation report of a previous run to compare with. The report already at -eval_report is used when empty.")
+	evalDiffFile := flag.String("eval_diff", "eval_diff.json", "File the comparison with the previous run is written to.")
+	evalWithPrometheus := flag.Bool("eval_with_prometheus", false, "Also run generated and expected queries against the Prometheus at PROMETHEUS_URL to check validity and compare results. Only enable it when PROMETHEUS_URL serves a fixture dataset, not a production Prometheus.")
+
+	proxyPrometheusCredentials := flag.Bool("proxy_prometheus_credentials", false, "Send PROMETHEUS_USER and PROMETHEUS_PASSWORD with the requests proxied by /v1/query and /v1/label/__name__/values instead of the client's own Authorization header. Anyone who can reach the server can then query Prometheus with these credentials.")
 
 	flag.Parse()
 
+	promptSet, err := prompts.Load(*promptsDir)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "Error loading prompt templates:", err)
+		os.Exit(1)
+	}
+	if *mode == "validate-prompts" {
+		// Load already rendered every template against sample data.
+		for _, prompt := range promptSet.Prompts() {
+			fmt.Printf("%-16s version %-14s %s\n", prompt.Name, prompt.Version, prompt.Source)
+		}
+		fmt.Println("All prompt templates are valid.")
+		return
+	}
+	var evalQuestions []eval.Question
+	if *mode == "eval" {
+		if *evalQuestionsFile == "" {
+			fmt.Fprintln(os.Stderr, "Evaluation needs a questions file: set -eval_questions.")
+			os.Exit(1)
+		}
+		evalQuestions, err = eval.LoadQuestions(*evalQuestionsFile)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, "Error loading evaluation questions:", err)
+			os.Exit(1)
+		}
+	}
+
 	// API Key Resolution (Flag > Env)
 	finalOpenAIAPIKey := *openaiAPIKeyFlag
 	if finalOpenAIAPIKey == "" {
@@ -46,51 +111,59 @@ func main() {
 	// // finalCohereAPIKey = os.Getenv("COHERE_API_KEY")
 	// // }
 
-
-	var lcModel llms.Model
-	var err error
-	modelName := *llmModelNameFlag
-
-	fmt.Printf("Attempting to initialize LLM model: %s\n", modelName)
-
-	switch {
-	case strings.HasPrefix(modelName, "openai/"):
-		if finalOpenAIAPIKey == "" {
-			fmt.Fprintln(os.Stderr, "OpenAI API key not provided via flag (-openai_api_key) or environment variable (OPENAI_API_KEY).")
+	prices := llm.DefaultPriceTable
+	if *llmPriceTable != "" {
+		prices, err = llm.LoadPriceTable(*llmPriceTable)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, "Error loading price table:", err)
 			os.Exit(1)
 		}
-		modelID := strings.TrimPrefix(modelName, "openai/")
-		lcModel, err = lcOpenai.New(lcOpenai.WithToken(finalOpenAIAPIKey), lcOpenai.WithModel(modelID))
+	}
+
+	exampleStore, err := examples.NewStore(*examplesDir)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "Error loading examples:", err)
+		os.Exit(1)
+	}
+	sharedOptions := []langchain.Option{langchain.WithPrompts(promptSet), langchain.WithExamples(exampleStore, *numExamples)}
+	var llmCache *langchain.ResponseCache
+	if *llmCacheDir != "" {
+		llmCache, err = langchain.NewResponseCache(*llmCacheDir, *llmCacheTTL, *llmCacheMaxEntries)
 		if err != nil {
-			fmt.Fprintf(os.Stderr, "Error initializing Langchain OpenAI model (%s): %v\n", modelID, err)
+			fmt.Fprintf(os.Stderr, "Error initializing LLM response cache: %v\n", err)
 			os.Exit(1)
 		}
-		fmt.Printf("Successfully initialized Langchain OpenAI model: %s\n", modelID)
-	case strings.HasPrefix(modelName, "anthropic/"):
-		if finalAnthropicAPIKey == "" {
-			fmt.Fprintln(os.Stderr, "Anthropic API key not provided via flag (-anthropic_api_key) or environment variable (ANTHROPIC_API_KEY).")
-			os.Exit(1)
+		sharedOptions = append(sharedOptions, langchain.WithCache(llmCache))
+	}
+
+	// newClient creates a LangChainClient for modelName with the shared options
+	// and a token budget derived from the model unless one was given.
+	newClient := func(modelName string) (*langchain.LangChainClient, error) {
+		fmt.Printf("Attempting to initialize LLM model: %s\n", modelName)
+		lcModel, err := newLangChainModel(modelName, finalOpenAIAPIKey, finalAnthropicAPIKey)
+		if err != nil {
+			return nil, err
 		}
-		modelID := strings.TrimPrefix(modelName, "anthropic/")
-		lcModel, err = anthropic.New(anthropic.WithModel(modelID)) // Assumes ANTHROPIC_API_KEY is read by New() or by http client
+		fmt.Printf("Successfully initialized LLM model: %s\n", modelName)
+		contextBudget := *llmContextBudget
+		if contextBudget < 0 {
+			contextBudget = langchain.DefaultTokenBudget(modelName)
+		}
+		clientOptions := append([]langchain.Option{langchain.WithModelName(modelName), langchain.WithTokenBudget(contextBudget)}, sharedOptions...)
+		return langchain.NewLangChainClient(lcModel, clientOptions...), nil
+	}
+
+	// Offline builds get their synonyms without an LLM, so they need none.
+	var chosenLLMClient llm.LLMClient
+	if *mode != "build" || *synonymSource != "offline" {
+		chosenLLMClient, err = newLLMClient(newClient, *llmModelNameFlag, *fallbackModels,
+			multillm.BreakerConfig{FailureThreshold: *breakerFailures, Cooldown: *breakerCooldown}, *ensembleModels, *ensembleTimeout)
 		if err != nil {
-			fmt.Fprintf(os.Stderr, "Error initializing Langchain Anthropic model (%s): %v\n", modelID, err)
+			fmt.Fprintln(os.Stderr, err)
 			os.Exit(1)
 		}
-		fmt.Printf("Successfully initialized Langchain Anthropic model: %s\n", modelID)
-	// TODO: Add case for "cohere/..." if/when Cohere is implemented
-	default:
-		fmt.Fprintf(os.Stderr, "Unsupported LLM model name: %s. Please use format like 'openai/model-id' or 'anthropic/model-id'.\n", modelName)
-		os.Exit(1)
 	}
 
-	chosenLLMClient := langchain.NewLangChainClient(lcModel)
-	// NewLangChainClient currently doesn't return an error. If it could, error should be handled:
-	// if err != nil {
-	// fmt.Fprintf(os.Stderr, "Error creating LangChainClient: %v\n", err)
-	// os.Exit(1)
-	// }
-
 	// 3. Get Prometheus Credentials from Environment Variables
 	promURL, promUser, promPassword, err := getPrometheusCredentials()
 	if err != nil {
@@ -100,11 +173,46 @@ func main() {
 
 	promClient := prometheus.NewPrometheusConnect(promURL, promUser, promPassword)
 
-	infoBuilder, err := info_structure.NewInfoBuilder(promClient, chosenLLMClient, nil)
+	var synonymGenerator llm.SynonymGenerator
+	switch *synonymSource {
+	case "llm":
+		synonymGenerator = chosenLLMClient
+	case "offline":
+		synonymGenerator = synonyms.NewGenerator()
+	default:
+		fmt.Fprintf(os.Stderr, "Invalid synonym generator: %s. Use 'llm' or 'offline'.\n", *synonymSource)
+		os.Exit(1)
+	}
+
+	if *generationMode != "promql" && *generationMode != "spec" {
+		fmt.Fprintf(os.Stderr, "Invalid generation mode: %s. Use 'promql' or 'spec'.\n", *generationMode)
+		os.Exit(1)
+	}
+	if *labelValueLimit < 0 {
+		fmt.Fprintln(os.Stderr, "Invalid label value limit: it must not be negative.")
+		os.Exit(1)
+	}
+	valueLimits, err := query_processing.ParseValueLimits(*labelValueLimits)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "Error parsing label value limits:", err)
+		os.Exit(1)
+	}
+
+	infoBuilder, err := info_structure.NewInfoBuilder(promClient, synonymGenerator, nil)
 	if err != nil {
 		fmt.Fprintln(os.Stderr, "Error getting info builder:", err)
 		os.Exit(1)
 	}
+	infoBuilder.Prices = prices
+	if *embeddingModel != "" {
+		embedder, err := newEmbedder(*embeddingModel, finalOpenAIAPIKey)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, "Error initializing embedder:", err)
+			os.Exit(1)
+		}
+		infoBuilder.Embedder = embedder
+		infoBuilder.EmbeddingModel = *embeddingModel
+	}
 
 	err = infoBuilder.BuildInformationStructure()
 	if err != nil {
@@ -112,6 +220,11 @@ func main() {
 		os.Exit(1)
 	}
 	fmt.Println("Information Structure Built Successfully.")
+	fmt.Println("Build LLM usage:", infoBuilder.GetBuildStatus().Usage)
+	printCacheStats(llmCache)
+	if *mode == "build" {
+		return
+	}
 	// Verbose printing of map lengths can be removed or put behind a debug flag if too noisy
 	// fmt.Println("Metric Map:", len(infoBuilder.MetricMap.AllNames))
 	// fmt.Println("Label Map:", len(infoBuilder.LabelMap.AllNames))
@@ -119,18 +232,44 @@ func main() {
 	// fmt.Println("LabelValueMap:", len(*infoBuilder.LabelValueMap))
 	// fmt.Println("NlpToMetricMap:", len(*infoBuilder.NlpToMetricMap))
 
+	pipeline := &query_processing.Pipeline{
+		LLMClient:         chosenLLMClient,
+		MetricMap:         *infoBuilder.MetricMap,
+		LabelMap:          *infoBuilder.LabelMap,
+		MetricLabelMap:    *infoBuilder.MetricLabelMap,
+		LabelValueMap:     *infoBuilder.LabelValueMap,
+		History:           infoBuilder.History,
+		LearnedSynonyms:   infoBuilder.LearnedSynonyms,
+		ValueIndex:        infoBuilder.ValueIndex,
+		MetricHelp:        infoBuilder.MetricHelp,
+		Prices:            prices,
+		MaxRepairAttempts: *maxRepairAttempts,
+		Embedder:          infoBuilder.Embedder,
+		VectorIndex:       infoBuilder.VectorIndex,
+		SemanticTopK:      *semanticTopK,
+		SemanticMinScore:  *semanticMinScore,
+		SpecGeneration:    *generationMode == "spec",
+		ValueLimit:        *labelValueLimit,
+		ValueLimits:       valueLimits,
+	}
+	if *validateWithPrometheus {
+		pipeline.QueryEngine = promClient
+	}
+	if saver, ok := infoBuilder.InfoLoaderSaver.(info_structure.HistorySaver); ok {
+		pipeline.HistorySaver = saver
+	}
+	if saver, ok := infoBuilder.InfoLoaderSaver.(info_structure.LearnedSynonymsSaver); ok {
+		pipeline.SynonymsSaver = saver
+	}
 
 	// Main application logic based on mode
 	switch *mode {
 	case "server":
-		promqlServer := server.NewPromQLServer(
-			chosenLLMClient,
-			*infoBuilder.MetricMap,
-			*infoBuilder.LabelMap,
-			*infoBuilder.MetricLabelMap,
-			*infoBuilder.LabelValueMap,
-			*infoBuilder.NlpToMetricMap,
-		)
+		proxyUser, proxyPassword := "", ""
+		if *proxyPrometheusCredentials {
+			proxyUser, proxyPassword = promUser, promPassword
+		}
+		promqlServer := server.NewPromQLServer(pipeline, exampleStore, promURL, proxyUser, proxyPassword)
 		fmt.Printf("Starting server on port %s...\n", *port)
 		if err := promqlServer.Start(*port); err != nil {
 			fmt.Fprintf(os.Stderr, "Server error: %v\n", err)
@@ -138,53 +277,85 @@ func main() {
 		}
 	case "chat":
 		fmt.Println("Entering chat mode...")
-		runChatMode(chosenLLMClient,
-			*infoBuilder.MetricMap,
-			*infoBuilder.LabelMap,
-			*infoBuilder.MetricLabelMap,
-			*infoBuilder.LabelValueMap,
-			*infoBuilder.NlpToMetricMap,
-		)
+		runChatMode(context.Background(), pipeline, prices)
+		printCacheStats(llmCache)
+	case "eval":
+		evaluator := &eval.Evaluator{Runner: pipeline, Progress: printEvalProgress}
+		if *evalWithPrometheus {
+			evaluator.QueryEngine = promClient
+		}
+		if err := runEval(context.Background(), evaluator, evalQuestions, *evalReportFile, *evalBaselineFile, *evalDiffFile); err != nil {
+			fmt.Fprintln(os.Stderr, "Error evaluating:", err)
+			os.Exit(1)
+		}
+		printCacheStats(llmCache)
 	default:
-		fmt.Fprintf(os.Stderr, "Invalid mode: %s. Use 'server' or 'chat'.\n", *mode)
+		fmt.Fprintf(os.Stderr, "Invalid mode: %s. Use 'server', 'chat', 'build', 'eval' or 'validate-prompts'.\n", *mode)
 		os.Exit(1)
 	}
 }
 
-func runChatMode(llmClient llm.LLMClient, metricMap info_structure.MetricMap, labelMap info_structure.LabelMap,
-	metricLabelMap info_structure.MetricLabelMap, labelValueMap info_structure.LabelValueMap,
-	nlpToMetricMap info_structure.NlpToMetricMap) {
+func runChatMode(ctx context.Context, pipeline *query_processing.Pipeline, prices llm.PriceTable) {
 	reader := bufio.NewReader(os.Stdin)
+	sessionUsage := llm.NewUsageRecorder(prices)
+	ctx = llm.WithUsageRecorder(ctx, sessionUsage)
+	defer func() {
+		fmt.Println("Session LLM usage:", sessionUsage.Report())
+	}()
 
+	debug := false
+	var lastQuery string
+	var lastOptions []string
+	var lastSynonyms []info_structure.LearnedSynonym
 	for {
-		fmt.Print("Enter your query about Prometheus data (or type 'exit'): ")
+		fmt.Print("Enter your query about Prometheus data (or type ':accept N', ':reject N', ':synonyms', ':revert N', ':debug' or 'exit'): ")
 		userQuery, _ := reader.ReadString('\n')
 		userQuery = strings.TrimSpace(userQuery)
 
 		if userQuery == "exit" {
 			break
 		}
+		if command, arg, _ := strings.Cut(userQuery, " "); command == ":accept" || command == ":reject" {
+			recordChatFeedback(ctx, pipeline, lastQuery, lastOptions, arg, command == ":accept")
+			continue
+		}
+		if userQuery == ":synonyms" {
+			lastSynonyms = pipeline.Synonyms()
+			printSynonyms(lastSynonyms)
+			continue
+		}
+		if command, arg, _ := strings.Cut(userQuery, " "); command == ":revert" {
+			revertChatSynonym(pipeline, lastSynonyms, arg)
+			continue
+		}
+		if userQuery == ":debug" {
+			debug = !debug
+			if debug {
+				fmt.Println("Debug output on: possible matches, token matches, scores and prompts are printed after each query.")
+			} else {
+				fmt.Println("Debug output off.")
+			}
+			continue
+		}
 
-		_, relevantMetrics, relevantLabels, relevantHistory, err := query_processing.ProcessUserQuery(
-			llmClient, userQuery, metricMap, labelMap, metricLabelMap, labelValueMap, nlpToMetricMap,
-		)
+		result, err := pipeline.Run(ctx, userQuery, newChatProgress())
 		if err != nil {
 			fmt.Fprintln(os.Stderr, "Error processing user query:", err)
 			continue
 		}
 
-		// Debugging prints for relevance data can be verbose; consider a debug flag for these
-		// fmt.Println("Possible Matches:", possibleMatches)
-		// fmt.Println("Relevant Metrics:", relevantMetrics)
-		// fmt.Println("Relevant Labels:", relevantLabels)
-		// fmt.Println("Relevant History:", relevantHistory)
+		if debug {
+			printDebug(result)
+		}
 
-		promqlOptions, err := llmClient.GetPromQLFromLLM(userQuery, relevantMetrics, relevantLabels, relevantHistory)
-		if err != nil {
-			fmt.Fprintln(os.Stderr, "Error generating PromQL options:", err)
-			continue
+		fmt.Println("LLM usage:", result.Usage)
+		if dropped := result.PromQL.Dropped; dropped != nil {
+			fmt.Printf("Note: context trimmed to %d tokens (budget %d); dropped %d metrics, %d labels.\n",
+				dropped.PromptTokens, dropped.Budget, len(dropped.Metrics), len(dropped.Labels))
 		}
 
+		promqlOptions := result.PromQL.Queries()
+		lastQuery, lastOptions = userQuery, promqlOptions
 		if len(promqlOptions) == 0 {
 			fmt.Println("No PromQL queries generated for the given input.")
 		} else {
@@ -196,6 +367,298 @@ func runChatMode(llmClient llm.LLMClient, metricMap info_structure.MetricMap, la
 	}
 }
 
+// recordChatFeedback records the verdict of a chat command on option arg (1
+// based) of the last generated options.
+func recordChatFeedback(ctx context.Context, pipeline *query_processing.Pipeline, query string, options []string, arg string, accepted bool) {
+	n, err := strconv.Atoi(strings.TrimSpace(arg))
+	if err != nil || n < 1 || n > len(options) {
+		fmt.Fprintf(os.Stderr, "Give the number of one of the %d options of the last query, e.g. ':accept 1'\n", len(options))
+		return
+	}
+	result, err := pipeline.RecordFeedback(ctx, query_processing.Feedback{Query: query, PromQL: options[n-1], Accepted: accepted})
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "Error recording feedback:", err)
+		return
+	}
+	for _, record := range result.Records {
+		fmt.Printf("Feedback recorded: %s for %s (score %d)\n", record.Metric, strings.Join(record.Tokens, ", "), record.Score())
+	}
+	for _, synonym := range result.Synonyms {
+		fmt.Printf("Synonym learned: %q for %s %s (weight %.2f)\n", synonym.Token, synonym.Kind, synonym.Name, synonym.Weight)
+	}
+}
+
+// printSynonyms lists the learned synonyms for review, numbered for ':revert N'.
+func printSynonyms(synonyms []info_structure.LearnedSynonym) {
+	if len(synonyms) == 0 {
+		fmt.Println("No synonyms learned yet; accept a generated option with ':accept N' to teach some.")
+		return
+	}
+	fmt.Println("Learned synonyms:")
+	for i, synonym := range synonyms {
+		status := ""
+		if len(synonym.Queries) > 0 {
+			status = fmt.Sprintf(", last from %q", synonym.Queries[len(synonym.Queries)-1])
+		}
+		if synonym.Reverted {
+			status += ", reverted"
+		}
+		fmt.Printf("%d. %q -> %s %s (weight %.2f, learned %d times%s)\n", i+1, synonym.Token, synonym.Kind,
+			synonym.Name, synonym.Weight, synonym.Count, status)
+	}
+}
+
+// revertChatSynonym reverts synonym arg (1 based) of the last ':synonyms' list.
+func revertChatSynonym(pipeline *query_processing.Pipeline, synonyms []info_structure.LearnedSynonym, arg string) {
+	n, err := strconv.Atoi(strings.TrimSpace(arg))
+	if err != nil || n < 1 || n > len(synonyms) {
+		fmt.Fprintf(os.Stderr, "Give the number of one of the %d synonyms listed by ':synonyms', e.g. ':revert 1'\n", len(synonyms))
+		return
+	}
+	synonym := synonyms[n-1]
+	if err := pipeline.RevertSynonym(synonym.Kind, synonym.Token, synonym.Name); err != nil {
+		fmt.Fprintln(os.Stderr, "Error reverting synonym:", err)
+		return
+	}
+	fmt.Printf("Reverted: %q is no longer a synonym of %s %s\n", synonym.Token, synonym.Kind, synonym.Name)
+}
+
+// runEval evaluates questions and writes the report to reportFile. If there
+// is a previous report, at baselineFile or else at reportFile, the comparison
+// with it is printed and written to diffFile.
+func runEval(ctx context.Context, evaluator *eval.Evaluator, questions []eval.Question, reportFile, baselineFile, diffFile string) error {
+	if baselineFile == "" {
+		if _, err := os.Stat(reportFile); err == nil {
+			baselineFile = reportFile
+		}
+	}
+	var baseline *eval.Report
+	if baselineFile != "" {
+		var err error
+		baseline, err = eval.LoadReport(baselineFile)
+		if err != nil {
+			return err
+		}
+	}
+
+	fmt.Printf("Evaluating %d questions...\n", len(questions))
+	report, err := evaluator.Evaluate(ctx, questions)
+	if err != nil {
+		return err
+	}
+	fmt.Println("Evaluation:", report.Summary)
+	if err := report.Save(reportFile); err != nil {
+		return err
+	}
+	fmt.Println("Report written to", reportFile)
+	if baseline == nil {
+		return nil
+	}
+	diff := eval.Compare(baseline, report)
+	fmt.Printf("Compared with %s:\n%s", baselineFile, diff)
+	if err := diff.Save(diffFile); err != nil {
+		return err
+	}
+	fmt.Println("Diff written to", diffFile)
+	return nil
+}
+
+// printEvalProgress prints the result of each evaluated question.
+func printEvalProgress(result eval.QuestionResult) {
+	status := "valid"
+	switch {
+	case result.Equivalent != nil && *result.Equivalent, result.ExactMatch:
+		status = "match"
+	case result.Error != "":
+		status = result.Error
+	case result.Equivalent != nil:
+		status = "different result"
+	}
+	fmt.Printf("[%s] %q -> %q: recall %.2f, %s\n", result.ID, result.Query, result.PromQL, result.MetricRecall, status)
+}
+
+// newChatProgress returns a ProgressFunc that prints pipeline progress for
+// chat mode, echoing the generation response as it streams in.
+func newChatProgress() query_processing.ProgressFunc {
+	streaming := false
+	return func(stage string, data interface{}) {
+		switch stage {
+		case query_processing.StageQueryAnalysis:
+			analysis, _ := data.(*llm.QueryAnalysis)
+			if analysis == nil {
+				analysis = &llm.QueryAnalysis{}
+			}
+			fmt.Printf("[query analysis] %d metric names, %d label names, %d label values\n",
+				len(analysis.MetricNames), len(analysis.LabelNames), len(analysis.LabelValues))
+			if analysis.Intent != nil {
+				intentJSON, _ := json.Marshal(analysis.Intent)
+				fmt.Printf("[query analysis] intent: %s\n", intentJSON)
+			}
+		case query_processing.StageRetrieval:
+			summary, _ := data.(query_processing.RetrievalSummary)
+			fmt.Printf("[retrieval] %d metrics, %d labels\n", len(summary.Metrics), len(summary.Labels))
+			if len(summary.Metrics) > 0 {
+				fmt.Printf("[retrieval] top metrics: %s\n", strings.Join(summary.Metrics[:min(len(summary.Metrics), 3)], ", "))
+			}
+		case query_processing.StageGeneration:
+			if !streaming {
+				fmt.Print("[generation] ")
+				streaming = true
+			}
+			fmt.Print(data)
+		case query_processing.StageGenerationReset:
+			if streaming {
+				fmt.Println()
+				streaming = false
+			}
+			fmt.Printf("[generation] discarded: %v\n", data)
+		case query_processing.StageCandidates:
+			if streaming {
+				fmt.Println()
+			}
+		}
+	}
+}
+
+// printDebug prints how result was produced: the possible matches extracted
+// by the LLM, how each retrieved metric and label was matched, their scores
+// and the prompts sent to the LLM.
+func printDebug(result *query_processing.Result) {
+	possibleMatches, _ := json.Marshal(result.Analysis)
+	fmt.Printf("[debug] possible matches: %s\n", possibleMatches)
+	for _, m := range result.Matches {
+		fmt.Printf("[debug] %q -> %q (%s, %.2f) -> %s %s\n", m.Token, m.Key, m.Match, m.Weight, m.Kind, m.Name)
+	}
+	for _, items := range []struct {
+		kind  string
+		items []query_processing.RankedItem
+	}{{"metric", result.Ranking.Metrics}, {"label", result.Ranking.Labels}} {
+		for _, item := range items.items {
+			breakdown, _ := json.Marshal(item.Breakdown)
+			fmt.Printf("[debug] %s %s: %.3f %s\n", items.kind, item.Name, item.Score, breakdown)
+		}
+	}
+	if len(result.RelevantHistory) > 0 {
+		history, _ := json.Marshal(result.RelevantHistory)
+		fmt.Printf("[debug] history: %s\n", history)
+	}
+	for _, prompt := range result.Prompts {
+		cached := ""
+		if prompt.Cached {
+			cached = ", cached"
+		}
+		fmt.Printf("[debug] prompt %s (%s, %s%s):\n", prompt.Operation, prompt.Model, prompt.PromptVersion, cached)
+		for _, message := range prompt.Messages {
+			fmt.Printf("--- %s ---\n%s\n", message.Role, message.Content)
+		}
+		fmt.Printf("--- response ---\n%s\n", prompt.Response)
+	}
+}
+
+// printCacheStats prints the LLM response cache counters, if caching is enabled.
+func printCacheStats(cache *langchain.ResponseCache) {
+	if cache == nil {
+		return
+	}
+	stats := cache.Stats()
+	fmt.Printf("LLM cache: %d hits, %d misses, %d evictions, %d entries\n",
+		stats.Hits, stats.Misses, stats.Evictions, stats.Entries)
+}
+
+// newLLMClient creates the client for primaryModel with newClient, wrapped in
+// a fallback chain if fallbackModels is set and in an ensemble if
+// ensembleModels is set.
+func newLLMClient(newClient func(modelName string) (*langchain.LangChainClient, error), primaryModel, fallbackModels string,
+	breaker multillm.BreakerConfig, ensembleModels string, ensembleTimeout time.Duration) (llm.LLMClient, error) {
+	primaryClient, err := newClient(primaryModel)
+	if err != nil {
+		return nil, err
+	}
+	var chosenLLMClient llm.LLMClient = primaryClient
+	if fallbackModels != "" {
+		providers := []multillm.Provider{{Name: primaryModel, Client: primaryClient}}
+		for _, modelName := range strings.Split(fallbackModels, ",") {
+			modelName = strings.TrimSpace(modelName)
+			client, err := newClient(modelName)
+			if err != nil {
+				return nil, err
+			}
+			providers = append(providers, multillm.Provider{Name: modelName, Client: client})
+		}
+		chosenLLMClient, err = multillm.NewFallback(breaker, providers...)
+		if err != nil {
+			return nil, fmt.Errorf("error creating fallback chain: %v", err)
+		}
+	}
+	if ensembleModels != "" {
+		specs, err := multillm.ParseMemberSpecs(ensembleModels, ensembleTimeout)
+		if err != nil {
+			return nil, fmt.Errorf("error parsing ensemble models: %v", err)
+		}
+		members := make([]multillm.Member, 0, len(specs))
+		for _, spec := range specs {
+			client, err := newClient(spec.Model)
+			if err != nil {
+				return nil, err
+			}
+			members = append(members, multillm.Member{Name: spec.Model, Client: client, Weight: spec.Weight, Timeout: spec.Timeout})
+		}
+		chosenLLMClient, err = multillm.NewEnsemble(chosenLLMClient, members...)
+		if err != nil {
+			return nil, fmt.Errorf("error creating ensemble: %v", err)
+		}
+		fmt.Printf("Generating PromQL with an ensemble of %d models\n", len(members))
+	}
+	return chosenLLMClient, nil
+}
+
+// newLangChainModel initializes the LangChainGo model identified by modelName,
+// e.g. "openai/gpt-3.5-turbo" or "anthropic/claude-2".
+func newLangChainModel(modelName, openAIAPIKey, anthropicAPIKey string) (llms.Model, error) {
+	switch {
+	case strings.HasPrefix(modelName, "openai/"):
+		if openAIAPIKey == "" {
+			return nil, fmt.Errorf("OpenAI API key not provided via flag (-openai_api_key) or environment variable (OPENAI_API_KEY)")
+		}
+		modelID := strings.TrimPrefix(modelName, "openai/")
+		lcModel, err := lcOpenai.New(lcOpenai.WithToken(openAIAPIKey), lcOpenai.WithModel(modelID))
+		if err != nil {
+			return nil, fmt.Errorf("error initializing Langchain OpenAI model (%s): %v", modelID, err)
+		}
+		return lcModel, nil
+	case strings.HasPrefix(modelName, "anthropic/"):
+		if anthropicAPIKey == "" {
+			return nil, fmt.Errorf("Anthropic API key not provided via flag (-anthropic_api_key) or environment variable (ANTHROPIC_API_KEY)")
+		}
+		modelID := strings.TrimPrefix(modelName, "anthropic/")
+		lcModel, err := anthropic.New(anthropic.WithModel(modelID), anthropic.WithToken(anthropicAPIKey))
+		if err != nil {
+			return nil, fmt.Errorf("error initializing Langchain Anthropic model (%s): %v", modelID, err)
+		}
+		return lcModel, nil
+	// TODO: Add case for "cohere/..." if/when Cohere is implemented
+	default:
+		return nil, fmt.Errorf("unsupported LLM model name: %s. Please use format like 'openai/model-id' or 'anthropic/model-id'", modelName)
+	}
+}
+
+// newEmbedder initializes the LangChainGo embedder identified by modelName,
+// e.g. "openai/text-embedding-3-small".
+func newEmbedder(modelName, openAIAPIKey string) (embeddings.Embedder, error) {
+	if !strings.HasPrefix(modelName, "openai/") {
+		return nil, fmt.Errorf("unsupported embedding model name: %s. Please use format like 'openai/model-id'", modelName)
+	}
+	if openAIAPIKey == "" {
+		return nil, fmt.Errorf("OpenAI API key not provided via flag (-openai_api_key) or environment variable (OPENAI_API_KEY)")
+	}
+	modelID := strings.TrimPrefix(modelName, "openai/")
+	client, err := lcOpenai.New(lcOpenai.WithToken(openAIAPIKey), lcOpenai.WithEmbeddingModel(modelID))
+	if err != nil {
+		return nil, fmt.Errorf("error initializing Langchain OpenAI embedding model (%s): %v", modelID, err)
+	}
+	return embeddings.NewEmbedder(client)
+}
+
 // getPrometheusCredentials retrieves Prometheus credentials from environment variables.
 func getPrometheusCredentials() (string, string, string, error) {
 	promURL := os.Getenv("PROMETHEUS_URL")
@@ -0,0 +1,366 @@
+// Package matching finds the keys of the information structure maps that a
+// query token refers to, tolerating differences in case, plurals and word
+// forms, abbreviations, typos and partial words. Approximate matches score
+// lower than exact ones.
+package matching
+
+import (
+	"sort"
+	"strings"
+
+	"github.com/prashantgupta17/nlpromql/synonyms"
+)
+
+// Kind is how a token matched a key.
+type Kind string
+
+const (
+	KindExact      Kind = "exact"      // The token is the key
+	KindNormalized Kind = "normalized" // Equal after Normalize
+	KindPrefix     Kind = "prefix"     // The token starts a word of the key
+	KindEdit       Kind = "edit"       // Within a small edit distance
+	KindNGram      Kind = "ngram"      // Shares most trigrams
+)
+
+// Scores of the kinds of match. An n-gram match scores ScoreNGram times the
+// trigram similarity, and an edit distance of 2 scores ScoreEdit2.
+const (
+	ScoreExact      = 1.0
+	ScoreNormalized = 0.9
+	ScorePrefix     = 0.7
+	ScoreEdit       = 0.6
+	ScoreEdit2      = 0.5
+	ScoreNGram      = 0.5
+)
+
+const (
+	minPrefixLength    = 3   // Shorter prefixes match too many words
+	minNGramSimilarity = 0.5 // Jaccard similarity of the trigram sets
+	maxFuzzyMatches    = 10  // Per token, best first
+	maxCandidates      = 100 // Keys sharing trigrams with a token that are scored
+	maxGramKeys        = 500 // Keys of a trigram above which it is common
+)
+
+// Match is a key a token matched.
+type Match struct {
+	Key   string
+	Kind  Kind
+	Score float64
+}
+
+// Index matches tokens against a fixed set of keys.
+type Index struct {
+	keys       map[string]struct{}
+	normalized map[string][]string // Normalized form -> keys
+	keyNorms   map[string]string   // Key -> normalized form
+	keyGrams   map[string]int      // Key -> number of trigrams of its normalized form
+	keyPadded  map[string]string   // Key -> normalized form padded as by trigrams
+	words      []string            // Sorted distinct words of the keys, for prefix search
+	wordKeys   map[string][]string // Word -> keys containing it
+	grams      map[string][]string // Trigram of a normalized form -> keys
+}
+
+// NewIndex indexes keys.
+func NewIndex(keys []string) *Index {
+	x := &Index{
+		keys:       make(map[string]struct{}, len(keys)),
+		normalized: make(map[string][]string),
+		keyNorms:   make(map[string]string, len(keys)),
+		keyGrams:   make(map[string]int, len(keys)),
+		keyPadded:  make(map[string]string, len(keys)),
+		wordKeys:   make(map[string][]string),
+		grams:      make(map[string][]string),
+	}
+	for _, key := range keys {
+		if _, ok := x.keys[key]; ok {
+			continue
+		}
+		x.keys[key] = struct{}{}
+		norm := Normalize(key)
+		x.keyNorms[key] = norm
+		x.normalized[norm] = append(x.normalized[norm], key)
+		for _, word := range synonyms.SplitName(key) {
+			if len(x.wordKeys[word]) == 0 {
+				x.words = append(x.words, word)
+			}
+			if keys := x.wordKeys[word]; len(keys) == 0 || keys[len(keys)-1] != key {
+				x.wordKeys[word] = append(keys, key)
+			}
+		}
+		grams := trigrams(norm)
+		x.keyGrams[key] = len(grams)
+		x.keyPadded[key] = pad(norm)
+		for gram := range grams {
+			x.grams[gram] = append(x.grams[gram], key)
+		}
+	}
+	sort.Strings(x.words)
+	return x
+}
+
+// Lookup returns the keys token matches, best first. An exact match is the
+// only result. Otherwise all keys equal to token after normalization are
+// returned, or, if there are none, up to 10 keys matched by prefix, edit
+// distance or shared trigrams. Edit distance and trigram similarity are only
+// computed for the keys sharing the most trigrams with token.
+func (x *Index) Lookup(token string) []Match {
+	if _, ok := x.keys[token]; ok {
+		return []Match{{Key: token, Kind: KindExact, Score: ScoreExact}}
+	}
+	norm := Normalize(token)
+	if norm == "" {
+		return nil
+	}
+	if keys := x.normalized[norm]; len(keys) > 0 {
+		matches := make([]Match, len(keys))
+		for i, key := range keys {
+			matches[i] = Match{Key: key, Kind: KindNormalized, Score: ScoreNormalized}
+		}
+		sortMatches(matches)
+		return matches
+	}
+
+	best := make(map[string]Match)
+	add := func(key string, kind Kind, score float64) {
+		if m, ok := best[key]; !ok || score > m.Score {
+			best[key] = Match{Key: key, Kind: kind, Score: score}
+		}
+	}
+
+	// Prefix of a word of the key, e.g. "mem" of node_memory_bytes.
+	if lower := strings.ToLower(token); len(lower) >= minPrefixLength && !strings.ContainsAny(lower, "_: ") {
+		for i := sort.SearchStrings(x.words, lower); i < len(x.words) && strings.HasPrefix(x.words[i], lower); i++ {
+			for _, key := range x.wordKeys[x.words[i]] {
+				add(key, KindPrefix, ScorePrefix)
+			}
+		}
+	}
+
+	// Keys sharing trigrams with the token are candidates for edit distance
+	// and n-gram matches.
+	tokenGrams := trigrams(norm)
+	shared := x.sharedGrams(tokenGrams)
+	for _, key := range x.candidates(norm, shared, len(tokenGrams)) {
+		n := shared[key]
+		if d := editDistance(norm, x.keyNorms[key], 2); d == 1 && len(norm) >= 4 {
+			add(key, KindEdit, ScoreEdit)
+		} else if d == 2 && len(norm) >= 8 {
+			add(key, KindEdit, ScoreEdit2)
+		}
+		similarity := float64(n) / float64(len(tokenGrams)+x.keyGrams[key]-n)
+		if similarity >= minNGramSimilarity {
+			add(key, KindNGram, ScoreNGram*similarity)
+		}
+	}
+
+	matches := make([]Match, 0, len(best))
+	for _, m := range best {
+		matches = append(matches, m)
+	}
+	sortMatches(matches)
+	if len(matches) > maxFuzzyMatches {
+		matches = matches[:maxFuzzyMatches]
+	}
+	return matches
+}
+
+// Similarities returns the trigram similarity to token, as computed by
+// Similarity, of the keys sharing the most trigrams with it, if at least
+// minSimilarity.
+func (x *Index) Similarities(token string, minSimilarity float64) map[string]float64 {
+	norm := Normalize(token)
+	if norm == "" {
+		return nil
+	}
+	similarities := make(map[string]float64)
+	for _, key := range x.normalized[norm] {
+		similarities[key] = 1
+	}
+	tokenGrams := trigrams(norm)
+	shared := x.sharedGrams(tokenGrams)
+	for _, key := range x.candidates(norm, shared, len(tokenGrams)) {
+		n := shared[key]
+		if similarity := float64(n) / float64(len(tokenGrams)+x.keyGrams[key]-n); similarity >= minSimilarity {
+			similarities[key] = similarity
+		}
+	}
+	return similarities
+}
+
+// sharedGrams counts the trigrams of grams each key shares. Keys are found
+// through the rarest trigrams first: a common trigram, such as the start of a
+// prefix most values have, only adds to the counts of the keys found already.
+// If every trigram is common, the keys are found through the first
+// maxGramKeys keys of the rarest, in the order they were given to NewIndex.
+func (x *Index) sharedGrams(grams map[string]struct{}) map[string]int {
+	byKeys := make([]string, 0, len(grams))
+	for gram := range grams {
+		byKeys = append(byKeys, gram)
+	}
+	sort.Slice(byKeys, func(i, j int) bool {
+		if ni, nj := len(x.grams[byKeys[i]]), len(x.grams[byKeys[j]]); ni != nj {
+			return ni < nj
+		}
+		return byKeys[i] < byKeys[j]
+	})
+	shared := make(map[string]int)
+	for _, gram := range byKeys {
+		keys := x.grams[gram]
+		if len(keys) > maxGramKeys {
+			if len(shared) > 0 {
+				for key := range shared {
+					if strings.Contains(x.keyPadded[key], gram) {
+						shared[key]++
+					}
+				}
+				continue
+			}
+			keys = keys[:maxGramKeys]
+		}
+		for _, key := range keys {
+			shared[key]++
+		}
+	}
+	return shared
+}
+
+// candidates returns the maxCandidates keys of shared, which counts the
+// trigrams each key shares with norm, that share the most. A common gram,
+// such as the padded start of norm, is shared by a large part of the keys, so
+// scoring them all would amount to a linear scan. Ties are broken by the
+// difference in length with norm, then by key.
+func (x *Index) candidates(norm string, shared map[string]int, numGrams int) []string {
+	byCount := make([][]string, numGrams+1)
+	for key, n := range shared {
+		byCount[n] = append(byCount[n], key)
+	}
+	keys := make([]string, 0, min(len(shared), maxCandidates))
+	for n := numGrams; n > 0 && len(keys) < maxCandidates; n-- {
+		bucket := byCount[n]
+		if len(keys)+len(bucket) > maxCandidates {
+			distances := make(map[string]int, len(bucket))
+			for _, key := range bucket {
+				distances[key] = max(len(x.keyNorms[key])-len(norm), len(norm)-len(x.keyNorms[key]))
+			}
+			sort.Slice(bucket, func(i, j int) bool {
+				if di, dj := distances[bucket[i]], distances[bucket[j]]; di != dj {
+					return di < dj
+				}
+				return bucket[i] < bucket[j]
+			})
+			bucket = bucket[:maxCandidates-len(keys)]
+		}
+		keys = append(keys, bucket...)
+	}
+	return keys
+}
+
+// sortMatches sorts by descending score, then by key.
+func sortMatches(matches []Match) {
+	sort.Slice(matches, func(i, j int) bool {
+		if matches[i].Score != matches[j].Score {
+			return matches[i].Score > matches[j].Score
+		}
+		return matches[i].Key < matches[j].Key
+	})
+}
+
+// Normalize lower-cases s, splits it into words at separators and camel
+// case, expands single-word abbreviations (see synonyms.DefaultAbbreviations),
+// stems each word and joins them with "_". "HTTP Requests", "http_requests"
+// and "httpRequest" all normalize to "http_request", and "k8s" to "kubernetes".
+func Normalize(s string) string {
+	words := synonyms.SplitName(s)
+	for i, word := range words {
+		if expansion := synonyms.DefaultAbbreviations[word]; len(expansion) == 1 {
+			word = expansion[0]
+		}
+		words[i] = Stem(word)
+	}
+	return strings.Join(words, "_")
+}
+
+// Stem strips common English inflections from a lower-case word, so that
+// "latencies" and "latency", or "errors" and "error", stem alike. Short words
+// are returned unchanged.
+func Stem(word string) string {
+	switch {
+	case len(word) <= 3:
+		return word
+	case strings.HasSuffix(word, "ies") && len(word) > 4:
+		return word[:len(word)-3] + "y"
+	case strings.HasSuffix(word, "sses"), strings.HasSuffix(word, "xes"),
+		strings.HasSuffix(word, "ches"), strings.HasSuffix(word, "shes"):
+		return word[:len(word)-2]
+	case strings.HasSuffix(word, "ing") && len(word) > 5:
+		return word[:len(word)-3]
+	case strings.HasSuffix(word, "ed") && len(word) > 4:
+		return word[:len(word)-2]
+	case strings.HasSuffix(word, "s") && !strings.HasSuffix(word, "ss") &&
+		!strings.HasSuffix(word, "us") && !strings.HasSuffix(word, "is"):
+		return word[:len(word)-1]
+	}
+	return word
+}
+
+// trigrams returns the trigrams of s, padded so that short strings and word
+// starts have trigrams too.
+func trigrams(s string) map[string]struct{} {
+	padded := pad(s)
+	grams := make(map[string]struct{}, len(padded))
+	for i := 0; i+3 <= len(padded); i++ {
+		grams[padded[i:i+3]] = struct{}{}
+	}
+	return grams
+}
+
+// pad marks the start and end of s for trigrams.
+func pad(s string) string {
+	return "$$" + s + "$"
+}
+
+// editDistance returns the Levenshtein distance between a and b, or max+1
+// if it exceeds max.
+func editDistance(a, b string, max int) int {
+	if d := len(a) - len(b); d > max || -d > max {
+		return max + 1
+	}
+	prev := make([]int, len(b)+1)
+	cur := make([]int, len(b)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+	for i := 1; i <= len(a); i++ {
+		cur[0] = i
+		rowMin := cur[0]
+		for j := 1; j <= len(b); j++ {
+			cost := 1
+			if a[i-1] == b[j-1] {
+				cost = 0
+			}
+			cur[j] = min(prev[j]+1, cur[j-1]+1, prev[j-1]+cost)
+			rowMin = min(rowMin, cur[j])
+		}
+		if rowMin > max {
+			return max + 1
+		}
+		prev, cur = cur, prev
+	}
+	if prev[len(b)] > max {
+		return max + 1
+	}
+	return prev[len(b)]
+}
+
+// Similarity returns the Jaccard similarity of the trigrams of the normalized
+// forms of a and b, from 0 for nothing in common to 1 for equal forms.
+func Similarity(a, b string) float64 {
+	gramsA, gramsB := trigrams(Normalize(a)), trigrams(Normalize(b))
+	shared := 0
+	for gram := range gramsA {
+		if _, ok := gramsB[gram]; ok {
+			shared++
+		}
+	}
+	return float64(shared) / float64(len(gramsA)+len(gramsB)-shared)
+}
@@ -0,0 +1,134 @@
+package matching_test
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/prashantgupta17/nlpromql/matching"
+)
+
+func TestIndex_Lookup(t *testing.T) {
+	index := matching.NewIndex([]string{
+		"http_request_duration_seconds_bucket",
+		"latency",
+		"node_memory_bytes",
+		"kubernetes",
+		"errors",
+		"namespace",
+		"production",
+	})
+
+	tests := []struct {
+		name         string
+		token        string
+		expectedKey  string
+		expectedKind matching.Kind
+	}{
+		{"Exact", "latency", "latency", matching.KindExact},
+		{"Plural", "latencies", "latency", matching.KindNormalized},
+		{"Case and separators", "HTTP Request Duration Seconds Bucket", "http_request_duration_seconds_bucket", matching.KindNormalized},
+		{"Abbreviation", "k8s", "kubernetes", matching.KindNormalized},
+		{"Abbreviated plural", "errs", "errors", matching.KindNormalized},
+		{"Prefix of a name segment", "mem", "node_memory_bytes", matching.KindPrefix},
+		{"Prefix of a value", "prod", "production", matching.KindPrefix},
+		{"Typo", "namespcae", "namespace", matching.KindEdit},
+		{"Misspelt", "lattency", "latency", matching.KindEdit},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			matches := index.Lookup(tt.token)
+			if len(matches) == 0 {
+				t.Fatalf("Lookup(%q) returned no matches", tt.token)
+			}
+			if matches[0].Key != tt.expectedKey || matches[0].Kind != tt.expectedKind {
+				t.Errorf("Lookup(%q) = %+v, expected %s by %s first", tt.token, matches, tt.expectedKey, tt.expectedKind)
+			}
+			if tt.expectedKind != matching.KindExact && matches[0].Score >= matching.ScoreExact {
+				t.Errorf("Lookup(%q): expected a %s match to score below an exact one, got %v", tt.token, tt.expectedKind, matches[0].Score)
+			}
+		})
+	}
+
+	if matches := index.Lookup("disk"); len(matches) != 0 {
+		t.Errorf("expected no matches for an unrelated token, got %+v", matches)
+	}
+	if matches := index.Lookup("me"); len(matches) != 0 {
+		t.Errorf("expected no prefix matches for a 2-letter token, got %+v", matches)
+	}
+}
+
+// highCardinalityKeys returns n keys like pod names, which all share the
+// trigrams of their common start.
+func highCardinalityKeys(n int) []string {
+	keys := make([]string, n)
+	for i := range keys {
+		keys[i] = fmt.Sprintf("payments-%x-%d", i*7919, i)
+	}
+	return keys
+}
+
+func TestIndex_Lookup_HighCardinality(t *testing.T) {
+	keys := append(highCardinalityKeys(5000), "payments-checkout")
+	index := matching.NewIndex(keys)
+	matches := index.Lookup("payments-chekout")
+	if len(matches) == 0 || matches[0].Key != "payments-checkout" || matches[0].Kind != matching.KindEdit {
+		t.Errorf("Lookup(%q) = %+v, expected payments-checkout by edit first", "payments-chekout", matches)
+	}
+}
+
+func TestIndex_Similarities(t *testing.T) {
+	keys := []string{"payments", "payments-api", "search", "checkout"}
+	similarities := matching.NewIndex(keys).Similarities("Payments", 0.3)
+	for _, key := range keys {
+		expected, ok := matching.Similarity("Payments", key), true
+		if expected < 0.3 {
+			expected, ok = 0, false
+		}
+		if got, found := similarities[key]; found != ok || got != expected {
+			t.Errorf("similarity of %s: expected %v (found %v), got %v (found %v)", key, expected, ok, got, found)
+		}
+	}
+}
+
+// BenchmarkIndexLookup looks up a misspelt value among keys that mostly
+// share its first trigrams.
+func BenchmarkIndexLookup(b *testing.B) {
+	for _, numKeys := range []int{1000, 100000} {
+		index := matching.NewIndex(append(highCardinalityKeys(numKeys), "payments-checkout"))
+		b.Run(fmt.Sprintf("keys=%d", numKeys), func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				index.Lookup("payments-chekout")
+			}
+		})
+	}
+}
+
+func TestNormalize(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{"http_requests_total", "http_request_total"},
+		{"HTTPRequestsTotal", "http_request_total"},
+		{"latencies", "latency"},
+		{"processes", "process"},
+		{"status", "status"},
+		{"conns", "connection"},
+	}
+	for _, tt := range tests {
+		if got := matching.Normalize(tt.input); got != tt.expected {
+			t.Errorf("Normalize(%q) = %q, expected %q", tt.input, got, tt.expected)
+		}
+	}
+}
+
+func TestSimilarity(t *testing.T) {
+	if got := matching.Similarity("Payments", "payment"); got != 1 {
+		t.Errorf("expected forms equal after normalization to have a similarity of 1, got %v", got)
+	}
+	related, unrelated := matching.Similarity("payments", "payments-api"), matching.Similarity("payments", "search")
+	if related <= unrelated || unrelated != 0 {
+		t.Errorf("expected payments to be more similar to payments-api (%v) than to search (%v), which shares nothing", related, unrelated)
+	}
+}
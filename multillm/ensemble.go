@@ -0,0 +1,311 @@
+// Package multillm provides llm.LLMClient implementations that combine several
+// underlying clients, each usually backed by a different model.
+package multillm
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+	"unicode"
+
+	"github.com/prashantgupta17/nlpromql/llm"
+	"github.com/prashantgupta17/nlpromql/promql"
+)
+
+// Member is one model of an Ensemble.
+type Member struct {
+	Name    string        // Identifier of the model, e.g. "openai/gpt-4o"; recorded on candidates
+	Client  llm.LLMClient // Client for the model
+	Weight  float64       // Weight of the model's scores; 0 means 1
+	Timeout time.Duration // Time after which the model's answer is abandoned; 0 means no limit
+}
+
+// Ensemble is an llm.LLMClient that sends GetPromQLFromLLM to all of its
+// members in parallel and merges their candidates. Equivalent candidates are
+// combined into one whose score is the weighted mean of the members' scores,
+// counting 0 for members that did not propose it, so agreement between models
+// raises a candidate. Members that fail or time out are left out of the mean.
+//
+// The other methods only need one answer and are sent to the primary client.
+type Ensemble struct {
+	primary llm.LLMClient
+	members []Member
+}
+
+// NewEnsemble creates an Ensemble of members. primary handles every method
+// except GetPromQLFromLLM.
+func NewEnsemble(primary llm.LLMClient, members ...Member) (*Ensemble, error) {
+	if primary == nil {
+		return nil, errors.New("ensemble needs a primary client")
+	}
+	if len(members) == 0 {
+		return nil, errors.New("ensemble needs at least one member")
+	}
+	e := &Ensemble{primary: primary, members: make([]Member, len(members))}
+	for i, member := range members {
+		if member.Client == nil {
+			return nil, fmt.Errorf("ensemble member %s has no client", member.Name)
+		}
+		if member.Weight < 0 {
+			return nil, fmt.Errorf("ensemble member %s has negative weight %v", member.Name, member.Weight)
+		}
+		if member.Weight == 0 {
+			member.Weight = 1
+		}
+		e.members[i] = member
+	}
+	return e, nil
+}
+
+// GetMetricSynonyms calls the primary client.
+func (e *Ensemble) GetMetricSynonyms(ctx context.Context, metricBatches []map[string]string) (map[string][]string, error) {
+	return e.primary.GetMetricSynonyms(ctx, metricBatches)
+}
+
+// GetLabelSynonyms calls the primary client.
+func (e *Ensemble) GetLabelSynonyms(ctx context.Context, labelBatches [][]string) (map[string][]string, error) {
+	return e.primary.GetLabelSynonyms(ctx, labelBatches)
+}
+
+// ProcessUserQuery calls the primary client.
+func (e *Ensemble) ProcessUserQuery(ctx context.Context, userQuery string) (*llm.QueryAnalysis, error) {
+	return e.primary.ProcessUserQuery(ctx, userQuery)
+}
+
+// RepairPromQL calls the primary client.
+func (e *Ensemble) RepairPromQL(ctx context.Context, userQuery, promql, validationError string) (string, error) {
+	return e.primary.RepairPromQL(ctx, userQuery, promql, validationError)
+}
+
+// GetPromQLFromLLM asks every member for candidates and merges them. Only the
+// first member streams its response, as interleaved chunks of several models
+// would be unreadable. It fails only if every member fails.
+func (e *Ensemble) GetPromQLFromLLM(ctx context.Context, userQuery string, relevantMetrics llm.RelevantMetricsMap, relevantLabels llm.RelevantLabelsMap, relevantHistory map[string]interface{}, intent *llm.QueryIntent, metricScores llm.MetricScores) (*llm.PromQLResult, error) {
+	results := make([]*llm.PromQLResult, len(e.members))
+	errs := make([]error, len(e.members))
+	usage := make([]*llm.UsageRecorder, len(e.members))
+	var wg sync.WaitGroup
+	for i, member := range e.members {
+		wg.Add(1)
+		go func(i int, member Member) {
+			defer wg.Done()
+			memberCtx, recorder := llm.WithScopedUsage(ctx)
+			usage[i] = recorder
+			if i > 0 {
+				memberCtx = llm.WithStreamingFunc(memberCtx, nil)
+			}
+			if member.Timeout > 0 {
+				var cancel context.CancelFunc
+				memberCtx, cancel = context.WithTimeout(memberCtx, member.Timeout)
+				defer cancel()
+			}
+
+			// Clients that do not honour the context must not hold up the ensemble either.
+			start := time.Now()
+			done := make(chan struct{})
+			var result *llm.PromQLResult
+			var err error
+			go func() {
+				defer close(done)
+				result, err = member.Client.GetPromQLFromLLM(memberCtx, userQuery, relevantMetrics, relevantLabels, relevantHistory, intent, metricScores)
+			}()
+			select {
+			case <-done:
+				results[i], errs[i] = result, err
+			case <-memberCtx.Done():
+				errs[i] = fmt.Errorf("no answer: %w", memberCtx.Err())
+			}
+			if errs[i] != nil {
+				log.Printf("Ensemble member %s failed after %v: %v\n", member.Name, time.Since(start).Round(time.Millisecond), errs[i])
+			}
+		}(i, member)
+	}
+	wg.Wait()
+
+	var answered []int
+	var failures []string
+	for i := range e.members {
+		if errs[i] != nil {
+			failures = append(failures, fmt.Sprintf("%s: %v", e.members[i].Name, errs[i]))
+		} else {
+			answered = append(answered, i)
+		}
+	}
+	if len(answered) == 0 {
+		return nil, fmt.Errorf("all ensemble members failed: %s", strings.Join(failures, "; "))
+	}
+	return e.merge(answered, results, usage), nil
+}
+
+// merge combines the results of the members at the indices in answered,
+// recording on each candidate the members that proposed it and their usage.
+func (e *Ensemble) merge(answered []int, results []*llm.PromQLResult, usage []*llm.UsageRecorder) *llm.PromQLResult {
+	type merged struct {
+		candidate   llm.PromQLCandidate
+		weightedSum float64
+		bestWeight  float64 // Weight of the member whose PromQL and label pairs are kept
+		order       int     // Position of first appearance, to keep ties stable
+	}
+	byKey := make(map[string]*merged)
+	var totalWeight float64
+	for _, i := range answered {
+		member := e.members[i]
+		totalWeight += member.Weight
+		seen := make(map[string]bool) // A member proposing a query twice counts once
+		for _, candidate := range results[i].Candidates {
+			key := candidateKey(candidate)
+			if seen[key] {
+				continue
+			}
+			seen[key] = true
+
+			m, ok := byKey[key]
+			if !ok {
+				m = &merged{order: len(byKey)}
+				byKey[key] = m
+			}
+			m.weightedSum += member.Weight * candidate.Score
+			if member.Weight > m.bestWeight {
+				m.bestWeight = member.Weight
+				m.candidate.PromQL = candidate.PromQL
+				m.candidate.MetricLabelPairs = candidate.MetricLabelPairs
+				m.candidate.Spec = candidate.Spec
+			}
+			m.candidate.Models = append(m.candidate.Models, member.Name)
+			m.candidate.Contributors = append(m.candidate.Contributors, llm.Contributor{
+				Model:    member.Name,
+				Provider: results[i].Provider,
+				Score:    candidate.Score,
+				Usage:    usage[i].Report().Total,
+			})
+		}
+	}
+
+	all := make([]*merged, 0, len(byKey))
+	for _, m := range byKey {
+		m.candidate.Score = m.weightedSum / totalWeight
+		all = append(all, m)
+	}
+	sort.Slice(all, func(i, j int) bool {
+		if all[i].candidate.Score != all[j].candidate.Score {
+			return all[i].candidate.Score > all[j].candidate.Score
+		}
+		return all[i].order < all[j].order
+	})
+
+	// Report what the heaviest answering member dropped and which prompts it used.
+	heaviest := answered[0]
+	for _, i := range answered {
+		if e.members[i].Weight > e.members[heaviest].Weight {
+			heaviest = i
+		}
+	}
+	result := &llm.PromQLResult{
+		Candidates:     make([]llm.PromQLCandidate, len(all)),
+		Dropped:        results[heaviest].Dropped,
+		PromptVersions: results[heaviest].PromptVersions,
+	}
+	for i, m := range all {
+		result.Candidates[i] = m.candidate
+	}
+	return result
+}
+
+// candidateKey returns the key under which equivalent candidates are merged:
+// the normalized query (see promql.Normalize), so that queries differing only
+// in label order, grouping placement, whitespace or redundant parentheses are
+// merged. Candidates generated as specs have no PromQL yet and are keyed by
+// their spec.
+func candidateKey(candidate llm.PromQLCandidate) string {
+	if candidate.PromQL == "" && candidate.Spec != nil {
+		if spec, err := json.Marshal(candidate.Spec); err == nil {
+			return "spec:" + string(spec)
+		}
+	}
+	if expr, err := promql.Parse(candidate.PromQL); err == nil {
+		return promql.Normalize(expr).String()
+	}
+	return normalizePromQL(candidate.PromQL)
+}
+
+// normalizePromQL returns the key under which equivalent candidates that do
+// not parse are merged: the query with all whitespace outside string
+// literals removed.
+func normalizePromQL(query string) string {
+	var b strings.Builder
+	var quote rune
+	escaped := false
+	for _, r := range query {
+		switch {
+		case quote != 0:
+			if escaped {
+				escaped = false
+			} else if r == '\\' {
+				escaped = true
+			} else if r == quote {
+				quote = 0
+			}
+		case r == '"' || r == '\'' || r == '`':
+			quote = r
+		case unicode.IsSpace(r):
+			continue
+		}
+		b.WriteRune(r)
+	}
+	return b.String()
+}
+
+// MemberSpec configures an ensemble member from the command line.
+type MemberSpec struct {
+	Model   string
+	Weight  float64
+	Timeout time.Duration
+}
+
+// ParseMemberSpecs parses a comma-separated list of models, each optionally
+// followed by ;weight=<float> and ;timeout=<duration>, e.g.
+// "openai/gpt-4o;weight=2;timeout=20s,anthropic/claude-3-haiku-20240307".
+// Members without a timeout get defaultTimeout.
+func ParseMemberSpecs(specs string, defaultTimeout time.Duration) ([]MemberSpec, error) {
+	var members []MemberSpec
+	for _, spec := range strings.Split(specs, ",") {
+		fields := strings.Split(strings.TrimSpace(spec), ";")
+		member := MemberSpec{Model: strings.TrimSpace(fields[0]), Weight: 1, Timeout: defaultTimeout}
+		if member.Model == "" {
+			return nil, fmt.Errorf("empty model in ensemble spec %q", specs)
+		}
+		for _, field := range fields[1:] {
+			key, value, ok := strings.Cut(strings.TrimSpace(field), "=")
+			if !ok {
+				return nil, fmt.Errorf("invalid option %q for ensemble member %s", field, member.Model)
+			}
+			var err error
+			switch key {
+			case "weight":
+				member.Weight, err = strconv.ParseFloat(value, 64)
+				if err == nil && member.Weight <= 0 {
+					err = errors.New("must be positive")
+				}
+			case "timeout":
+				member.Timeout, err = time.ParseDuration(value)
+			default:
+				err = errors.New("unknown option")
+			}
+			if err != nil {
+				return nil, fmt.Errorf("invalid option %q for ensemble member %s: %v", field, member.Model, err)
+			}
+		}
+		members = append(members, member)
+	}
+	return members, nil
+}
+
+// Ensure Ensemble implements the llm.LLMClient interface.
+var _ llm.LLMClient = (*Ensemble)(nil)
@@ -0,0 +1,173 @@
+package multillm_test
+
+import (
+	"context"
+	"errors"
+	"math"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/prashantgupta17/nlpromql/llm"
+	"github.com/prashantgupta17/nlpromql/multillm"
+)
+
+// MockLLMClient_EnsembleTest answers GetPromQLFromLLM with fixed candidates
+// after an optional delay, served by Provider and using PromptTokens.
+type MockLLMClient_EnsembleTest struct {
+	Candidates   []llm.PromQLCandidate
+	Provider     string
+	PromptTokens int
+	Err          error
+	Delay        time.Duration
+	Streamed     bool // Whether the context carried a streaming function
+}
+
+func (m *MockLLMClient_EnsembleTest) GetMetricSynonyms(ctx context.Context, metricBatches []map[string]string) (map[string][]string, error) {
+	return map[string][]string{"metric": {"synonym"}}, nil
+}
+
+func (m *MockLLMClient_EnsembleTest) GetLabelSynonyms(ctx context.Context, labelBatches [][]string) (map[string][]string, error) {
+	return map[string][]string{"label": {"synonym"}}, nil
+}
+
+func (m *MockLLMClient_EnsembleTest) ProcessUserQuery(ctx context.Context, userQuery string) (*llm.QueryAnalysis, error) {
+	return &llm.QueryAnalysis{MetricNames: []string{userQuery}}, nil
+}
+
+func (m *MockLLMClient_EnsembleTest) RepairPromQL(ctx context.Context, userQuery, promql, validationError string) (string, error) {
+	return promql, nil
+}
+
+func (m *MockLLMClient_EnsembleTest) GetPromQLFromLLM(ctx context.Context, userQuery string, relevantMetrics llm.RelevantMetricsMap, relevantLabels llm.RelevantLabelsMap, relevantHistory map[string]interface{}, intent *llm.QueryIntent, metricScores llm.MetricScores) (*llm.PromQLResult, error) {
+	m.Streamed = llm.StreamingFuncFromContext(ctx) != nil
+	if m.Delay > 0 {
+		// Deliberately ignores ctx, like a client stuck in a call.
+		time.Sleep(m.Delay)
+	}
+	if m.Err != nil {
+		return nil, m.Err
+	}
+	llm.RecordUsage(ctx, llm.OperationPromQLGeneration, m.Provider, m.PromptTokens, 10, false)
+	return &llm.PromQLResult{Candidates: m.Candidates, Provider: m.Provider}, nil
+}
+
+func TestEnsemble_GetPromQLFromLLM(t *testing.T) {
+	primary := &MockLLMClient_EnsembleTest{PromptTokens: 100, Candidates: []llm.PromQLCandidate{
+		{PromQL: "sum by (job) (rate(http_requests_total[5m]))", Score: 0.9, MetricLabelPairs: map[string]interface{}{"http_requests_total": map[string]interface{}{"job": ""}}},
+		{PromQL: "up", Score: 0.3},
+	}}
+	secondary := &MockLLMClient_EnsembleTest{Provider: "b-backup", PromptTokens: 50, Candidates: []llm.PromQLCandidate{
+		{PromQL: "sum(rate(http_requests_total[5m])) by(job)", Score: 0.6},
+		{PromQL: `count(up{job="my job"})`, Score: 0.8},
+	}}
+	failing := &MockLLMClient_EnsembleTest{Err: errors.New("rate limited")}
+	slow := &MockLLMClient_EnsembleTest{Delay: time.Second, Candidates: []llm.PromQLCandidate{{PromQL: "slow", Score: 1}}}
+
+	ensemble, err := multillm.NewEnsemble(primary,
+		multillm.Member{Name: "a", Client: primary, Weight: 2},
+		multillm.Member{Name: "b", Client: secondary},
+		multillm.Member{Name: "failing", Client: failing, Weight: 5},
+		multillm.Member{Name: "slow", Client: slow, Weight: 5, Timeout: 20 * time.Millisecond},
+	)
+	if err != nil {
+		t.Fatalf("NewEnsemble returned an unexpected error: %v", err)
+	}
+
+	requestUsage := llm.NewUsageRecorder(nil)
+	ctx := llm.WithUsageRecorder(context.Background(), requestUsage)
+	ctx = llm.WithStreamingFunc(ctx, func(context.Context, []byte) error { return nil })
+	start := time.Now()
+	result, err := ensemble.GetPromQLFromLLM(ctx, "requests per job", llm.RelevantMetricsMap{}, llm.RelevantLabelsMap{}, nil, nil, nil)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if elapsed := time.Since(start); elapsed > 500*time.Millisecond {
+		t.Errorf("slow member held up the ensemble for %v", elapsed)
+	}
+	if !primary.Streamed || secondary.Streamed {
+		t.Errorf("expected only the first member to stream, got a=%v b=%v", primary.Streamed, secondary.Streamed)
+	}
+
+	// Scores are weighted means over the answering members (total weight 3).
+	usageA := llm.TokenUsage{Calls: 1, PromptTokens: 100, CompletionTokens: 10}
+	usageB := llm.TokenUsage{Calls: 1, PromptTokens: 50, CompletionTokens: 10}
+	expected := []llm.PromQLCandidate{
+		{PromQL: "sum by (job) (rate(http_requests_total[5m]))", Score: (2*0.9 + 0.6) / 3, Models: []string{"a", "b"},
+			MetricLabelPairs: map[string]interface{}{"http_requests_total": map[string]interface{}{"job": ""}},
+			Contributors: []llm.Contributor{
+				{Model: "a", Score: 0.9, Usage: usageA},
+				{Model: "b", Provider: "b-backup", Score: 0.6, Usage: usageB},
+			}},
+		{PromQL: `count(up{job="my job"})`, Score: 0.8 / 3, Models: []string{"b"},
+			Contributors: []llm.Contributor{{Model: "b", Provider: "b-backup", Score: 0.8, Usage: usageB}}},
+		{PromQL: "up", Score: 2 * 0.3 / 3, Models: []string{"a"},
+			Contributors: []llm.Contributor{{Model: "a", Score: 0.3, Usage: usageA}}},
+	}
+	if len(result.Candidates) != len(expected) {
+		t.Fatalf("expected %d candidates, got %+v", len(expected), result.Candidates)
+	}
+	for i, candidate := range result.Candidates {
+		if math.Abs(candidate.Score-expected[i].Score) > 1e-9 {
+			t.Errorf("candidate %d: expected score %v, got %v", i, expected[i].Score, candidate.Score)
+		}
+		candidate.Score = expected[i].Score
+		if !reflect.DeepEqual(candidate, expected[i]) {
+			t.Errorf("candidate %d: expected %+v, got %+v", i, expected[i], candidate)
+		}
+	}
+	// The members' usage is still recorded for the whole request.
+	if total := requestUsage.Report().Total; total.PromptTokens != 150 {
+		t.Errorf("expected 150 prompt tokens recorded for the request, got %+v", total)
+	}
+}
+
+func TestEnsemble_AllMembersFail(t *testing.T) {
+	a := &MockLLMClient_EnsembleTest{Err: errors.New("rate limited")}
+	b := &MockLLMClient_EnsembleTest{Err: errors.New("rate limited")}
+	ensemble, err := multillm.NewEnsemble(a, multillm.Member{Name: "a", Client: a}, multillm.Member{Name: "b", Client: b})
+	if err != nil {
+		t.Fatalf("NewEnsemble returned an unexpected error: %v", err)
+	}
+	_, err = ensemble.GetPromQLFromLLM(context.Background(), "q", nil, nil, nil, nil, nil)
+	if err == nil || !strings.Contains(err.Error(), "a: rate limited") || !strings.Contains(err.Error(), "b: rate limited") {
+		t.Errorf("expected an error naming every member, got %v", err)
+	}
+}
+
+func TestParseMemberSpecs(t *testing.T) {
+	tests := []struct {
+		spec        string
+		expected    []multillm.MemberSpec
+		expectedErr string
+	}{
+		{
+			spec: "openai/gpt-4o;weight=2;timeout=20s, anthropic/claude-3-haiku-20240307",
+			expected: []multillm.MemberSpec{
+				{Model: "openai/gpt-4o", Weight: 2, Timeout: 20 * time.Second},
+				{Model: "anthropic/claude-3-haiku-20240307", Weight: 1, Timeout: time.Minute},
+			},
+		},
+		{spec: "openai/gpt-4o;weight=0", expectedErr: "must be positive"},
+		{spec: "openai/gpt-4o;timeout=soon", expectedErr: "invalid option"},
+		{spec: "openai/gpt-4o;temperature=1", expectedErr: "unknown option"},
+		{spec: "openai/gpt-4o,,", expectedErr: "empty model"},
+	}
+	for _, tt := range tests {
+		got, err := multillm.ParseMemberSpecs(tt.spec, time.Minute)
+		if tt.expectedErr != "" {
+			if err == nil || !strings.Contains(err.Error(), tt.expectedErr) {
+				t.Errorf("ParseMemberSpecs(%q): expected error containing %q, got %v", tt.spec, tt.expectedErr, err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("ParseMemberSpecs(%q) returned an unexpected error: %v", tt.spec, err)
+			continue
+		}
+		if !reflect.DeepEqual(got, tt.expected) {
+			t.Errorf("ParseMemberSpecs(%q) = %+v, expected %+v", tt.spec, got, tt.expected)
+		}
+	}
+}
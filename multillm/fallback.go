@@ -0,0 +1,207 @@
+package multillm
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/prashantgupta17/nlpromql/llm"
+)
+
+// Provider is one client of a Fallback chain.
+type Provider struct {
+	Name   string // Identifier of the provider's model, e.g. "openai/gpt-4o"
+	Client llm.LLMClient
+}
+
+// BreakerConfig configures the circuit breaker of each provider in a Fallback.
+type BreakerConfig struct {
+	// FailureThreshold is the number of consecutive failures after which a
+	// provider is skipped. 0 disables the breaker.
+	FailureThreshold int
+	// Cooldown is how long a provider is skipped for. Once it has passed, the
+	// next call tries the provider again; a failure opens the breaker for
+	// another cooldown, a success closes it.
+	Cooldown time.Duration
+}
+
+// breaker tracks the consecutive failures of a provider.
+type breaker struct {
+	mu        sync.Mutex
+	failures  int
+	openUntil time.Time
+}
+
+func (b *breaker) allow(now time.Time) bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return !now.Before(b.openUntil)
+}
+
+func (b *breaker) success() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.failures = 0
+	b.openUntil = time.Time{}
+}
+
+// failure records a failure and reports whether it opened the breaker.
+func (b *breaker) failure(now time.Time, config BreakerConfig) bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.failures++
+	if config.FailureThreshold > 0 && b.failures >= config.FailureThreshold {
+		b.openUntil = now.Add(config.Cooldown)
+		return true
+	}
+	return false
+}
+
+// Fallback is an llm.LLMClient that sends each call to its first provider and,
+// if that fails, to the next ones in order. Providers that keep failing are
+// skipped for a cooldown period by a circuit breaker, so an outage does not
+// add the failing provider's latency to every call.
+//
+// The provider that served each call is logged, and GetPromQLFromLLM records
+// it in the result.
+type Fallback struct {
+	providers []Provider
+	breakers  []*breaker
+	config    BreakerConfig
+}
+
+// NewFallback creates a Fallback trying providers in the given order.
+func NewFallback(config BreakerConfig, providers ...Provider) (*Fallback, error) {
+	if len(providers) == 0 {
+		return nil, errors.New("fallback chain needs at least one provider")
+	}
+	f := &Fallback{providers: providers, config: config}
+	for _, provider := range providers {
+		if provider.Client == nil {
+			return nil, fmt.Errorf("provider %s has no client", provider.Name)
+		}
+		f.breakers = append(f.breakers, &breaker{})
+	}
+	return f, nil
+}
+
+// call runs fn against each provider in turn until one succeeds, and returns
+// the name of that provider.
+func (f *Fallback) call(ctx context.Context, op llm.Operation, fn func(client llm.LLMClient) error) (string, error) {
+	var failures []string
+	for i, provider := range f.providers {
+		if !f.breakers[i].allow(time.Now()) {
+			failures = append(failures, fmt.Sprintf("%s: circuit open", provider.Name))
+			continue
+		}
+
+		err := fn(provider.Client)
+		if err == nil {
+			f.breakers[i].success()
+			log.Printf("LLM call %s served by %s\n", op, provider.Name)
+			return provider.Name, nil
+		}
+		if ctx.Err() != nil {
+			// The caller gave up; the provider is not to blame.
+			return "", err
+		}
+		if f.breakers[i].failure(time.Now(), f.config) {
+			log.Printf("LLM provider %s failed %d times in a row; skipping it for %v\n", provider.Name, f.config.FailureThreshold, f.config.Cooldown)
+		}
+		log.Printf("LLM call %s failed on %s: %v\n", op, provider.Name, err)
+		failures = append(failures, fmt.Sprintf("%s: %v", provider.Name, err))
+	}
+	return "", fmt.Errorf("all LLM providers failed for %s: %s", op, strings.Join(failures, "; "))
+}
+
+// GetMetricSynonyms calls the first available provider.
+func (f *Fallback) GetMetricSynonyms(ctx context.Context, metricBatches []map[string]string) (map[string][]string, error) {
+	var synonyms map[string][]string
+	_, err := f.call(ctx, llm.OperationMetricSynonyms, func(client llm.LLMClient) error {
+		var err error
+		synonyms, err = client.GetMetricSynonyms(ctx, metricBatches)
+		return err
+	})
+	return synonyms, err
+}
+
+// GetLabelSynonyms calls the first available provider.
+func (f *Fallback) GetLabelSynonyms(ctx context.Context, labelBatches [][]string) (map[string][]string, error) {
+	var synonyms map[string][]string
+	_, err := f.call(ctx, llm.OperationLabelSynonyms, func(client llm.LLMClient) error {
+		var err error
+		synonyms, err = client.GetLabelSynonyms(ctx, labelBatches)
+		return err
+	})
+	return synonyms, err
+}
+
+// ProcessUserQuery calls the first available provider.
+func (f *Fallback) ProcessUserQuery(ctx context.Context, userQuery string) (*llm.QueryAnalysis, error) {
+	var result *llm.QueryAnalysis
+	_, err := f.call(ctx, llm.OperationQueryAnalysis, func(client llm.LLMClient) error {
+		var err error
+		result, err = client.ProcessUserQuery(ctx, userQuery)
+		return err
+	})
+	return result, err
+}
+
+// GetPromQLFromLLM calls the first available provider and records its name in
+// the result.
+//
+// If a provider fails after streaming part of its response, the stream is
+// reset (see llm.WithStreamResetFunc) before the next provider streams, so
+// that the two responses are not mixed. Without a reset function, the next
+// providers do not stream at all.
+func (f *Fallback) GetPromQLFromLLM(ctx context.Context, userQuery string, relevantMetrics llm.RelevantMetricsMap, relevantLabels llm.RelevantLabelsMap, relevantHistory map[string]interface{}, intent *llm.QueryIntent, metricScores llm.MetricScores) (*llm.PromQLResult, error) {
+	streamCtx := ctx
+	streamed := false // Whether the current provider has streamed anything
+	if streamingFunc := llm.StreamingFuncFromContext(ctx); streamingFunc != nil {
+		streamCtx = llm.WithStreamingFunc(ctx, func(ctx context.Context, chunk []byte) error {
+			streamed = true
+			return streamingFunc(ctx, chunk)
+		})
+	}
+
+	var result *llm.PromQLResult
+	var lastErr error
+	provider, err := f.call(ctx, llm.OperationPromQLGeneration, func(client llm.LLMClient) error {
+		if streamed {
+			// The previous provider failed partway through its response.
+			streamed = false
+			if reset := llm.StreamResetFuncFromContext(ctx); reset != nil {
+				reset(ctx, lastErr.Error())
+			} else {
+				streamCtx = llm.WithStreamingFunc(ctx, nil)
+			}
+		}
+		var err error
+		result, err = client.GetPromQLFromLLM(streamCtx, userQuery, relevantMetrics, relevantLabels, relevantHistory, intent, metricScores)
+		lastErr = err
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	result.Provider = provider
+	return result, nil
+}
+
+// RepairPromQL calls the first available provider.
+func (f *Fallback) RepairPromQL(ctx context.Context, userQuery, promql, validationError string) (string, error) {
+	var repaired string
+	_, err := f.call(ctx, llm.OperationPromQLRepair, func(client llm.LLMClient) error {
+		var err error
+		repaired, err = client.RepairPromQL(ctx, userQuery, promql, validationError)
+		return err
+	})
+	return repaired, err
+}
+
+// Ensure Fallback implements the llm.LLMClient interface.
+var _ llm.LLMClient = (*Fallback)(nil)
@@ -0,0 +1,192 @@
+package multillm_test
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/prashantgupta17/nlpromql/llm"
+	"github.com/prashantgupta17/nlpromql/multillm"
+)
+
+// MockLLMClient_FallbackTest fails every call while Err is set and counts the
+// calls it receives. GetPromQLFromLLM streams Chunks before failing or
+// answering.
+type MockLLMClient_FallbackTest struct {
+	Name   string
+	Err    error
+	Calls  int
+	Chunks []string
+}
+
+func (m *MockLLMClient_FallbackTest) GetMetricSynonyms(ctx context.Context, metricBatches []map[string]string) (map[string][]string, error) {
+	m.Calls++
+	if m.Err != nil {
+		return nil, m.Err
+	}
+	return map[string][]string{"metric": {m.Name}}, nil
+}
+
+func (m *MockLLMClient_FallbackTest) GetLabelSynonyms(ctx context.Context, labelBatches [][]string) (map[string][]string, error) {
+	m.Calls++
+	if m.Err != nil {
+		return nil, m.Err
+	}
+	return map[string][]string{"label": {m.Name}}, nil
+}
+
+func (m *MockLLMClient_FallbackTest) ProcessUserQuery(ctx context.Context, userQuery string) (*llm.QueryAnalysis, error) {
+	m.Calls++
+	if m.Err != nil {
+		return nil, m.Err
+	}
+	return &llm.QueryAnalysis{MetricNames: []string{m.Name}}, nil
+}
+
+func (m *MockLLMClient_FallbackTest) GetPromQLFromLLM(ctx context.Context, userQuery string, relevantMetrics llm.RelevantMetricsMap, relevantLabels llm.RelevantLabelsMap, relevantHistory map[string]interface{}, intent *llm.QueryIntent, metricScores llm.MetricScores) (*llm.PromQLResult, error) {
+	m.Calls++
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	if streamingFunc := llm.StreamingFuncFromContext(ctx); streamingFunc != nil {
+		for _, chunk := range m.Chunks {
+			if err := streamingFunc(ctx, []byte(chunk)); err != nil {
+				return nil, err
+			}
+		}
+	}
+	if m.Err != nil {
+		return nil, m.Err
+	}
+	return &llm.PromQLResult{Candidates: []llm.PromQLCandidate{{PromQL: "up", Score: 1}}}, nil
+}
+
+func (m *MockLLMClient_FallbackTest) RepairPromQL(ctx context.Context, userQuery, promql, validationError string) (string, error) {
+	m.Calls++
+	if m.Err != nil {
+		return "", m.Err
+	}
+	return promql, nil
+}
+
+func TestFallback_CircuitBreaker(t *testing.T) {
+	primary := &MockLLMClient_FallbackTest{Name: "primary", Err: errors.New("503 service unavailable")}
+	backup := &MockLLMClient_FallbackTest{Name: "backup"}
+	fallback, err := multillm.NewFallback(multillm.BreakerConfig{FailureThreshold: 2, Cooldown: 50 * time.Millisecond},
+		multillm.Provider{Name: "primary", Client: primary},
+		multillm.Provider{Name: "backup", Client: backup},
+	)
+	if err != nil {
+		t.Fatalf("NewFallback returned an unexpected error: %v", err)
+	}
+
+	// The first two calls try the primary before falling back; then the breaker opens.
+	for i := 0; i < 3; i++ {
+		result, err := fallback.GetPromQLFromLLM(context.Background(), "q", nil, nil, nil, nil, nil)
+		if err != nil {
+			t.Fatalf("call %d: unexpected error: %v", i, err)
+		}
+		if result.Provider != "backup" {
+			t.Errorf("call %d: expected the backup to serve, got %q", i, result.Provider)
+		}
+	}
+	if primary.Calls != 2 || backup.Calls != 3 {
+		t.Errorf("expected 2 primary and 3 backup calls, got %d and %d", primary.Calls, backup.Calls)
+	}
+
+	// Once the cooldown has passed, the recovered primary is tried again.
+	time.Sleep(60 * time.Millisecond)
+	primary.Err = nil
+	served, err := fallback.ProcessUserQuery(context.Background(), "q")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !reflect.DeepEqual(served.MetricNames, []string{"primary"}) {
+		t.Errorf("expected the primary to serve after the cooldown, got %v", served.MetricNames)
+	}
+}
+
+func TestFallback_Errors(t *testing.T) {
+	primary := &MockLLMClient_FallbackTest{Name: "primary", Err: errors.New("503 service unavailable")}
+	backup := &MockLLMClient_FallbackTest{Name: "backup", Err: errors.New("429 too many requests")}
+	fallback, err := multillm.NewFallback(multillm.BreakerConfig{FailureThreshold: 1, Cooldown: time.Minute},
+		multillm.Provider{Name: "primary", Client: primary},
+		multillm.Provider{Name: "backup", Client: backup},
+	)
+	if err != nil {
+		t.Fatalf("NewFallback returned an unexpected error: %v", err)
+	}
+
+	// A cancelled request neither falls back nor counts against the provider.
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, err := fallback.GetPromQLFromLLM(ctx, "q", nil, nil, nil, nil, nil); !errors.Is(err, context.Canceled) {
+		t.Errorf("expected context.Canceled, got %v", err)
+	}
+	if backup.Calls != 0 {
+		t.Errorf("expected no fallback for a cancelled request, got %d backup calls", backup.Calls)
+	}
+
+	_, err = fallback.GetMetricSynonyms(context.Background(), nil)
+	if err == nil || !strings.Contains(err.Error(), "primary: 503") || !strings.Contains(err.Error(), "backup: 429") {
+		t.Errorf("expected an error naming every provider, got %v", err)
+	}
+	_, err = fallback.GetLabelSynonyms(context.Background(), nil)
+	if err == nil || !strings.Contains(err.Error(), "primary: circuit open") {
+		t.Errorf("expected open circuits to be reported, got %v", err)
+	}
+	if primary.Calls != 2 {
+		t.Errorf("expected the open circuit to skip the primary, got %d calls", primary.Calls)
+	}
+}
+
+func TestFallback_StreamFailure(t *testing.T) {
+	tests := []struct {
+		name     string
+		primary  []string // Chunks the primary streams before failing
+		reset    bool     // Whether the context has a reset function
+		expected []string
+	}{
+		{"Failure mid-stream", []string{`[{"promql": "ra`}, true,
+			[]string{`[{"promql": "ra`, "reset: 503 service unavailable", `[{"promql": "up"}]`}},
+		{"Failure before streaming", nil, true, []string{`[{"promql": "up"}]`}},
+		{"Failure mid-stream without reset", []string{`[{"promql": "ra`}, false, []string{`[{"promql": "ra`}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			primary := &MockLLMClient_FallbackTest{Name: "primary", Err: errors.New("503 service unavailable"), Chunks: tt.primary}
+			backup := &MockLLMClient_FallbackTest{Name: "backup", Chunks: []string{`[{"promql": "up"}]`}}
+			fallback, err := multillm.NewFallback(multillm.BreakerConfig{},
+				multillm.Provider{Name: "primary", Client: primary},
+				multillm.Provider{Name: "backup", Client: backup},
+			)
+			if err != nil {
+				t.Fatalf("NewFallback returned an unexpected error: %v", err)
+			}
+
+			var events []string
+			ctx := llm.WithStreamingFunc(context.Background(), func(_ context.Context, chunk []byte) error {
+				events = append(events, string(chunk))
+				return nil
+			})
+			if tt.reset {
+				ctx = llm.WithStreamResetFunc(ctx, func(_ context.Context, reason string) {
+					events = append(events, "reset: "+reason)
+				})
+			}
+			result, err := fallback.GetPromQLFromLLM(ctx, "q", nil, nil, nil, nil, nil)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if result.Provider != "backup" {
+				t.Errorf("expected the backup to serve, got %q", result.Provider)
+			}
+			if !reflect.DeepEqual(events, tt.expected) {
+				t.Errorf("streamed %q, expected %q", events, tt.expected)
+			}
+		})
+	}
+}
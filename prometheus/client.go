@@ -94,8 +94,10 @@ func (p *PrometheusConnect) CustomQuery(query string) ([]Metric, error) {
 	defer resp.Body.Close()
 
 	var result struct {
-		Status string `json:"status"`
-		Data   struct {
+		Status    string `json:"status"`
+		ErrorType string `json:"errorType"`
+		Error     string `json:"error"`
+		Data      struct {
 			ResultType string   `json:"resultType"`
 			Result     []Metric `json:"result"`
 		} `json:"data"`
@@ -104,6 +106,9 @@ func (p *PrometheusConnect) CustomQuery(query string) ([]Metric, error) {
 		return nil, fmt.Errorf("error decoding query response: %v", err)
 	}
 	if result.Status != "success" {
+		if result.ErrorType != "" {
+			return nil, &APIError{Type: result.ErrorType, Message: result.Error}
+		}
 		return nil, fmt.Errorf("prometheus API error: %s", result.Status)
 	}
 
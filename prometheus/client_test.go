@@ -0,0 +1,86 @@
+package prometheus_test
+
+import (
+	"errors"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/prashantgupta17/nlpromql/prometheus"
+	"github.com/prashantgupta17/nlpromql/promtest"
+)
+
+func TestPrometheusConnect(t *testing.T) {
+	server := promtest.NewServer(promtest.DefaultDataset(time.Now()))
+	defer server.Close()
+	server.Handler.Username, server.Handler.Password = "user", "secret"
+	client := prometheus.NewPrometheusConnect(server.URL, "user", "secret")
+
+	metrics, err := client.AllMetrics()
+	if err != nil {
+		t.Fatalf("AllMetrics returned an unexpected error: %v", err)
+	}
+	if expected := []string{"http_requests_total", "node_memory_MemAvailable_bytes", "node_memory_MemTotal_bytes", "up"}; !reflect.DeepEqual(metrics, expected) {
+		t.Errorf("AllMetrics = %v, expected %v", metrics, expected)
+	}
+
+	labels, err := client.AllLabels()
+	if err != nil {
+		t.Fatalf("AllLabels returned an unexpected error: %v", err)
+	}
+	if expected := []string{"__name__", "code", "instance", "job", "method"}; !reflect.DeepEqual(labels, expected) {
+		t.Errorf("AllLabels = %v, expected %v", labels, expected)
+	}
+
+	metadata, err := client.AllMetadata()
+	if err != nil {
+		t.Fatalf("AllMetadata returned an unexpected error: %v", err)
+	}
+	if len(metadata) != 4 || metadata["http_requests_total"] != "Total number of HTTP requests." {
+		t.Errorf("unexpected AllMetadata %v", metadata)
+	}
+
+	result, err := client.CustomQuery(`sum by (job) (rate(http_requests_total{code!="500"}[5m]))`)
+	if err != nil {
+		t.Fatalf("CustomQuery returned an unexpected error: %v", err)
+	}
+	var got []string
+	for _, metric := range result {
+		got = append(got, metric.Metric["job"]+"="+metric.Value[1].(string))
+	}
+	sort.Strings(got)
+	if expected := []string{"api=1", "web=2"}; !reflect.DeepEqual(got, expected) {
+		t.Errorf("CustomQuery = %v, expected %v", got, expected)
+	}
+}
+
+func TestPrometheusConnect_Errors(t *testing.T) {
+	server := promtest.NewServer(promtest.DefaultDataset(time.Now()))
+	defer server.Close()
+
+	tests := []struct {
+		name      string
+		query     string
+		errorType string
+	}{
+		{"Parse error", "sum(rate(x[5m])", "bad_data"},
+		{"Execution error", "max_over_time(up[10m:1m])", "execution"},
+	}
+	client := prometheus.NewPrometheusConnect(server.URL, "", "")
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := client.CustomQuery(tt.query)
+			var apiErr *prometheus.APIError
+			if !errors.As(err, &apiErr) || apiErr.Type != tt.errorType {
+				t.Errorf("expected an APIError of type %q, got %v", tt.errorType, err)
+			}
+		})
+	}
+
+	server.Handler.Username, server.Handler.Password = "user", "secret"
+	if _, err := client.AllMetrics(); err == nil || !strings.Contains(err.Error(), "decoding") {
+		t.Errorf("expected AllMetrics to fail without credentials, got %v", err)
+	}
+}
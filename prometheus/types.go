@@ -1,5 +1,7 @@
 package prometheus
 
+import "fmt"
+
 // Metric represents a Prometheus metric with its labels and value.
 type Metric struct {
 	Metric map[string]string `json:"metric"`
@@ -17,3 +19,14 @@ type AllLabelsResult struct {
 	Status string   `json:"status"`
 	Data   []string `json:"data"`
 }
+
+// APIError is an error reported by the Prometheus API, such as a query that
+// fails to parse (Type "bad_data") or to evaluate (Type "execution").
+type APIError struct {
+	Type    string
+	Message string
+}
+
+func (e *APIError) Error() string {
+	return fmt.Sprintf("prometheus API error (%s): %s", e.Type, e.Message)
+}
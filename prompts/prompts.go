@@ -0,0 +1,237 @@
+// Package prompts holds the prompt templates sent to the LLM. The defaults are
+// embedded in the binary; Load lets a directory of .tmpl files override them
+// so prompts can be tuned without recompiling.
+package prompts
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"embed"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"text/template"
+)
+
+//go:embed templates/*.tmpl
+var embedded embed.FS
+
+// Name identifies a prompt. Its template is read from <name>.tmpl.
+type Name string
+
+const (
+	MetricSynonyms Name = "metric_synonyms"
+	LabelSynonyms  Name = "label_synonyms"
+	ProcessQuery   Name = "process_query"
+	System         Name = "system"
+	PromQLUser     Name = "promql_user"
+	PromQLRepair   Name = "promql_repair"
+	SpecSystem     Name = "spec_system"
+)
+
+// Names lists every prompt, in the order they are validated and reported.
+var Names = []Name{MetricSynonyms, LabelSynonyms, ProcessQuery, System, PromQLUser, PromQLRepair, SpecSystem}
+
+// MetricSynonymsData is rendered into the MetricSynonyms template.
+type MetricSynonymsData struct {
+	MetricData string // JSON object of metric names to descriptions
+}
+
+// LabelSynonymsData is rendered into the LabelSynonyms template.
+type LabelSynonymsData struct {
+	LabelData string // JSON array of label names
+}
+
+// ProcessQueryData is rendered into the ProcessQuery template.
+type ProcessQueryData struct {
+	UserQuery string
+}
+
+// PromQLUserData is rendered into the PromQLUser template. The System and
+// SpecSystem templates take no data.
+type PromQLUserData struct {
+	RelevantMetrics string // JSON
+	RelevantLabels  string // JSON
+	RelevantHistory string // JSON
+	Examples        string // JSON array of few-shot examples; empty when there are none
+	Intent          string // JSON of the query intent; empty when there is none
+	UserQuery       string
+}
+
+// PromQLRepairData is rendered into the PromQLRepair template.
+type PromQLRepairData struct {
+	UserQuery string
+	PromQL    string // The query that failed validation
+	Error     string // The parser or Prometheus error, verbatim
+}
+
+// SampleData holds representative data for each prompt, used by Validate.
+var SampleData = map[Name]interface{}{
+	MetricSynonyms: MetricSynonymsData{MetricData: `{"http_requests_total": "Total number of HTTP requests."}`},
+	LabelSynonyms:  LabelSynonymsData{LabelData: `["instance", "job"]`},
+	ProcessQuery:   ProcessQueryData{UserQuery: "http request rate by job in the dev environment"},
+	System:         nil,
+	SpecSystem:     nil,
+	PromQLUser: PromQLUserData{
+		RelevantMetrics: `{"http_requests_total": {"job": {"MatchScore": 1, "Values": ["api"]}}}`,
+		RelevantLabels:  `{}`,
+		RelevantHistory: `{}`,
+		Examples:        `[{"query": "requests per second", "promql": "sum(rate(http_requests_total[5m]))"}]`,
+		Intent:          `{"time_range": "5m", "aggregation": "sum", "group_by": ["job"], "functions": ["rate"]}`,
+		UserQuery:       "http request rate by job",
+	},
+	PromQLRepair: PromQLRepairData{
+		UserQuery: "http request rate by job",
+		PromQL:    "sum by (job) (rate(http_requests_total))",
+		Error:     `1:18: parse error: expected type range vector in call to function "rate", got instant vector`,
+	},
+}
+
+// versionHeader matches an optional first-line comment declaring the
+// template's version, e.g. {{/* version: 3 */ -}}.
+var versionHeader = regexp.MustCompile(`^\{\{-?\s*/\*\s*version:\s*(\S+)\s*\*/\s*-?\}\}`)
+
+// Prompt is a parsed prompt template.
+type Prompt struct {
+	Name Name
+	// Version is the version declared in the template header followed by a
+	// hash of the template text, e.g. "3-1a2b3c4d", so that edits made
+	// without bumping the declared version are still told apart.
+	Version string
+	Source  string // Path the template was read from, or "embedded"
+
+	tmpl *template.Template
+}
+
+func newPrompt(name Name, source, text string) (*Prompt, error) {
+	tmpl, err := template.New(string(name)).Option("missingkey=error").Parse(text)
+	if err != nil {
+		return nil, fmt.Errorf("error parsing prompt template %s (%s): %v", name, source, err)
+	}
+	sum := sha256.Sum256([]byte(text))
+	version := hex.EncodeToString(sum[:4])
+	if m := versionHeader.FindStringSubmatch(text); m != nil {
+		version = m[1] + "-" + version
+	}
+	return &Prompt{Name: name, Version: version, Source: source, tmpl: tmpl}, nil
+}
+
+// Render executes the template with data.
+func (p *Prompt) Render(data interface{}) (string, error) {
+	var buf bytes.Buffer
+	if err := p.tmpl.Execute(&buf, data); err != nil {
+		return "", fmt.Errorf("error rendering prompt %s (version %s): %v", p.Name, p.Version, err)
+	}
+	return buf.String(), nil
+}
+
+// Set is a complete set of prompts.
+type Set struct {
+	prompts map[Name]*Prompt
+}
+
+var defaultSet = mustLoadEmbedded()
+
+func mustLoadEmbedded() *Set {
+	set, err := Load("")
+	if err != nil {
+		panic(err)
+	}
+	return set
+}
+
+// Default returns the embedded prompts.
+func Default() *Set {
+	return defaultSet
+}
+
+// Load reads <name>.tmpl for every prompt from dir, falling back to the
+// embedded template for files that do not exist. An empty dir loads only the
+// embedded templates. Every template is validated against SampleData.
+func Load(dir string) (*Set, error) {
+	set := &Set{prompts: make(map[Name]*Prompt, len(Names))}
+	known := make(map[string]bool, len(Names))
+	for _, name := range Names {
+		fileName := string(name) + ".tmpl"
+		known[fileName] = true
+
+		source := "embedded"
+		var text []byte
+		var err error
+		if dir != "" {
+			path := filepath.Join(dir, fileName)
+			text, err = os.ReadFile(path)
+			if err == nil {
+				source = path
+			} else if !os.IsNotExist(err) {
+				return nil, fmt.Errorf("error reading prompt template: %v", err)
+			}
+		}
+		if source == "embedded" {
+			text, err = embedded.ReadFile("templates/" + fileName)
+			if err != nil {
+				return nil, fmt.Errorf("error reading embedded prompt template %s: %v", name, err)
+			}
+		}
+
+		prompt, err := newPrompt(name, source, string(text))
+		if err != nil {
+			return nil, err
+		}
+		set.prompts[name] = prompt
+	}
+
+	if dir != "" {
+		// A misspelt file name would otherwise silently fall back to the default.
+		entries, err := os.ReadDir(dir)
+		if err != nil {
+			return nil, fmt.Errorf("error reading prompts directory: %v", err)
+		}
+		for _, entry := range entries {
+			if strings.HasSuffix(entry.Name(), ".tmpl") && !known[entry.Name()] {
+				return nil, fmt.Errorf("unknown prompt template %s in %s", entry.Name(), dir)
+			}
+		}
+	}
+
+	if err := set.Validate(); err != nil {
+		return nil, err
+	}
+	return set, nil
+}
+
+// Get returns the prompt called name.
+func (s *Set) Get(name Name) *Prompt {
+	return s.prompts[name]
+}
+
+// Render renders the prompt called name with data.
+func (s *Set) Render(name Name, data interface{}) (string, error) {
+	prompt := s.prompts[name]
+	if prompt == nil {
+		return "", fmt.Errorf("unknown prompt %s", name)
+	}
+	return prompt.Render(data)
+}
+
+// Prompts returns the prompts of the set in the order of Names.
+func (s *Set) Prompts() []*Prompt {
+	prompts := make([]*Prompt, 0, len(Names))
+	for _, name := range Names {
+		prompts = append(prompts, s.prompts[name])
+	}
+	return prompts
+}
+
+// Validate renders every prompt against SampleData and returns the first error.
+func (s *Set) Validate() error {
+	for _, prompt := range s.Prompts() {
+		if _, err := prompt.Render(SampleData[prompt.Name]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
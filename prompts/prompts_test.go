@@ -0,0 +1,109 @@
+package prompts_test
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/prashantgupta17/nlpromql/prompts"
+)
+
+func TestDefault(t *testing.T) {
+	set := prompts.Default()
+	if err := set.Validate(); err != nil {
+		t.Fatalf("embedded prompts failed validation: %v", err)
+	}
+	for _, prompt := range set.Prompts() {
+		if prompt.Source != "embedded" {
+			t.Errorf("%s: expected embedded source, got %s", prompt.Name, prompt.Source)
+		}
+		if !strings.Contains(prompt.Version, "-") {
+			t.Errorf("%s: expected a declared version, got %s", prompt.Name, prompt.Version)
+		}
+	}
+
+	rendered, err := set.Render(prompts.ProcessQuery, prompts.ProcessQueryData{UserQuery: "cpu usage of dev hosts"})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !strings.Contains(rendered, "User Query: cpu usage of dev hosts\n") {
+		t.Errorf("user query not rendered into prompt:\n%s", rendered)
+	}
+	if strings.Contains(rendered, "version:") {
+		t.Errorf("version header leaked into prompt:\n%s", rendered)
+	}
+}
+
+func TestLoad(t *testing.T) {
+	tests := []struct {
+		name        string
+		files       map[string]string
+		expectedErr string
+		check       func(t *testing.T, set *prompts.Set)
+	}{
+		{
+			name:  "override falls back to embedded for missing files",
+			files: map[string]string{"process_query.tmpl": "{{/* version: 7 */ -}}\nQuery: {{.UserQuery}}"},
+			check: func(t *testing.T, set *prompts.Set) {
+				prompt := set.Get(prompts.ProcessQuery)
+				if !strings.HasPrefix(prompt.Version, "7-") || !strings.HasSuffix(prompt.Source, "process_query.tmpl") {
+					t.Errorf("unexpected override prompt: %+v", prompt)
+				}
+				if rendered, _ := prompt.Render(prompts.ProcessQueryData{UserQuery: "q"}); rendered != "Query: q" {
+					t.Errorf("unexpected rendering %q", rendered)
+				}
+				if set.Get(prompts.System).Version != prompts.Default().Get(prompts.System).Version {
+					t.Errorf("expected system prompt to fall back to the embedded template")
+				}
+			},
+		},
+		{
+			name:  "unversioned template is identified by its hash",
+			files: map[string]string{"system.tmpl": "Be brief."},
+			check: func(t *testing.T, set *prompts.Set) {
+				if version := set.Get(prompts.System).Version; len(version) != 8 {
+					t.Errorf("expected a bare hash as version, got %s", version)
+				}
+			},
+		},
+		{
+			name:        "unknown field fails validation",
+			files:       map[string]string{"process_query.tmpl": "{{.Query}}"},
+			expectedErr: "error rendering prompt process_query",
+		},
+		{
+			name:        "syntax error",
+			files:       map[string]string{"system.tmpl": "{{if}}"},
+			expectedErr: "error parsing prompt template system",
+		},
+		{
+			name:        "misspelt file name",
+			files:       map[string]string{"systm.tmpl": "Be brief."},
+			expectedErr: "unknown prompt template systm.tmpl",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			for name, content := range tt.files {
+				if err := os.WriteFile(filepath.Join(dir, name), []byte(content), 0644); err != nil {
+					t.Fatalf("error writing template: %v", err)
+				}
+			}
+
+			set, err := prompts.Load(dir)
+			if tt.expectedErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.expectedErr) {
+					t.Fatalf("expected error containing %q, got %v", tt.expectedErr, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			tt.check(t, set)
+		})
+	}
+}
@@ -0,0 +1,321 @@
+package promql
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ValueType is the type an expression evaluates to.
+type ValueType string
+
+const (
+	ValueTypeScalar ValueType = "scalar"
+	ValueTypeVector ValueType = "vector"
+	ValueTypeMatrix ValueType = "matrix"
+	ValueTypeString ValueType = "string"
+)
+
+// documented returns the name the Prometheus documentation and error messages
+// use for the type.
+func (t ValueType) documented() string {
+	switch t {
+	case ValueTypeVector:
+		return "instant vector"
+	case ValueTypeMatrix:
+		return "range vector"
+	}
+	return string(t)
+}
+
+// Expr is a node of a parsed PromQL expression. String renders the node back
+// to PromQL in a canonical form.
+type Expr interface {
+	Type() ValueType
+	String() string
+}
+
+// NumberLiteral is a number such as 5, 1e3 or Inf.
+type NumberLiteral struct {
+	Val float64
+}
+
+// StringLiteral is a quoted string.
+type StringLiteral struct {
+	Val string
+}
+
+// ParenExpr is an expression in parentheses.
+type ParenExpr struct {
+	Expr Expr
+}
+
+// UnaryExpr is a negated or explicitly positive expression.
+type UnaryExpr struct {
+	Op   string // "-" or "+"
+	Expr Expr
+}
+
+// VectorMatching describes how the series of the two sides of a binary
+// operation between vectors are matched.
+type VectorMatching struct {
+	On             bool     // on(...) rather than ignoring(...)
+	MatchingLabels []string // Labels of on(...) or ignoring(...)
+	Card           string   // "" for one-to-one, "group_left" or "group_right"
+	Include        []string // Labels of group_left(...) or group_right(...)
+}
+
+// BinaryExpr is a binary operation such as a + b or a and on(job) b.
+type BinaryExpr struct {
+	Op         string
+	LHS, RHS   Expr
+	ReturnBool bool
+	// VectorMatching is nil unless on(...) or ignoring(...) was given.
+	VectorMatching *VectorMatching
+}
+
+// Call is a function call.
+type Call struct {
+	Func *Function
+	Args []Expr
+}
+
+// AggregateExpr is an aggregation such as sum by (job) (x) or topk(5, x).
+type AggregateExpr struct {
+	Op       string
+	Expr     Expr
+	Param    Expr // Parameter of topk, quantile, count_values, etc.; nil otherwise
+	Grouping []string
+	Without  bool // Grouping lists the labels of without(...) rather than by(...)
+}
+
+// MatchType is the operator of a label matcher.
+type MatchType string
+
+const (
+	MatchEqual     MatchType = "="
+	MatchNotEqual  MatchType = "!="
+	MatchRegexp    MatchType = "=~"
+	MatchNotRegexp MatchType = "!~"
+)
+
+// LabelMatcher is a single label condition of a vector selector.
+type LabelMatcher struct {
+	Name  string
+	Type  MatchType
+	Value string
+}
+
+func (m *LabelMatcher) String() string {
+	return m.Name + string(m.Type) + strconv.Quote(m.Value)
+}
+
+// VectorSelector selects series by metric name and label matchers.
+type VectorSelector struct {
+	Name          string // Empty if the selector has no metric name
+	LabelMatchers []*LabelMatcher
+	Offset        time.Duration
+	At            string // Evaluation time of the @ modifier: a timestamp, "start()" or "end()"
+}
+
+// MatrixSelector selects a range of samples for each series of a vector selector.
+type MatrixSelector struct {
+	VectorSelector *VectorSelector
+	Range          time.Duration
+}
+
+// SubqueryExpr evaluates an instant vector expression over a range.
+type SubqueryExpr struct {
+	Expr   Expr
+	Range  time.Duration
+	Step   time.Duration // 0 means the default evaluation interval
+	Offset time.Duration
+	At     string
+}
+
+func (*NumberLiteral) Type() ValueType  { return ValueTypeScalar }
+func (*StringLiteral) Type() ValueType  { return ValueTypeString }
+func (e *ParenExpr) Type() ValueType    { return e.Expr.Type() }
+func (e *UnaryExpr) Type() ValueType    { return e.Expr.Type() }
+func (e *Call) Type() ValueType         { return e.Func.ReturnType }
+func (*AggregateExpr) Type() ValueType  { return ValueTypeVector }
+func (*VectorSelector) Type() ValueType { return ValueTypeVector }
+func (*MatrixSelector) Type() ValueType { return ValueTypeMatrix }
+func (*SubqueryExpr) Type() ValueType   { return ValueTypeMatrix }
+
+func (e *BinaryExpr) Type() ValueType {
+	if e.LHS.Type() == ValueTypeScalar && e.RHS.Type() == ValueTypeScalar {
+		return ValueTypeScalar
+	}
+	return ValueTypeVector
+}
+
+func (e *NumberLiteral) String() string {
+	switch {
+	case math.IsInf(e.Val, 1):
+		return "Inf"
+	case math.IsInf(e.Val, -1):
+		return "-Inf"
+	case math.IsNaN(e.Val):
+		return "NaN"
+	}
+	return strconv.FormatFloat(e.Val, 'f', -1, 64)
+}
+
+func (e *StringLiteral) String() string { return strconv.Quote(e.Val) }
+func (e *ParenExpr) String() string     { return "(" + e.Expr.String() + ")" }
+func (e *UnaryExpr) String() string     { return e.Op + e.Expr.String() }
+
+func (e *BinaryExpr) String() string {
+	var b strings.Builder
+	b.WriteString(e.LHS.String())
+	b.WriteString(" " + e.Op)
+	if e.ReturnBool {
+		b.WriteString(" bool")
+	}
+	if m := e.VectorMatching; m != nil {
+		if m.On {
+			b.WriteString(" on " + labelList(m.MatchingLabels))
+		} else {
+			b.WriteString(" ignoring " + labelList(m.MatchingLabels))
+		}
+		if m.Card != "" {
+			b.WriteString(" " + m.Card)
+			if len(m.Include) > 0 {
+				b.WriteString(" " + labelList(m.Include))
+			}
+		}
+	}
+	b.WriteString(" " + e.RHS.String())
+	return b.String()
+}
+
+func (e *Call) String() string {
+	args := make([]string, len(e.Args))
+	for i, arg := range e.Args {
+		args[i] = arg.String()
+	}
+	return e.Func.Name + "(" + strings.Join(args, ", ") + ")"
+}
+
+func (e *AggregateExpr) String() string {
+	var b strings.Builder
+	b.WriteString(e.Op)
+	if e.Without {
+		b.WriteString(" without " + labelList(e.Grouping) + " ")
+	} else if len(e.Grouping) > 0 {
+		b.WriteString(" by " + labelList(e.Grouping) + " ")
+	}
+	b.WriteString("(")
+	if e.Param != nil {
+		b.WriteString(e.Param.String() + ", ")
+	}
+	b.WriteString(e.Expr.String() + ")")
+	return b.String()
+}
+
+func (e *VectorSelector) String() string {
+	return e.selector() + modifiers(e.Offset, e.At)
+}
+
+// selector renders the selector without its offset and @ modifiers.
+func (e *VectorSelector) selector() string {
+	matchers := make([]string, len(e.LabelMatchers))
+	for i, m := range e.LabelMatchers {
+		matchers[i] = m.String()
+	}
+	if len(matchers) == 0 {
+		return e.Name
+	}
+	return e.Name + "{" + strings.Join(matchers, ", ") + "}"
+}
+
+func (e *MatrixSelector) String() string {
+	vs := e.VectorSelector
+	return vs.selector() + "[" + FormatDuration(e.Range) + "]" + modifiers(vs.Offset, vs.At)
+}
+
+func (e *SubqueryExpr) String() string {
+	step := ""
+	if e.Step != 0 {
+		step = FormatDuration(e.Step)
+	}
+	return e.Expr.String() + "[" + FormatDuration(e.Range) + ":" + step + "]" + modifiers(e.Offset, e.At)
+}
+
+func modifiers(offset time.Duration, at string) string {
+	s := ""
+	if at != "" {
+		s += " @ " + at
+	}
+	if offset != 0 {
+		s += " offset " + FormatDuration(offset)
+	}
+	return s
+}
+
+func labelList(labels []string) string {
+	return "(" + strings.Join(labels, ", ") + ")"
+}
+
+var durationUnits = []struct {
+	unit string
+	d    time.Duration
+}{
+	{"y", 365 * 24 * time.Hour},
+	{"w", 7 * 24 * time.Hour},
+	{"d", 24 * time.Hour},
+	{"h", time.Hour},
+	{"m", time.Minute},
+	{"s", time.Second},
+	{"ms", time.Millisecond},
+}
+
+// FormatDuration renders d in PromQL duration syntax, e.g. "1h30m".
+func FormatDuration(d time.Duration) string {
+	if d == 0 {
+		return "0s"
+	}
+	sign := ""
+	if d < 0 {
+		sign, d = "-", -d
+	}
+	var b strings.Builder
+	for _, u := range durationUnits {
+		if n := d / u.d; n > 0 {
+			fmt.Fprintf(&b, "%d%s", n, u.unit)
+			d -= n * u.d
+		}
+	}
+	return sign + b.String()
+}
+
+// ParseDuration parses a PromQL duration such as "5m" or "1h30m".
+func ParseDuration(s string) (time.Duration, error) {
+	if s == "" || durationPattern.FindString(s) != s {
+		return 0, fmt.Errorf("not a valid duration string: %q", s)
+	}
+	var total time.Duration
+	for rest := s; rest != ""; {
+		i := 0
+		for rest[i] >= '0' && rest[i] <= '9' {
+			i++
+		}
+		n, err := strconv.ParseInt(rest[:i], 10, 64)
+		if err != nil {
+			return 0, fmt.Errorf("not a valid duration string: %q", s)
+		}
+		rest = rest[i:]
+		for _, u := range durationUnits {
+			// "ms" must not be read as "m" followed by "s".
+			if strings.HasPrefix(rest, u.unit) && !(u.unit == "m" && strings.HasPrefix(rest, "ms")) {
+				total += time.Duration(n) * u.d
+				rest = rest[len(u.unit):]
+				break
+			}
+		}
+	}
+	return total, nil
+}
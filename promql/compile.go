@@ -0,0 +1,180 @@
+package promql
+
+import (
+	"fmt"
+	"regexp"
+)
+
+// QuerySpec describes a query in the shape most questions take: a selector,
+// optionally a range function over a window, an aggregation, a threshold and
+// top or bottom k. Compile turns it into PromQL that is valid by construction,
+// so an LLM filling in a QuerySpec cannot produce a syntax error.
+type QuerySpec struct {
+	Metric        string          `json:"metric"`
+	Matchers      []MatcherSpec   `json:"matchers,omitempty"`
+	RangeFunction string          `json:"range_function,omitempty"` // rate, increase, avg_over_time, ...
+	Window        string          `json:"window,omitempty"`         // Range of RangeFunction, e.g. "5m"
+	Quantile      float64         `json:"quantile,omitempty"`       // Applies histogram_quantile to a _bucket metric
+	Aggregation   string          `json:"aggregation,omitempty"`    // sum, avg, min, max, count, group, stddev or stdvar
+	By            []string        `json:"by,omitempty"`
+	Without       []string        `json:"without,omitempty"`
+	Comparison    *ComparisonSpec `json:"comparison,omitempty"`
+	TopK          int             `json:"top_k,omitempty"`
+	BottomK       int             `json:"bottom_k,omitempty"`
+}
+
+// MatcherSpec is a label matcher of a QuerySpec.
+type MatcherSpec struct {
+	Label    string `json:"label"`
+	Operator string `json:"operator"` // =, !=, =~ or !~
+	Value    string `json:"value"`
+}
+
+// ComparisonSpec filters the result of a QuerySpec by a threshold.
+type ComparisonSpec struct {
+	Operator string  `json:"operator"` // ==, !=, >, <, >= or <=
+	Value    float64 `json:"value"`
+}
+
+// Schema tells Compile which metrics exist and which labels they have.
+type Schema interface {
+	HasMetric(metric string) bool
+	HasLabel(metric, label string) bool
+}
+
+var (
+	metricNamePattern = regexp.MustCompile(`^[a-zA-Z_:][a-zA-Z0-9_:]*$`)
+	labelNamePattern  = regexp.MustCompile(`^[a-zA-Z_][a-zA-Z0-9_]*$`)
+)
+
+// Compile builds the expression described by spec. Metric and label names are
+// checked against schema unless it is nil. The parts are applied in the order
+// selector, range function, aggregation (inside histogram_quantile if Quantile
+// is set), comparison and top or bottom k, e.g.
+// topk(5, sum by (pod) (rate(x[5m])) > 10).
+func Compile(spec *QuerySpec, schema Schema) (Expr, error) {
+	if !metricNamePattern.MatchString(spec.Metric) {
+		return nil, fmt.Errorf("invalid metric name %q", spec.Metric)
+	}
+	if schema != nil && !schema.HasMetric(spec.Metric) {
+		return nil, fmt.Errorf("unknown metric %q", spec.Metric)
+	}
+	checkLabel := func(label string) error {
+		if !labelNamePattern.MatchString(label) {
+			return fmt.Errorf("invalid label name %q", label)
+		}
+		if schema != nil && !schema.HasLabel(spec.Metric, label) {
+			return fmt.Errorf("metric %s has no label %q", spec.Metric, label)
+		}
+		return nil
+	}
+
+	selector := &VectorSelector{Name: spec.Metric}
+	for _, m := range spec.Matchers {
+		if err := checkLabel(m.Label); err != nil {
+			return nil, err
+		}
+		matchType := MatchType(m.Operator)
+		switch matchType {
+		case MatchEqual, MatchNotEqual:
+		case MatchRegexp, MatchNotRegexp:
+			if _, err := regexp.Compile("^(?:" + m.Value + ")$"); err != nil {
+				return nil, fmt.Errorf("invalid regular expression for label %s: %v", m.Label, err)
+			}
+		default:
+			return nil, fmt.Errorf("unknown matcher operator %q for label %s", m.Operator, m.Label)
+		}
+		selector.LabelMatchers = append(selector.LabelMatchers, &LabelMatcher{Name: m.Label, Type: matchType, Value: m.Value})
+	}
+
+	var expr Expr = selector
+	switch {
+	case spec.RangeFunction != "":
+		fn, ok := Functions[spec.RangeFunction]
+		if !ok || fn.MinArgs != 1 || fn.MaxArgs != 1 || fn.ArgTypes[0] != ValueTypeMatrix {
+			return nil, fmt.Errorf("%q is not a function of one range vector", spec.RangeFunction)
+		}
+		if spec.Window == "" {
+			return nil, fmt.Errorf("range function %s requires a window", spec.RangeFunction)
+		}
+		window, err := ParseDuration(spec.Window)
+		if err != nil {
+			return nil, err
+		}
+		if window <= 0 {
+			return nil, fmt.Errorf("window of %s must be positive", spec.RangeFunction)
+		}
+		expr = &Call{Func: fn, Args: []Expr{&MatrixSelector{VectorSelector: selector, Range: window}}}
+	case spec.Window != "":
+		return nil, fmt.Errorf("window %s requires a range function", spec.Window)
+	}
+
+	if len(spec.By) > 0 && len(spec.Without) > 0 {
+		return nil, fmt.Errorf("by and without cannot both be set")
+	}
+	for _, label := range append(append([]string(nil), spec.By...), spec.Without...) {
+		if err := checkLabel(label); err != nil {
+			return nil, err
+		}
+	}
+	grouping, without := spec.By, len(spec.Without) > 0
+	if without {
+		grouping = spec.Without
+	}
+
+	if spec.Quantile != 0 {
+		if spec.Quantile < 0 || spec.Quantile > 1 {
+			return nil, fmt.Errorf("quantile %v is not between 0 and 1", spec.Quantile)
+		}
+		if spec.Aggregation != "" && spec.Aggregation != "sum" {
+			return nil, fmt.Errorf("quantile requires the buckets to be summed, not aggregated with %s", spec.Aggregation)
+		}
+		if err := checkLabel("le"); err != nil {
+			return nil, fmt.Errorf("quantile requires a histogram: %v", err)
+		}
+		// The buckets of each group must be kept apart by le.
+		if without {
+			for _, label := range grouping {
+				if label == "le" {
+					return nil, fmt.Errorf("quantile cannot aggregate without le")
+				}
+			}
+		} else {
+			grouping = append(append([]string(nil), grouping...), "le")
+		}
+		expr = &Call{Func: Functions["histogram_quantile"], Args: []Expr{
+			&NumberLiteral{Val: spec.Quantile},
+			&AggregateExpr{Op: "sum", Expr: expr, Grouping: grouping, Without: without},
+		}}
+	} else if spec.Aggregation != "" {
+		paramType, ok := aggregations[spec.Aggregation]
+		if !ok {
+			return nil, fmt.Errorf("unknown aggregation %q", spec.Aggregation)
+		}
+		if paramType != "" {
+			return nil, fmt.Errorf("aggregation %s is not supported; use quantile, top_k or bottom_k", spec.Aggregation)
+		}
+		expr = &AggregateExpr{Op: spec.Aggregation, Expr: expr, Grouping: grouping, Without: without}
+	} else if len(grouping) > 0 {
+		return nil, fmt.Errorf("by and without require an aggregation")
+	}
+
+	if c := spec.Comparison; c != nil {
+		if !isComparison(c.Operator) {
+			return nil, fmt.Errorf("unknown comparison operator %q", c.Operator)
+		}
+		expr = &BinaryExpr{Op: c.Operator, LHS: expr, RHS: &NumberLiteral{Val: c.Value}}
+	}
+
+	switch {
+	case spec.TopK < 0 || spec.BottomK < 0:
+		return nil, fmt.Errorf("top_k and bottom_k must not be negative")
+	case spec.TopK > 0 && spec.BottomK > 0:
+		return nil, fmt.Errorf("top_k and bottom_k cannot both be set")
+	case spec.TopK > 0:
+		expr = &AggregateExpr{Op: "topk", Param: &NumberLiteral{Val: float64(spec.TopK)}, Expr: expr}
+	case spec.BottomK > 0:
+		expr = &AggregateExpr{Op: "bottomk", Param: &NumberLiteral{Val: float64(spec.BottomK)}, Expr: expr}
+	}
+	return expr, nil
+}
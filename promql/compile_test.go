@@ -0,0 +1,136 @@
+package promql_test
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/prashantgupta17/nlpromql/promql"
+)
+
+// mockSchema_CompileTest maps metric names to their labels.
+type mockSchema_CompileTest map[string][]string
+
+func (s mockSchema_CompileTest) HasMetric(metric string) bool {
+	_, ok := s[metric]
+	return ok
+}
+
+func (s mockSchema_CompileTest) HasLabel(metric, label string) bool {
+	for _, l := range s[metric] {
+		if l == label {
+			return true
+		}
+	}
+	return false
+}
+
+func TestCompile(t *testing.T) {
+	schema := mockSchema_CompileTest{
+		"http_requests_total":                  {"job", "code", "pod"},
+		"http_request_duration_seconds_bucket": {"job", "le", "pod"},
+		"node_filesystem_avail_bytes":          {"instance", "mountpoint"},
+	}
+
+	tests := []struct {
+		name     string
+		spec     promql.QuerySpec
+		expected string
+	}{
+		{"Metric", promql.QuerySpec{Metric: "http_requests_total"}, "http_requests_total"},
+		{
+			"Rate by job",
+			promql.QuerySpec{Metric: "http_requests_total", RangeFunction: "rate", Window: "5m", Aggregation: "sum", By: []string{"job"}},
+			"sum by (job) (rate(http_requests_total[5m]))",
+		},
+		{
+			"Matchers quoted",
+			promql.QuerySpec{Metric: "http_requests_total", Matchers: []promql.MatcherSpec{{Label: "code", Operator: "=~", Value: `5..`}, {Label: "job", Operator: "!=", Value: `a"b`}}},
+			`http_requests_total{code=~"5..", job!="a\"b"}`,
+		},
+		{
+			"Quantile adds le",
+			promql.QuerySpec{Metric: "http_request_duration_seconds_bucket", RangeFunction: "rate", Window: "5m", Quantile: 0.99, By: []string{"pod"}},
+			"histogram_quantile(0.99, sum by (pod, le) (rate(http_request_duration_seconds_bucket[5m])))",
+		},
+		{
+			"Without",
+			promql.QuerySpec{Metric: "http_requests_total", Aggregation: "max", Without: []string{"pod"}},
+			"max without (pod) (http_requests_total)",
+		},
+		{
+			"Comparison inside topk",
+			promql.QuerySpec{Metric: "http_requests_total", RangeFunction: "increase", Window: "1h", Aggregation: "sum", By: []string{"pod"}, Comparison: &promql.ComparisonSpec{Operator: ">", Value: 100}, TopK: 5},
+			"topk(5, sum by (pod) (increase(http_requests_total[1h])) > 100)",
+		},
+		{
+			"Bottomk",
+			promql.QuerySpec{Metric: "node_filesystem_avail_bytes", BottomK: 3},
+			"bottomk(3, node_filesystem_avail_bytes)",
+		},
+		{
+			"Over time",
+			promql.QuerySpec{Metric: "node_filesystem_avail_bytes", RangeFunction: "min_over_time", Window: "1d"},
+			"min_over_time(node_filesystem_avail_bytes[1d])",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			expr, err := promql.Compile(&tt.spec, schema)
+			if err != nil {
+				t.Fatalf("Compile returned an unexpected error: %v", err)
+			}
+			if got := expr.String(); got != tt.expected {
+				t.Errorf("Compile() = %q, expected %q", got, tt.expected)
+			}
+			// The result must be valid PromQL.
+			if _, err := promql.Parse(expr.String()); err != nil {
+				t.Errorf("compiled query %q does not parse: %v", expr, err)
+			}
+		})
+	}
+}
+
+func TestCompile_Errors(t *testing.T) {
+	schema := mockSchema_CompileTest{
+		"http_requests_total":                  {"job"},
+		"http_request_duration_seconds_bucket": {"job", "le"},
+	}
+
+	tests := []struct {
+		name          string
+		spec          promql.QuerySpec
+		expectedError string
+	}{
+		{"Unknown metric", promql.QuerySpec{Metric: "http_requests"}, `unknown metric "http_requests"`},
+		{"Invalid metric name", promql.QuerySpec{Metric: "http-requests"}, `invalid metric name "http-requests"`},
+		{"Unknown label", promql.QuerySpec{Metric: "http_requests_total", Matchers: []promql.MatcherSpec{{Label: "pod", Operator: "=", Value: "a"}}}, `metric http_requests_total has no label "pod"`},
+		{"Unknown grouping label", promql.QuerySpec{Metric: "http_requests_total", Aggregation: "sum", By: []string{"pod"}}, `has no label "pod"`},
+		{"Bad matcher operator", promql.QuerySpec{Metric: "http_requests_total", Matchers: []promql.MatcherSpec{{Label: "job", Operator: "==", Value: "a"}}}, `unknown matcher operator "=="`},
+		{"Bad regexp", promql.QuerySpec{Metric: "http_requests_total", Matchers: []promql.MatcherSpec{{Label: "job", Operator: "=~", Value: "("}}}, "invalid regular expression"},
+		{"Instant function", promql.QuerySpec{Metric: "http_requests_total", RangeFunction: "abs", Window: "5m"}, `"abs" is not a function of one range vector`},
+		{"Missing window", promql.QuerySpec{Metric: "http_requests_total", RangeFunction: "rate"}, "requires a window"},
+		{"Bad window", promql.QuerySpec{Metric: "http_requests_total", RangeFunction: "rate", Window: "5 minutes"}, "not a valid duration string"},
+		{"Window without function", promql.QuerySpec{Metric: "http_requests_total", Window: "5m"}, "requires a range function"},
+		{"Grouping without aggregation", promql.QuerySpec{Metric: "http_requests_total", By: []string{"job"}}, "require an aggregation"},
+		{"By and without", promql.QuerySpec{Metric: "http_requests_total", Aggregation: "sum", By: []string{"job"}, Without: []string{"job"}}, "cannot both be set"},
+		{"Parameterized aggregation", promql.QuerySpec{Metric: "http_requests_total", Aggregation: "topk"}, "aggregation topk is not supported"},
+		{"Quantile of a counter", promql.QuerySpec{Metric: "http_requests_total", Quantile: 0.9}, "quantile requires a histogram"},
+		{"Quantile out of range", promql.QuerySpec{Metric: "http_request_duration_seconds_bucket", Quantile: 99}, "quantile 99 is not between 0 and 1"},
+		{"Quantile with avg", promql.QuerySpec{Metric: "http_request_duration_seconds_bucket", Quantile: 0.9, Aggregation: "avg"}, "not aggregated with avg"},
+		{"Bad comparison", promql.QuerySpec{Metric: "http_requests_total", Comparison: &promql.ComparisonSpec{Operator: "=>", Value: 1}}, `unknown comparison operator "=>"`},
+		{"Topk and bottomk", promql.QuerySpec{Metric: "http_requests_total", TopK: 1, BottomK: 1}, "cannot both be set"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := promql.Compile(&tt.spec, schema)
+			if err == nil {
+				t.Fatalf("Compile(%+v) expected error %q, got nil", tt.spec, tt.expectedError)
+			}
+			if !strings.Contains(err.Error(), tt.expectedError) {
+				t.Errorf("Compile(%+v) error = %q, expected it to contain %q", tt.spec, err.Error(), tt.expectedError)
+			}
+		})
+	}
+}
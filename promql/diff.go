@@ -0,0 +1,175 @@
+package promql
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Difference is a place where two expressions differ once normalized.
+type Difference struct {
+	// Path leads from the root to the differing node or field, e.g.
+	// "expr.args[0].range"; it is empty if the roots differ altogether.
+	Path string `json:"path"`
+	A    string `json:"a"`
+	B    string `json:"b"`
+}
+
+func (d Difference) String() string {
+	if d.Path == "" {
+		return fmt.Sprintf("%s -> %s", d.A, d.B)
+	}
+	return fmt.Sprintf("%s: %s -> %s", d.Path, d.A, d.B)
+}
+
+// Diff returns where a and b differ once normalized (see Normalize), or nil
+// if they are equivalent. Nodes of different kinds, e.g. a call and an
+// aggregation, are a single difference; otherwise each differing field is
+// one, and the operands are compared in turn.
+func Diff(a, b Expr) []Difference {
+	var differences []Difference
+	diffExprs(Normalize(a), Normalize(b), "", &differences)
+	return differences
+}
+
+func diffExprs(a, b Expr, path string, differences *[]Difference) {
+	add := func(field, valueA, valueB string) {
+		if valueA != valueB {
+			*differences = append(*differences, Difference{Path: diffPath(path, field), A: valueA, B: valueB})
+		}
+	}
+	// Normalize only keeps the parentheses needed around the operands, which
+	// differ with the operators.
+	a, b = unparen(a), unparen(b)
+	switch a := a.(type) {
+	case *UnaryExpr:
+		if b, ok := b.(*UnaryExpr); ok {
+			add("op", a.Op, b.Op)
+			diffExprs(a.Expr, b.Expr, diffPath(path, "expr"), differences)
+			return
+		}
+	case *BinaryExpr:
+		if b, ok := b.(*BinaryExpr); ok {
+			add("op", a.Op, b.Op)
+			add("bool", fmt.Sprint(a.ReturnBool), fmt.Sprint(b.ReturnBool))
+			add("matching", matchingString(a.VectorMatching), matchingString(b.VectorMatching))
+			diffExprs(a.LHS, b.LHS, diffPath(path, "lhs"), differences)
+			diffExprs(a.RHS, b.RHS, diffPath(path, "rhs"), differences)
+			return
+		}
+	case *Call:
+		if b, ok := b.(*Call); ok {
+			add("func", a.Func.Name, b.Func.Name)
+			if len(a.Args) != len(b.Args) {
+				add("args", argsString(a.Args), argsString(b.Args))
+				return
+			}
+			for i := range a.Args {
+				diffExprs(a.Args[i], b.Args[i], diffPath(path, fmt.Sprintf("args[%d]", i)), differences)
+			}
+			return
+		}
+	case *AggregateExpr:
+		if b, ok := b.(*AggregateExpr); ok {
+			add("op", a.Op, b.Op)
+			add("grouping", groupingString(a), groupingString(b))
+			switch {
+			case a.Param != nil && b.Param != nil:
+				diffExprs(a.Param, b.Param, diffPath(path, "param"), differences)
+			case a.Param != nil || b.Param != nil:
+				add("param", exprString(a.Param), exprString(b.Param))
+			}
+			diffExprs(a.Expr, b.Expr, diffPath(path, "expr"), differences)
+			return
+		}
+	case *VectorSelector:
+		if b, ok := b.(*VectorSelector); ok {
+			diffSelectors(a, b, add)
+			return
+		}
+	case *MatrixSelector:
+		if b, ok := b.(*MatrixSelector); ok {
+			diffSelectors(a.VectorSelector, b.VectorSelector, add)
+			add("range", FormatDuration(a.Range), FormatDuration(b.Range))
+			return
+		}
+	case *SubqueryExpr:
+		if b, ok := b.(*SubqueryExpr); ok {
+			add("range", FormatDuration(a.Range), FormatDuration(b.Range))
+			add("step", FormatDuration(a.Step), FormatDuration(b.Step))
+			add("offset", FormatDuration(a.Offset), FormatDuration(b.Offset))
+			add("at", a.At, b.At)
+			diffExprs(a.Expr, b.Expr, diffPath(path, "expr"), differences)
+			return
+		}
+	}
+	// Literals, and nodes of different kinds.
+	add("", a.String(), b.String())
+}
+
+func diffSelectors(a, b *VectorSelector, add func(field, valueA, valueB string)) {
+	add("name", a.Name, b.Name)
+	add("matchers", matchersString(a.LabelMatchers), matchersString(b.LabelMatchers))
+	add("offset", FormatDuration(a.Offset), FormatDuration(b.Offset))
+	add("at", a.At, b.At)
+}
+
+func unparen(expr Expr) Expr {
+	for {
+		paren, ok := expr.(*ParenExpr)
+		if !ok {
+			return expr
+		}
+		expr = paren.Expr
+	}
+}
+
+func diffPath(path, field string) string {
+	if path == "" || field == "" {
+		return path + field
+	}
+	return path + "." + field
+}
+
+func matchingString(m *VectorMatching) string {
+	if m == nil {
+		return ""
+	}
+	s := "ignoring " + labelList(m.MatchingLabels)
+	if m.On {
+		s = "on " + labelList(m.MatchingLabels)
+	}
+	if m.Card != "" {
+		s += " " + m.Card + " " + labelList(m.Include)
+	}
+	return s
+}
+
+func groupingString(e *AggregateExpr) string {
+	if e.Without {
+		return "without " + labelList(e.Grouping)
+	}
+	return "by " + labelList(e.Grouping)
+}
+
+func matchersString(matchers []*LabelMatcher) string {
+	s := make([]string, len(matchers))
+	for i, m := range matchers {
+		s[i] = m.String()
+	}
+	return "{" + strings.Join(s, ", ") + "}"
+}
+
+func argsString(args []Expr) string {
+	s := make([]string, len(args))
+	for i, arg := range args {
+		s[i] = arg.String()
+	}
+	return "(" + strings.Join(s, ", ") + ")"
+}
+
+func exprString(expr Expr) string {
+	if expr == nil {
+		return ""
+	}
+	return expr.String()
+}
@@ -0,0 +1,78 @@
+package promql
+
+// Function describes a PromQL function.
+type Function struct {
+	Name string
+	// ArgTypes are the types of the arguments. When MaxArgs exceeds
+	// len(ArgTypes), the extra arguments have the type of the last one.
+	ArgTypes   []ValueType
+	MinArgs    int
+	MaxArgs    int // -1 for no limit
+	ReturnType ValueType
+}
+
+func (f *Function) argType(i int) ValueType {
+	if i >= len(f.ArgTypes) {
+		return f.ArgTypes[len(f.ArgTypes)-1]
+	}
+	return f.ArgTypes[i]
+}
+
+// Functions holds the functions of PromQL by name.
+var Functions = map[string]*Function{}
+
+func init() {
+	s, v, m, str := ValueTypeScalar, ValueTypeVector, ValueTypeMatrix, ValueTypeString
+	define := func(name string, ret ValueType, minArgs, maxArgs int, args ...ValueType) {
+		Functions[name] = &Function{Name: name, ArgTypes: args, MinArgs: minArgs, MaxArgs: maxArgs, ReturnType: ret}
+	}
+
+	// Functions of one instant vector.
+	for _, name := range []string{
+		"abs", "absent", "ceil", "exp", "floor", "ln", "log2", "log10", "sgn", "sqrt", "sort", "sort_desc", "timestamp",
+		"acos", "acosh", "asin", "asinh", "atan", "atanh", "cos", "cosh", "sin", "sinh", "tan", "tanh", "deg", "rad",
+		"histogram_count", "histogram_sum", "histogram_avg", "histogram_stddev", "histogram_stdvar",
+	} {
+		define(name, v, 1, 1, v)
+	}
+	// Functions of one range vector.
+	for _, name := range []string{
+		"rate", "irate", "increase", "delta", "idelta", "deriv", "changes", "resets", "absent_over_time",
+		"avg_over_time", "min_over_time", "max_over_time", "sum_over_time", "count_over_time",
+		"stddev_over_time", "stdvar_over_time", "last_over_time", "present_over_time", "mad_over_time",
+	} {
+		define(name, v, 1, 1, m)
+	}
+	// Date functions default to the evaluation time when called without argument.
+	for _, name := range []string{"day_of_month", "day_of_week", "day_of_year", "days_in_month", "hour", "minute", "month", "year"} {
+		define(name, v, 0, 1, v)
+	}
+
+	define("clamp", v, 3, 3, v, s, s)
+	define("clamp_max", v, 2, 2, v, s)
+	define("clamp_min", v, 2, 2, v, s)
+	define("round", v, 1, 2, v, s)
+	define("histogram_quantile", v, 2, 2, s, v)
+	define("histogram_fraction", v, 3, 3, s, s, v)
+	define("holt_winters", v, 3, 3, m, s, s)
+	define("double_exponential_smoothing", v, 3, 3, m, s, s)
+	define("predict_linear", v, 2, 2, m, s)
+	define("quantile_over_time", v, 2, 2, s, m)
+	define("label_replace", v, 5, 5, v, str, str, str, str)
+	define("label_join", v, 3, -1, v, str, str, str)
+	define("sort_by_label", v, 1, -1, v, str)
+	define("sort_by_label_desc", v, 1, -1, v, str)
+	define("scalar", s, 1, 1, v)
+	define("vector", v, 1, 1, s)
+	define("time", s, 0, 0)
+	define("pi", s, 0, 0)
+}
+
+// aggregations maps the aggregation operators to the type of their
+// parameter, or "" if they take none.
+var aggregations = map[string]ValueType{
+	"sum": "", "avg": "", "count": "", "min": "", "max": "", "group": "", "stddev": "", "stdvar": "",
+	"topk": ValueTypeScalar, "bottomk": ValueTypeScalar, "quantile": ValueTypeScalar,
+	"limitk": ValueTypeScalar, "limit_ratio": ValueTypeScalar,
+	"count_values": ValueTypeString,
+}
@@ -0,0 +1,90 @@
+package promql
+
+// Inspect traverses expr depth-first, calling f for each expression before
+// its children. Children are skipped when f returns false.
+func Inspect(expr Expr, f func(Expr) bool) {
+	if expr == nil || !f(expr) {
+		return
+	}
+	switch e := expr.(type) {
+	case *ParenExpr:
+		Inspect(e.Expr, f)
+	case *UnaryExpr:
+		Inspect(e.Expr, f)
+	case *BinaryExpr:
+		Inspect(e.LHS, f)
+		Inspect(e.RHS, f)
+	case *Call:
+		for _, arg := range e.Args {
+			Inspect(arg, f)
+		}
+	case *AggregateExpr:
+		if e.Param != nil {
+			Inspect(e.Param, f)
+		}
+		Inspect(e.Expr, f)
+	case *MatrixSelector:
+		Inspect(e.VectorSelector, f)
+	case *SubqueryExpr:
+		Inspect(e.Expr, f)
+	}
+}
+
+// MetricLabels returns the metrics selected by expr, each with the labels
+// the query uses with it: the labels of its matchers, with their values for
+// equality matchers and "" otherwise, and the labels aggregations around it
+// group by. Selectors that do not name a metric, directly or with a
+// __name__ equality matcher, are ignored.
+func MetricLabels(expr Expr) map[string]map[string]string {
+	metrics := make(map[string]map[string]string)
+	var visit func(expr Expr, grouping []string)
+	visit = func(expr Expr, grouping []string) {
+		Inspect(expr, func(e Expr) bool {
+			switch e := e.(type) {
+			case *AggregateExpr:
+				if e.Param != nil {
+					visit(e.Param, grouping)
+				}
+				inner := grouping
+				if !e.Without {
+					inner = append(append([]string(nil), grouping...), e.Grouping...)
+				}
+				visit(e.Expr, inner)
+				return false
+			case *VectorSelector:
+				name := e.Name
+				for _, m := range e.LabelMatchers {
+					if name == "" && m.Name == "__name__" && m.Type == MatchEqual {
+						name = m.Value
+					}
+				}
+				if name == "" {
+					return false
+				}
+				labels, ok := metrics[name]
+				if !ok {
+					labels = make(map[string]string)
+					metrics[name] = labels
+				}
+				for _, label := range grouping {
+					if _, ok := labels[label]; !ok {
+						labels[label] = ""
+					}
+				}
+				for _, m := range e.LabelMatchers {
+					if m.Name == "__name__" {
+						continue
+					}
+					if m.Type == MatchEqual {
+						labels[m.Name] = m.Value
+					} else if _, ok := labels[m.Name]; !ok {
+						labels[m.Name] = ""
+					}
+				}
+			}
+			return true
+		})
+	}
+	visit(expr, nil)
+	return metrics
+}
@@ -0,0 +1,70 @@
+package promql_test
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/prashantgupta17/nlpromql/promql"
+)
+
+func TestInspect(t *testing.T) {
+	expr, err := promql.Parse(`sum by (job) (rate(a[5m])) / on (job) count(max_over_time(b[1h:5m]))`)
+	if err != nil {
+		t.Fatalf("Parse returned an unexpected error: %v", err)
+	}
+	var selectors []string
+	promql.Inspect(expr, func(e promql.Expr) bool {
+		if s, ok := e.(*promql.VectorSelector); ok {
+			selectors = append(selectors, s.Name)
+		}
+		return true
+	})
+	if expected := []string{"a", "b"}; !reflect.DeepEqual(selectors, expected) {
+		t.Errorf("expected selectors %v in order, got %v", expected, selectors)
+	}
+
+	calls := 0
+	promql.Inspect(expr, func(e promql.Expr) bool {
+		calls++
+		return false
+	})
+	if calls != 1 {
+		t.Errorf("expected children to be skipped, got %d calls", calls)
+	}
+}
+
+func TestMetricLabels(t *testing.T) {
+	tests := []struct {
+		name     string
+		input    string
+		expected map[string]map[string]string
+	}{
+		{"Selector", `up`, map[string]map[string]string{"up": {}}},
+		{"Matchers", `http_requests_total{job="api", code=~"5.."}`, map[string]map[string]string{"http_requests_total": {"job": "api", "code": ""}}},
+		{"Name matcher", `{__name__="up", instance="a"}`, map[string]map[string]string{"up": {"instance": "a"}}},
+		{"No metric name", `{job="api"}`, map[string]map[string]string{}},
+		{
+			"Grouping",
+			`histogram_quantile(0.9, sum by (le, pod) (rate(h_bucket{namespace="prod"}[5m])))`,
+			map[string]map[string]string{"h_bucket": {"le": "", "pod": "", "namespace": "prod"}},
+		},
+		{"Without is not grouping", `sum without (instance) (up)`, map[string]map[string]string{"up": {}}},
+		{
+			"Binary",
+			`sum by (job) (a) / sum(b{job="x"})`,
+			map[string]map[string]string{"a": {"job": ""}, "b": {"job": "x"}},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			expr, err := promql.Parse(tt.input)
+			if err != nil {
+				t.Fatalf("Parse(%q) returned an unexpected error: %v", tt.input, err)
+			}
+			if got := promql.MetricLabels(expr); !reflect.DeepEqual(got, tt.expected) {
+				t.Errorf("MetricLabels(%q) = %v, expected %v", tt.input, got, tt.expected)
+			}
+		})
+	}
+}
@@ -0,0 +1,176 @@
+package promql
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+type tokenType int
+
+const (
+	tokenEOF tokenType = iota
+	tokenIdentifier
+	tokenNumber
+	tokenDuration
+	tokenString
+	tokenOperator // Binary operators and label matcher operators, e.g. "+", "==", "=~"
+	tokenLeftParen
+	tokenRightParen
+	tokenLeftBrace
+	tokenRightBrace
+	tokenLeftBracket
+	tokenRightBracket
+	tokenComma
+	tokenColon
+	tokenAt
+)
+
+type token struct {
+	typ tokenType
+	val string // Source text, except for strings, which hold the unquoted value
+	pos int    // Byte offset in the input
+}
+
+func (t token) String() string {
+	switch t.typ {
+	case tokenEOF:
+		return "end of input"
+	case tokenString:
+		return fmt.Sprintf("string %q", t.val)
+	case tokenNumber:
+		return "number " + t.val
+	case tokenDuration:
+		return "duration " + t.val
+	case tokenIdentifier:
+		return "identifier " + strconv.Quote(t.val)
+	}
+	return strconv.Quote(t.val)
+}
+
+var (
+	durationPattern = regexp.MustCompile(`^(?:[0-9]+(?:ms|[smhdwy]))+`)
+	numberPattern   = regexp.MustCompile(`^(?:0[xX][0-9a-fA-F]+|(?:[0-9]+\.?[0-9]*|\.[0-9]+)(?:[eE][+-]?[0-9]+)?)`)
+)
+
+// operators lists the operators that are not words, longest first so that
+// "==" is not lexed as two "=".
+var operators = []string{"==", "!=", ">=", "<=", "=~", "!~", "+", "-", "*", "/", "%", "^", ">", "<", "="}
+
+func isIdentifierStart(c byte) bool {
+	return c == '_' || c == ':' || (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z')
+}
+
+func isIdentifierChar(c byte) bool {
+	return isIdentifierStart(c) || (c >= '0' && c <= '9')
+}
+
+// lex splits input into tokens, ending with a tokenEOF.
+func lex(input string) ([]token, error) {
+	var tokens []token
+	inBrackets := false // Inside [...], ':' separates the range and step of a subquery
+	for pos := 0; ; {
+		for pos < len(input) && strings.ContainsRune(" \t\r\n", rune(input[pos])) {
+			pos++
+		}
+		if pos < len(input) && input[pos] == '#' {
+			// Comment to the end of the line.
+			for pos < len(input) && input[pos] != '\n' {
+				pos++
+			}
+			continue
+		}
+		if pos >= len(input) {
+			return append(tokens, token{typ: tokenEOF, pos: pos}), nil
+		}
+
+		rest := input[pos:]
+		c := rest[0]
+		switch {
+		case isIdentifierStart(c) && !(inBrackets && c == ':'):
+			end := 1
+			for end < len(rest) && isIdentifierChar(rest[end]) {
+				end++
+			}
+			tokens = append(tokens, token{typ: tokenIdentifier, val: rest[:end], pos: pos})
+			pos += end
+		case (c >= '0' && c <= '9') || (c == '.' && len(rest) > 1 && rest[1] >= '0' && rest[1] <= '9'):
+			typ := tokenNumber
+			text := numberPattern.FindString(rest)
+			if duration := durationPattern.FindString(rest); len(duration) > len(text) {
+				typ, text = tokenDuration, duration
+			}
+			if len(text) < len(rest) && !(inBrackets && rest[len(text)] == ':') && isIdentifierChar(rest[len(text)]) {
+				return nil, errorAt(input, pos, "bad number or duration syntax: %q", rest[:len(text)+1])
+			}
+			tokens = append(tokens, token{typ: typ, val: text, pos: pos})
+			pos += len(text)
+		case c == '"' || c == '\'' || c == '`':
+			value, length, err := lexString(rest)
+			if err != nil {
+				return nil, errorAt(input, pos, "%v", err)
+			}
+			tokens = append(tokens, token{typ: tokenString, val: value, pos: pos})
+			pos += length
+		default:
+			single := map[byte]tokenType{
+				'(': tokenLeftParen, ')': tokenRightParen,
+				'{': tokenLeftBrace, '}': tokenRightBrace,
+				'[': tokenLeftBracket, ']': tokenRightBracket,
+				',': tokenComma, ':': tokenColon, '@': tokenAt,
+			}
+			if typ, ok := single[c]; ok {
+				inBrackets = typ == tokenLeftBracket || (inBrackets && typ != tokenRightBracket)
+				tokens = append(tokens, token{typ: typ, val: string(c), pos: pos})
+				pos++
+				continue
+			}
+			matched := false
+			for _, op := range operators {
+				if strings.HasPrefix(rest, op) {
+					tokens = append(tokens, token{typ: tokenOperator, val: op, pos: pos})
+					pos += len(op)
+					matched = true
+					break
+				}
+			}
+			if !matched {
+				r, _ := utf8.DecodeRuneInString(rest)
+				return nil, errorAt(input, pos, "unexpected character: %q", r)
+			}
+		}
+	}
+}
+
+// lexString reads the string literal at the start of s and returns its value
+// and its length in s. Double and single quoted strings use Go escapes,
+// backtick strings are raw.
+func lexString(s string) (string, int, error) {
+	quote := s[0]
+	for i := 1; i < len(s); i++ {
+		switch s[i] {
+		case '\\':
+			if quote != '`' {
+				i++
+			}
+		case '\n':
+			if quote != '`' {
+				return "", 0, fmt.Errorf("unterminated quoted string")
+			}
+		case quote:
+			literal := s[:i+1]
+			if quote == '\'' {
+				// strconv only unquotes single characters in single quotes.
+				literal = `"` + strings.ReplaceAll(strings.ReplaceAll(literal[1:i], `\'`, `'`), `"`, `\"`) + `"`
+			}
+			value, err := strconv.Unquote(literal)
+			if err != nil {
+				return "", 0, fmt.Errorf("invalid string literal %s: %v", s[:i+1], err)
+			}
+			return value, i + 1, nil
+		}
+	}
+	return "", 0, fmt.Errorf("unterminated quoted string")
+}
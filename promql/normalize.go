@@ -0,0 +1,170 @@
+package promql
+
+import (
+	"sort"
+)
+
+// Normalize returns a copy of expr in a canonical form, so that queries that
+// differ only in presentation render to the same String:
+//
+//   - label matchers are sorted and deduplicated, and a __name__ equality
+//     matcher becomes the metric name;
+//   - the labels of by, without, on, ignoring, group_left and group_right
+//     are sorted and deduplicated, and an empty ignoring() without grouping
+//     is dropped;
+//   - parentheses are kept only where precedence needs them.
+//
+// Where by or without is written, and whitespace, are lost by parsing
+// already. expr is left as it is.
+func Normalize(expr Expr) Expr {
+	switch e := expr.(type) {
+	case *NumberLiteral:
+		c := *e
+		return &c
+	case *StringLiteral:
+		c := *e
+		return &c
+	case *ParenExpr:
+		return Normalize(e.Expr)
+	case *UnaryExpr:
+		inner := Normalize(e.Expr)
+		if number, ok := inner.(*NumberLiteral); ok {
+			// As the parser does for -1.
+			if e.Op == "-" {
+				number.Val = -number.Val
+			}
+			return number
+		}
+		if binary, ok := inner.(*BinaryExpr); ok && binaryPrecedence[binary.Op] < unaryPrecedence {
+			inner = &ParenExpr{Expr: inner}
+		}
+		return &UnaryExpr{Op: e.Op, Expr: inner}
+	case *BinaryExpr:
+		return normalizeBinary(e)
+	case *Call:
+		c := &Call{Func: e.Func, Args: make([]Expr, len(e.Args))}
+		for i, arg := range e.Args {
+			c.Args[i] = Normalize(arg)
+		}
+		return c
+	case *AggregateExpr:
+		c := &AggregateExpr{Op: e.Op, Expr: Normalize(e.Expr), Grouping: sortedLabels(e.Grouping), Without: e.Without}
+		if e.Param != nil {
+			c.Param = Normalize(e.Param)
+		}
+		return c
+	case *VectorSelector:
+		return normalizeSelector(e)
+	case *MatrixSelector:
+		return &MatrixSelector{VectorSelector: normalizeSelector(e.VectorSelector), Range: e.Range}
+	case *SubqueryExpr:
+		inner := Normalize(e.Expr)
+		switch inner.(type) {
+		case *BinaryExpr, *UnaryExpr:
+			inner = &ParenExpr{Expr: inner}
+		}
+		return &SubqueryExpr{Expr: inner, Range: e.Range, Step: e.Step, Offset: e.Offset, At: e.At}
+	}
+	return expr
+}
+
+// normalizeBinary normalizes a binary expression and its operands, putting
+// an operand in parentheses only if it would otherwise parse differently.
+func normalizeBinary(e *BinaryExpr) *BinaryExpr {
+	c := &BinaryExpr{Op: e.Op, LHS: Normalize(e.LHS), RHS: Normalize(e.RHS), ReturnBool: e.ReturnBool}
+	if m := e.VectorMatching; m != nil && (m.On || len(m.MatchingLabels) > 0 || m.Card != "") {
+		c.VectorMatching = &VectorMatching{
+			On:             m.On,
+			MatchingLabels: sortedLabels(m.MatchingLabels),
+			Card:           m.Card,
+			Include:        sortedLabels(m.Include),
+		}
+	}
+
+	precedence := binaryPrecedence[e.Op]
+	rightAssociative := e.Op == "^"
+	if lhs, ok := c.LHS.(*BinaryExpr); ok {
+		if p := binaryPrecedence[lhs.Op]; p < precedence || (p == precedence && rightAssociative) {
+			c.LHS = &ParenExpr{Expr: lhs}
+		}
+	} else if rightAssociative && isNegative(c.LHS) {
+		// -a ^ b is -(a ^ b).
+		c.LHS = &ParenExpr{Expr: c.LHS}
+	}
+	if rhs, ok := c.RHS.(*BinaryExpr); ok {
+		if p := binaryPrecedence[rhs.Op]; p < precedence || (p == precedence && !rightAssociative) {
+			c.RHS = &ParenExpr{Expr: rhs}
+		}
+	}
+	return c
+}
+
+// isNegative reports whether expr renders with a leading sign.
+func isNegative(expr Expr) bool {
+	switch e := expr.(type) {
+	case *UnaryExpr:
+		return true
+	case *NumberLiteral:
+		return e.Val < 0 || (e.Val == 0 && 1/e.Val < 0)
+	}
+	return false
+}
+
+// normalizeSelector sorts and deduplicates the matchers of a selector, and
+// turns a __name__ equality matcher into its name.
+func normalizeSelector(e *VectorSelector) *VectorSelector {
+	c := &VectorSelector{Name: e.Name, Offset: e.Offset, At: e.At}
+	seen := make(map[LabelMatcher]bool, len(e.LabelMatchers))
+	for _, m := range e.LabelMatchers {
+		if m.Name == "__name__" && m.Type == MatchEqual && (c.Name == "" || c.Name == m.Value) {
+			c.Name = m.Value
+			continue
+		}
+		if !seen[*m] {
+			seen[*m] = true
+			matcher := *m
+			c.LabelMatchers = append(c.LabelMatchers, &matcher)
+		}
+	}
+	sort.Slice(c.LabelMatchers, func(i, j int) bool {
+		a, b := c.LabelMatchers[i], c.LabelMatchers[j]
+		if a.Name != b.Name {
+			return a.Name < b.Name
+		}
+		if a.Type != b.Type {
+			return a.Type < b.Type
+		}
+		return a.Value < b.Value
+	})
+	return c
+}
+
+// sortedLabels returns labels sorted, without repeats.
+func sortedLabels(labels []string) []string {
+	if labels == nil {
+		return nil
+	}
+	sorted := append([]string(nil), labels...)
+	sort.Strings(sorted)
+	unique := sorted[:0]
+	for i, label := range sorted {
+		if i == 0 || label != sorted[i-1] {
+			unique = append(unique, label)
+		}
+	}
+	return unique
+}
+
+// Equivalent reports whether two queries are the same once normalized (see
+// Normalize). It returns an error if either does not parse.
+func Equivalent(a, b string) (bool, error) {
+	exprA, err := Parse(a)
+	if err != nil {
+		return false, err
+	}
+	exprB, err := Parse(b)
+	if err != nil {
+		return false, err
+	}
+	return Normalize(exprA).String() == Normalize(exprB).String(), nil
+}
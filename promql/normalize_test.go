@@ -0,0 +1,129 @@
+package promql_test
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/prashantgupta17/nlpromql/promql"
+)
+
+func TestNormalize(t *testing.T) {
+	tests := []struct {
+		name     string
+		input    string
+		expected string
+	}{
+		{"Grouping last", "sum(rate(x[5m])) by (job)", "sum by (job) (rate(x[5m]))"},
+		{"Grouping order", "sum by (job, instance, job) (x)", "sum by (instance, job) (x)"},
+		{"Matcher order", `x{job="api", code=~"5..", job="api"}`, `x{code=~"5..", job="api"}`},
+		{"Name matcher", `{job="api", __name__="up"}`, `up{job="api"}`},
+		{"Name regexp kept", `{job="a", __name__=~"u.*"}`, `{__name__=~"u.*", job="a"}`},
+		{"Vector matching labels", "a / on (job, env) group_left (team, owner) b", "a / on (env, job) group_left (owner, team) b"},
+		{"Empty ignoring", "a + ignoring () b", "a + b"},
+		{"Empty on kept", "a and on () b", "a and on () b"},
+		{"Redundant parentheses", "((sum(((x)))))", "sum(x)"},
+		{"Left associative", "(a - b) - (c - d)", "a - b - (c - d)"},
+		{"Lower precedence operand", "(a + b) * c", "(a + b) * c"},
+		{"Higher precedence operand", "a + (b * c)", "a + b * c"},
+		{"Right associative", "(a ^ b) ^ (c ^ d)", "(a ^ b) ^ c ^ d"},
+		{"Negated power", "-(2 ^ x)", "-2 ^ x"},
+		{"Negative base", "(-2) ^ x", "(-2) ^ x"},
+		{"Negated sum", "-(a + b)", "-(a + b)"},
+		{"Negated literal", "-(2)", "-2"},
+		{"Subquery of a binary operation", "max_over_time((a + b)[1h:])", "max_over_time((a + b)[1h:])"},
+		{"Subquery of a call", "max_over_time((rate(x[1m]))[1h:])", "max_over_time(rate(x[1m])[1h:])"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			expr, err := promql.Parse(tt.input)
+			if err != nil {
+				t.Fatalf("Parse(%q) returned an unexpected error: %v", tt.input, err)
+			}
+			before := expr.String()
+			normalized := promql.Normalize(expr)
+			if got := normalized.String(); got != tt.expected {
+				t.Errorf("Normalize(%q) = %q, expected %q", tt.input, got, tt.expected)
+			}
+			if expr.String() != before {
+				t.Errorf("Normalize modified its input: %q became %q", before, expr.String())
+			}
+			// The normalized form must parse back to itself.
+			reparsed, err := promql.Parse(normalized.String())
+			if err != nil {
+				t.Fatalf("Parse(%q) returned an unexpected error: %v", normalized, err)
+			}
+			if got := promql.Normalize(reparsed).String(); got != tt.expected {
+				t.Errorf("normalized form reparses to %q, expected %q", got, tt.expected)
+			}
+		})
+	}
+}
+
+func TestEquivalent(t *testing.T) {
+	tests := []struct {
+		name     string
+		a, b     string
+		expected bool
+		err      bool
+	}{
+		{"Grouping placement", "sum by(job)(rate(x[5m]))", "sum(rate(x[5m])) by (job)", true, false},
+		{"Whitespace", "sum ( rate( x [5m] ) )", "sum(rate(x[5m]))", true, false},
+		{"Matcher order", `x{a="1",b="2"}`, `x{b="2", a="1"}`, true, false},
+		{"Durations", "rate(x[90m])", "rate(x[1h30m])", true, false},
+		{"Different grouping", "sum by (job) (x)", "sum without (job) (x)", false, false},
+		{"Different range", "rate(x[5m])", "rate(x[1m])", false, false},
+		{"Different precedence", "(a + b) * c", "a + b * c", false, false},
+		{"Invalid", "sum(", "sum(x)", false, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := promql.Equivalent(tt.a, tt.b)
+			if (err != nil) != tt.err {
+				t.Fatalf("Equivalent(%q, %q) returned error %v, expected an error: %v", tt.a, tt.b, err, tt.err)
+			}
+			if got != tt.expected {
+				t.Errorf("Equivalent(%q, %q) = %v, expected %v", tt.a, tt.b, got, tt.expected)
+			}
+		})
+	}
+}
+
+func TestDiff(t *testing.T) {
+	tests := []struct {
+		name     string
+		a, b     string
+		expected []string
+	}{
+		{"Equivalent", "sum by(job)(rate(x[5m]))", "sum(rate(x[5m])) by (job)", nil},
+		{"Range and grouping", "sum by (job) (rate(x[5m]))", "sum by (instance) (rate(x[1m]))",
+			[]string{"grouping: by (job) -> by (instance)", "expr.args[0].range: 5m -> 1m"}},
+		{"Function", "rate(x[5m])", "irate(x[5m])", []string{"func: rate -> irate"}},
+		{"Matchers", `x{job="a"}`, `y{job="b"}`, []string{"name: x -> y", `matchers: {job="a"} -> {job="b"}`}},
+		{"Operand in parentheses", "(a + b) * c", "a - b * c", []string{"op: * -> -", "lhs: a + b -> a", "rhs: c -> b * c"}},
+		{"Matching", "a / on (job) b", "a / b", []string{"matching: on (job) -> "}},
+		{"Different kinds", "sum(x)", "rate(x[5m])", []string{"sum(x) -> rate(x[5m])"}},
+		{"Parameter", "topk(5, x)", "topk(10, x)", []string{"param: 5 -> 10"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			a, err := promql.Parse(tt.a)
+			if err != nil {
+				t.Fatal(err)
+			}
+			b, err := promql.Parse(tt.b)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var got []string
+			for _, d := range promql.Diff(a, b) {
+				got = append(got, d.String())
+			}
+			if !reflect.DeepEqual(got, tt.expected) {
+				t.Errorf("Diff(%q, %q) = %q, expected %q", tt.a, tt.b, got, tt.expected)
+			}
+		})
+	}
+}
@@ -0,0 +1,648 @@
+// Package promql parses and type checks PromQL expressions, so that generated
+// queries can be checked without a round trip to Prometheus. Error messages
+// follow the wording of the Prometheus parser, which LLMs are familiar with.
+package promql
+
+import (
+	"fmt"
+	"math"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ParseError is a syntax or type error in a PromQL expression.
+type ParseError struct {
+	Pos   int    // Byte offset of the error in Query
+	Query string // The expression that failed to parse
+	Err   string
+}
+
+// Error renders the error like Prometheus does, with a line:column position.
+func (e *ParseError) Error() string {
+	line, col := 1, 1
+	for _, r := range e.Query[:e.Pos] {
+		if r == '\n' {
+			line, col = line+1, 1
+		} else {
+			col++
+		}
+	}
+	return fmt.Sprintf("%d:%d: parse error: %s", line, col, e.Err)
+}
+
+func errorAt(input string, pos int, format string, args ...interface{}) *ParseError {
+	if pos > len(input) {
+		pos = len(input)
+	}
+	return &ParseError{Pos: pos, Query: input, Err: fmt.Sprintf(format, args...)}
+}
+
+// Binary operator precedences, higher binds tighter.
+var binaryPrecedence = map[string]int{
+	"or":  1,
+	"and": 2, "unless": 2,
+	"==": 3, "!=": 3, ">": 3, "<": 3, ">=": 3, "<=": 3,
+	"+": 4, "-": 4,
+	"*": 5, "/": 5, "%": 5, "atan2": 5,
+	"^": 6,
+}
+
+const unaryPrecedence = 6 // Unary minus binds less tightly than ^: -2^2 is -(2^2)
+
+func isComparison(op string) bool { return binaryPrecedence[op] == 3 }
+func isSetOperator(op string) bool {
+	return op == "and" || op == "or" || op == "unless"
+}
+
+type parser struct {
+	input  string
+	tokens []token
+	pos    int
+}
+
+// Parse parses and type checks a PromQL expression. Errors are of type *ParseError.
+func Parse(input string) (Expr, error) {
+	tokens, err := lex(input)
+	if err != nil {
+		return nil, err
+	}
+	p := &parser{input: input, tokens: tokens}
+	expr, err := p.parseExpr(0)
+	if err != nil {
+		return nil, err
+	}
+	if t := p.peek(); t.typ != tokenEOF {
+		return nil, p.unexpected(t, "")
+	}
+	return expr, nil
+}
+
+func (p *parser) peek() token { return p.tokens[p.pos] }
+
+func (p *parser) next() token {
+	t := p.tokens[p.pos]
+	if t.typ != tokenEOF {
+		p.pos++
+	}
+	return t
+}
+
+func (p *parser) errorf(t token, format string, args ...interface{}) error {
+	return errorAt(p.input, t.pos, format, args...)
+}
+
+func (p *parser) unexpected(t token, context string) error {
+	if context != "" {
+		return p.errorf(t, "unexpected %s in %s", t, context)
+	}
+	return p.errorf(t, "unexpected %s", t)
+}
+
+func (p *parser) expect(typ tokenType, context string) (token, error) {
+	t := p.next()
+	if t.typ != typ {
+		return t, p.unexpected(t, context)
+	}
+	return t, nil
+}
+
+// isKeyword reports whether t is the identifier word.
+func isKeyword(t token, word string) bool {
+	return t.typ == tokenIdentifier && t.val == word
+}
+
+// binaryOperator returns the binary operator at t, if any.
+func binaryOperator(t token) (string, bool) {
+	if t.typ != tokenOperator && t.typ != tokenIdentifier {
+		return "", false
+	}
+	if _, ok := binaryPrecedence[t.val]; ok {
+		return t.val, true
+	}
+	return "", false
+}
+
+func (p *parser) parseExpr(minPrecedence int) (Expr, error) {
+	lhs, err := p.parseUnary()
+	if err != nil {
+		return nil, err
+	}
+	for {
+		opToken := p.peek()
+		op, ok := binaryOperator(opToken)
+		if !ok || binaryPrecedence[op] < minPrecedence {
+			return lhs, nil
+		}
+		p.next()
+
+		binary := &BinaryExpr{Op: op, LHS: lhs}
+		if err := p.parseBinaryModifiers(binary); err != nil {
+			return nil, err
+		}
+		nextPrecedence := binaryPrecedence[op] + 1
+		if op == "^" {
+			nextPrecedence = binaryPrecedence[op] // Right associative
+		}
+		binary.RHS, err = p.parseExpr(nextPrecedence)
+		if err != nil {
+			return nil, err
+		}
+		if err := p.checkBinary(opToken, binary); err != nil {
+			return nil, err
+		}
+		lhs = binary
+	}
+}
+
+func (p *parser) parseBinaryModifiers(binary *BinaryExpr) error {
+	if isKeyword(p.peek(), "bool") {
+		binary.ReturnBool = true
+		p.next()
+	}
+	if t := p.peek(); isKeyword(t, "on") || isKeyword(t, "ignoring") {
+		p.next()
+		labels, err := p.parseLabelList(t.val + " clause")
+		if err != nil {
+			return err
+		}
+		binary.VectorMatching = &VectorMatching{On: t.val == "on", MatchingLabels: labels}
+
+		if t := p.peek(); isKeyword(t, "group_left") || isKeyword(t, "group_right") {
+			p.next()
+			binary.VectorMatching.Card = t.val
+			if p.peek().typ == tokenLeftParen {
+				if binary.VectorMatching.Include, err = p.parseLabelList(t.val + " clause"); err != nil {
+					return err
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// checkBinary type checks binary once both of its sides are parsed.
+func (p *parser) checkBinary(opToken token, binary *BinaryExpr) error {
+	op := binary.Op
+	lt, rt := binary.LHS.Type(), binary.RHS.Type()
+	for _, t := range []ValueType{lt, rt} {
+		if t != ValueTypeScalar && t != ValueTypeVector {
+			return p.errorf(opToken, "binary expression must contain only scalar and instant vector types")
+		}
+	}
+	bothVectors := lt == ValueTypeVector && rt == ValueTypeVector
+
+	if binary.ReturnBool && !isComparison(op) {
+		return p.errorf(opToken, "bool modifier can only be used on comparison operators")
+	}
+	if isComparison(op) && !binary.ReturnBool && lt == ValueTypeScalar && rt == ValueTypeScalar {
+		return p.errorf(opToken, "comparisons between scalars must use BOOL modifier")
+	}
+	if isSetOperator(op) {
+		if !bothVectors {
+			return p.errorf(opToken, "set operator %q not allowed in binary scalar expression", op)
+		}
+		if binary.VectorMatching != nil && binary.VectorMatching.Card != "" {
+			return p.errorf(opToken, "no grouping allowed for %q operation", op)
+		}
+	}
+	if binary.VectorMatching != nil && !bothVectors {
+		return p.errorf(opToken, "vector matching only allowed between instant vectors")
+	}
+	return nil
+}
+
+func (p *parser) parseUnary() (Expr, error) {
+	t := p.peek()
+	if t.typ == tokenOperator && (t.val == "-" || t.val == "+") {
+		p.next()
+		expr, err := p.parseExpr(unaryPrecedence)
+		if err != nil {
+			return nil, err
+		}
+		if typ := expr.Type(); typ != ValueTypeScalar && typ != ValueTypeVector {
+			return nil, p.errorf(t, "unary expression only allowed on expressions of type scalar or instant vector, got %q", typ.documented())
+		}
+		if number, ok := expr.(*NumberLiteral); ok {
+			if t.val == "-" {
+				number.Val = -number.Val
+			}
+			return number, nil
+		}
+		return &UnaryExpr{Op: t.val, Expr: expr}, nil
+	}
+	expr, err := p.parsePrimary()
+	if err != nil {
+		return nil, err
+	}
+	return p.parsePostfix(expr)
+}
+
+func (p *parser) parsePrimary() (Expr, error) {
+	t := p.next()
+	switch t.typ {
+	case tokenNumber:
+		return p.parseNumber(t)
+	case tokenString:
+		return &StringLiteral{Val: t.val}, nil
+	case tokenLeftParen:
+		expr, err := p.parseExpr(0)
+		if err != nil {
+			return nil, err
+		}
+		if _, err := p.expect(tokenRightParen, "parenthesized expression"); err != nil {
+			return nil, err
+		}
+		return &ParenExpr{Expr: expr}, nil
+	case tokenLeftBrace:
+		return p.parseVectorSelector(t, "")
+	case tokenIdentifier:
+		lower := strings.ToLower(t.val)
+		if lower == "inf" || lower == "nan" {
+			return p.parseNumber(t)
+		}
+		if _, ok := aggregations[t.val]; ok {
+			if next := p.peek(); next.typ == tokenLeftParen || isKeyword(next, "by") || isKeyword(next, "without") {
+				return p.parseAggregation(t)
+			}
+		}
+		if p.peek().typ == tokenLeftParen {
+			return p.parseCall(t)
+		}
+		if _, ok := binaryPrecedence[t.val]; ok {
+			return nil, p.unexpected(t, "")
+		}
+		return p.parseVectorSelector(t, t.val)
+	}
+	return nil, p.unexpected(t, "")
+}
+
+func (p *parser) parseNumber(t token) (Expr, error) {
+	var val float64
+	var err error
+	switch lower := strings.ToLower(t.val); {
+	case lower == "inf":
+		val = math.Inf(1)
+	case lower == "nan":
+		val = math.NaN()
+	case strings.HasPrefix(lower, "0x"):
+		var n uint64
+		n, err = strconv.ParseUint(lower[2:], 16, 64)
+		val = float64(n)
+	default:
+		val, err = strconv.ParseFloat(t.val, 64)
+	}
+	if err != nil {
+		return nil, p.errorf(t, "invalid number %q", t.val)
+	}
+	return &NumberLiteral{Val: val}, nil
+}
+
+// parsePostfix parses the range, subquery, offset and @ modifiers following expr.
+func (p *parser) parsePostfix(expr Expr) (Expr, error) {
+	for {
+		t := p.peek()
+		switch {
+		case t.typ == tokenLeftBracket:
+			p.next()
+			var err error
+			if expr, err = p.parseRange(t, expr); err != nil {
+				return nil, err
+			}
+		case isKeyword(t, "offset"):
+			p.next()
+			offset, err := p.parseOffset()
+			if err != nil {
+				return nil, err
+			}
+			if err := p.setOffset(t, expr, offset); err != nil {
+				return nil, err
+			}
+		case t.typ == tokenAt:
+			p.next()
+			at, err := p.parseAt()
+			if err != nil {
+				return nil, err
+			}
+			if err := p.setAt(t, expr, at); err != nil {
+				return nil, err
+			}
+		default:
+			return expr, nil
+		}
+	}
+}
+
+// parseRange parses the rest of [range] or [range:step] following expr.
+func (p *parser) parseRange(open token, expr Expr) (Expr, error) {
+	rangeToken, err := p.expect(tokenDuration, "range")
+	if err != nil {
+		return nil, err
+	}
+	rng, err := ParseDuration(rangeToken.val)
+	if err != nil {
+		return nil, p.errorf(rangeToken, "%v", err)
+	}
+
+	if p.peek().typ == tokenColon {
+		p.next()
+		var step time.Duration
+		if t := p.peek(); t.typ == tokenDuration {
+			p.next()
+			if step, err = ParseDuration(t.val); err != nil {
+				return nil, p.errorf(t, "%v", err)
+			}
+		}
+		if _, err := p.expect(tokenRightBracket, "subquery selector"); err != nil {
+			return nil, err
+		}
+		if typ := expr.Type(); typ != ValueTypeVector {
+			return nil, p.errorf(open, "subquery is only allowed on instant vector, got %s in %q instead", typ.documented(), expr.String())
+		}
+		return &SubqueryExpr{Expr: expr, Range: rng, Step: step}, nil
+	}
+
+	if _, err := p.expect(tokenRightBracket, "range selector"); err != nil {
+		return nil, err
+	}
+	vs, ok := expr.(*VectorSelector)
+	if !ok {
+		return nil, p.errorf(open, "ranges only allowed for vector selectors")
+	}
+	if vs.Offset != 0 || vs.At != "" {
+		return nil, p.errorf(open, "no offset or @ modifiers allowed before range")
+	}
+	return &MatrixSelector{VectorSelector: vs, Range: rng}, nil
+}
+
+func (p *parser) parseOffset() (time.Duration, error) {
+	sign := time.Duration(1)
+	if t := p.peek(); t.typ == tokenOperator && t.val == "-" {
+		p.next()
+		sign = -1
+	}
+	t, err := p.expect(tokenDuration, "offset")
+	if err != nil {
+		return 0, err
+	}
+	d, err := ParseDuration(t.val)
+	if err != nil {
+		return 0, p.errorf(t, "%v", err)
+	}
+	return sign * d, nil
+}
+
+func (p *parser) parseAt() (string, error) {
+	t := p.next()
+	switch {
+	case t.typ == tokenNumber:
+		return t.val, nil
+	case isKeyword(t, "start") || isKeyword(t, "end"):
+		if _, err := p.expect(tokenLeftParen, "@ modifier"); err != nil {
+			return "", err
+		}
+		if _, err := p.expect(tokenRightParen, "@ modifier"); err != nil {
+			return "", err
+		}
+		return t.val + "()", nil
+	}
+	return "", p.unexpected(t, "@ modifier")
+}
+
+func (p *parser) setOffset(t token, expr Expr, offset time.Duration) error {
+	var target *time.Duration
+	switch e := expr.(type) {
+	case *VectorSelector:
+		target = &e.Offset
+	case *MatrixSelector:
+		target = &e.VectorSelector.Offset
+	case *SubqueryExpr:
+		target = &e.Offset
+	default:
+		return p.errorf(t, "offset modifier must be preceded by an instant vector selector or range vector selector or a subquery")
+	}
+	if *target != 0 {
+		return p.errorf(t, "offset may not be set multiple times")
+	}
+	*target = offset
+	return nil
+}
+
+func (p *parser) setAt(t token, expr Expr, at string) error {
+	var target *string
+	switch e := expr.(type) {
+	case *VectorSelector:
+		target = &e.At
+	case *MatrixSelector:
+		target = &e.VectorSelector.At
+	case *SubqueryExpr:
+		target = &e.At
+	default:
+		return p.errorf(t, "@ modifier must be preceded by an instant vector selector or range vector selector or a subquery")
+	}
+	if *target != "" {
+		return p.errorf(t, "@ <timestamp> may not be set multiple times")
+	}
+	*target = at
+	return nil
+}
+
+// parseLabelList parses a parenthesized, comma-separated list of label names.
+func (p *parser) parseLabelList(context string) ([]string, error) {
+	if _, err := p.expect(tokenLeftParen, context); err != nil {
+		return nil, err
+	}
+	labels := []string{}
+	for {
+		t := p.next()
+		switch {
+		case t.typ == tokenRightParen:
+			return labels, nil
+		case t.typ == tokenIdentifier && !strings.Contains(t.val, ":"):
+			labels = append(labels, t.val)
+		default:
+			return nil, p.unexpected(t, "grouping opts")
+		}
+		if t := p.peek(); t.typ == tokenComma {
+			p.next()
+		} else if t.typ != tokenRightParen {
+			return nil, p.unexpected(t, "grouping opts")
+		}
+	}
+}
+
+// parseVectorSelector parses a selector after its metric name, if any, or
+// after its opening brace if it has no name.
+func (p *parser) parseVectorSelector(start token, name string) (Expr, error) {
+	vs := &VectorSelector{Name: name}
+	if name != "" {
+		if p.peek().typ != tokenLeftBrace {
+			return vs, nil
+		}
+		p.next()
+	}
+
+	for {
+		t := p.next()
+		if t.typ == tokenRightBrace {
+			break
+		}
+		if t.typ != tokenIdentifier || strings.Contains(t.val, ":") {
+			return nil, p.unexpected(t, "label matching")
+		}
+		opToken := p.next()
+		matchType := MatchType(opToken.val)
+		if opToken.typ != tokenOperator || (matchType != MatchEqual && matchType != MatchNotEqual && matchType != MatchRegexp && matchType != MatchNotRegexp) {
+			return nil, p.unexpected(opToken, "label matching, expected one of \"=\", \"!=\", \"=~\" or \"!~\"")
+		}
+		valueToken, err := p.expect(tokenString, "label matching, expected string")
+		if err != nil {
+			return nil, err
+		}
+		if matchType == MatchRegexp || matchType == MatchNotRegexp {
+			if _, err := regexp.Compile("^(?:" + valueToken.val + ")$"); err != nil {
+				return nil, p.errorf(valueToken, "invalid regular expression in label matcher: %v", err)
+			}
+		}
+		if t.val == "__name__" && name != "" {
+			return nil, p.errorf(t, "metric name must not be set twice: %q or %q", name, valueToken.val)
+		}
+		vs.LabelMatchers = append(vs.LabelMatchers, &LabelMatcher{Name: t.val, Type: matchType, Value: valueToken.val})
+
+		if next := p.peek(); next.typ == tokenComma {
+			p.next()
+		} else if next.typ != tokenRightBrace {
+			return nil, p.unexpected(next, "label matching, expected \",\" or \"}\"")
+		}
+	}
+
+	if name == "" {
+		nonEmpty := false
+		for _, m := range vs.LabelMatchers {
+			if !matchesEmpty(m) {
+				nonEmpty = true
+				break
+			}
+		}
+		if !nonEmpty {
+			return nil, p.errorf(start, "vector selector must contain at least one non-empty matcher")
+		}
+	}
+	return vs, nil
+}
+
+// matchesEmpty reports whether m matches series that do not have the label.
+func matchesEmpty(m *LabelMatcher) bool {
+	switch m.Type {
+	case MatchEqual:
+		return m.Value == ""
+	case MatchNotEqual:
+		return m.Value != ""
+	}
+	matched := regexp.MustCompile("^(?:" + m.Value + ")$").MatchString("")
+	return matched == (m.Type == MatchRegexp)
+}
+
+func (p *parser) parseCall(name token) (Expr, error) {
+	fn, ok := Functions[name.val]
+	if !ok {
+		return nil, p.errorf(name, "unknown function with name %q", name.val)
+	}
+	args, err := p.parseArgs(fmt.Sprintf("call to %q", name.val))
+	if err != nil {
+		return nil, err
+	}
+
+	switch {
+	case fn.MinArgs == fn.MaxArgs && len(args) != fn.MinArgs:
+		return nil, p.errorf(name, "expected %d argument(s) in call to %q, got %d", fn.MinArgs, fn.Name, len(args))
+	case len(args) < fn.MinArgs:
+		return nil, p.errorf(name, "expected at least %d argument(s) in call to %q, got %d", fn.MinArgs, fn.Name, len(args))
+	case fn.MaxArgs >= 0 && len(args) > fn.MaxArgs:
+		return nil, p.errorf(name, "expected at most %d argument(s) in call to %q, got %d", fn.MaxArgs, fn.Name, len(args))
+	}
+	for i, arg := range args {
+		if expected := fn.argType(i); arg.Type() != expected {
+			return nil, p.errorf(name, "expected type %s in call to function %q, got %s", expected.documented(), fn.Name, arg.Type().documented())
+		}
+	}
+	return &Call{Func: fn, Args: args}, nil
+}
+
+// parseArgs parses a parenthesized, comma-separated list of expressions.
+func (p *parser) parseArgs(context string) ([]Expr, error) {
+	if _, err := p.expect(tokenLeftParen, context); err != nil {
+		return nil, err
+	}
+	args := []Expr{}
+	if p.peek().typ == tokenRightParen {
+		p.next()
+		return args, nil
+	}
+	for {
+		arg, err := p.parseExpr(0)
+		if err != nil {
+			return nil, err
+		}
+		args = append(args, arg)
+		t := p.next()
+		if t.typ == tokenRightParen {
+			return args, nil
+		}
+		if t.typ != tokenComma {
+			return nil, p.unexpected(t, context)
+		}
+	}
+}
+
+func (p *parser) parseAggregation(op token) (Expr, error) {
+	agg := &AggregateExpr{Op: op.val}
+	parseGrouping := func() error {
+		t := p.peek()
+		if !isKeyword(t, "by") && !isKeyword(t, "without") {
+			return nil
+		}
+		if agg.Grouping != nil {
+			return p.unexpected(t, "aggregation")
+		}
+		p.next()
+		labels, err := p.parseLabelList(t.val + " clause")
+		if err != nil {
+			return err
+		}
+		agg.Grouping, agg.Without = labels, t.val == "without"
+		return nil
+	}
+
+	if err := parseGrouping(); err != nil {
+		return nil, err
+	}
+	args, err := p.parseArgs("aggregation")
+	if err != nil {
+		return nil, err
+	}
+	if err := parseGrouping(); err != nil {
+		return nil, err
+	}
+
+	paramType := aggregations[op.val]
+	expected := 1
+	if paramType != "" {
+		expected = 2
+	}
+	if len(args) != expected {
+		return nil, p.errorf(op, "wrong number of arguments for aggregate expression provided, expected %d, got %d", expected, len(args))
+	}
+	if paramType != "" {
+		agg.Param = args[0]
+		if typ := agg.Param.Type(); typ != paramType {
+			return nil, p.errorf(op, "expected type %s in aggregation parameter, got %s", paramType.documented(), typ.documented())
+		}
+	}
+	agg.Expr = args[len(args)-1]
+	if typ := agg.Expr.Type(); typ != ValueTypeVector {
+		return nil, p.errorf(op, "expected type instant vector in aggregation expression, got %s", typ.documented())
+	}
+	return agg, nil
+}
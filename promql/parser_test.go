@@ -0,0 +1,89 @@
+package promql_test
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/prashantgupta17/nlpromql/promql"
+)
+
+func TestParse(t *testing.T) {
+	tests := []struct {
+		name     string
+		input    string
+		expected string
+	}{
+		{"Metric", "up", "up"},
+		{"Matchers", `http_requests_total{job="api", code=~'5..'}`, `http_requests_total{job="api", code=~"5.."}`},
+		{"Name matcher only", `{__name__="up"}`, `{__name__="up"}`},
+		{"Rate", "rate(http_requests_total[5m])", "rate(http_requests_total[5m])"},
+		{"Aggregation grouping first", "sum by (job) (rate(x[1m]))", "sum by (job) (rate(x[1m]))"},
+		{"Aggregation grouping last", "sum(rate(x[1m])) without (instance)", "sum without (instance) (rate(x[1m]))"},
+		{"Topk", "topk(5, x)", "topk(5, x)"},
+		{"Histogram quantile", "histogram_quantile(0.9, sum by (le) (rate(h_bucket[5m])))", "histogram_quantile(0.9, sum by (le) (rate(h_bucket[5m])))"},
+		{"Precedence", "a + b * c ^ 2 ^ 3", "a + b * c ^ 2 ^ 3"},
+		{"Unary minus", "-2 ^ 2", "-2 ^ 2"},
+		{"Vector matching", "a / on (job) group_left (team) b", "a / on (job) group_left (team) b"},
+		{"Bool comparison", "1 > bool 2", "1 > bool 2"},
+		{"Set operator", "a and ignoring (instance) b", "a and ignoring (instance) b"},
+		{"Offset and at", "rate(x[5m] @ end() offset 1h)", "rate(x[5m] @ end() offset 1h)"},
+		{"Subquery", "max_over_time(rate(x[1m])[1h:5m])", "max_over_time(rate(x[1m])[1h:5m])"},
+		{"Compound duration", "x offset 90m", "x offset 1h30m"},
+		{"Label replace", `label_replace(up, "host", "$1", "instance", "(.*):.*")`, `label_replace(up, "host", "$1", "instance", "(.*):.*")`},
+		{"Inf", "x > Inf", "x > Inf"},
+		{"Comment", "up # is it?", "up"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			expr, err := promql.Parse(tt.input)
+			if err != nil {
+				t.Fatalf("Parse(%q) returned an unexpected error: %v", tt.input, err)
+			}
+			if got := expr.String(); got != tt.expected {
+				t.Errorf("Parse(%q).String() = %q, expected %q", tt.input, got, tt.expected)
+			}
+		})
+	}
+}
+
+func TestParse_Errors(t *testing.T) {
+	tests := []struct {
+		name          string
+		input         string
+		expectedError string
+	}{
+		{"Unknown function", "rat(x[5m])", `1:1: parse error: unknown function with name "rat"`},
+		{"Instant vector to rate", "rate(x)", `expected type range vector in call to function "rate", got instant vector`},
+		{"Wrong argument count", "histogram_quantile(x)", `expected 2 argument(s) in call to "histogram_quantile", got 1`},
+		{"Range on function", "rate(x[5m])[5m]", "ranges only allowed for vector selectors"},
+		{"Range in binary expression", "x[5m] + 1", "binary expression must contain only scalar and instant vector types"},
+		{"Scalar comparison", "1 > 2", "comparisons between scalars must use BOOL modifier"},
+		{"Bool on arithmetic", "a + bool b", "bool modifier can only be used on comparison operators"},
+		{"Scalar set operation", "a and 1", `set operator "and" not allowed in binary scalar expression`},
+		{"Grouping on set operation", "a or on (job) group_left b", `no grouping allowed for "or" operation`},
+		{"Aggregation of range", "sum(x[5m])", "expected type instant vector in aggregation expression, got range vector"},
+		{"Missing aggregation parameter", "topk(x)", "wrong number of arguments for aggregate expression provided, expected 2, got 1"},
+		{"Empty matchers", `{job=""}`, "vector selector must contain at least one non-empty matcher"},
+		{"Bad regexp", `x{job=~"("}`, "invalid regular expression in label matcher"},
+		{"Bad matcher operator", `x{job=="a"}`, "unexpected \"==\" in label matching"},
+		{"Unquoted label value", `x{job=api}`, "unexpected identifier \"api\" in label matching, expected string"},
+		{"Unclosed paren", "sum(rate(x[5m])", "unexpected end of input in aggregation"},
+		{"Bad duration", "rate(x[5q])", "bad number or duration syntax"},
+		{"Offset on function", "rate(x[5m]) offset 5m", "offset modifier must be preceded by"},
+		{"Subquery of range", "x[5m][1h:]", "subquery is only allowed on instant vector"},
+		{"Line and column", "sum(\n  rate(x))", `2:3: parse error: expected type range vector in call to function "rate"`},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := promql.Parse(tt.input)
+			if err == nil {
+				t.Fatalf("Parse(%q) expected an error containing %q, got nil", tt.input, tt.expectedError)
+			}
+			if !strings.Contains(err.Error(), tt.expectedError) {
+				t.Errorf("Parse(%q) error = %q, expected it to contain %q", tt.input, err.Error(), tt.expectedError)
+			}
+		})
+	}
+}
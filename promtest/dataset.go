@@ -0,0 +1,255 @@
+// Package promtest serves a fixture dataset over the Prometheus HTTP API, for
+// tests of code that talks to Prometheus. It answers label, metadata and
+// series requests, evaluates instant and range queries for a subset of
+// PromQL, and lists configured targets and rules.
+package promtest
+
+import (
+	"regexp"
+	"sort"
+	"time"
+
+	"github.com/prashantgupta17/nlpromql/promql"
+)
+
+// Sample is a value of a series at a time.
+type Sample struct {
+	Time  time.Time
+	Value float64
+}
+
+// Series is a series of the dataset: its labels, including __name__, and its
+// samples in time order.
+type Series struct {
+	Labels  map[string]string
+	Samples []Sample
+}
+
+// Metadata describes a metric, as returned by /api/v1/metadata.
+type Metadata struct {
+	Type string `json:"type"`
+	Help string `json:"help"`
+	Unit string `json:"unit"`
+}
+
+// Target is a scrape target, as returned by /api/v1/targets.
+type Target struct {
+	DiscoveredLabels map[string]string `json:"discoveredLabels"`
+	Labels           map[string]string `json:"labels"`
+	ScrapePool       string            `json:"scrapePool"`
+	ScrapeURL        string            `json:"scrapeUrl"`
+	LastError        string            `json:"lastError"`
+	LastScrape       time.Time         `json:"lastScrape"`
+	Health           string            `json:"health"` // "up", "down" or "unknown"
+}
+
+// RuleGroup is a group of recording and alerting rules, as returned by
+// /api/v1/rules.
+type RuleGroup struct {
+	Name     string  `json:"name"`
+	File     string  `json:"file"`
+	Interval float64 `json:"interval"` // Seconds
+	Rules    []Rule  `json:"rules"`
+}
+
+// Rule is a recording or alerting rule.
+type Rule struct {
+	Type        string            `json:"type"` // "recording" or "alerting"
+	Name        string            `json:"name"`
+	Query       string            `json:"query"`
+	Duration    float64           `json:"duration,omitempty"` // Seconds an alert must be pending; alerting rules only
+	Labels      map[string]string `json:"labels,omitempty"`
+	Annotations map[string]string `json:"annotations,omitempty"`
+	Health      string            `json:"health"`
+}
+
+// Dataset is the data a Server serves. It must not be modified while the
+// server is in use.
+type Dataset struct {
+	Series     []Series
+	Metadata   map[string]Metadata // By metric name
+	Targets    []Target
+	RuleGroups []RuleGroup
+}
+
+// Samples returns samples of values spaced step apart, the last at end.
+func Samples(end time.Time, step time.Duration, values ...float64) []Sample {
+	samples := make([]Sample, len(values))
+	for i, value := range values {
+		samples[i] = Sample{Time: end.Add(-time.Duration(len(values)-1-i) * step), Value: value}
+	}
+	return samples
+}
+
+// DefaultDataset returns a small dataset of HTTP, memory and scrape health
+// metrics with samples every minute for ten minutes up to now, along with
+// their metadata, two targets and a rule of each kind. The
+// http_requests_total counters grow by 60, 6 and 120 per minute, so their
+// rates are 1, 0.1 and 2 per second over any range.
+func DefaultDataset(now time.Time) *Dataset {
+	counter := func(perMinute float64) []Sample {
+		values := make([]float64, 11)
+		for i := range values {
+			values[i] = 1000 + perMinute*float64(i)
+		}
+		return Samples(now, time.Minute, values...)
+	}
+	gauge := func(value float64) []Sample {
+		values := make([]float64, 11)
+		for i := range values {
+			values[i] = value
+		}
+		return Samples(now, time.Minute, values...)
+	}
+	labels := func(name string, pairs ...string) map[string]string {
+		l := map[string]string{"__name__": name}
+		for i := 0; i+1 < len(pairs); i += 2 {
+			l[pairs[i]] = pairs[i+1]
+		}
+		return l
+	}
+	lastScrape := now.Add(-15 * time.Second)
+	return &Dataset{
+		Series: []Series{
+			{labels("http_requests_total", "job", "api", "instance", "api-1:8080", "method", "GET", "code", "200"), counter(60)},
+			{labels("http_requests_total", "job", "api", "instance", "api-1:8080", "method", "POST", "code", "500"), counter(6)},
+			{labels("http_requests_total", "job", "web", "instance", "web-1:8080", "method", "GET", "code", "200"), counter(120)},
+			{labels("node_memory_MemAvailable_bytes", "job", "node", "instance", "node-1:9100"), gauge(2e9)},
+			{labels("node_memory_MemTotal_bytes", "job", "node", "instance", "node-1:9100"), gauge(8e9)},
+			{labels("up", "job", "api", "instance", "api-1:8080"), gauge(1)},
+			{labels("up", "job", "node", "instance", "node-1:9100"), gauge(0)},
+		},
+		Metadata: map[string]Metadata{
+			"http_requests_total":            {Type: "counter", Help: "Total number of HTTP requests."},
+			"node_memory_MemAvailable_bytes": {Type: "gauge", Help: "Memory information field MemAvailable_bytes.", Unit: "bytes"},
+			"node_memory_MemTotal_bytes":     {Type: "gauge", Help: "Memory information field MemTotal_bytes.", Unit: "bytes"},
+			"up":                             {Type: "gauge", Help: "Whether the target was scraped successfully."},
+		},
+		Targets: []Target{
+			{
+				DiscoveredLabels: map[string]string{"__address__": "api-1:8080", "job": "api"},
+				Labels:           map[string]string{"instance": "api-1:8080", "job": "api"},
+				ScrapePool:       "api",
+				ScrapeURL:        "http://api-1:8080/metrics",
+				LastScrape:       lastScrape,
+				Health:           "up",
+			},
+			{
+				DiscoveredLabels: map[string]string{"__address__": "node-1:9100", "job": "node"},
+				Labels:           map[string]string{"instance": "node-1:9100", "job": "node"},
+				ScrapePool:       "node",
+				ScrapeURL:        "http://node-1:9100/metrics",
+				LastError:        "connection refused",
+				LastScrape:       lastScrape,
+				Health:           "down",
+			},
+		},
+		RuleGroups: []RuleGroup{{
+			Name:     "example",
+			File:     "/etc/prometheus/rules.yml",
+			Interval: 60,
+			Rules: []Rule{
+				{Type: "recording", Name: "job:http_requests:rate5m", Query: "sum by (job) (rate(http_requests_total[5m]))", Health: "ok"},
+				{
+					Type:        "alerting",
+					Name:        "TargetDown",
+					Query:       "up == 0",
+					Duration:    300,
+					Labels:      map[string]string{"severity": "warning"},
+					Annotations: map[string]string{"summary": "{{ $labels.instance }} is down"},
+					Health:      "ok",
+				},
+			},
+		}},
+	}
+}
+
+// selectSeries returns the series matching a selector.
+func (d *Dataset) selectSeries(selector *promql.VectorSelector) ([]Series, error) {
+	matches, err := seriesMatcher(selector)
+	if err != nil {
+		return nil, err
+	}
+	var selected []Series
+	for _, s := range d.Series {
+		if matches(s) {
+			selected = append(selected, s)
+		}
+	}
+	return selected, nil
+}
+
+// seriesMatcher returns a function reporting whether a series matches all the
+// matchers of a selector.
+func seriesMatcher(selector *promql.VectorSelector) (func(Series) bool, error) {
+	matchers := selector.LabelMatchers
+	if selector.Name != "" {
+		matchers = append([]*promql.LabelMatcher{{Name: "__name__", Type: promql.MatchEqual, Value: selector.Name}}, matchers...)
+	}
+	matches := make([]func(string) bool, len(matchers))
+	for i, m := range matchers {
+		match, err := matchFunc(m)
+		if err != nil {
+			return nil, err
+		}
+		matches[i] = match
+	}
+	return func(s Series) bool {
+		for i, m := range matchers {
+			if !matches[i](s.Labels[m.Name]) {
+				return false
+			}
+		}
+		return true
+	}, nil
+}
+
+// matchFunc returns a function reporting whether a label value, empty if the
+// label is missing, satisfies m.
+func matchFunc(m *promql.LabelMatcher) (func(string) bool, error) {
+	switch m.Type {
+	case promql.MatchEqual:
+		return func(v string) bool { return v == m.Value }, nil
+	case promql.MatchNotEqual:
+		return func(v string) bool { return v != m.Value }, nil
+	}
+	re, err := regexp.Compile("^(?:" + m.Value + ")$")
+	if err != nil {
+		return nil, err
+	}
+	if m.Type == promql.MatchNotRegexp {
+		return func(v string) bool { return !re.MatchString(v) }, nil
+	}
+	return re.MatchString, nil
+}
+
+// labelNames returns the sorted label names of series.
+func labelNames(series []Series) []string {
+	names := make(map[string]bool)
+	for _, s := range series {
+		for name := range s.Labels {
+			names[name] = true
+		}
+	}
+	return sortedSet(names)
+}
+
+// labelValues returns the sorted values of a label in series.
+func labelValues(series []Series, name string) []string {
+	values := make(map[string]bool)
+	for _, s := range series {
+		if value, ok := s.Labels[name]; ok {
+			values[value] = true
+		}
+	}
+	return sortedSet(values)
+}
+
+func sortedSet(set map[string]bool) []string {
+	sorted := make([]string, 0, len(set))
+	for s := range set {
+		sorted = append(sorted, s)
+	}
+	sort.Strings(sorted)
+	return sorted
+}
@@ -0,0 +1,646 @@
+package promtest
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/prashantgupta17/nlpromql/promql"
+)
+
+// lookbackDelta is how far back an instant vector selector looks for the
+// latest sample of a series, as in Prometheus.
+const lookbackDelta = 5 * time.Minute
+
+// Values an expression evaluates to.
+type (
+	scalar float64
+	str    string
+	vector []vectorSample
+	matrix []Series
+)
+
+type vectorSample struct {
+	Labels map[string]string
+	Value  float64
+}
+
+// unsupportedError is returned for valid PromQL that the evaluator does not
+// implement.
+type unsupportedError struct {
+	what string
+}
+
+func (e *unsupportedError) Error() string {
+	return e.what + " is not supported by promtest"
+}
+
+// evaluator evaluates parsed queries against a dataset. start and end are
+// the times of @ start() and @ end(): the range of a range query, or the time
+// of an instant query.
+//
+// It implements the selectors, scalar and vector arithmetic and comparisons
+// with one-to-one matching, the set operators, the aggregations sum, avg,
+// min, max, count, group, topk and bottomk, and common functions. rate is
+// the slope between the first and last samples in the range, corrected for
+// counter resets, and increase and delta that slope over the whole range,
+// close to what Prometheus extrapolates for regularly scraped series.
+type evaluator struct {
+	dataset    *Dataset
+	start, end time.Time
+}
+
+func (ev *evaluator) eval(expr promql.Expr, t time.Time) (interface{}, error) {
+	switch e := expr.(type) {
+	case *promql.NumberLiteral:
+		return scalar(e.Val), nil
+	case *promql.StringLiteral:
+		return str(e.Val), nil
+	case *promql.ParenExpr:
+		return ev.eval(e.Expr, t)
+	case *promql.UnaryExpr:
+		value, err := ev.eval(e.Expr, t)
+		if err != nil || e.Op == "+" {
+			return value, err
+		}
+		switch v := value.(type) {
+		case scalar:
+			return -v, nil
+		case vector:
+			return mapVector(v, func(f float64) float64 { return -f }), nil
+		}
+		return nil, fmt.Errorf("unexpected operand of unary minus: %T", value)
+	case *promql.VectorSelector:
+		return ev.selectVector(e, t)
+	case *promql.MatrixSelector:
+		return ev.selectMatrix(e, t)
+	case *promql.BinaryExpr:
+		return ev.binary(e, t)
+	case *promql.AggregateExpr:
+		return ev.aggregate(e, t)
+	case *promql.Call:
+		return ev.call(e, t)
+	case *promql.SubqueryExpr:
+		return nil, &unsupportedError{"subquery"}
+	}
+	return nil, fmt.Errorf("unexpected expression %T", expr)
+}
+
+// at returns the evaluation time of a selector at t.
+func (ev *evaluator) at(t time.Time, at string, offset time.Duration) (time.Time, error) {
+	switch at {
+	case "":
+	case "start()":
+		t = ev.start
+	case "end()":
+		t = ev.end
+	default:
+		seconds, err := strconv.ParseFloat(at, 64)
+		if err != nil {
+			return time.Time{}, fmt.Errorf("invalid @ timestamp %q", at)
+		}
+		t = timeFromSeconds(seconds)
+	}
+	return t.Add(-offset), nil
+}
+
+func (ev *evaluator) selectVector(e *promql.VectorSelector, t time.Time) (vector, error) {
+	ts, err := ev.at(t, e.At, e.Offset)
+	if err != nil {
+		return nil, err
+	}
+	series, err := ev.dataset.selectSeries(e)
+	if err != nil {
+		return nil, err
+	}
+	var result vector
+	for _, s := range series {
+		samples := samplesIn(s.Samples, ts.Add(-lookbackDelta), ts)
+		if len(samples) > 0 {
+			result = append(result, vectorSample{Labels: s.Labels, Value: samples[len(samples)-1].Value})
+		}
+	}
+	return result, nil
+}
+
+func (ev *evaluator) selectMatrix(e *promql.MatrixSelector, t time.Time) (matrix, error) {
+	ts, err := ev.at(t, e.VectorSelector.At, e.VectorSelector.Offset)
+	if err != nil {
+		return nil, err
+	}
+	series, err := ev.dataset.selectSeries(e.VectorSelector)
+	if err != nil {
+		return nil, err
+	}
+	var result matrix
+	for _, s := range series {
+		if samples := samplesIn(s.Samples, ts.Add(-e.Range), ts); len(samples) > 0 {
+			result = append(result, Series{Labels: s.Labels, Samples: samples})
+		}
+	}
+	return result, nil
+}
+
+// samplesIn returns the samples in the range (from, to].
+func samplesIn(samples []Sample, from, to time.Time) []Sample {
+	var in []Sample
+	for _, s := range samples {
+		if s.Time.After(from) && !s.Time.After(to) {
+			in = append(in, s)
+		}
+	}
+	return in
+}
+
+func (ev *evaluator) binary(e *promql.BinaryExpr, t time.Time) (interface{}, error) {
+	lhs, err := ev.eval(e.LHS, t)
+	if err != nil {
+		return nil, err
+	}
+	rhs, err := ev.eval(e.RHS, t)
+	if err != nil {
+		return nil, err
+	}
+	comparison := isComparison(e.Op)
+	switch l := lhs.(type) {
+	case scalar:
+		switch r := rhs.(type) {
+		case scalar:
+			value, keep := apply(e.Op, float64(l), float64(r))
+			if comparison && !keep {
+				value = 0
+			}
+			return scalar(value), nil
+		case vector:
+			return scalarVector(e, r, func(v float64) (float64, bool) { return apply(e.Op, float64(l), v) }), nil
+		}
+	case vector:
+		switch r := rhs.(type) {
+		case scalar:
+			return scalarVector(e, l, func(v float64) (float64, bool) { return apply(e.Op, v, float64(r)) }), nil
+		case vector:
+			return vectorVector(e, l, r)
+		}
+	}
+	return nil, fmt.Errorf("unexpected operands of %s: %T and %T", e.Op, lhs, rhs)
+}
+
+// scalarVector applies op to each sample of a vector and a scalar.
+func scalarVector(e *promql.BinaryExpr, v vector, op func(float64) (float64, bool)) vector {
+	var result vector
+	for _, sample := range v {
+		value, keep := op(sample.Value)
+		switch {
+		case e.ReturnBool:
+			result = append(result, vectorSample{Labels: dropName(sample.Labels), Value: boolValue(keep)})
+		case isComparison(e.Op):
+			if keep {
+				result = append(result, sample)
+			}
+		default:
+			result = append(result, vectorSample{Labels: dropName(sample.Labels), Value: value})
+		}
+	}
+	return result
+}
+
+// vectorVector applies a set operator, or an operator with one-to-one
+// matching, to two vectors.
+func vectorVector(e *promql.BinaryExpr, lhs, rhs vector) (vector, error) {
+	m := e.VectorMatching
+	if m == nil {
+		m = &promql.VectorMatching{}
+	}
+	if m.Card != "" {
+		return nil, &unsupportedError{m.Card}
+	}
+	signature := func(labels map[string]string) string { return matchingSignature(labels, m) }
+
+	switch e.Op {
+	case "and", "unless":
+		inRHS := make(map[string]bool, len(rhs))
+		for _, sample := range rhs {
+			inRHS[signature(sample.Labels)] = true
+		}
+		var result vector
+		for _, sample := range lhs {
+			if inRHS[signature(sample.Labels)] == (e.Op == "and") {
+				result = append(result, sample)
+			}
+		}
+		return result, nil
+	case "or":
+		inLHS := make(map[string]bool, len(lhs))
+		for _, sample := range lhs {
+			inLHS[signature(sample.Labels)] = true
+		}
+		result := append(vector(nil), lhs...)
+		for _, sample := range rhs {
+			if !inLHS[signature(sample.Labels)] {
+				result = append(result, sample)
+			}
+		}
+		return result, nil
+	}
+
+	bySignature := make(map[string]vectorSample, len(rhs))
+	for _, sample := range rhs {
+		key := signature(sample.Labels)
+		if _, ok := bySignature[key]; ok {
+			return nil, fmt.Errorf("found duplicate series for the match group %s on the right hand-side of the operation", key)
+		}
+		bySignature[key] = sample
+	}
+	var result vector
+	matched := make(map[string]bool, len(lhs))
+	for _, sample := range lhs {
+		key := signature(sample.Labels)
+		other, ok := bySignature[key]
+		if !ok {
+			continue
+		}
+		if matched[key] {
+			return nil, fmt.Errorf("found duplicate series for the match group %s on the left hand-side of the operation", key)
+		}
+		matched[key] = true
+		value, keep := apply(e.Op, sample.Value, other.Value)
+		if isComparison(e.Op) && !e.ReturnBool {
+			if keep {
+				result = append(result, vectorSample{Labels: resultLabels(sample.Labels, m, false), Value: sample.Value})
+			}
+			continue
+		}
+		if e.ReturnBool {
+			value = boolValue(keep)
+		}
+		result = append(result, vectorSample{Labels: resultLabels(sample.Labels, m, true), Value: value})
+	}
+	return result, nil
+}
+
+// matchingSignature identifies the labels two series are matched on.
+func matchingSignature(labels map[string]string, m *promql.VectorMatching) string {
+	return labelsKey(resultLabels(labels, m, true))
+}
+
+// resultLabels returns the labels of the result of a one-to-one operation:
+// those of on(...), or all but those of ignoring(...).
+func resultLabels(labels map[string]string, m *promql.VectorMatching, dropMetricName bool) map[string]string {
+	result := make(map[string]string, len(labels))
+	if m.On {
+		for _, name := range m.MatchingLabels {
+			if value, ok := labels[name]; ok {
+				result[name] = value
+			}
+		}
+		return result
+	}
+	for name, value := range labels {
+		result[name] = value
+	}
+	for _, name := range m.MatchingLabels {
+		delete(result, name)
+	}
+	if dropMetricName {
+		delete(result, "__name__")
+	}
+	return result
+}
+
+func isComparison(op string) bool {
+	switch op {
+	case "==", "!=", ">", "<", ">=", "<=":
+		return true
+	}
+	return false
+}
+
+// apply applies an arithmetic or comparison operator. For comparisons it
+// returns the left operand and whether the comparison holds.
+func apply(op string, l, r float64) (float64, bool) {
+	switch op {
+	case "+":
+		return l + r, true
+	case "-":
+		return l - r, true
+	case "*":
+		return l * r, true
+	case "/":
+		return l / r, true
+	case "%":
+		return math.Mod(l, r), true
+	case "^":
+		return math.Pow(l, r), true
+	case "atan2":
+		return math.Atan2(l, r), true
+	case "==":
+		return l, l == r
+	case "!=":
+		return l, l != r
+	case ">":
+		return l, l > r
+	case "<":
+		return l, l < r
+	case ">=":
+		return l, l >= r
+	case "<=":
+		return l, l <= r
+	}
+	return math.NaN(), false
+}
+
+func boolValue(b bool) float64 {
+	if b {
+		return 1
+	}
+	return 0
+}
+
+func (ev *evaluator) aggregate(e *promql.AggregateExpr, t time.Time) (vector, error) {
+	value, err := ev.eval(e.Expr, t)
+	if err != nil {
+		return nil, err
+	}
+	input := value.(vector)
+	groupLabels := func(labels map[string]string) map[string]string {
+		grouped := make(map[string]string)
+		if e.Without {
+			for name, value := range labels {
+				grouped[name] = value
+			}
+			delete(grouped, "__name__")
+			for _, name := range e.Grouping {
+				delete(grouped, name)
+			}
+			return grouped
+		}
+		for _, name := range e.Grouping {
+			if value, ok := labels[name]; ok {
+				grouped[name] = value
+			}
+		}
+		return grouped
+	}
+	var order []string
+	groups := make(map[string][]vectorSample)
+	for _, sample := range input {
+		key := labelsKey(groupLabels(sample.Labels))
+		if _, ok := groups[key]; !ok {
+			order = append(order, key)
+		}
+		groups[key] = append(groups[key], sample)
+	}
+
+	var result vector
+	switch e.Op {
+	case "topk", "bottomk":
+		param, err := ev.eval(e.Param, t)
+		if err != nil {
+			return nil, err
+		}
+		k := int(param.(scalar))
+		for _, key := range order {
+			samples := append([]vectorSample(nil), groups[key]...)
+			sort.SliceStable(samples, func(i, j int) bool {
+				if e.Op == "topk" {
+					return samples[i].Value > samples[j].Value
+				}
+				return samples[i].Value < samples[j].Value
+			})
+			if len(samples) > k {
+				samples = samples[:k]
+			}
+			result = append(result, samples...)
+		}
+		return result, nil
+	case "sum", "avg", "min", "max", "count", "group":
+	default:
+		return nil, &unsupportedError{"aggregation " + strconv.Quote(e.Op)}
+	}
+	for _, key := range order {
+		samples := groups[key]
+		values := make([]float64, len(samples))
+		for i, sample := range samples {
+			values[i] = sample.Value
+		}
+		result = append(result, vectorSample{Labels: groupLabels(samples[0].Labels), Value: aggregateValues(e.Op, values)})
+	}
+	return result, nil
+}
+
+// aggregateValues aggregates the values of a group, or of a series over
+// time. op is an aggregation or the prefix of an _over_time function.
+func aggregateValues(op string, values []float64) float64 {
+	switch op {
+	case "count":
+		return float64(len(values))
+	case "group":
+		return 1
+	case "last":
+		return values[len(values)-1]
+	}
+	result := values[0]
+	for _, v := range values[1:] {
+		switch op {
+		case "sum", "avg":
+			result += v
+		case "min":
+			if v < result || math.IsNaN(result) {
+				result = v
+			}
+		case "max":
+			if v > result || math.IsNaN(result) {
+				result = v
+			}
+		}
+	}
+	if op == "avg" {
+		result /= float64(len(values))
+	}
+	return result
+}
+
+// mathFunctions are the functions applying a function to each sample of a
+// vector.
+var mathFunctions = map[string]func(float64) float64{
+	"abs":   math.Abs,
+	"ceil":  math.Ceil,
+	"floor": math.Floor,
+	"exp":   math.Exp,
+	"ln":    math.Log,
+	"log2":  math.Log2,
+	"log10": math.Log10,
+	"sqrt":  math.Sqrt,
+	"sgn": func(v float64) float64 {
+		if v > 0 {
+			return 1
+		} else if v < 0 {
+			return -1
+		}
+		return v
+	},
+}
+
+func (ev *evaluator) call(e *promql.Call, t time.Time) (interface{}, error) {
+	name := e.Func.Name
+	args := make([]interface{}, len(e.Args))
+	for i, arg := range e.Args {
+		value, err := ev.eval(arg, t)
+		if err != nil {
+			return nil, err
+		}
+		args[i] = value
+	}
+
+	if f, ok := mathFunctions[name]; ok {
+		return mapVector(args[0].(vector), f), nil
+	}
+	if op := strings.TrimSuffix(name, "_over_time"); op != name {
+		switch op {
+		case "sum", "avg", "min", "max", "count", "last":
+			return mapMatrix(args[0].(matrix), func(samples []Sample) (float64, bool) {
+				values := make([]float64, len(samples))
+				for i, s := range samples {
+					values[i] = s.Value
+				}
+				return aggregateValues(op, values), true
+			}), nil
+		}
+	}
+	switch name {
+	case "rate", "increase", "delta":
+		rangeSeconds := e.Args[0].(*promql.MatrixSelector).Range.Seconds()
+		return mapMatrix(args[0].(matrix), func(samples []Sample) (float64, bool) {
+			if len(samples) < 2 {
+				return 0, false
+			}
+			first, last := samples[0], samples[len(samples)-1]
+			change := last.Value - first.Value
+			if name != "delta" {
+				change = counterIncrease(samples)
+			}
+			slope := change / last.Time.Sub(first.Time).Seconds()
+			if name == "rate" {
+				return slope, true
+			}
+			return slope * rangeSeconds, true
+		}), nil
+	case "irate", "idelta":
+		return mapMatrix(args[0].(matrix), func(samples []Sample) (float64, bool) {
+			if len(samples) < 2 {
+				return 0, false
+			}
+			previous, last := samples[len(samples)-2], samples[len(samples)-1]
+			change := last.Value - previous.Value
+			if name == "idelta" {
+				return change, true
+			}
+			if change < 0 {
+				change = last.Value // Counter reset
+			}
+			return change / last.Time.Sub(previous.Time).Seconds(), true
+		}), nil
+	case "clamp_min", "clamp_max":
+		limit := float64(args[1].(scalar))
+		return mapVector(args[0].(vector), func(v float64) float64 {
+			if name == "clamp_min" {
+				return math.Max(v, limit)
+			}
+			return math.Min(v, limit)
+		}), nil
+	case "time":
+		return scalar(secondsFromTime(t)), nil
+	case "vector":
+		return vector{{Labels: map[string]string{}, Value: float64(args[0].(scalar))}}, nil
+	case "scalar":
+		if v := args[0].(vector); len(v) == 1 {
+			return scalar(v[0].Value), nil
+		}
+		return scalar(math.NaN()), nil
+	case "sort", "sort_desc":
+		sorted := append(vector(nil), args[0].(vector)...)
+		sort.SliceStable(sorted, func(i, j int) bool {
+			if name == "sort" {
+				return sorted[i].Value < sorted[j].Value
+			}
+			return sorted[i].Value > sorted[j].Value
+		})
+		return sorted, nil
+	}
+	return nil, &unsupportedError{"function " + strconv.Quote(name)}
+}
+
+// counterIncrease returns how much a counter grew over samples, counting a
+// decrease as a reset to zero.
+func counterIncrease(samples []Sample) float64 {
+	increase := 0.0
+	for i := 1; i < len(samples); i++ {
+		if samples[i].Value < samples[i-1].Value {
+			increase += samples[i].Value
+		} else {
+			increase += samples[i].Value - samples[i-1].Value
+		}
+	}
+	return increase
+}
+
+// mapVector applies f to the value of each sample, dropping the metric name.
+func mapVector(v vector, f func(float64) float64) vector {
+	result := make(vector, len(v))
+	for i, sample := range v {
+		result[i] = vectorSample{Labels: dropName(sample.Labels), Value: f(sample.Value)}
+	}
+	return result
+}
+
+// mapMatrix turns each series into a sample of the value f returns for its
+// samples, dropping the series for which f returns false.
+func mapMatrix(m matrix, f func([]Sample) (float64, bool)) vector {
+	var result vector
+	for _, series := range m {
+		if value, ok := f(series.Samples); ok {
+			result = append(result, vectorSample{Labels: dropName(series.Labels), Value: value})
+		}
+	}
+	return result
+}
+
+func dropName(labels map[string]string) map[string]string {
+	dropped := make(map[string]string, len(labels))
+	for name, value := range labels {
+		if name != "__name__" {
+			dropped[name] = value
+		}
+	}
+	return dropped
+}
+
+// labelsKey identifies a label set.
+func labelsKey(labels map[string]string) string {
+	names := make([]string, 0, len(labels))
+	for name := range labels {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	var b strings.Builder
+	b.WriteString("{")
+	for i, name := range names {
+		if i > 0 {
+			b.WriteString(", ")
+		}
+		b.WriteString(name + "=" + strconv.Quote(labels[name]))
+	}
+	b.WriteString("}")
+	return b.String()
+}
+
+func timeFromSeconds(seconds float64) time.Time {
+	return time.Unix(0, int64(seconds*float64(time.Second)))
+}
+
+func secondsFromTime(t time.Time) float64 {
+	return float64(t.UnixNano()) / float64(time.Second)
+}
@@ -0,0 +1,428 @@
+package promtest
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/prashantgupta17/nlpromql/promql"
+)
+
+// maxRangePoints is the most points a range query may return per series,
+// as in Prometheus.
+const maxRangePoints = 11000
+
+// Server is an HTTP server serving a Dataset over the Prometheus API, on a
+// local port, until it is closed.
+type Server struct {
+	*httptest.Server
+	Handler *Handler
+}
+
+// NewServer starts a Server for dataset. Its URL is the base URL to give
+// Prometheus clients.
+func NewServer(dataset *Dataset) *Server {
+	handler := &Handler{Dataset: dataset}
+	return &Server{Server: httptest.NewServer(handler), Handler: handler}
+}
+
+// Handler serves a Dataset over the Prometheus API:
+//
+//	/api/v1/labels, /api/v1/label/<name>/values, /api/v1/series,
+//	/api/v1/metadata, /api/v1/query, /api/v1/query_range,
+//	/api/v1/targets and /api/v1/rules
+//
+// Errors are reported as Prometheus does, e.g. status 400 and errorType
+// "bad_data" for a query that does not parse, and 422 and "execution" for one
+// that fails to evaluate, including one using PromQL the evaluator does not
+// support.
+type Handler struct {
+	Dataset *Dataset
+	// Username and Password, if Username is set, are required as basic
+	// authentication. Set them before sending requests.
+	Username, Password string
+}
+
+type response struct {
+	Status    string      `json:"status"`
+	Data      interface{} `json:"data,omitempty"`
+	ErrorType string      `json:"errorType,omitempty"`
+	Error     string      `json:"error,omitempty"`
+}
+
+// apiError is an error reported in a response.
+type apiError struct {
+	status int
+	typ    string
+	err    error
+}
+
+func badData(format string, args ...interface{}) *apiError {
+	return &apiError{http.StatusBadRequest, "bad_data", fmt.Errorf(format, args...)}
+}
+
+func (h *Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if h.Username != "" {
+		if user, pass, ok := r.BasicAuth(); !ok || user != h.Username || pass != h.Password {
+			http.Error(w, "Unauthorized", http.StatusUnauthorized)
+			return
+		}
+	}
+	if err := r.ParseForm(); err != nil {
+		h.respond(w, nil, badData("error parsing form values: %v", err))
+		return
+	}
+
+	path := strings.TrimPrefix(r.URL.Path, "/api/v1")
+	var data interface{}
+	var err *apiError
+	switch {
+	case path == "/labels":
+		data, err = h.labels(r)
+	case strings.HasPrefix(path, "/label/") && strings.HasSuffix(path, "/values"):
+		data, err = h.labelValues(r, strings.TrimSuffix(strings.TrimPrefix(path, "/label/"), "/values"))
+	case path == "/series":
+		data, err = h.series(r)
+	case path == "/metadata":
+		data, err = h.metadata(r)
+	case path == "/query":
+		data, err = h.query(r)
+	case path == "/query_range":
+		data, err = h.queryRange(r)
+	case path == "/targets":
+		data, err = h.targets(r)
+	case path == "/rules":
+		data, err = h.rules(r)
+	default:
+		http.NotFound(w, r)
+		return
+	}
+	h.respond(w, data, err)
+}
+
+func (h *Handler) respond(w http.ResponseWriter, data interface{}, err *apiError) {
+	w.Header().Set("Content-Type", "application/json")
+	if err != nil {
+		w.WriteHeader(err.status)
+		json.NewEncoder(w).Encode(response{Status: "error", ErrorType: err.typ, Error: err.err.Error()})
+		return
+	}
+	json.NewEncoder(w).Encode(response{Status: "success", Data: data})
+}
+
+// matchedSeries returns the series matching any of the match[] selectors of
+// r, or all series if there are none.
+func (h *Handler) matchedSeries(r *http.Request, required bool) ([]Series, *apiError) {
+	selectors := r.Form["match[]"]
+	if len(selectors) == 0 {
+		if required {
+			return nil, badData("no match[] parameter provided")
+		}
+		return h.Dataset.Series, nil
+	}
+	var matchers []func(Series) bool
+	for _, selector := range selectors {
+		expr, err := promql.Parse(selector)
+		if err != nil {
+			return nil, badData("%v", err)
+		}
+		vs, ok := expr.(*promql.VectorSelector)
+		if !ok {
+			return nil, badData("invalid parameter \"match[]\": %q is not a series selector", selector)
+		}
+		matches, err := seriesMatcher(vs)
+		if err != nil {
+			return nil, badData("%v", err)
+		}
+		matchers = append(matchers, matches)
+	}
+	var matched []Series
+	for _, s := range h.Dataset.Series {
+		for _, matches := range matchers {
+			if matches(s) {
+				matched = append(matched, s)
+				break
+			}
+		}
+	}
+	return matched, nil
+}
+
+func (h *Handler) labels(r *http.Request) (interface{}, *apiError) {
+	series, err := h.matchedSeries(r, false)
+	if err != nil {
+		return nil, err
+	}
+	return labelNames(series), nil
+}
+
+func (h *Handler) labelValues(r *http.Request, name string) (interface{}, *apiError) {
+	series, err := h.matchedSeries(r, false)
+	if err != nil {
+		return nil, err
+	}
+	return labelValues(series, name), nil
+}
+
+func (h *Handler) series(r *http.Request) (interface{}, *apiError) {
+	series, err := h.matchedSeries(r, true)
+	if err != nil {
+		return nil, err
+	}
+	labels := make([]map[string]string, len(series))
+	for i, s := range series {
+		labels[i] = s.Labels
+	}
+	return labels, nil
+}
+
+func (h *Handler) metadata(r *http.Request) (interface{}, *apiError) {
+	limit := -1
+	if s := r.FormValue("limit"); s != "" {
+		var err error
+		if limit, err = strconv.Atoi(s); err != nil {
+			return nil, badData("invalid limit %q", s)
+		}
+	}
+	metric := r.FormValue("metric")
+	names := make([]string, 0, len(h.Dataset.Metadata))
+	for name := range h.Dataset.Metadata {
+		if metric == "" || name == metric {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	metadata := make(map[string][]Metadata)
+	for _, name := range names {
+		if limit >= 0 && len(metadata) >= limit {
+			break
+		}
+		metadata[name] = []Metadata{h.Dataset.Metadata[name]}
+	}
+	return metadata, nil
+}
+
+func (h *Handler) query(r *http.Request) (interface{}, *apiError) {
+	t, err := parseTime(r.FormValue("time"), time.Now())
+	if err != nil {
+		return nil, badData("invalid parameter \"time\": %v", err)
+	}
+	expr, apiErr := parseQuery(r)
+	if apiErr != nil {
+		return nil, apiErr
+	}
+	ev := &evaluator{dataset: h.Dataset, start: t, end: t}
+	value, err := ev.eval(expr, t)
+	if err != nil {
+		return nil, executionError(err)
+	}
+	ts := secondsFromTime(t)
+	switch v := value.(type) {
+	case scalar:
+		return queryData{"scalar", point(ts, float64(v))}, nil
+	case str:
+		return queryData{"string", []interface{}{ts, string(v)}}, nil
+	case vector:
+		result := make([]vectorResult, len(v))
+		for i, sample := range v {
+			result[i] = vectorResult{Metric: sample.Labels, Value: point(ts, sample.Value)}
+		}
+		return queryData{"vector", result}, nil
+	case matrix:
+		result := make([]matrixResult, len(v))
+		for i, series := range v {
+			result[i] = matrixResult{Metric: series.Labels, Values: points(series.Samples)}
+		}
+		return queryData{"matrix", result}, nil
+	}
+	return nil, executionError(fmt.Errorf("unexpected result %T", value))
+}
+
+func (h *Handler) queryRange(r *http.Request) (interface{}, *apiError) {
+	start, err := parseTime(r.FormValue("start"), time.Time{})
+	if err != nil || start.IsZero() {
+		return nil, badData("invalid parameter \"start\": %q", r.FormValue("start"))
+	}
+	end, err := parseTime(r.FormValue("end"), time.Time{})
+	if err != nil || end.IsZero() {
+		return nil, badData("invalid parameter \"end\": %q", r.FormValue("end"))
+	}
+	if end.Before(start) {
+		return nil, badData("invalid parameter \"end\": end timestamp must not be before start time")
+	}
+	step, err := parseDuration(r.FormValue("step"))
+	if err != nil || step <= 0 {
+		return nil, badData("invalid parameter \"step\": zero or negative query resolution step widths are not accepted. Try a positive integer")
+	}
+	if end.Sub(start)/step > maxRangePoints {
+		return nil, badData("exceeded maximum resolution of %d points per timeseries. Try decreasing the query resolution (?step=XX)", maxRangePoints)
+	}
+	expr, apiErr := parseQuery(r)
+	if apiErr != nil {
+		return nil, apiErr
+	}
+	if typ := expr.Type(); typ != promql.ValueTypeScalar && typ != promql.ValueTypeVector {
+		return nil, badData("invalid expression type %q for range query, must be Scalar or instant Vector", typ)
+	}
+
+	ev := &evaluator{dataset: h.Dataset, start: start, end: end}
+	var order []string
+	series := make(map[string]*matrixResult)
+	for t := start; !t.After(end); t = t.Add(step) {
+		value, err := ev.eval(expr, t)
+		if err != nil {
+			return nil, executionError(err)
+		}
+		samples, ok := value.(vector)
+		if s, isScalar := value.(scalar); isScalar {
+			samples, ok = vector{{Labels: map[string]string{}, Value: float64(s)}}, true
+		}
+		if !ok {
+			return nil, executionError(fmt.Errorf("unexpected result %T", value))
+		}
+		for _, sample := range samples {
+			key := labelsKey(sample.Labels)
+			if _, ok := series[key]; !ok {
+				order = append(order, key)
+				series[key] = &matrixResult{Metric: sample.Labels}
+			}
+			series[key].Values = append(series[key].Values, point(secondsFromTime(t), sample.Value))
+		}
+	}
+	result := make([]matrixResult, len(order))
+	for i, key := range order {
+		result[i] = *series[key]
+	}
+	return queryData{"matrix", result}, nil
+}
+
+func (h *Handler) targets(r *http.Request) (interface{}, *apiError) {
+	active := make([]Target, 0, len(h.Dataset.Targets))
+	if state := r.FormValue("state"); state == "" || state == "any" || state == "active" {
+		active = append(active, h.Dataset.Targets...)
+	}
+	return struct {
+		ActiveTargets  []Target `json:"activeTargets"`
+		DroppedTargets []Target `json:"droppedTargets"`
+	}{active, []Target{}}, nil
+}
+
+func (h *Handler) rules(r *http.Request) (interface{}, *apiError) {
+	var ruleType string
+	switch typ := r.FormValue("type"); typ {
+	case "":
+	case "alert":
+		ruleType = "alerting"
+	case "record":
+		ruleType = "recording"
+	default:
+		return nil, badData("invalid parameter \"type\": %q", typ)
+	}
+	groups := make([]RuleGroup, 0, len(h.Dataset.RuleGroups))
+	for _, group := range h.Dataset.RuleGroups {
+		rules := make([]Rule, 0, len(group.Rules))
+		for _, rule := range group.Rules {
+			if ruleType == "" || rule.Type == ruleType {
+				rules = append(rules, rule)
+			}
+		}
+		if ruleType == "" || len(rules) > 0 {
+			group.Rules = rules
+			groups = append(groups, group)
+		}
+	}
+	return struct {
+		Groups []RuleGroup `json:"groups"`
+	}{groups}, nil
+}
+
+// queryData is the data of a query response.
+type queryData struct {
+	ResultType string      `json:"resultType"`
+	Result     interface{} `json:"result"`
+}
+
+type vectorResult struct {
+	Metric map[string]string `json:"metric"`
+	Value  []interface{}     `json:"value"`
+}
+
+type matrixResult struct {
+	Metric map[string]string `json:"metric"`
+	Values [][]interface{}   `json:"values"`
+}
+
+// point renders a sample as Prometheus does: [<seconds>, "<value>"].
+func point(seconds, value float64) []interface{} {
+	return []interface{}{seconds, formatValue(value)}
+}
+
+func points(samples []Sample) [][]interface{} {
+	result := make([][]interface{}, len(samples))
+	for i, s := range samples {
+		result[i] = point(secondsFromTime(s.Time), s.Value)
+	}
+	return result
+}
+
+func formatValue(v float64) string {
+	switch {
+	case math.IsInf(v, 1):
+		return "+Inf"
+	case math.IsInf(v, -1):
+		return "-Inf"
+	case math.IsNaN(v):
+		return "NaN"
+	}
+	return strconv.FormatFloat(v, 'f', -1, 64)
+}
+
+func parseQuery(r *http.Request) (promql.Expr, *apiError) {
+	query := r.FormValue("query")
+	if query == "" {
+		return nil, badData("invalid parameter \"query\": query must not be empty")
+	}
+	expr, err := promql.Parse(query)
+	if err != nil {
+		return nil, badData("invalid parameter \"query\": %v", err)
+	}
+	return expr, nil
+}
+
+func executionError(err error) *apiError {
+	return &apiError{http.StatusUnprocessableEntity, "execution", err}
+}
+
+// parseTime parses a Unix timestamp in seconds or an RFC 3339 time, returning
+// def for an empty string.
+func parseTime(s string, def time.Time) (time.Time, error) {
+	if s == "" {
+		return def, nil
+	}
+	if seconds, err := strconv.ParseFloat(s, 64); err == nil {
+		return timeFromSeconds(seconds), nil
+	}
+	if t, err := time.Parse(time.RFC3339Nano, s); err == nil {
+		return t, nil
+	}
+	return time.Time{}, fmt.Errorf("cannot parse %q to a valid timestamp", s)
+}
+
+// parseDuration parses a number of seconds or a Prometheus duration.
+func parseDuration(s string) (time.Duration, error) {
+	if seconds, err := strconv.ParseFloat(s, 64); err == nil {
+		return time.Duration(seconds * float64(time.Second)), nil
+	}
+	expr, err := promql.Parse("x[" + s + "]")
+	if err != nil {
+		return 0, errors.New("invalid duration")
+	}
+	return expr.(*promql.MatrixSelector).Range, nil
+}
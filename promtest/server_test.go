@@ -0,0 +1,217 @@
+package promtest_test
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/prashantgupta17/nlpromql/promtest"
+)
+
+type response struct {
+	Status    string          `json:"status"`
+	Data      json.RawMessage `json:"data"`
+	ErrorType string          `json:"errorType"`
+	Error     string          `json:"error"`
+}
+
+type queryResult struct {
+	ResultType string `json:"resultType"`
+	Result     []struct {
+		Metric map[string]string `json:"metric"`
+		Value  []interface{}     `json:"value"`
+		Values [][]interface{}   `json:"values"`
+	} `json:"result"`
+}
+
+// get requests path from server and decodes the response.
+func get(t *testing.T, server *promtest.Server, path string, params url.Values) (int, response) {
+	t.Helper()
+	resp, err := http.Get(server.URL + path + "?" + params.Encode())
+	if err != nil {
+		t.Fatalf("GET %s: %v", path, err)
+	}
+	defer resp.Body.Close()
+	var r response
+	if err := json.NewDecoder(resp.Body).Decode(&r); err != nil {
+		t.Fatalf("decoding the response to %s: %v", path, err)
+	}
+	return resp.StatusCode, r
+}
+
+// getData requests path from server and decodes the data of a successful
+// response into data.
+func getData(t *testing.T, server *promtest.Server, path string, params url.Values, data interface{}) {
+	t.Helper()
+	status, r := get(t, server, path, params)
+	if status != http.StatusOK || r.Status != "success" {
+		t.Fatalf("GET %s?%s: status %d, %+v", path, params.Encode(), status, r)
+	}
+	if err := json.Unmarshal(r.Data, data); err != nil {
+		t.Fatalf("decoding the data of %s: %v", path, err)
+	}
+}
+
+// describe renders the series of a vector result as sorted "labels value"
+// lines.
+func describe(result queryResult) []string {
+	var lines []string
+	for _, r := range result.Result {
+		names := make([]string, 0, len(r.Metric))
+		for name := range r.Metric {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		pairs := make([]string, len(names))
+		for i, name := range names {
+			pairs[i] = name + "=" + r.Metric[name]
+		}
+		line := "{" + strings.Join(pairs, ",") + "}"
+		if r.Value != nil {
+			line += " " + r.Value[1].(string)
+		}
+		for _, v := range r.Values {
+			line += " " + v[1].(string)
+		}
+		lines = append(lines, line)
+	}
+	sort.Strings(lines)
+	return lines
+}
+
+func TestServer_Metadata(t *testing.T) {
+	server := promtest.NewServer(promtest.DefaultDataset(time.Now()))
+	defer server.Close()
+
+	var labels []string
+	getData(t, server, "/api/v1/labels", nil, &labels)
+	if expected := []string{"__name__", "code", "instance", "job", "method"}; !reflect.DeepEqual(labels, expected) {
+		t.Errorf("labels %v, expected %v", labels, expected)
+	}
+	var values []string
+	getData(t, server, "/api/v1/label/job/values", url.Values{"match[]": {"up"}}, &values)
+	if expected := []string{"api", "node"}; !reflect.DeepEqual(values, expected) {
+		t.Errorf("job values of up %v, expected %v", values, expected)
+	}
+	var series []map[string]string
+	getData(t, server, "/api/v1/series", url.Values{"match[]": {`{__name__=~"node_.*"}`, `up{job="node"}`}}, &series)
+	if len(series) != 3 {
+		t.Errorf("expected 3 series, got %v", series)
+	}
+	var metadata map[string][]promtest.Metadata
+	getData(t, server, "/api/v1/metadata", url.Values{"metric": {"up"}}, &metadata)
+	if expected := map[string][]promtest.Metadata{"up": {{Type: "gauge", Help: "Whether the target was scraped successfully."}}}; !reflect.DeepEqual(metadata, expected) {
+		t.Errorf("metadata %v, expected %v", metadata, expected)
+	}
+	var targets struct {
+		ActiveTargets []promtest.Target `json:"activeTargets"`
+	}
+	getData(t, server, "/api/v1/targets", nil, &targets)
+	if len(targets.ActiveTargets) != 2 || targets.ActiveTargets[1].Health != "down" {
+		t.Errorf("unexpected targets %+v", targets)
+	}
+	var rules struct {
+		Groups []promtest.RuleGroup `json:"groups"`
+	}
+	getData(t, server, "/api/v1/rules", url.Values{"type": {"alert"}}, &rules)
+	if len(rules.Groups) != 1 || len(rules.Groups[0].Rules) != 1 || rules.Groups[0].Rules[0].Name != "TargetDown" {
+		t.Errorf("unexpected alerting rules %+v", rules)
+	}
+}
+
+func TestServer_Query(t *testing.T) {
+	now := time.Now().Truncate(time.Second)
+	server := promtest.NewServer(promtest.DefaultDataset(now))
+	defer server.Close()
+
+	tests := []struct {
+		name       string
+		query      string
+		resultType string
+		expected   []string
+	}{
+		{"Selector", `up{job=~"a.*"}`, "vector", []string{"{__name__=up,instance=api-1:8080,job=api} 1"}},
+		{"Rate by job", "sum by (job) (rate(http_requests_total[5m]))", "vector", []string{"{job=api} 1.1", "{job=web} 2"}},
+		{"Increase", `increase(http_requests_total{code="500"}[5m])`, "vector", []string{"{code=500,instance=api-1:8080,job=api,method=POST} 30"}},
+		{"Comparison", "up == 0", "vector", []string{"{__name__=up,instance=node-1:9100,job=node} 0"}},
+		{"One-to-one matching", "node_memory_MemAvailable_bytes / on (instance) node_memory_MemTotal_bytes", "vector", []string{"{instance=node-1:9100} 0.25"}},
+		{"Set operator", `http_requests_total unless on (job) up`, "vector", []string{"{__name__=http_requests_total,code=200,instance=web-1:8080,job=web,method=GET} 2200"}},
+		{"Topk", "topk(1, http_requests_total)", "vector", []string{"{__name__=http_requests_total,code=200,instance=web-1:8080,job=web,method=GET} 2200"}},
+		{"Offset", "http_requests_total{job=\"web\"} offset 5m", "vector", []string{"{__name__=http_requests_total,code=200,instance=web-1:8080,job=web,method=GET} 1600"}},
+		{"Range vector", "up[2m]", "matrix", []string{"{__name__=up,instance=api-1:8080,job=api} 1 1", "{__name__=up,instance=node-1:9100,job=node} 0 0"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var result queryResult
+			getData(t, server, "/api/v1/query", url.Values{"query": {tt.query}, "time": {strconv.FormatInt(now.Unix(), 10)}}, &result)
+			if result.ResultType != tt.resultType {
+				t.Errorf("result type %q, expected %q", result.ResultType, tt.resultType)
+			}
+			if got := describe(result); !reflect.DeepEqual(got, tt.expected) {
+				t.Errorf("result %q, expected %q", got, tt.expected)
+			}
+		})
+	}
+
+	var scalar struct {
+		ResultType string        `json:"resultType"`
+		Result     []interface{} `json:"result"`
+	}
+	getData(t, server, "/api/v1/query", url.Values{"query": {"1 + 2 * 3"}}, &scalar)
+	if scalar.ResultType != "scalar" || len(scalar.Result) != 2 || scalar.Result[1] != "7" {
+		t.Errorf("unexpected scalar result %+v", scalar)
+	}
+
+	var result queryResult
+	getData(t, server, "/api/v1/query_range", url.Values{
+		"query": {`sum(up)`},
+		"start": {strconv.FormatInt(now.Add(-2*time.Minute).Unix(), 10)},
+		"end":   {strconv.FormatInt(now.Unix(), 10)},
+		"step":  {"1m"},
+	}, &result)
+	if got, expected := describe(result), []string{"{} 1 1 1"}; result.ResultType != "matrix" || !reflect.DeepEqual(got, expected) {
+		t.Errorf("range query result %q of type %q, expected a matrix %q", got, result.ResultType, expected)
+	}
+}
+
+func TestServer_Errors(t *testing.T) {
+	server := promtest.NewServer(promtest.DefaultDataset(time.Now()))
+	defer server.Close()
+
+	tests := []struct {
+		name      string
+		path      string
+		params    url.Values
+		status    int
+		errorType string
+	}{
+		{"Parse error", "/api/v1/query", url.Values{"query": {"sum("}}, http.StatusBadRequest, "bad_data"},
+		{"Unsupported", "/api/v1/query", url.Values{"query": {"max_over_time(up[5m:1m])"}}, http.StatusUnprocessableEntity, "execution"},
+		{"Range query of a range vector", "/api/v1/query_range", url.Values{"query": {"up[5m]"}, "start": {"1"}, "end": {"2"}, "step": {"1"}}, http.StatusBadRequest, "bad_data"},
+		{"Series without match", "/api/v1/series", nil, http.StatusBadRequest, "bad_data"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			status, r := get(t, server, tt.path, tt.params)
+			if status != tt.status || r.Status != "error" || r.ErrorType != tt.errorType || r.Error == "" {
+				t.Errorf("status %d, %+v; expected status %d and error type %q", status, r, tt.status, tt.errorType)
+			}
+		})
+	}
+
+	server.Handler.Username, server.Handler.Password = "user", "secret"
+	resp, err := http.Get(server.URL + "/api/v1/labels")
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusUnauthorized {
+		t.Errorf("expected status 401 without credentials, got %d", resp.StatusCode)
+	}
+}
@@ -0,0 +1,82 @@
+package query_processing
+
+import (
+	"context"
+	"sort"
+
+	"github.com/prashantgupta17/nlpromql/info_structure"
+	"github.com/prashantgupta17/nlpromql/llm"
+)
+
+// ExactRetriever looks the tokens of the possible matches up in the
+// information structure maps as they are: metric tokens in MetricMap, label
+// tokens in LabelMap and label values in the value index. Each metric found
+// gets the labels found that it has.
+type ExactRetriever struct {
+	metricMap      info_structure.MetricMap
+	labelMap       info_structure.LabelMap
+	metricLabelMap info_structure.MetricLabelMap
+	valueIndex     info_structure.ValueIndex
+}
+
+// NewExactRetriever returns an ExactRetriever over the maps of a built
+// information structure.
+func NewExactRetriever(metricMap info_structure.MetricMap, labelMap info_structure.LabelMap,
+	metricLabelMap info_structure.MetricLabelMap, valueIndex info_structure.ValueIndex) *ExactRetriever {
+	return &ExactRetriever{
+		metricMap:      metricMap,
+		labelMap:       labelMap,
+		metricLabelMap: metricLabelMap,
+		valueIndex:     valueIndex,
+	}
+}
+
+// Retrieve looks up the tokens of matches, each with a weight of 1.
+func (r *ExactRetriever) Retrieve(ctx context.Context, matches PossibleMatches) (*RetrievalResult, error) {
+	return r.lookup(weighted(matches.MetricNames), weighted(matches.LabelNames), weighted(matches.LabelValues)), nil
+}
+
+// lookup looks up weighted tokens, and records them in the result whether
+// they were found or not.
+func (r *ExactRetriever) lookup(metricTokens, labelTokens, valueTokens []WeightedToken) *RetrievalResult {
+	result := NewRetrievalResult()
+	result.MetricTokens = metricTokens
+	result.LabelTokens = labelTokens
+	result.ValueTokens = valueTokens
+
+	for _, t := range metricTokens {
+		for _, metric := range sortedSet(r.metricMap.Map[t.Token]) {
+			result.addMetric(metric)
+			result.Matches = append(result.Matches, t.tokenMatch(MatchMetric, metric))
+		}
+	}
+	for _, t := range labelTokens {
+		for _, label := range sortedSet(r.labelMap.Map[t.Token]) {
+			result.Labels[label] = llm.LabelContextDetail{}
+			result.Matches = append(result.Matches, t.tokenMatch(MatchLabel, label))
+		}
+	}
+	for _, t := range valueTokens {
+		// The value is indexed once per metric that has it.
+		matched := make(map[string]bool)
+		for _, ref := range r.valueIndex.Lookup(t.Token) {
+			result.Labels[ref.Label] = llm.LabelContextDetail{}
+			if !matched[ref.Label] {
+				matched[ref.Label] = true
+				result.Matches = append(result.Matches, t.tokenMatch(MatchValue, ref.Label))
+			}
+		}
+	}
+	result.attachLabels(r.metricLabelMap)
+	return result
+}
+
+// sortedSet returns the members of set in order.
+func sortedSet(set map[string]struct{}) []string {
+	members := make([]string, 0, len(set))
+	for member := range set {
+		members = append(members, member)
+	}
+	sort.Strings(members)
+	return members
+}
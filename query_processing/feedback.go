@@ -0,0 +1,217 @@
+package query_processing
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/prashantgupta17/nlpromql/info_structure"
+	"github.com/prashantgupta17/nlpromql/promql"
+)
+
+// recentQueries is how many queries the pipeline remembers the matches of,
+// so that feedback on them does not repeat query analysis.
+const recentQueries = 100
+
+// recentQuery is what retrieval found for a query.
+type recentQuery struct {
+	matches PossibleMatches
+	found   []TokenMatch
+}
+
+// Feedback is a user's verdict on a PromQL query generated for a natural
+// language query.
+type Feedback struct {
+	Query    string `json:"query"`
+	PromQL   string `json:"promql"`
+	Accepted bool   `json:"accepted"`
+}
+
+// FeedbackResult is what RecordFeedback updated.
+type FeedbackResult struct {
+	Records  []info_structure.HistoryRecord  `json:"records"`
+	Synonyms []info_structure.LearnedSynonym `json:"synonyms"` // Learned from an accepted query
+}
+
+// RecordFeedback records the metrics of a query in History, with the labels
+// used with them, against the tokens of the natural language query that found
+// them: a record for each metric token and label token pair. Later queries
+// with the same tokens get the metrics of accepted queries in their relevant
+// history. The tokens of an accepted query are also learned as synonyms of
+// its metrics and labels in LearnedSynonyms (see learnableSynonyms). It
+// returns the records and synonyms updated, in order.
+//
+// The tokens of queries the pipeline ran recently are remembered; other
+// queries are analysed again. A metric that no token found is recorded
+// against every metric token of the query, and a metric whose labels no
+// label token found against the metric tokens alone.
+func (p *Pipeline) RecordFeedback(ctx context.Context, feedback Feedback) (*FeedbackResult, error) {
+	expr, err := promql.Parse(feedback.PromQL)
+	if err != nil {
+		return nil, fmt.Errorf("error parsing feedback PromQL: %w", err)
+	}
+	metricLabels := promql.MetricLabels(expr)
+	if len(metricLabels) == 0 {
+		return nil, fmt.Errorf("feedback PromQL %q selects no metric", feedback.PromQL)
+	}
+
+	p.init()
+	recent, ok := p.recall(feedback.Query)
+	if !ok {
+		analysis, err := p.LLMClient.ProcessUserQuery(ctx, feedback.Query)
+		if err != nil {
+			return nil, fmt.Errorf("error processing user query via LLM: %w", err)
+		}
+		recent.matches = NewPossibleMatches(feedback.Query, analysis)
+		retrieved, err := p.retriever.Retrieve(ctx, recent.matches)
+		if err != nil {
+			return nil, err
+		}
+		recent.found = retrieved.Matches
+	}
+	if len(recent.matches.MetricNames) == 0 {
+		return nil, fmt.Errorf("no metric tokens in query %q to record feedback against", feedback.Query)
+	}
+
+	p.feedbackMu.Lock()
+	defer p.feedbackMu.Unlock()
+	now := time.Now()
+	updated := &FeedbackResult{}
+	metrics := make([]string, 0, len(metricLabels))
+	for metric := range metricLabels {
+		metrics = append(metrics, metric)
+	}
+	sort.Strings(metrics)
+	for _, metric := range metrics {
+		labels := metricLabels[metric]
+		metricTokens := foundBy(recent.found, recent.matches.MetricNames, func(m TokenMatch) bool {
+			return m.Kind == MatchMetric && m.Name == metric
+		})
+		if len(metricTokens) == 0 {
+			metricTokens = recent.matches.MetricNames
+		}
+		labelTokens := foundBy(recent.found, recent.matches.LabelNames, func(m TokenMatch) bool {
+			_, ok := labels[m.Name]
+			return m.Kind == MatchLabel && ok
+		})
+		if len(labelTokens) == 0 {
+			labelTokens = []string{""}
+		}
+
+		for _, metricToken := range metricTokens {
+			for _, labelToken := range labelTokens {
+				updated.Records = append(updated.Records, p.History.Record([]string{metricToken, labelToken}, metric, labels,
+					feedback.PromQL, feedback.Accepted, now))
+			}
+		}
+	}
+
+	if p.HistorySaver != nil {
+		if err := p.HistorySaver.SaveHistory(p.History); err != nil {
+			return nil, fmt.Errorf("error saving query history: %w", err)
+		}
+	}
+
+	if !feedback.Accepted {
+		return updated, nil
+	}
+	updated.Synonyms = p.learnSynonyms(feedback, recent, metricLabels, now)
+	if len(updated.Synonyms) > 0 && p.SynonymsSaver != nil {
+		if err := p.SynonymsSaver.SaveLearnedSynonyms(p.LearnedSynonyms); err != nil {
+			return nil, fmt.Errorf("error saving learned synonyms: %w", err)
+		}
+	}
+	return updated, nil
+}
+
+// learnSynonyms learns the metric and label tokens of an accepted query as
+// synonyms of the metrics and labels it used, leaving reverted synonyms
+// alone. It returns the synonyms learned.
+func (p *Pipeline) learnSynonyms(feedback Feedback, recent recentQuery, metricLabels map[string]map[string]string,
+	now time.Time) []info_structure.LearnedSynonym {
+	metrics := make(map[string]struct{}, len(metricLabels))
+	labels := make(map[string]struct{})
+	values := make(map[string]struct{})
+	for metric, metricValues := range metricLabels {
+		metrics[metric] = struct{}{}
+		for label, value := range metricValues {
+			labels[label] = struct{}{}
+			if value != "" {
+				values[strings.ToLower(value)] = struct{}{}
+			}
+		}
+	}
+	candidates := learnableSynonyms(info_structure.SynonymKindMetric, MatchMetric, recent.matches.MetricNames, recent.found, metrics, values)
+	candidates = append(candidates,
+		learnableSynonyms(info_structure.SynonymKindLabel, MatchLabel, recent.matches.LabelNames, recent.found, labels, values)...)
+
+	var learned []info_structure.LearnedSynonym
+	for _, c := range candidates {
+		if synonym, ok := p.LearnedSynonyms.Learn(c.kind, c.token, c.name, feedback.Query, feedback.PromQL, now); ok {
+			learned = append(learned, synonym)
+		}
+	}
+	return learned
+}
+
+// Synonyms returns the synonyms learned from feedback, reverted ones
+// included, with their current weights, for review.
+func (p *Pipeline) Synonyms() []info_structure.LearnedSynonym {
+	p.init()
+	return p.LearnedSynonyms.List(time.Now())
+}
+
+// RevertSynonym stops using a learned synonym, and learning it again, and
+// saves the learned synonyms.
+func (p *Pipeline) RevertSynonym(kind, token, name string) error {
+	p.init()
+	p.feedbackMu.Lock()
+	defer p.feedbackMu.Unlock()
+	if err := p.LearnedSynonyms.Revert(kind, token, name); err != nil {
+		return err
+	}
+	if p.SynonymsSaver != nil {
+		if err := p.SynonymsSaver.SaveLearnedSynonyms(p.LearnedSynonyms); err != nil {
+			return fmt.Errorf("error saving learned synonyms: %w", err)
+		}
+	}
+	return nil
+}
+
+// foundBy returns the tokens, in order, that found what match accepts.
+func foundBy(found []TokenMatch, tokens []string, match func(TokenMatch) bool) []string {
+	var result []string
+	for _, token := range tokens {
+		if slices.ContainsFunc(found, func(m TokenMatch) bool { return m.Token == token && match(m) }) {
+			result = append(result, token)
+		}
+	}
+	return result
+}
+
+// remember keeps the matches of a query for feedback on it, forgetting the
+// oldest query beyond recentQueries.
+func (p *Pipeline) remember(matches PossibleMatches, found []TokenMatch) {
+	p.recentMu.Lock()
+	defer p.recentMu.Unlock()
+	p.recent = slices.DeleteFunc(p.recent, func(q recentQuery) bool { return q.matches.Query == matches.Query })
+	p.recent = append(p.recent, recentQuery{matches: matches, found: found})
+	if len(p.recent) > recentQueries {
+		p.recent = p.recent[len(p.recent)-recentQueries:]
+	}
+}
+
+// recall returns the matches of a recent query.
+func (p *Pipeline) recall(query string) (recentQuery, bool) {
+	p.recentMu.Lock()
+	defer p.recentMu.Unlock()
+	for _, q := range p.recent {
+		if q.matches.Query == query {
+			return q, true
+		}
+	}
+	return recentQuery{}, false
+}
@@ -0,0 +1,236 @@
+package query_processing_test
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"testing"
+
+	"github.com/prashantgupta17/nlpromql/info_structure"
+	"github.com/prashantgupta17/nlpromql/llm"
+	"github.com/prashantgupta17/nlpromql/query_processing"
+)
+
+// MockHistorySaver_FeedbackTest keeps the records of the last history saved.
+type MockHistorySaver_FeedbackTest struct {
+	Saved []info_structure.HistoryRecord
+}
+
+func (m *MockHistorySaver_FeedbackTest) SaveHistory(history *info_structure.QueryHistory) error {
+	m.Saved = history.Records()
+	return nil
+}
+
+// summarize describes records as "metric tokens score labels".
+func summarize(records []info_structure.HistoryRecord) []string {
+	summary := make([]string, len(records))
+	for i, r := range records {
+		summary[i] = fmt.Sprintf("%s %v %d %v", r.Metric, r.Tokens, r.Score(), r.Labels)
+	}
+	return summary
+}
+
+func TestPipeline_RecordFeedback(t *testing.T) {
+	metricMap, labelMap, metricLabelMap, labelValueMap := retrievalMaps()
+	saver := &MockHistorySaver_FeedbackTest{}
+	pipeline := &query_processing.Pipeline{
+		LLMClient: &MockLLMClient_PipelineTest{
+			Analysis: &llm.QueryAnalysis{
+				MetricNames: []string{"latencies"},
+				LabelNames:  []string{"service", "pod"},
+			},
+			Candidates: []string{"up"},
+		},
+		MetricMap:      metricMap,
+		LabelMap:       labelMap,
+		MetricLabelMap: metricLabelMap,
+		LabelValueMap:  labelValueMap,
+		HistorySaver:   saver,
+	}
+	ctx := context.Background()
+	query := "api latencies by service"
+	if _, err := pipeline.Run(ctx, query, nil); err != nil {
+		t.Fatalf("Run returned an unexpected error: %v", err)
+	}
+
+	accepted := query_processing.Feedback{
+		Query:    query,
+		PromQL:   `sum by (job) (rate(api_latency_seconds{namespace="production"}[5m])) / sum(rate(http_requests_total[5m]))`,
+		Accepted: true,
+	}
+	updated, err := pipeline.RecordFeedback(ctx, accepted)
+	if err != nil {
+		t.Fatalf("RecordFeedback returned an unexpected error: %v", err)
+	}
+	// "latencies" found the first metric and "service" its job label; no
+	// token found the second metric, nor a label of it.
+	expected := []string{
+		"api_latency_seconds [latencies service] 1 map[job: namespace:production]",
+		"http_requests_total [latencies] 1 map[]",
+	}
+	if got := summarize(updated.Records); !reflect.DeepEqual(got, expected) {
+		t.Errorf("expected records %v, got %v", expected, got)
+	}
+	if _, err := pipeline.RecordFeedback(ctx, accepted); err != nil {
+		t.Fatalf("RecordFeedback returned an unexpected error: %v", err)
+	}
+	expected = []string{
+		"api_latency_seconds [latencies service] 2 map[job: namespace:production]",
+		"http_requests_total [latencies] 2 map[]",
+	}
+	if got := summarize(saver.Saved); !reflect.DeepEqual(got, expected) {
+		t.Errorf("expected history %v to be saved, got %v", expected, got)
+	}
+	if saved := saver.Saved[0]; saved.PromQL != accepted.PromQL || saved.Source != info_structure.HistorySourceFeedback ||
+		saved.FirstUsed.IsZero() || saved.LastUsed.Before(saved.FirstUsed) {
+		t.Errorf("expected the accepted query, source and times to be recorded, got %+v", saved)
+	}
+
+	// A later query with the same tokens gets the accepted metrics as history.
+	result, err := pipeline.Run(ctx, "latencies of each service", nil)
+	if err != nil {
+		t.Fatalf("Run returned an unexpected error: %v", err)
+	}
+	for _, metric := range []string{"api_latency_seconds", "http_requests_total"} {
+		if _, ok := result.RelevantHistory[metric]; !ok {
+			t.Errorf("expected %s in the relevant history, got %v", metric, result.RelevantHistory)
+		}
+	}
+
+	// Rejections take acceptances back, until the metric is no longer
+	// retrieved. The query was not run, so it is analysed again.
+	rejected := query_processing.Feedback{Query: "slow api", PromQL: `api_latency_seconds{job="api"}`}
+	for i := 0; i < 2; i++ {
+		if _, err := pipeline.RecordFeedback(ctx, rejected); err != nil {
+			t.Fatalf("RecordFeedback returned an unexpected error: %v", err)
+		}
+	}
+	if saved := saver.Saved[0]; saved.Accepted != 2 || saved.Rejected != 2 {
+		t.Errorf("expected 2 acceptances and 2 rejections, got %+v", saved)
+	}
+	result, err = pipeline.Run(ctx, "latencies of each service", nil)
+	if err != nil {
+		t.Fatalf("Run returned an unexpected error: %v", err)
+	}
+	if _, ok := result.RelevantHistory["api_latency_seconds"]; ok || len(result.RelevantHistory) != 1 {
+		t.Errorf("expected only http_requests_total in the relevant history, got %v", result.RelevantHistory)
+	}
+
+	for _, promQL := range []string{"sum(", `{job="api"}`} {
+		if _, err := pipeline.RecordFeedback(ctx, query_processing.Feedback{Query: query, PromQL: promQL, Accepted: true}); err == nil {
+			t.Errorf("expected an error for feedback on %q", promQL)
+		}
+	}
+}
+
+// MockSynonymsSaver_FeedbackTest counts the saves of learned synonyms.
+type MockSynonymsSaver_FeedbackTest struct {
+	Saves int
+}
+
+func (m *MockSynonymsSaver_FeedbackTest) SaveLearnedSynonyms(synonyms *info_structure.LearnedSynonyms) error {
+	m.Saves++
+	return nil
+}
+
+// describeSynonyms describes synonyms as "kind token name count".
+func describeSynonyms(synonyms []info_structure.LearnedSynonym) []string {
+	description := make([]string, len(synonyms))
+	for i, s := range synonyms {
+		description[i] = fmt.Sprintf("%s %s %s %d", s.Kind, s.Token, s.Name, s.Count)
+	}
+	return description
+}
+
+func TestPipeline_LearnSynonyms(t *testing.T) {
+	metricMap, labelMap, metricLabelMap, labelValueMap := retrievalMaps()
+	saver := &MockSynonymsSaver_FeedbackTest{}
+	pipeline := &query_processing.Pipeline{
+		LLMClient: &MockLLMClient_PipelineTest{
+			Analysis: &llm.QueryAnalysis{
+				MetricNames: []string{"latencies"},
+				LabelNames:  []string{"service", "env", "production"},
+			},
+			Candidates: []string{"up"},
+		},
+		MetricMap:      metricMap,
+		LabelMap:       labelMap,
+		MetricLabelMap: metricLabelMap,
+		LabelValueMap:  labelValueMap,
+		SynonymsSaver:  saver,
+	}
+	ctx := context.Background()
+	query := "api latencies by service in the production env"
+	if _, err := pipeline.Run(ctx, query, nil); err != nil {
+		t.Fatalf("Run returned an unexpected error: %v", err)
+	}
+
+	accepted := query_processing.Feedback{
+		Query:    query,
+		PromQL:   `sum by (job) (rate(api_latency_seconds{namespace="production"}[5m]))`,
+		Accepted: true,
+	}
+	updated, err := pipeline.RecordFeedback(ctx, accepted)
+	if err != nil {
+		t.Fatalf("RecordFeedback returned an unexpected error: %v", err)
+	}
+	// "latencies" found the metric approximately, and "env" is the only token
+	// left for the only label no token found. "service" is a synonym of job
+	// already, and "production" a value of the query.
+	expected := []string{"metric latencies api_latency_seconds 1", "label env namespace 1"}
+	if got := describeSynonyms(updated.Synonyms); !reflect.DeepEqual(got, expected) {
+		t.Errorf("expected synonyms %v to be learned, got %v", expected, got)
+	}
+	if learned := updated.Synonyms[0]; learned.Weight != 1 || learned.PromQL != accepted.PromQL ||
+		!reflect.DeepEqual(learned.Queries, []string{query}) {
+		t.Errorf("expected the synonym's weight and provenance, got %+v", learned)
+	}
+	if saver.Saves != 1 {
+		t.Errorf("expected the learned synonyms to be saved once, got %d", saver.Saves)
+	}
+	if updated, err := pipeline.RecordFeedback(ctx, query_processing.Feedback{Query: query, PromQL: accepted.PromQL}); err != nil || len(updated.Synonyms) != 0 {
+		t.Errorf("expected a rejection to learn nothing, got %+v, %v", updated, err)
+	}
+
+	// The learned synonyms find and score the label in later queries.
+	result, err := pipeline.Run(ctx, "latency per env", nil)
+	if err != nil {
+		t.Fatalf("Run returned an unexpected error: %v", err)
+	}
+	var learnedScore float64
+	for _, item := range result.Ranking.Labels {
+		if item.Name == "namespace" {
+			learnedScore = item.Breakdown.Learned
+		}
+	}
+	if learnedScore <= 0 {
+		t.Errorf("expected namespace to score for a learned synonym, got ranking %+v", result.Ranking.Labels)
+	}
+
+	// A reverted synonym is neither used nor learned again.
+	if err := pipeline.RevertSynonym(info_structure.SynonymKindLabel, "env", "namespace"); err != nil {
+		t.Fatalf("RevertSynonym returned an unexpected error: %v", err)
+	}
+	if err := pipeline.RevertSynonym(info_structure.SynonymKindLabel, "env", "job"); err == nil {
+		t.Errorf("expected an error reverting a synonym never learned")
+	}
+	updated, err = pipeline.RecordFeedback(ctx, accepted)
+	if err != nil {
+		t.Fatalf("RecordFeedback returned an unexpected error: %v", err)
+	}
+	if got := describeSynonyms(updated.Synonyms); !reflect.DeepEqual(got, []string{"metric latencies api_latency_seconds 2"}) {
+		t.Errorf("expected only the metric synonym to be learned again, got %v", got)
+	}
+	review := pipeline.Synonyms()
+	if got := describeSynonyms(review); !reflect.DeepEqual(got, []string{"label env namespace 1", "metric latencies api_latency_seconds 2"}) ||
+		!review[0].Reverted {
+		t.Errorf("expected both synonyms for review, the label's reverted, got %+v", review)
+	}
+	result, err = pipeline.Run(ctx, "latency per env", nil)
+	if err != nil {
+		t.Fatalf("Run returned an unexpected error: %v", err)
+	}
+	if _, ok := result.RelevantLabels["namespace"]; ok {
+		t.Errorf("expected the reverted synonym not to find namespace, got %v", result.RelevantLabels)
+	}
+}
@@ -0,0 +1,72 @@
+package query_processing
+
+import (
+	"context"
+	"sort"
+
+	"github.com/prashantgupta17/nlpromql/info_structure"
+	"github.com/prashantgupta17/nlpromql/matching"
+)
+
+// FuzzyRetriever matches the tokens extracted by the LLM approximately
+// against the keys of the information structure maps, so that retrieval
+// finds "latencies", "mem" or "namespcae" even though the maps only hold
+// "latency", "memory" and "namespace". The keys matched are looked up by an
+// ExactRetriever, weighted by the score of the match. Tokens that are keys
+// themselves are left to the ExactRetriever.
+type FuzzyRetriever struct {
+	exact   *ExactRetriever
+	metrics *matching.Index // Keys of MetricMap
+	labels  *matching.Index // Keys of LabelMap
+	values  *matching.Index // Values of LabelValueMap
+}
+
+// NewFuzzyRetriever indexes the keys of the maps exact looks tokens up in,
+// and the values of labelValueMap, in sorted order so that lookups are
+// repeatable.
+func NewFuzzyRetriever(exact *ExactRetriever, labelValueMap info_structure.LabelValueMap) *FuzzyRetriever {
+	metricTokens := make([]string, 0, len(exact.metricMap.Map))
+	for token := range exact.metricMap.Map {
+		metricTokens = append(metricTokens, token)
+	}
+	labelTokens := make([]string, 0, len(exact.labelMap.Map))
+	for token := range exact.labelMap.Map {
+		labelTokens = append(labelTokens, token)
+	}
+	var values []string
+	for _, info := range labelValueMap {
+		for value := range info.Values {
+			values = append(values, value)
+		}
+	}
+	sort.Strings(metricTokens)
+	sort.Strings(labelTokens)
+	sort.Strings(values)
+	return &FuzzyRetriever{
+		exact:   exact,
+		metrics: matching.NewIndex(metricTokens),
+		labels:  matching.NewIndex(labelTokens),
+		values:  matching.NewIndex(values),
+	}
+}
+
+// Retrieve looks up the keys approximately matched by the tokens of matches.
+func (r *FuzzyRetriever) Retrieve(ctx context.Context, matches PossibleMatches) (*RetrievalResult, error) {
+	return r.exact.lookup(approximate(r.metrics, matches.MetricNames), approximate(r.labels, matches.LabelNames),
+		approximate(r.values, matches.LabelValues)), nil
+}
+
+// approximate returns the keys of index that tokens match approximately,
+// weighted by the score of their best match.
+func approximate(index *matching.Index, tokens []string) []WeightedToken {
+	var keys []WeightedToken
+	for _, token := range tokens {
+		for _, m := range index.Lookup(token) {
+			if m.Kind == matching.KindExact {
+				continue
+			}
+			keys = mergeTokens(keys, []WeightedToken{{Token: m.Key, Weight: m.Score, From: token, Match: m.Kind}})
+		}
+	}
+	return keys
+}
@@ -0,0 +1,42 @@
+package query_processing
+
+import (
+	"context"
+
+	"github.com/prashantgupta17/nlpromql/info_structure"
+)
+
+// HistoryRetriever finds the metrics of previously accepted queries: the
+// records of History whose tokens are all among the metric and label tokens
+// of the query. Each metric found is reported with its summed score and the
+// labels used with it, e.g.
+// {"api_latency_seconds": {"score": 2, "labels": {"job": "api"}}}.
+type HistoryRetriever struct {
+	History *info_structure.QueryHistory // May be nil
+}
+
+// Retrieve returns the history of matches in RetrievalResult.History.
+func (r *HistoryRetriever) Retrieve(ctx context.Context, matches PossibleMatches) (*RetrievalResult, error) {
+	result := NewRetrievalResult()
+	if r.History == nil {
+		return result, nil
+	}
+	tokens := append(append([]string(nil), matches.MetricNames...), matches.LabelNames...)
+	scores := make(map[string]int)
+	labels := make(map[string]map[string]string)
+	for _, record := range r.History.Lookup(tokens) {
+		scores[record.Metric] += record.Score()
+		if labels[record.Metric] == nil {
+			labels[record.Metric] = make(map[string]string)
+		}
+		for label, value := range record.Labels {
+			if value != "" || labels[record.Metric][label] == "" {
+				labels[record.Metric][label] = value
+			}
+		}
+	}
+	for metric, score := range scores {
+		result.History[metric] = map[string]interface{}{"score": score, "labels": labels[metric]}
+	}
+	return result, nil
+}
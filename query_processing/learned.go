@@ -0,0 +1,148 @@
+package query_processing
+
+import (
+	"context"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/prashantgupta17/nlpromql/info_structure"
+	"github.com/prashantgupta17/nlpromql/llm"
+	"github.com/prashantgupta17/nlpromql/matching"
+)
+
+// MatchLearned is the TokenMatch.Match of metrics and labels found by a
+// synonym learned from feedback. Its Weight is the token's weight times the
+// decayed weight of the synonym.
+const MatchLearned matching.Kind = "learned"
+
+// LearnedRetriever looks the metric and label tokens of the possible matches
+// up in the synonyms learned from accepted feedback, as MetricMap and
+// LabelMap do for the synonyms generated at build time. Learned synonyms of
+// metrics and labels no longer in the maps are ignored.
+//
+// Learned synonyms are kept out of MetricMap and LabelMap, since they change
+// with feedback; other retrievers, such as FuzzyRetriever, do not see them.
+type LearnedRetriever struct {
+	synonyms  *info_structure.LearnedSynonyms
+	metricMap info_structure.MetricMap
+	labelMap  info_structure.LabelMap
+}
+
+// NewLearnedRetriever returns a LearnedRetriever of synonyms, which may be
+// nil, over the metrics and labels of a built information structure.
+func NewLearnedRetriever(synonyms *info_structure.LearnedSynonyms, metricMap info_structure.MetricMap,
+	labelMap info_structure.LabelMap) *LearnedRetriever {
+	return &LearnedRetriever{synonyms: synonyms, metricMap: metricMap, labelMap: labelMap}
+}
+
+// Retrieve returns the metrics and labels the tokens of matches are learned
+// synonyms of, recording each in RetrievalResult.Matches.
+func (r *LearnedRetriever) Retrieve(ctx context.Context, matches PossibleMatches) (*RetrievalResult, error) {
+	result := NewRetrievalResult()
+	if r.synonyms == nil {
+		return result, nil
+	}
+	now := time.Now()
+	for _, token := range matches.MetricNames {
+		for _, metric := range r.lookup(info_structure.SynonymKindMetric, token, r.metricMap.AllNames, now) {
+			result.addMetric(metric.name)
+			result.Matches = append(result.Matches, metric.match(token, MatchMetric))
+		}
+	}
+	for _, token := range matches.LabelNames {
+		for _, label := range r.lookup(info_structure.SynonymKindLabel, token, r.labelMap.AllNames, now) {
+			result.Labels[label.name] = llm.LabelContextDetail{}
+			result.Matches = append(result.Matches, label.match(token, MatchLabel))
+		}
+	}
+	return result, nil
+}
+
+// learnedName is a name a token is a learned synonym of.
+type learnedName struct {
+	name   string
+	weight float64
+}
+
+func (n learnedName) match(token, kind string) TokenMatch {
+	return TokenMatch{Token: token, Key: token, Match: MatchLearned, Weight: n.weight, Kind: kind, Name: n.name}
+}
+
+// lookup returns the names of known that token is a learned synonym of, in
+// order.
+func (r *LearnedRetriever) lookup(kind, token string, known map[string]struct{}, now time.Time) []learnedName {
+	var names []learnedName
+	for name, weight := range r.synonyms.Lookup(kind, token, now) {
+		if _, ok := known[name]; ok {
+			names = append(names, learnedName{name: name, weight: weight})
+		}
+	}
+	sort.Slice(names, func(i, j int) bool { return names[i].name < names[j].name })
+	return names
+}
+
+// synonymCandidate is a query token to learn as a synonym of a name.
+type synonymCandidate struct {
+	kind, token, name string
+}
+
+// learnableSynonyms returns the query tokens of kind that an accepted query
+// using names (metrics or labels) teaches as synonyms of them. Tokens that
+// found any of names exactly are synonyms already, and the label values of
+// the accepted query are not synonyms.
+//
+// A token that found names approximately (by a fuzzy or learned match) is
+// learned for each of them. A token that found none of names is learned for
+// the one name that no token found, if there is exactly one (semantic search
+// does not count): in "checkout latency", answered with
+// checkout_duration_seconds, "latency" is learned for it when nothing else
+// found it.
+func learnableSynonyms(kind, matchKind string, tokens []string, found []TokenMatch, names, values map[string]struct{}) []synonymCandidate {
+	var candidates, unmatched []synonymCandidate
+	foundNames := make(map[string]bool)
+	for _, m := range found {
+		if _, ok := names[m.Name]; ok && m.Kind == matchKind && m.Match != MatchEmbedding {
+			foundNames[m.Name] = true
+		}
+	}
+	for _, token := range tokens {
+		if _, ok := values[strings.ToLower(token)]; ok {
+			continue
+		}
+		exact, approximate := false, make(map[string]struct{})
+		for _, m := range found {
+			if _, ok := names[m.Name]; !ok || m.Kind != matchKind || m.Token != token {
+				continue
+			}
+			if m.Match == matching.KindExact {
+				exact = true
+			} else if m.Match != MatchEmbedding {
+				approximate[m.Name] = struct{}{}
+			}
+		}
+		if exact {
+			continue
+		}
+		for _, name := range sortedSet(approximate) {
+			candidates = append(candidates, synonymCandidate{kind: kind, token: token, name: name})
+		}
+		if len(approximate) == 0 {
+			unmatched = append(unmatched, synonymCandidate{kind: kind, token: token})
+		}
+	}
+
+	var missing []string
+	for _, name := range sortedSet(names) {
+		if !foundNames[name] {
+			missing = append(missing, name)
+		}
+	}
+	if len(missing) == 1 {
+		for _, c := range unmatched {
+			c.name = missing[0]
+			candidates = append(candidates, c)
+		}
+	}
+	return candidates
+}
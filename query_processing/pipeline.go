@@ -0,0 +1,233 @@
+package query_processing
+
+import (
+	"context"
+	"fmt"
+	"sync"
+
+	"github.com/prashantgupta17/nlpromql/info_structure"
+	"github.com/prashantgupta17/nlpromql/llm"
+	"github.com/prashantgupta17/nlpromql/vectorindex"
+	"github.com/tmc/langchaingo/embeddings"
+)
+
+// Stages reported to a ProgressFunc, in the order they complete.
+const (
+	// StageQueryAnalysis carries the possible matches extracted by the LLM,
+	// including the query intent (*llm.QueryAnalysis).
+	StageQueryAnalysis = "query_analysis"
+	// StageRetrieval carries the metrics and labels found in the info structure (RetrievalSummary).
+	StageRetrieval = "retrieval"
+	// StageGeneration carries a chunk of the PromQL generation response as it streams in (string).
+	StageGeneration = "generation"
+	// StageGenerationReset tells that the generation chunks received so far
+	// are to be discarded, as another response follows; it carries why (string).
+	StageGenerationReset = "generation_reset"
+	// StageCandidates carries the final candidates, after any repair (*llm.PromQLResult).
+	StageCandidates = "candidates"
+)
+
+// ProgressFunc is called as each pipeline stage completes. The type of data
+// depends on the stage; see the Stage constants.
+type ProgressFunc func(stage string, data interface{})
+
+// RetrievalSummary lists the names of the metrics and labels retrieved for a
+// query, most relevant first.
+type RetrievalSummary struct {
+	Metrics []string `json:"metrics"`
+	Labels  []string `json:"labels"`
+}
+
+// Pipeline runs the full natural language to PromQL flow against a built
+// information structure: query analysis, retrieval, generation (optionally of
+// specs that are compiled to PromQL) and, if MaxRepairAttempts is set, repair
+// of invalid candidates.
+type Pipeline struct {
+	LLMClient      llm.LLMClient
+	MetricMap      info_structure.MetricMap
+	LabelMap       info_structure.LabelMap
+	MetricLabelMap info_structure.MetricLabelMap
+	LabelValueMap  info_structure.LabelValueMap
+	History        *info_structure.QueryHistory // Recorded by RecordFeedback; created empty when nil
+	ValueIndex     *info_structure.ValueIndex   // Built from MetricLabelMap when nil
+	MetricHelp     map[string]string            // HELP text of each metric, used for relevance scoring; may be nil
+	Prices         llm.PriceTable               // Used to estimate the cost of each request; may be nil
+	// LearnedSynonyms are learned by RecordFeedback and looked up alongside
+	// MetricMap and LabelMap; created empty when nil.
+	LearnedSynonyms *info_structure.LearnedSynonyms
+	// MaxRepairAttempts is how many times an invalid candidate is sent back
+	// to the LLM for a fix. 0 disables validation.
+	MaxRepairAttempts int
+	// QueryEngine, if not nil, runs candidates against Prometheus during
+	// validation, in addition to the local parser.
+	QueryEngine info_structure.QueryEngine
+	// Embedder and VectorIndex, if both set, add the metrics and labels most
+	// similar to the query to those found by token lookup (see
+	// EmbeddingRetriever).
+	Embedder         embeddings.Embedder
+	VectorIndex      *vectorindex.Index
+	SemanticTopK     int     // Metrics and labels each; DefaultSemanticTopK when 0
+	SemanticMinScore float64 // Minimum cosine similarity; DefaultSemanticMinScore when 0
+	// SpecGeneration asks the LLM for promql.QuerySpecs instead of PromQL and
+	// compiles them against MetricLabelMap, so candidates cannot have syntax
+	// errors or use unknown metrics and labels.
+	SpecGeneration bool
+	// ValueLimit is how many values of each retrieved label are sent to
+	// generation, besides the values the user named; DefaultValueLimit when
+	// 0. ValueLimits overrides it for individual labels.
+	ValueLimit  int
+	ValueLimits map[string]int
+	// Retriever finds the metrics and labels relevant to each query. When
+	// nil, the exact, learned, fuzzy, embedding and history retrievers over
+	// the maps above are merged.
+	Retriever Retriever
+
+	// HistorySaver and SynonymsSaver, if not nil, save History and
+	// LearnedSynonyms each time feedback updates them.
+	HistorySaver  info_structure.HistorySaver
+	SynonymsSaver info_structure.LearnedSynonymsSaver
+
+	indexOnce sync.Once // Builds retriever, scorer, values and any missing ValueIndex, History and LearnedSynonyms on first use
+	retriever Retriever
+	scorer    *relevanceScorer
+	values    *valueSelector
+
+	feedbackMu sync.Mutex // Serializes feedback, and the saves of its history
+	recentMu   sync.Mutex
+	recent     []recentQuery // Matches of the latest queries, oldest first
+}
+
+// Result holds everything the pipeline produced for a query.
+type Result struct {
+	Analysis        *llm.QueryAnalysis // Possible matches and intent extracted by the LLM
+	Intent          *llm.QueryIntent   // nil if the LLM returned no valid intent
+	Ranking         Ranking            // Retrieved metrics and labels with their scores, most relevant first
+	RelevantMetrics llm.RelevantMetricsMap
+	RelevantLabels  llm.RelevantLabelsMap
+	RelevantHistory map[string]interface{}
+	PromQL          *llm.PromQLResult
+	Usage           llm.UsageReport // LLM calls made for this query
+	// Matches and Prompts explain the result: how each retrieved metric and
+	// label was found, and the prompts sent to the LLM, in order.
+	Matches []TokenMatch
+	Prompts []llm.PromptRecord
+}
+
+// Run processes userQuery and generates PromQL candidates for it. If progress
+// is not nil it is called after each stage, and the generation response is
+// streamed to it chunk by chunk.
+func (p *Pipeline) Run(ctx context.Context, userQuery string, progress ProgressFunc) (*Result, error) {
+	if progress == nil {
+		progress = func(string, interface{}) {}
+	}
+	usage := llm.NewUsageRecorder(p.Prices)
+	ctx = llm.WithUsageRecorder(ctx, usage)
+	prompts := llm.NewPromptRecorder()
+	ctx = llm.WithPromptRecorder(ctx, prompts)
+
+	analysis, err := p.LLMClient.ProcessUserQuery(ctx, userQuery)
+	if err != nil {
+		return nil, fmt.Errorf("error processing user query via LLM: %w", err)
+	}
+	if analysis == nil {
+		analysis = &llm.QueryAnalysis{}
+	}
+	progress(StageQueryAnalysis, analysis)
+	intent := analysis.Intent
+
+	p.init()
+	matches := NewPossibleMatches(userQuery, analysis)
+	retrieved, err := p.retriever.Retrieve(ctx, matches)
+	if err != nil {
+		return nil, err
+	}
+	p.remember(matches, retrieved.Matches)
+	ranking := p.scorer.rank(retrieved)
+	p.values.apply(newQueryValues(matches, retrieved), retrieved.Metrics, retrieved.Labels)
+	progress(StageRetrieval, summarizeRetrieval(ranking))
+
+	generationCtx := llm.WithStreamingFunc(ctx, func(_ context.Context, chunk []byte) error {
+		progress(StageGeneration, string(chunk))
+		return nil
+	})
+	generationCtx = llm.WithStreamResetFunc(generationCtx, func(_ context.Context, reason string) {
+		progress(StageGenerationReset, reason)
+	})
+	if p.SpecGeneration {
+		generationCtx = llm.WithSpecGeneration(generationCtx)
+	}
+	promqlResult, err := p.LLMClient.GetPromQLFromLLM(generationCtx, userQuery, retrieved.Metrics, retrieved.Labels, retrieved.History, intent, ranking.MetricScores())
+	if err != nil {
+		return nil, fmt.Errorf("error generating PromQL: %w", err)
+	}
+	if p.SpecGeneration {
+		p.compileSpecs(promqlResult)
+	}
+	if p.MaxRepairAttempts > 0 {
+		p.repairCandidates(ctx, userQuery, promqlResult)
+	}
+	progress(StageCandidates, promqlResult)
+
+	return &Result{
+		Analysis:        analysis,
+		Intent:          intent,
+		Ranking:         ranking,
+		RelevantMetrics: retrieved.Metrics,
+		RelevantLabels:  retrieved.Labels,
+		RelevantHistory: retrieved.History,
+		PromQL:          promqlResult,
+		Usage:           usage.Report(),
+		Matches:         retrieved.Matches,
+		Prompts:         prompts.Records(),
+	}, nil
+}
+
+// init builds the retriever, scorer, value selector and any missing
+// ValueIndex, History and LearnedSynonyms of p, once.
+func (p *Pipeline) init() {
+	p.indexOnce.Do(func() {
+		if p.ValueIndex == nil {
+			valueIndex := info_structure.BuildValueIndex(p.MetricLabelMap)
+			p.ValueIndex = &valueIndex
+		}
+		if p.History == nil {
+			p.History = info_structure.NewQueryHistory()
+		}
+		if p.LearnedSynonyms == nil {
+			p.LearnedSynonyms = info_structure.NewLearnedSynonyms()
+		}
+		p.retriever = p.Retriever
+		if p.retriever == nil {
+			p.retriever = p.defaultRetriever()
+		}
+		p.scorer = newRelevanceScorer(p.MetricMap, p.LabelMap, p.MetricLabelMap, p.LabelValueMap, *p.ValueIndex, p.MetricHelp)
+		p.values = newValueSelector(p.MetricLabelMap, p.LabelValueMap, p.ValueLimit, p.ValueLimits)
+	})
+}
+
+// defaultRetriever merges the exact, learned, fuzzy, embedding and history
+// retrievers over the maps of p.
+func (p *Pipeline) defaultRetriever() Retriever {
+	exact := NewExactRetriever(p.MetricMap, p.LabelMap, p.MetricLabelMap, *p.ValueIndex)
+	return NewMerger(p.MetricLabelMap,
+		exact,
+		NewLearnedRetriever(p.LearnedSynonyms, p.MetricMap, p.LabelMap),
+		NewFuzzyRetriever(exact, p.LabelValueMap),
+		&EmbeddingRetriever{Embedder: p.Embedder, Index: p.VectorIndex, TopK: p.SemanticTopK, MinScore: p.SemanticMinScore},
+		&HistoryRetriever{History: p.History},
+	)
+}
+
+func summarizeRetrieval(ranking Ranking) RetrievalSummary {
+	summary := RetrievalSummary{
+		Metrics: make([]string, 0, len(ranking.Metrics)),
+		Labels:  make([]string, 0, len(ranking.Labels)),
+	}
+	for _, item := range ranking.Metrics {
+		summary.Metrics = append(summary.Metrics, item.Name)
+	}
+	for _, item := range ranking.Labels {
+		summary.Labels = append(summary.Labels, item.Name)
+	}
+	return summary
+}
@@ -0,0 +1,683 @@
+package query_processing_test
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"reflect"
+	"sync"
+	"testing"
+
+	"github.com/prashantgupta17/nlpromql/info_structure"
+	"github.com/prashantgupta17/nlpromql/llm"
+	"github.com/prashantgupta17/nlpromql/matching"
+	"github.com/prashantgupta17/nlpromql/prometheus"
+	"github.com/prashantgupta17/nlpromql/promql"
+	"github.com/prashantgupta17/nlpromql/query_processing"
+	"github.com/prashantgupta17/nlpromql/vectorindex"
+)
+
+// MockLLMClient_PipelineTest returns Analysis from query analysis,
+// generates Candidates, or Specs when asked for specs, and repairs queries by
+// looking them up in Repairs.
+type MockLLMClient_PipelineTest struct {
+	Analysis   *llm.QueryAnalysis
+	Candidates []string
+	Specs      []promql.QuerySpec
+	Repairs    map[string]string
+
+	mu             sync.Mutex
+	RepairRequests []string         // "promql: error" of every repair request
+	Intent         *llm.QueryIntent // Intent passed to the last GetPromQLFromLLM call
+}
+
+func (m *MockLLMClient_PipelineTest) GetMetricSynonyms(ctx context.Context, metricBatches []map[string]string) (map[string][]string, error) {
+	return map[string][]string{}, nil
+}
+
+func (m *MockLLMClient_PipelineTest) GetLabelSynonyms(ctx context.Context, labelBatches [][]string) (map[string][]string, error) {
+	return map[string][]string{}, nil
+}
+
+func (m *MockLLMClient_PipelineTest) ProcessUserQuery(ctx context.Context, userQuery string) (*llm.QueryAnalysis, error) {
+	if m.Analysis != nil {
+		return m.Analysis, nil
+	}
+	return &llm.QueryAnalysis{}, nil
+}
+
+func (m *MockLLMClient_PipelineTest) GetPromQLFromLLM(ctx context.Context, userQuery string, relevantMetrics llm.RelevantMetricsMap, relevantLabels llm.RelevantLabelsMap, relevantHistory map[string]interface{}, intent *llm.QueryIntent, metricScores llm.MetricScores) (*llm.PromQLResult, error) {
+	m.mu.Lock()
+	m.Intent = intent
+	m.mu.Unlock()
+	llm.RecordPrompt(ctx, llm.PromptRecord{
+		Operation: llm.OperationPromQLGeneration,
+		Messages:  []llm.PromptMessage{{Role: "human", Content: userQuery}},
+	})
+	result := &llm.PromQLResult{}
+	if llm.SpecGeneration(ctx) {
+		for i := range m.Specs {
+			result.Candidates = append(result.Candidates, llm.PromQLCandidate{Spec: &m.Specs[i], Score: 1})
+		}
+		return result, nil
+	}
+	for _, query := range m.Candidates {
+		result.Candidates = append(result.Candidates, llm.PromQLCandidate{PromQL: query, Score: 1})
+	}
+	return result, nil
+}
+
+func (m *MockLLMClient_PipelineTest) RepairPromQL(ctx context.Context, userQuery, promql, validationError string) (string, error) {
+	m.mu.Lock()
+	m.RepairRequests = append(m.RepairRequests, promql+": "+validationError)
+	m.mu.Unlock()
+	if repaired, ok := m.Repairs[promql]; ok {
+		return repaired, nil
+	}
+	return "", errors.New("no repair")
+}
+
+// MockQueryEngine_PipelineTest rejects the queries in Rejected with a
+// Prometheus API error and fails every call while Err is set.
+type MockQueryEngine_PipelineTest struct {
+	Rejected map[string]string
+	Err      error
+}
+
+func (m *MockQueryEngine_PipelineTest) AllMetrics() ([]string, error)           { return nil, nil }
+func (m *MockQueryEngine_PipelineTest) AllLabels() ([]string, error)            { return nil, nil }
+func (m *MockQueryEngine_PipelineTest) AllMetadata() (map[string]string, error) { return nil, nil }
+
+func (m *MockQueryEngine_PipelineTest) CustomQuery(query string) ([]prometheus.Metric, error) {
+	if m.Err != nil {
+		return nil, m.Err
+	}
+	if message, ok := m.Rejected[query]; ok {
+		return nil, &prometheus.APIError{Type: "execution", Message: message}
+	}
+	return nil, nil
+}
+
+func TestPipeline_RepairCandidates(t *testing.T) {
+	tests := []struct {
+		name              string
+		candidates        []string
+		repairs           map[string]string
+		queryEngine       *MockQueryEngine_PipelineTest
+		maxRepairAttempts int
+		expected          []llm.PromQLCandidate
+		expectedRepairs   int
+	}{
+		{
+			name:              "Parse error repaired",
+			candidates:        []string{"rate(x)", "up"},
+			repairs:           map[string]string{"rate(x)": "rate(x[5m])"},
+			maxRepairAttempts: 2,
+			expected: []llm.PromQLCandidate{
+				{PromQL: "rate(x[5m])", Score: 1, Attempts: []llm.RepairAttempt{
+					{PromQL: "rate(x)", Error: `1:1: parse error: expected type range vector in call to function "rate", got instant vector`},
+				}},
+				{PromQL: "up", Score: 1},
+			},
+			expectedRepairs: 1,
+		},
+		{
+			name:              "Prometheus error repaired over two attempts",
+			candidates:        []string{"sum(x) / y"},
+			repairs:           map[string]string{"sum(x) / y": "sum(x) / z", "sum(x) / z": "sum(x) / sum(z)"},
+			queryEngine:       &MockQueryEngine_PipelineTest{Rejected: map[string]string{"sum(x) / y": "no y", "sum(x) / z": "no z"}},
+			maxRepairAttempts: 3,
+			expected: []llm.PromQLCandidate{
+				{PromQL: "sum(x) / sum(z)", Score: 1, Attempts: []llm.RepairAttempt{
+					{PromQL: "sum(x) / y", Error: "no y"},
+					{PromQL: "sum(x) / z", Error: "no z"},
+				}},
+			},
+			expectedRepairs: 2,
+		},
+		{
+			name:              "Gives up after the last attempt",
+			candidates:        []string{"rate(x)"},
+			repairs:           map[string]string{"rate(x)": "rate(y)", "rate(y)": "rate(z)"},
+			maxRepairAttempts: 1,
+			expected: []llm.PromQLCandidate{
+				{PromQL: "rate(y)", Score: 1,
+					Attempts: []llm.RepairAttempt{
+						{PromQL: "rate(x)", Error: `1:1: parse error: expected type range vector in call to function "rate", got instant vector`},
+					},
+					ValidationError: `1:1: parse error: expected type range vector in call to function "rate", got instant vector`,
+				},
+			},
+			expectedRepairs: 1,
+		},
+		{
+			name:              "Unreachable Prometheus does not invalidate",
+			candidates:        []string{"up"},
+			queryEngine:       &MockQueryEngine_PipelineTest{Err: errors.New("connection refused")},
+			maxRepairAttempts: 1,
+			expected:          []llm.PromQLCandidate{{PromQL: "up", Score: 1}},
+		},
+		{
+			name:              "Disabled",
+			candidates:        []string{"rate(x)"},
+			maxRepairAttempts: 0,
+			expected:          []llm.PromQLCandidate{{PromQL: "rate(x)", Score: 1}},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			client := &MockLLMClient_PipelineTest{Candidates: tt.candidates, Repairs: tt.repairs}
+			pipeline := &query_processing.Pipeline{LLMClient: client, MaxRepairAttempts: tt.maxRepairAttempts}
+			if tt.queryEngine != nil {
+				pipeline.QueryEngine = tt.queryEngine
+			}
+
+			result, err := pipeline.Run(context.Background(), "query", nil)
+			if err != nil {
+				t.Fatalf("Run returned an unexpected error: %v", err)
+			}
+			if len(client.RepairRequests) != tt.expectedRepairs {
+				t.Errorf("expected %d repair requests, got %d: %v", tt.expectedRepairs, len(client.RepairRequests), client.RepairRequests)
+			}
+			candidates := result.PromQL.Candidates
+			if len(candidates) != len(tt.expected) {
+				t.Fatalf("expected %d candidates, got %d", len(tt.expected), len(candidates))
+			}
+			for i, expected := range tt.expected {
+				got := candidates[i]
+				if got.PromQL != expected.PromQL || got.ValidationError != expected.ValidationError {
+					t.Errorf("candidate %d: expected %q (validation error %q), got %q (validation error %q)",
+						i, expected.PromQL, expected.ValidationError, got.PromQL, got.ValidationError)
+				}
+				if len(got.Attempts) != len(expected.Attempts) {
+					t.Fatalf("candidate %d: expected attempts %v, got %v", i, expected.Attempts, got.Attempts)
+				}
+				for j := range expected.Attempts {
+					if got.Attempts[j] != expected.Attempts[j] {
+						t.Errorf("candidate %d attempt %d: expected %v, got %v", i, j, expected.Attempts[j], got.Attempts[j])
+					}
+				}
+			}
+		})
+	}
+}
+
+// MockEmbedder_PipelineTest embeds every query as Vector.
+type MockEmbedder_PipelineTest struct {
+	Vector []float32
+	Err    error
+}
+
+func (m *MockEmbedder_PipelineTest) EmbedDocuments(ctx context.Context, texts []string) ([][]float32, error) {
+	return nil, errors.New("not implemented")
+}
+
+func (m *MockEmbedder_PipelineTest) EmbedQuery(ctx context.Context, text string) ([]float32, error) {
+	return m.Vector, m.Err
+}
+
+func TestPipeline_SemanticRetrieval(t *testing.T) {
+	index := vectorindex.New("test-model")
+	index.Add(
+		vectorindex.Entry{Kind: vectorindex.KindMetric, Name: "node_memory_MemAvailable_bytes", Vector: []float32{1, 0.1, 0}},
+		vectorindex.Entry{Kind: vectorindex.KindMetric, Name: "node_cpu_seconds_total", Vector: []float32{0, 0, 1}},
+		vectorindex.Entry{Kind: vectorindex.KindLabel, Name: "instance", Vector: []float32{0.9, 0.5, 0}},
+		vectorindex.Entry{Kind: vectorindex.KindLabel, Name: "mode", Vector: []float32{0, 0.1, 1}},
+	)
+	metricLabelMap := info_structure.MetricLabelMap{
+		"node_memory_MemAvailable_bytes": {Labels: map[string]info_structure.LabelInfo{
+			"instance": {Values: map[string]struct{}{"host1:9100": {}}},
+		}},
+	}
+	labelValueMap := info_structure.LabelValueMap{
+		"instance": {Values: map[string]struct{}{"host1:9100": {}, "host2:9100": {}}},
+	}
+
+	tests := []struct {
+		name            string
+		embedder        *MockEmbedder_PipelineTest
+		expectedMetrics llm.RelevantMetricsMap
+		expectedLabels  []string
+	}{
+		{
+			name:     "Similar metrics and labels added",
+			embedder: &MockEmbedder_PipelineTest{Vector: []float32{1, 0, 0}},
+			expectedMetrics: llm.RelevantMetricsMap{
+				"node_memory_MemAvailable_bytes": {"instance": {Values: []string{"host1:9100"}}},
+			},
+			expectedLabels: []string{"instance"},
+		},
+		{
+			name:            "Embedding error leaves retrieval unchanged",
+			embedder:        &MockEmbedder_PipelineTest{Err: errors.New("rate limited")},
+			expectedMetrics: llm.RelevantMetricsMap{},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			pipeline := &query_processing.Pipeline{
+				LLMClient:        &MockLLMClient_PipelineTest{Candidates: []string{"up"}},
+				MetricLabelMap:   metricLabelMap,
+				LabelValueMap:    labelValueMap,
+				Embedder:         tt.embedder,
+				VectorIndex:      index,
+				SemanticTopK:     2,
+				SemanticMinScore: 0.5,
+			}
+
+			result, err := pipeline.Run(context.Background(), "free memory per host", nil)
+			if err != nil {
+				t.Fatalf("Run returned an unexpected error: %v", err)
+			}
+			if len(result.RelevantMetrics) != len(tt.expectedMetrics) {
+				t.Fatalf("expected relevant metrics %v, got %v", tt.expectedMetrics, result.RelevantMetrics)
+			}
+			for metric, labels := range tt.expectedMetrics {
+				got, ok := result.RelevantMetrics[metric]
+				if !ok || len(got) != len(labels) {
+					t.Fatalf("expected metric %s with labels %v, got %v", metric, labels, got)
+				}
+				for label, detail := range labels {
+					if len(got[label].Values) != len(detail.Values) || got[label].Values[0] != detail.Values[0] || got[label].MatchScore < 0.5 {
+						t.Errorf("metric %s label %s: expected values %v with a score of at least 0.5, got %+v", metric, label, detail.Values, got[label])
+					}
+				}
+			}
+			if len(result.RelevantLabels) != len(tt.expectedLabels) {
+				t.Fatalf("expected relevant labels %v, got %v", tt.expectedLabels, result.RelevantLabels)
+			}
+			for _, label := range tt.expectedLabels {
+				if detail, ok := result.RelevantLabels[label]; !ok || len(detail.Values) != 2 {
+					t.Errorf("expected label %s with its 2 values, got %+v", label, detail)
+				}
+			}
+		})
+	}
+}
+
+func TestPipeline_QueryIntent(t *testing.T) {
+	intent := &llm.QueryIntent{
+		TimeRange:    "5m",
+		Aggregation:  "sum",
+		GroupBy:      []string{"pod"},
+		Functions:    []string{"rate", "histogram_quantile"},
+		Quantile:     0.99,
+		Comparison:   &llm.Comparison{Operator: ">", Value: 0.5},
+		LabelFilters: []llm.LabelFilter{{Label: "namespace", Operator: "=", Value: "prod"}},
+	}
+	tests := []struct {
+		name     string
+		analysis *llm.QueryAnalysis
+		expected *llm.QueryIntent
+	}{
+		{
+			name:     "Intent passed to generation",
+			analysis: &llm.QueryAnalysis{MetricNames: []string{"latency"}, Intent: intent},
+			expected: intent,
+		},
+		{
+			name:     "No intent",
+			analysis: &llm.QueryAnalysis{MetricNames: []string{"latency"}},
+			expected: nil,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			client := &MockLLMClient_PipelineTest{Analysis: tt.analysis, Candidates: []string{"up"}}
+			pipeline := &query_processing.Pipeline{LLMClient: client}
+
+			result, err := pipeline.Run(context.Background(), "p99 latency by pod in prod above 0.5s", nil)
+			if err != nil {
+				t.Fatalf("Run returned an unexpected error: %v", err)
+			}
+			if !reflect.DeepEqual(result.Intent, tt.expected) {
+				t.Errorf("expected result intent %+v, got %+v", tt.expected, result.Intent)
+			}
+			if !reflect.DeepEqual(client.Intent, tt.expected) {
+				t.Errorf("expected intent %+v passed to generation, got %+v", tt.expected, client.Intent)
+			}
+		})
+	}
+}
+
+func TestPipeline_SpecGeneration(t *testing.T) {
+	client := &MockLLMClient_PipelineTest{
+		Candidates: []string{"not used"},
+		Specs: []promql.QuerySpec{
+			{Metric: "http_requests_total", RangeFunction: "rate", Window: "5m", Aggregation: "sum", By: []string{"job"}},
+			{Metric: "http_requests", RangeFunction: "rate", Window: "5m"},
+			{Metric: "http_requests_total", Matchers: []promql.MatcherSpec{{Label: "code", Operator: "=", Value: "500"}}},
+			{Metric: "http_requests_total", Matchers: []promql.MatcherSpec{{Label: "pod", Operator: "=", Value: "a"}}},
+		},
+	}
+	pipeline := &query_processing.Pipeline{
+		LLMClient: client,
+		MetricLabelMap: info_structure.MetricLabelMap{
+			"http_requests_total": {Labels: map[string]info_structure.LabelInfo{
+				"job":  {Values: map[string]struct{}{"api": {}}},
+				"code": {Values: map[string]struct{}{"500": {}}},
+			}},
+		},
+		SpecGeneration: true,
+	}
+
+	result, err := pipeline.Run(context.Background(), "request rate by job", nil)
+	if err != nil {
+		t.Fatalf("Run returned an unexpected error: %v", err)
+	}
+	expected := []string{"sum by (job) (rate(http_requests_total[5m]))", `http_requests_total{code="500"}`}
+	if queries := result.PromQL.Queries(); !reflect.DeepEqual(queries, expected) {
+		t.Fatalf("expected the specs with known names to be compiled to %q, got %q", expected, queries)
+	}
+	pairs := result.PromQL.Candidates[1].MetricLabelPairs
+	if labels, _ := pairs["http_requests_total"].(map[string]interface{}); labels["code"] != "500" {
+		t.Errorf("expected metric label pairs from the spec matchers, got %v", pairs)
+	}
+}
+
+func TestPipeline_RelevanceRanking(t *testing.T) {
+	// "total" is a synonym of every metric, "latency" of one only.
+	metricMap := info_structure.MetricMap{
+		Map: map[string]map[string]struct{}{
+			"total":   {"http_requests_total": {}, "http_errors_total": {}, "node_cpu_seconds_total": {}, "api_latency_seconds_total": {}},
+			"latency": {"api_latency_seconds_total": {}},
+			"errors":  {"http_errors_total": {}},
+		},
+		AllNames: map[string]struct{}{"http_requests_total": {}, "http_errors_total": {}, "node_cpu_seconds_total": {}, "api_latency_seconds_total": {}},
+	}
+	labelMap := info_structure.LabelMap{
+		Map: map[string]map[string]struct{}{
+			"service": {"job": {}},
+			"job":     {"job": {}},
+		},
+		AllNames: map[string]struct{}{"job": {}, "instance": {}},
+	}
+	metricLabelMap := info_structure.MetricLabelMap{
+		"http_requests_total":       {Labels: map[string]info_structure.LabelInfo{"job": {Values: map[string]struct{}{"api": {}, "web": {}}}}},
+		"http_errors_total":         {Labels: map[string]info_structure.LabelInfo{"job": {Values: map[string]struct{}{"web": {}}}}},
+		"node_cpu_seconds_total":    {Labels: map[string]info_structure.LabelInfo{"instance": {Values: map[string]struct{}{"host1": {}}}}},
+		"api_latency_seconds_total": {Labels: map[string]info_structure.LabelInfo{"job": {Values: map[string]struct{}{"api": {}}}}},
+	}
+	labelValueMap := info_structure.LabelValueMap{
+		"job":      {Values: map[string]struct{}{"api": {}, "web": {}}},
+		"instance": {Values: map[string]struct{}{"host1": {}}},
+	}
+
+	client := &MockLLMClient_PipelineTest{
+		Analysis: &llm.QueryAnalysis{
+			MetricNames: []string{"total", "latency"},
+			LabelNames:  []string{"service"},
+			LabelValues: []string{"api"},
+		},
+		Candidates: []string{"up"},
+	}
+	pipeline := &query_processing.Pipeline{
+		LLMClient:      client,
+		MetricMap:      metricMap,
+		LabelMap:       labelMap,
+		MetricLabelMap: metricLabelMap,
+		LabelValueMap:  labelValueMap,
+		MetricHelp:     map[string]string{"http_requests_total": "Total number of HTTP requests by latency bucket."},
+	}
+
+	result, err := pipeline.Run(context.Background(), "api service latency", nil)
+	if err != nil {
+		t.Fatalf("Run returned an unexpected error: %v", err)
+	}
+
+	var order []string
+	scores := make(map[string]query_processing.RankedItem)
+	for _, item := range result.Ranking.Metrics {
+		order = append(order, item.Name)
+		scores[item.Name] = item
+		if diff := item.Score - item.Breakdown.Total(); diff > 1e-9 || diff < -1e-9 {
+			t.Errorf("%s: score %v does not match its breakdown %+v", item.Name, item.Score, item.Breakdown)
+		}
+	}
+	expectedOrder := []string{"api_latency_seconds_total", "http_requests_total", "http_errors_total", "node_cpu_seconds_total"}
+	if !reflect.DeepEqual(order, expectedOrder) {
+		t.Fatalf("expected metrics ranked %v, got %v", expectedOrder, result.Ranking.Metrics)
+	}
+
+	latency := scores["api_latency_seconds_total"].Breakdown
+	if latency.Name <= 0 || latency.Synonyms <= 0 || latency.Labels <= 0 || latency.Values <= 0 {
+		t.Errorf("expected name, synonym, label and value scores for api_latency_seconds_total, got %+v", latency)
+	}
+	if help := scores["http_requests_total"].Breakdown.Help; help <= 0 {
+		t.Errorf("expected a HELP text score for http_requests_total, got %v", help)
+	}
+	// "total" names every metric, so it weighs less than "latency", which names one.
+	cpu := scores["node_cpu_seconds_total"].Breakdown
+	if cpu.Synonyms >= latency.Synonyms {
+		t.Errorf("expected the generic token to weigh less: %v for node_cpu_seconds_total, %v for api_latency_seconds_total", cpu.Synonyms, latency.Synonyms)
+	}
+
+	if len(result.Ranking.Labels) != 1 || result.Ranking.Labels[0].Name != "job" {
+		t.Fatalf("expected the job label to be ranked, got %+v", result.Ranking.Labels)
+	}
+	if score := result.RelevantLabels["job"].MatchScore; score != result.Ranking.Labels[0].Score {
+		t.Errorf("expected the label MatchScore %v to be its ranking score %v", score, result.Ranking.Labels[0].Score)
+	}
+	requests := result.RelevantMetrics["http_requests_total"]["job"].MatchScore
+	errs := result.RelevantMetrics["http_errors_total"]["job"].MatchScore
+	if requests <= errs {
+		t.Errorf("expected job of http_requests_total, which has the value api, to score higher than job of http_errors_total: %v <= %v", requests, errs)
+	}
+}
+
+func TestPipeline_FuzzyRetrieval(t *testing.T) {
+	metricMap := info_structure.MetricMap{
+		Map: map[string]map[string]struct{}{
+			"latency":           {"api_latency_seconds": {}},
+			"memory":            {"node_memory_bytes": {}},
+			"node_memory_bytes": {"node_memory_bytes": {}},
+		},
+		AllNames: map[string]struct{}{"api_latency_seconds": {}, "node_memory_bytes": {}},
+	}
+	labelMap := info_structure.LabelMap{
+		Map:      map[string]map[string]struct{}{"namespace": {"namespace": {}}},
+		AllNames: map[string]struct{}{"namespace": {}},
+	}
+	metricLabelMap := info_structure.MetricLabelMap{
+		"api_latency_seconds": {Labels: map[string]info_structure.LabelInfo{"namespace": {Values: map[string]struct{}{"production": {}}}}},
+		"node_memory_bytes":   {Labels: map[string]info_structure.LabelInfo{}},
+	}
+	labelValueMap := info_structure.LabelValueMap{
+		"namespace": {Values: map[string]struct{}{"production": {}}},
+	}
+
+	run := func(metricTokens, labelTokens, valueTokens []string) *query_processing.Result {
+		pipeline := &query_processing.Pipeline{
+			LLMClient: &MockLLMClient_PipelineTest{
+				Analysis: &llm.QueryAnalysis{
+					MetricNames: metricTokens,
+					LabelNames:  labelTokens,
+					LabelValues: valueTokens,
+				},
+				Candidates: []string{"up"},
+			},
+			MetricMap:      metricMap,
+			LabelMap:       labelMap,
+			MetricLabelMap: metricLabelMap,
+			LabelValueMap:  labelValueMap,
+		}
+		result, err := pipeline.Run(context.Background(), "query", nil)
+		if err != nil {
+			t.Fatalf("Run returned an unexpected error: %v", err)
+		}
+		return result
+	}
+
+	exact := run([]string{"latency", "memory"}, []string{"namespace"}, []string{"production"})
+	fuzzy := run([]string{"latencies", "mem"}, []string{"namespcae"}, []string{"prod"})
+
+	for _, metric := range []string{"api_latency_seconds", "node_memory_bytes"} {
+		if _, ok := fuzzy.RelevantMetrics[metric]; !ok {
+			t.Errorf("expected %s to be retrieved by fuzzy tokens, got %v", metric, fuzzy.RelevantMetrics)
+		}
+	}
+	if detail, ok := fuzzy.RelevantLabels["namespace"]; !ok || len(detail.Values) == 0 || detail.Values[0] != "production" {
+		t.Errorf("expected the namespace label with the value production, got %+v", fuzzy.RelevantLabels)
+	}
+
+	scores := func(result *query_processing.Result) map[string]float64 {
+		m := make(map[string]float64)
+		for _, item := range result.Ranking.Metrics {
+			m["metric "+item.Name] = item.Score
+		}
+		for _, item := range result.Ranking.Labels {
+			m["label "+item.Name] = item.Score
+		}
+		return m
+	}
+	exactScores, fuzzyScores := scores(exact), scores(fuzzy)
+	if len(exactScores) != 3 || len(fuzzyScores) != 3 {
+		t.Fatalf("expected 2 metrics and 1 label in both rankings, got %v and %v", exactScores, fuzzyScores)
+	}
+	for item, exactScore := range exactScores {
+		if fuzzyScores[item] <= 0 || fuzzyScores[item] >= exactScore {
+			t.Errorf("%s: expected the fuzzy score %v to be positive and below the exact score %v", item, fuzzyScores[item], exactScore)
+		}
+	}
+}
+
+func TestPipeline_ValueSelection(t *testing.T) {
+	services := map[string]struct{}{"auth": {}, "cart": {}, "checkout": {}, "payments-api": {}, "search": {}, "worker": {}}
+	metricLabelMap := info_structure.MetricLabelMap{
+		"http_requests_total": {Labels: map[string]info_structure.LabelInfo{
+			"service": {Values: services},
+			"env":     {Values: map[string]struct{}{"dev": {}, "production": {}, "staging": {}}},
+		}},
+		// worker is the only service of two metrics.
+		"jobs_processed_total": {Labels: map[string]info_structure.LabelInfo{"service": {Values: map[string]struct{}{"worker": {}}}}},
+		"cart_items":           {Labels: map[string]info_structure.LabelInfo{"service": {Values: map[string]struct{}{"cart": {}}}}},
+	}
+	labelValueMap := info_structure.LabelValueMap{
+		"service": {Values: services},
+		"env":     {Values: map[string]struct{}{"dev": {}, "production": {}, "staging": {}}},
+	}
+	pipeline := &query_processing.Pipeline{
+		LLMClient: &MockLLMClient_PipelineTest{
+			Analysis: &llm.QueryAnalysis{
+				MetricNames: []string{"http_requests_total"},
+				LabelNames:  []string{"service", "env"},
+				LabelValues: []string{"checkout"},
+			},
+			Candidates: []string{"up"},
+		},
+		MetricMap: info_structure.MetricMap{
+			Map:      map[string]map[string]struct{}{"http_requests_total": {"http_requests_total": {}}},
+			AllNames: map[string]struct{}{"http_requests_total": {}, "jobs_processed_total": {}, "cart_items": {}},
+		},
+		LabelMap: info_structure.LabelMap{
+			Map:      map[string]map[string]struct{}{"service": {"service": {}}, "env": {"env": {}}},
+			AllNames: map[string]struct{}{"service": {}, "env": {}},
+		},
+		MetricLabelMap: metricLabelMap,
+		LabelValueMap:  labelValueMap,
+		ValueLimit:     2,
+		ValueLimits:    map[string]int{"env": 1},
+	}
+
+	result, err := pipeline.Run(context.Background(), "error rate of payments in prod", nil)
+	if err != nil {
+		t.Fatalf("Run returned an unexpected error: %v", err)
+	}
+
+	// The named value first and beyond the limit, then the value most similar
+	// to the query, then the most frequent; ties by name.
+	expected := map[string][]string{
+		"service": {"checkout", "payments-api", "cart"},
+		"env":     {"production"},
+	}
+	for label, values := range expected {
+		if got := result.RelevantLabels[label].Values; !reflect.DeepEqual(got, values) {
+			t.Errorf("label %s: expected values %v, got %v", label, values, got)
+		}
+		if got := result.RelevantMetrics["http_requests_total"][label].Values; !reflect.DeepEqual(got, values) {
+			t.Errorf("label %s of http_requests_total: expected values %v, got %v", label, values, got)
+		}
+	}
+}
+
+// BenchmarkPipeline_ValueSelection runs a query retrieving a pod label with
+// many values, most sharing their first trigrams with a query word.
+func BenchmarkPipeline_ValueSelection(b *testing.B) {
+	for _, numValues := range []int{1000, 100000} {
+		pods := make(map[string]struct{}, numValues)
+		for i := 0; i < numValues; i++ {
+			pods[fmt.Sprintf("payments-%x-%d", i*7919, i)] = struct{}{}
+		}
+		pipeline := &query_processing.Pipeline{
+			LLMClient: &MockLLMClient_PipelineTest{
+				Analysis: &llm.QueryAnalysis{
+					MetricNames: []string{"container_cpu_usage_seconds_total"},
+					LabelNames:  []string{"pod"},
+					LabelValues: []string{"payments-checkout"},
+				},
+				Candidates: []string{"up"},
+			},
+			MetricMap: info_structure.MetricMap{
+				Map:      map[string]map[string]struct{}{"container_cpu_usage_seconds_total": {"container_cpu_usage_seconds_total": {}}},
+				AllNames: map[string]struct{}{"container_cpu_usage_seconds_total": {}},
+			},
+			LabelMap: info_structure.LabelMap{
+				Map:      map[string]map[string]struct{}{"pod": {"pod": {}}},
+				AllNames: map[string]struct{}{"pod": {}},
+			},
+			MetricLabelMap: info_structure.MetricLabelMap{
+				"container_cpu_usage_seconds_total": {Labels: map[string]info_structure.LabelInfo{"pod": {Values: pods}}},
+			},
+			LabelValueMap: info_structure.LabelValueMap{"pod": {Values: pods}},
+		}
+		// The first run builds the indexes.
+		if _, err := pipeline.Run(context.Background(), "cpu of the payments pods", nil); err != nil {
+			b.Fatalf("Run returned an unexpected error: %v", err)
+		}
+
+		b.Run(fmt.Sprintf("values=%d", numValues), func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				if _, err := pipeline.Run(context.Background(), "cpu of the payments pods", nil); err != nil {
+					b.Fatalf("Run returned an unexpected error: %v", err)
+				}
+			}
+		})
+	}
+}
+
+func TestPipeline_Explain(t *testing.T) {
+	metricMap, labelMap, metricLabelMap, labelValueMap := retrievalMaps()
+	pipeline := &query_processing.Pipeline{
+		LLMClient: &MockLLMClient_PipelineTest{
+			Analysis: &llm.QueryAnalysis{
+				MetricNames: []string{"latencies"},
+				LabelNames:  []string{"service"},
+				LabelValues: []string{"production"},
+			},
+			Candidates: []string{"up"},
+		},
+		MetricMap:      metricMap,
+		LabelMap:       labelMap,
+		MetricLabelMap: metricLabelMap,
+		LabelValueMap:  labelValueMap,
+	}
+	result, err := pipeline.Run(context.Background(), "api latencies by service in production", nil)
+	if err != nil {
+		t.Fatalf("Run returned an unexpected error: %v", err)
+	}
+
+	expected := []query_processing.TokenMatch{
+		{Token: "service", Key: "service", Match: matching.KindExact, Weight: 1, Kind: query_processing.MatchLabel, Name: "job"},
+		{Token: "production", Key: "production", Match: matching.KindExact, Weight: 1, Kind: query_processing.MatchValue, Name: "namespace"},
+		{Token: "latencies", Key: "latency", Match: matching.KindNormalized, Weight: matching.ScoreNormalized, Kind: query_processing.MatchMetric, Name: "api_latency_seconds"},
+	}
+	if !reflect.DeepEqual(result.Matches, expected) {
+		t.Errorf("expected matches %+v, got %+v", expected, result.Matches)
+	}
+	if len(result.Prompts) != 1 || result.Prompts[0].Messages[0].Content != "api latencies by service in production" {
+		t.Errorf("expected the generation prompt to be recorded, got %+v", result.Prompts)
+	}
+}
@@ -0,0 +1,74 @@
+package query_processing
+
+import (
+	"context"
+	"errors"
+	"log"
+	"sync"
+
+	"github.com/prashantgupta17/nlpromql/info_structure"
+	"github.com/prashantgupta17/nlpromql/llm"
+	"github.com/prashantgupta17/nlpromql/prometheus"
+	"github.com/prashantgupta17/nlpromql/promql"
+)
+
+// validateQuery returns the error that query fails validation with, or "" if
+// it is valid. The query is parsed locally first, then, if queryEngine is not
+// nil, run against Prometheus. Only errors Prometheus reports about the query
+// itself count: a Prometheus that cannot be reached does not make the query
+// invalid.
+func validateQuery(query string, queryEngine info_structure.QueryEngine) string {
+	if _, err := promql.Parse(query); err != nil {
+		return err.Error()
+	}
+	if queryEngine == nil {
+		return ""
+	}
+	_, err := queryEngine.CustomQuery(query)
+	var apiErr *prometheus.APIError
+	if errors.As(err, &apiErr) && (apiErr.Type == "bad_data" || apiErr.Type == "execution") {
+		return apiErr.Message
+	}
+	if err != nil {
+		log.Printf("Could not validate %q against Prometheus: %v\n", query, err)
+	}
+	return ""
+}
+
+// repairCandidates validates every candidate of result and, while it is
+// invalid, sends the failing query and its error to the LLM for a fix, up to
+// p.MaxRepairAttempts times. Failed versions are recorded in the candidate's
+// Attempts. Candidates are repaired concurrently.
+func (p *Pipeline) repairCandidates(ctx context.Context, userQuery string, result *llm.PromQLResult) {
+	var wg sync.WaitGroup
+	for i := range result.Candidates {
+		wg.Add(1)
+		go func(candidate *llm.PromQLCandidate) {
+			defer wg.Done()
+			p.repairCandidate(ctx, userQuery, candidate)
+		}(&result.Candidates[i])
+	}
+	wg.Wait()
+}
+
+func (p *Pipeline) repairCandidate(ctx context.Context, userQuery string, candidate *llm.PromQLCandidate) {
+	for attempt := 0; ; attempt++ {
+		validationError := validateQuery(candidate.PromQL, p.QueryEngine)
+		if validationError == "" {
+			candidate.ValidationError = ""
+			return
+		}
+		candidate.ValidationError = validationError
+		if attempt == p.MaxRepairAttempts || ctx.Err() != nil {
+			return
+		}
+
+		repaired, err := p.LLMClient.RepairPromQL(ctx, userQuery, candidate.PromQL, validationError)
+		if err != nil {
+			log.Printf("Error repairing PromQL %q: %v\n", candidate.PromQL, err)
+			return
+		}
+		candidate.Attempts = append(candidate.Attempts, llm.RepairAttempt{PromQL: candidate.PromQL, Error: validationError})
+		candidate.PromQL = repaired
+	}
+}
@@ -0,0 +1,235 @@
+package query_processing
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/prashantgupta17/nlpromql/info_structure"
+	"github.com/prashantgupta17/nlpromql/llm"
+	"github.com/prashantgupta17/nlpromql/matching"
+)
+
+// PossibleMatches is what query analysis extracted from a query: the words
+// that may name metrics, labels and label values.
+type PossibleMatches struct {
+	Query       string
+	MetricNames []string
+	LabelNames  []string
+	LabelValues []string
+}
+
+// NewPossibleMatches reads the token lists of the analysis returned by
+// llm.LLMClient.ProcessUserQuery for query, which may be nil. Repeated tokens
+// are dropped.
+func NewPossibleMatches(query string, analysis *llm.QueryAnalysis) PossibleMatches {
+	if analysis == nil {
+		analysis = &llm.QueryAnalysis{}
+	}
+	return PossibleMatches{
+		Query:       query,
+		MetricNames: uniqueTokens(analysis.MetricNames),
+		LabelNames:  uniqueTokens(analysis.LabelNames),
+		LabelValues: uniqueTokens(analysis.LabelValues),
+	}
+}
+
+// uniqueTokens returns the distinct tokens of a token list from query
+// analysis.
+func uniqueTokens(list []string) []string {
+	tokens := make([]string, 0, len(list))
+	seen := make(map[string]bool, len(list))
+	for _, token := range list {
+		if !seen[token] {
+			seen[token] = true
+			tokens = append(tokens, token)
+		}
+	}
+	return tokens
+}
+
+// WeightedToken is a token a retriever looked up in the information
+// structure maps, weighted by how closely it matches the query: 1 for a
+// token of the query itself, less for an approximate match.
+type WeightedToken struct {
+	Token  string
+	Weight float64
+	From   string        // Token of the query that Token approximately matches; empty if it is Token
+	Match  matching.Kind // How From matched Token
+}
+
+// weighted returns tokens with a weight of 1.
+func weighted(tokens []string) []WeightedToken {
+	result := make([]WeightedToken, len(tokens))
+	for i, token := range tokens {
+		result[i] = WeightedToken{Token: token, Weight: 1, Match: matching.KindExact}
+	}
+	return result
+}
+
+// What a TokenMatch found.
+const (
+	MatchMetric = "metric"
+	MatchLabel  = "label"
+	MatchValue  = "value" // Name is the label of the value
+)
+
+// MatchEmbedding is the TokenMatch.Match of metrics and labels found by
+// semantic search, whose Token is the whole query.
+const MatchEmbedding matching.Kind = "embedding"
+
+// TokenMatch explains why a metric or label was retrieved: the token of the
+// query, the key of the information structure it matched (a synonym, label
+// value or the name itself), how, and what the key led to.
+type TokenMatch struct {
+	Token  string        `json:"token"`
+	Key    string        `json:"key"`
+	Match  matching.Kind `json:"match"`
+	Weight float64       `json:"weight"`
+	Kind   string        `json:"kind"` // MatchMetric, MatchLabel or MatchValue
+	Name   string        `json:"name"`
+}
+
+// tokenMatch returns the TokenMatch of t leading to name.
+func (t WeightedToken) tokenMatch(kind, name string) TokenMatch {
+	token := t.From
+	if token == "" {
+		token = t.Token
+	}
+	return TokenMatch{Token: token, Key: t.Token, Match: t.Match, Weight: t.Weight, Kind: kind, Name: name}
+}
+
+// RetrievalResult is what a Retriever found for a query. The MatchScores and
+// Values of the labels are left for the pipeline to fill in.
+type RetrievalResult struct {
+	Metrics llm.RelevantMetricsMap // Metric -> its labels that are relevant
+	Labels  llm.RelevantLabelsMap
+	History map[string]interface{} // Metrics used by previous queries, from QueryHistory
+
+	// Tokens the metrics, labels and values were looked up by, used to score them.
+	MetricTokens []WeightedToken
+	LabelTokens  []WeightedToken
+	ValueTokens  []WeightedToken
+
+	// Cosine similarities of the metrics and labels to the query, from embeddings.
+	MetricSimilarities map[string]float64
+	LabelSimilarities  map[string]float64
+
+	// Matches explains how each metric and label was found, in the order found.
+	Matches []TokenMatch
+}
+
+// NewRetrievalResult returns an empty RetrievalResult.
+func NewRetrievalResult() *RetrievalResult {
+	return &RetrievalResult{
+		Metrics:            make(llm.RelevantMetricsMap),
+		Labels:             make(llm.RelevantLabelsMap),
+		History:            make(map[string]interface{}),
+		MetricSimilarities: make(map[string]float64),
+		LabelSimilarities:  make(map[string]float64),
+	}
+}
+
+// addMetric adds metric, with no labels if it is new.
+func (r *RetrievalResult) addMetric(metric string) {
+	if _, ok := r.Metrics[metric]; !ok {
+		r.Metrics[metric] = make(map[string]llm.LabelContextDetail)
+	}
+}
+
+// attachLabels adds to each metric the labels of r.Labels that it has in
+// metricLabelMap.
+func (r *RetrievalResult) attachLabels(metricLabelMap info_structure.MetricLabelMap) {
+	for metric, labels := range r.Metrics {
+		for label := range r.Labels {
+			if _, ok := metricLabelMap[metric].Labels[label]; ok {
+				labels[label] = llm.LabelContextDetail{}
+			}
+		}
+	}
+}
+
+// Retriever finds the metrics and labels of the information structure that
+// are relevant to a query.
+type Retriever interface {
+	Retrieve(ctx context.Context, matches PossibleMatches) (*RetrievalResult, error)
+}
+
+// Merger is a Retriever that combines the results of several retrievers.
+type Merger struct {
+	retrievers     []Retriever
+	metricLabelMap info_structure.MetricLabelMap
+}
+
+// NewMerger returns a Merger of retrievers. Each metric of the merged result
+// gets every merged label it has in metricLabelMap, whichever retriever found
+// them.
+func NewMerger(metricLabelMap info_structure.MetricLabelMap, retrievers ...Retriever) *Merger {
+	return &Merger{retrievers: retrievers, metricLabelMap: metricLabelMap}
+}
+
+// Retrieve runs the retrievers in order and merges their results. Metrics,
+// labels, history and matches are united, a token that several retrievers
+// looked up keeps its highest weight, and so does a similarity. It fails if
+// any retriever fails.
+func (m *Merger) Retrieve(ctx context.Context, matches PossibleMatches) (*RetrievalResult, error) {
+	merged := NewRetrievalResult()
+	for _, retriever := range m.retrievers {
+		result, err := retriever.Retrieve(ctx, matches)
+		if err != nil {
+			return nil, fmt.Errorf("error retrieving with %T: %w", retriever, err)
+		}
+		for metric, labels := range result.Metrics {
+			merged.addMetric(metric)
+			for label := range labels {
+				merged.Metrics[metric][label] = llm.LabelContextDetail{}
+			}
+		}
+		for label := range result.Labels {
+			merged.Labels[label] = llm.LabelContextDetail{}
+		}
+		for metric, entry := range result.History {
+			if _, ok := merged.History[metric]; !ok {
+				merged.History[metric] = entry
+			}
+		}
+		merged.MetricTokens = mergeTokens(merged.MetricTokens, result.MetricTokens)
+		merged.LabelTokens = mergeTokens(merged.LabelTokens, result.LabelTokens)
+		merged.ValueTokens = mergeTokens(merged.ValueTokens, result.ValueTokens)
+		mergeSimilarities(merged.MetricSimilarities, result.MetricSimilarities)
+		mergeSimilarities(merged.LabelSimilarities, result.LabelSimilarities)
+		merged.Matches = append(merged.Matches, result.Matches...)
+	}
+	merged.attachLabels(m.metricLabelMap)
+	return merged, nil
+}
+
+// mergeTokens adds the tokens of add to tokens, keeping the one with the
+// highest weight of a token found in both.
+func mergeTokens(tokens, add []WeightedToken) []WeightedToken {
+	for _, t := range add {
+		found := false
+		for i := range tokens {
+			if tokens[i].Token == t.Token {
+				if t.Weight > tokens[i].Weight {
+					tokens[i] = t
+				}
+				found = true
+				break
+			}
+		}
+		if !found {
+			tokens = append(tokens, t)
+		}
+	}
+	return tokens
+}
+
+// mergeSimilarities adds the similarities of add to similarities, keeping the
+// highest.
+func mergeSimilarities(similarities, add map[string]float64) {
+	for name, similarity := range add {
+		if current, ok := similarities[name]; !ok || similarity > current {
+			similarities[name] = similarity
+		}
+	}
+}
@@ -0,0 +1,255 @@
+package query_processing_test
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"sort"
+	"testing"
+	"time"
+
+	"github.com/prashantgupta17/nlpromql/info_structure"
+	"github.com/prashantgupta17/nlpromql/llm"
+	"github.com/prashantgupta17/nlpromql/query_processing"
+	"github.com/prashantgupta17/nlpromql/vectorindex"
+)
+
+// retrievalMaps is a small information structure shared by the retriever tests.
+func retrievalMaps() (info_structure.MetricMap, info_structure.LabelMap, info_structure.MetricLabelMap, info_structure.LabelValueMap) {
+	metricMap := info_structure.MetricMap{
+		Map: map[string]map[string]struct{}{
+			"latency":  {"api_latency_seconds": {}},
+			"requests": {"http_requests_total": {}},
+		},
+		AllNames: map[string]struct{}{"api_latency_seconds": {}, "http_requests_total": {}},
+	}
+	labelMap := info_structure.LabelMap{
+		Map:      map[string]map[string]struct{}{"namespace": {"namespace": {}}, "service": {"job": {}}},
+		AllNames: map[string]struct{}{"namespace": {}, "job": {}},
+	}
+	metricLabelMap := info_structure.MetricLabelMap{
+		"api_latency_seconds": {Labels: map[string]info_structure.LabelInfo{
+			"namespace": {Values: map[string]struct{}{"production": {}}},
+			"job":       {Values: map[string]struct{}{"api": {}}},
+		}},
+		"http_requests_total": {Labels: map[string]info_structure.LabelInfo{
+			"job": {Values: map[string]struct{}{"api": {}, "web": {}}},
+		}},
+	}
+	labelValueMap := info_structure.LabelValueMap{
+		"namespace": {Values: map[string]struct{}{"production": {}}},
+		"job":       {Values: map[string]struct{}{"api": {}, "web": {}}},
+	}
+	return metricMap, labelMap, metricLabelMap, labelValueMap
+}
+
+// labelsOf returns the sorted labels of each metric of result.
+func labelsOf(result *query_processing.RetrievalResult) map[string][]string {
+	metrics := make(map[string][]string)
+	for metric, labels := range result.Metrics {
+		metrics[metric] = []string{}
+		for label := range labels {
+			metrics[metric] = append(metrics[metric], label)
+		}
+		sort.Strings(metrics[metric])
+	}
+	return metrics
+}
+
+func sortedKeys(labels llm.RelevantLabelsMap) []string {
+	keys := []string{}
+	for label := range labels {
+		keys = append(keys, label)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func TestExactRetriever(t *testing.T) {
+	metricMap, labelMap, metricLabelMap, _ := retrievalMaps()
+	retriever := query_processing.NewExactRetriever(metricMap, labelMap, metricLabelMap, info_structure.BuildValueIndex(metricLabelMap))
+
+	tests := []struct {
+		name            string
+		matches         query_processing.PossibleMatches
+		expectedMetrics map[string][]string
+		expectedLabels  []string
+	}{
+		{
+			name:            "Metric and label synonyms",
+			matches:         query_processing.PossibleMatches{MetricNames: []string{"latency", "requests"}, LabelNames: []string{"service"}},
+			expectedMetrics: map[string][]string{"api_latency_seconds": {"job"}, "http_requests_total": {"job"}},
+			expectedLabels:  []string{"job"},
+		},
+		{
+			name:            "Label of a value",
+			matches:         query_processing.PossibleMatches{MetricNames: []string{"latency"}, LabelValues: []string{"production"}},
+			expectedMetrics: map[string][]string{"api_latency_seconds": {"namespace"}},
+			expectedLabels:  []string{"namespace"},
+		},
+		{
+			name:            "Approximate tokens are not matched",
+			matches:         query_processing.PossibleMatches{MetricNames: []string{"latencies"}, LabelValues: []string{"prod"}},
+			expectedMetrics: map[string][]string{},
+			expectedLabels:  []string{},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			result, err := retriever.Retrieve(context.Background(), tt.matches)
+			if err != nil {
+				t.Fatalf("Retrieve returned an unexpected error: %v", err)
+			}
+			if got := labelsOf(result); !reflect.DeepEqual(got, tt.expectedMetrics) {
+				t.Errorf("expected metrics %v, got %v", tt.expectedMetrics, got)
+			}
+			if got := sortedKeys(result.Labels); !reflect.DeepEqual(got, tt.expectedLabels) {
+				t.Errorf("expected labels %v, got %v", tt.expectedLabels, got)
+			}
+			for _, token := range result.MetricTokens {
+				if token.Weight != 1 {
+					t.Errorf("expected exact tokens to weigh 1, got %+v", token)
+				}
+			}
+		})
+	}
+}
+
+func TestFuzzyRetriever(t *testing.T) {
+	metricMap, labelMap, metricLabelMap, labelValueMap := retrievalMaps()
+	exact := query_processing.NewExactRetriever(metricMap, labelMap, metricLabelMap, info_structure.BuildValueIndex(metricLabelMap))
+	retriever := query_processing.NewFuzzyRetriever(exact, labelValueMap)
+
+	result, err := retriever.Retrieve(context.Background(), query_processing.PossibleMatches{
+		MetricNames: []string{"latencies", "requests"},
+		LabelNames:  []string{"namespcae"},
+		LabelValues: []string{"prod"},
+	})
+	if err != nil {
+		t.Fatalf("Retrieve returned an unexpected error: %v", err)
+	}
+
+	expectedMetrics := map[string][]string{"api_latency_seconds": {"namespace"}}
+	if got := labelsOf(result); !reflect.DeepEqual(got, expectedMetrics) {
+		t.Errorf("expected metrics %v, leaving the exact token to the exact retriever, got %v", expectedMetrics, got)
+	}
+	for _, tokens := range [][]query_processing.WeightedToken{result.MetricTokens, result.LabelTokens, result.ValueTokens} {
+		if len(tokens) != 1 || tokens[0].Weight <= 0 || tokens[0].Weight >= 1 {
+			t.Errorf("expected one approximately matched key weighing less than 1, got %+v", tokens)
+		}
+	}
+	if result.ValueTokens[0].Token != "production" {
+		t.Errorf("expected prod to match production, got %+v", result.ValueTokens)
+	}
+}
+
+func TestEmbeddingRetriever(t *testing.T) {
+	index := vectorindex.New("test-model")
+	index.Add(
+		vectorindex.Entry{Kind: vectorindex.KindMetric, Name: "api_latency_seconds", Vector: []float32{1, 0}},
+		vectorindex.Entry{Kind: vectorindex.KindLabel, Name: "job", Vector: []float32{0, 1}},
+	)
+	retriever := &query_processing.EmbeddingRetriever{
+		Embedder: &MockEmbedder_PipelineTest{Vector: []float32{1, 0.1}},
+		Index:    index,
+		MinScore: 0.5,
+	}
+
+	result, err := retriever.Retrieve(context.Background(), query_processing.PossibleMatches{Query: "how slow is the api"})
+	if err != nil {
+		t.Fatalf("Retrieve returned an unexpected error: %v", err)
+	}
+	if _, ok := result.Metrics["api_latency_seconds"]; !ok || len(result.Metrics) != 1 || len(result.Labels) != 0 {
+		t.Errorf("expected only the similar metric, got metrics %v and labels %v", result.Metrics, result.Labels)
+	}
+	if similarity := result.MetricSimilarities["api_latency_seconds"]; similarity < 0.9 {
+		t.Errorf("expected the cosine similarity of the metric to be recorded, got %v", similarity)
+	}
+}
+
+func TestHistoryRetriever(t *testing.T) {
+	history := info_structure.NewQueryHistory()
+	now := time.Now()
+	for i := 0; i < 2; i++ {
+		history.Record([]string{"latency", "service"}, "api_latency_seconds", map[string]string{"job": "api"}, "", true, now)
+	}
+	history.Record([]string{"latency", "service"}, "api_latency_seconds", map[string]string{"job": ""}, "", true, now)
+	history.Record([]string{"requests", "pod"}, "http_requests_total", nil, "", true, now)
+	history.Record([]string{"latency"}, "node_latency_seconds", nil, "", true, now)
+	history.Record([]string{"latency"}, "rejected_seconds", nil, "", false, now)
+	retriever := &query_processing.HistoryRetriever{History: history}
+
+	result, err := retriever.Retrieve(context.Background(), query_processing.PossibleMatches{
+		MetricNames: []string{"latency", "requests"},
+		LabelNames:  []string{"service"},
+	})
+	if err != nil {
+		t.Fatalf("Retrieve returned an unexpected error: %v", err)
+	}
+	expected := map[string]interface{}{
+		"api_latency_seconds":  map[string]interface{}{"score": 3, "labels": map[string]string{"job": "api"}},
+		"node_latency_seconds": map[string]interface{}{"score": 1, "labels": map[string]string{}},
+	}
+	if !reflect.DeepEqual(result.History, expected) {
+		t.Errorf("expected the history of latency by service and of latency alone %v, got %v", expected, result.History)
+	}
+
+	retriever.History = nil
+	if result, err := retriever.Retrieve(context.Background(), query_processing.PossibleMatches{}); err != nil || len(result.History) != 0 {
+		t.Errorf("expected no history without a QueryHistory, got %v, %v", result, err)
+	}
+}
+
+// MockRetriever_RetrievalTest returns Result, or Err.
+type MockRetriever_RetrievalTest struct {
+	Result *query_processing.RetrievalResult
+	Err    error
+}
+
+func (m *MockRetriever_RetrievalTest) Retrieve(ctx context.Context, matches query_processing.PossibleMatches) (*query_processing.RetrievalResult, error) {
+	return m.Result, m.Err
+}
+
+func TestMerger(t *testing.T) {
+	_, _, metricLabelMap, _ := retrievalMaps()
+
+	byMetric := query_processing.NewRetrievalResult()
+	byMetric.Metrics["api_latency_seconds"] = map[string]llm.LabelContextDetail{}
+	byMetric.MetricTokens = []query_processing.WeightedToken{{Token: "latency", Weight: 0.6}}
+	byMetric.History["api_latency_seconds"] = "first"
+
+	byLabel := query_processing.NewRetrievalResult()
+	byLabel.Labels["namespace"] = llm.LabelContextDetail{}
+	byLabel.MetricTokens = []query_processing.WeightedToken{{Token: "latency", Weight: 0.9}, {Token: "api", Weight: 1}}
+	byLabel.LabelSimilarities["namespace"] = 0.7
+	byLabel.History["api_latency_seconds"] = "second"
+
+	merger := query_processing.NewMerger(metricLabelMap,
+		&MockRetriever_RetrievalTest{Result: byMetric}, &MockRetriever_RetrievalTest{Result: byLabel})
+	result, err := merger.Retrieve(context.Background(), query_processing.PossibleMatches{})
+	if err != nil {
+		t.Fatalf("Retrieve returned an unexpected error: %v", err)
+	}
+
+	// The label found by one retriever is attached to the metric found by the other.
+	expectedMetrics := map[string][]string{"api_latency_seconds": {"namespace"}}
+	if got := labelsOf(result); !reflect.DeepEqual(got, expectedMetrics) {
+		t.Errorf("expected metrics %v, got %v", expectedMetrics, got)
+	}
+	expectedTokens := []query_processing.WeightedToken{{Token: "latency", Weight: 0.9}, {Token: "api", Weight: 1}}
+	if !reflect.DeepEqual(result.MetricTokens, expectedTokens) {
+		t.Errorf("expected tokens %v keeping their highest weight, got %v", expectedTokens, result.MetricTokens)
+	}
+	if result.LabelSimilarities["namespace"] != 0.7 {
+		t.Errorf("expected the label similarity to be kept, got %v", result.LabelSimilarities)
+	}
+	if result.History["api_latency_seconds"] != "first" {
+		t.Errorf("expected the first history entry of a metric to win, got %v", result.History)
+	}
+
+	failing := query_processing.NewMerger(metricLabelMap,
+		&MockRetriever_RetrievalTest{Result: byMetric}, &MockRetriever_RetrievalTest{Err: errors.New("boom")})
+	if _, err := failing.Retrieve(context.Background(), query_processing.PossibleMatches{}); err == nil {
+		t.Errorf("expected the error of a failing retriever")
+	}
+}
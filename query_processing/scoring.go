@@ -0,0 +1,309 @@
+package query_processing
+
+import (
+	"math"
+	"sort"
+	"strings"
+
+	"github.com/prashantgupta17/nlpromql/info_structure"
+	"github.com/prashantgupta17/nlpromql/llm"
+	"github.com/prashantgupta17/nlpromql/synonyms"
+)
+
+// BM25 parameters, and the weight of HELP text matches relative to name
+// matches: HELP text is longer and less specific than the name.
+const (
+	bm25K1     = 1.2
+	bm25B      = 0.75
+	helpWeight = 0.5
+)
+
+// ScoreBreakdown is the contribution of each kind of match to the relevance
+// score of a metric or label.
+type ScoreBreakdown struct {
+	Name     float64 `json:"name,omitempty"`     // Query words in the name
+	Synonyms float64 `json:"synonyms,omitempty"` // Query tokens that are synonyms of the name
+	Learned  float64 `json:"learned,omitempty"`  // Query tokens that are synonyms of the name learned from feedback
+	Help     float64 `json:"help,omitempty"`     // Query words in the metric's HELP text
+	Labels   float64 `json:"labels,omitempty"`   // Labels of the metric matched by the query
+	Values   float64 `json:"values,omitempty"`   // Label values matched by the query
+	Semantic float64 `json:"semantic,omitempty"` // Cosine similarity from semantic retrieval
+}
+
+// Total returns the sum of the contributions.
+func (b ScoreBreakdown) Total() float64 {
+	return b.Name + b.Synonyms + b.Learned + b.Help + b.Labels + b.Values + b.Semantic
+}
+
+// RankedItem is a retrieved metric or label with its relevance score.
+type RankedItem struct {
+	Name      string         `json:"name"`
+	Score     float64        `json:"score"`
+	Breakdown ScoreBreakdown `json:"breakdown"`
+}
+
+// Ranking lists the retrieved metrics and labels, most relevant first.
+type Ranking struct {
+	Metrics []RankedItem `json:"metrics"`
+	Labels  []RankedItem `json:"labels"`
+}
+
+// MetricScores returns the score of each ranked metric.
+func (r Ranking) MetricScores() llm.MetricScores {
+	scores := make(llm.MetricScores, len(r.Metrics))
+	for _, item := range r.Metrics {
+		scores[item.Name] = item.Score
+	}
+	return scores
+}
+
+// idf is the BM25 inverse document frequency of a term found in df of n
+// documents. It is positive even for terms found in every document.
+func idf(n, df int) float64 {
+	if n < df {
+		n = df
+	}
+	return math.Log(1 + (float64(n)-float64(df)+0.5)/(float64(df)+0.5))
+}
+
+// fieldIndex holds the BM25 statistics of one field of a set of documents.
+type fieldIndex struct {
+	terms     map[string]map[string]int // Document -> word -> count
+	lengths   map[string]int
+	df        map[string]int
+	avgLength float64
+}
+
+func newFieldIndex(docs map[string][]string) *fieldIndex {
+	f := &fieldIndex{
+		terms:   make(map[string]map[string]int, len(docs)),
+		lengths: make(map[string]int, len(docs)),
+		df:      make(map[string]int),
+	}
+	total := 0
+	for doc, words := range docs {
+		counts := make(map[string]int, len(words))
+		for _, word := range words {
+			if counts[word] == 0 {
+				f.df[word]++
+			}
+			counts[word]++
+		}
+		f.terms[doc] = counts
+		f.lengths[doc] = len(words)
+		total += len(words)
+	}
+	if len(docs) > 0 {
+		f.avgLength = float64(total) / float64(len(docs))
+	}
+	return f
+}
+
+// weightedWord is a query word with the weight of the token it came from.
+type weightedWord struct {
+	word   string
+	weight float64
+}
+
+// bm25 scores doc for the query words, which must not repeat. The score of
+// each word is multiplied by its weight.
+func (f *fieldIndex) bm25(doc string, words []weightedWord) float64 {
+	var score float64
+	for _, w := range words {
+		tf := f.terms[doc][w.word]
+		if tf == 0 {
+			continue
+		}
+		norm := 1 - bm25B + bm25B*float64(f.lengths[doc])/f.avgLength
+		score += w.weight * idf(len(f.terms), f.df[w.word]) * float64(tf) * (bm25K1 + 1) / (float64(tf) + bm25K1*norm)
+	}
+	return score
+}
+
+// relevanceScorer scores retrieved metrics and labels against the tokens
+// extracted from a query. Its indexes are built once from the information
+// structure.
+type relevanceScorer struct {
+	metricMap      info_structure.MetricMap
+	labelMap       info_structure.LabelMap
+	metricLabelMap info_structure.MetricLabelMap
+	labelValueMap  info_structure.LabelValueMap
+	valueIndex     info_structure.ValueIndex
+	names          *fieldIndex // Words of metric names
+	help           *fieldIndex // Words of metric HELP texts
+}
+
+func newRelevanceScorer(metricMap info_structure.MetricMap, labelMap info_structure.LabelMap,
+	metricLabelMap info_structure.MetricLabelMap, labelValueMap info_structure.LabelValueMap, valueIndex info_structure.ValueIndex,
+	help map[string]string) *relevanceScorer {
+	names := make(map[string][]string, len(metricMap.AllNames))
+	helpWords := make(map[string][]string, len(metricMap.AllNames))
+	for metric := range metricMap.AllNames {
+		names[metric] = synonyms.SplitName(metric)
+		helpWords[metric] = synonyms.SplitName(help[metric])
+	}
+	return &relevanceScorer{
+		metricMap:      metricMap,
+		labelMap:       labelMap,
+		metricLabelMap: metricLabelMap,
+		labelValueMap:  labelValueMap,
+		valueIndex:     valueIndex,
+		names:          newFieldIndex(names),
+		help:           newFieldIndex(helpWords),
+	}
+}
+
+// rank scores every metric and label of result, replacing their
+// MatchScores, and returns them ranked. The contribution of each token is
+// multiplied by its weight.
+//
+// A label scores the IDF, over LabelMap, of each label token that maps to it,
+// plus the IDF, over all labels, of each value token it has, plus its
+// semantic similarity. Within a metric, only the values the metric has count.
+// A metric scores BM25 for the words of the metric tokens against its name
+// and HELP text, the IDF over MetricMap of each metric token that is one of
+// its synonyms, plus the scores of its labels and its semantic similarity.
+// Learned synonyms score like synonyms, weighted by their decayed weight,
+// with the IDF over the names each token was learned for.
+func (s *relevanceScorer) rank(result *RetrievalResult) Ranking {
+	// A word keeps the highest weight of the tokens it is part of.
+	var metricWords []weightedWord
+	wordIndex := make(map[string]int)
+	for _, t := range result.MetricTokens {
+		weight := t.Weight
+		for _, word := range synonyms.SplitName(t.Token) {
+			if i, ok := wordIndex[word]; ok {
+				metricWords[i].weight = max(metricWords[i].weight, weight)
+				continue
+			}
+			wordIndex[word] = len(metricWords)
+			metricWords = append(metricWords, weightedWord{word: word, weight: weight})
+		}
+	}
+
+	// IDF of each value token over the labels that have it.
+	valueIDF := make(map[string]float64, len(result.ValueTokens))
+	for _, t := range result.ValueTokens {
+		labels := make(map[string]struct{})
+		for _, ref := range s.valueIndex.Lookup(t.Token) {
+			labels[ref.Label] = struct{}{}
+		}
+		if df := len(labels); df > 0 {
+			valueIDF[t.Token] = t.Weight * idf(len(s.labelValueMap), df)
+		}
+	}
+	valueScore := func(values map[string]struct{}) float64 {
+		var score float64
+		for _, t := range result.ValueTokens {
+			if _, ok := values[t.Token]; ok {
+				score += valueIDF[t.Token]
+			}
+		}
+		return score
+	}
+
+	// Learned synonym scores of each metric and label.
+	learned := s.learnedScores(result.Matches)
+
+	// Name and synonym scores of every label mentioned, whether on its own or
+	// as a label of a metric.
+	labelNames := make(map[string]ScoreBreakdown)
+	nameBreakdown := func(label string) ScoreBreakdown {
+		if b, ok := labelNames[label]; ok {
+			return b
+		}
+		var b ScoreBreakdown
+		for _, t := range result.LabelTokens {
+			labels := s.labelMap.Map[t.Token]
+			if _, ok := labels[label]; !ok {
+				continue
+			}
+			weight := t.Weight * idf(len(s.labelMap.AllNames), len(labels))
+			if t.Token == strings.ToLower(label) {
+				b.Name += weight
+			} else {
+				b.Synonyms += weight
+			}
+		}
+		b.Learned = learned[MatchLabel][label]
+		b.Semantic = result.LabelSimilarities[label]
+		labelNames[label] = b
+		return b
+	}
+
+	var ranking Ranking
+	for label, detail := range result.Labels {
+		b := nameBreakdown(label)
+		b.Values = valueScore(s.labelValueMap[label].Values)
+		detail.MatchScore = b.Total()
+		result.Labels[label] = detail
+		ranking.Labels = append(ranking.Labels, RankedItem{Name: label, Score: detail.MatchScore, Breakdown: b})
+	}
+
+	for metric, labels := range result.Metrics {
+		var b ScoreBreakdown
+		b.Name = s.names.bm25(metric, metricWords)
+		b.Help = helpWeight * s.help.bm25(metric, metricWords)
+		for _, t := range result.MetricTokens {
+			metrics := s.metricMap.Map[t.Token]
+			if _, ok := metrics[metric]; !ok {
+				continue
+			}
+			weight := t.Weight * idf(len(s.metricMap.AllNames), len(metrics))
+			if t.Token == strings.ToLower(metric) {
+				b.Name += weight
+			} else {
+				b.Synonyms += weight
+			}
+		}
+		b.Learned = learned[MatchMetric][metric]
+		for label, detail := range labels {
+			labelBreakdown := nameBreakdown(label)
+			values := valueScore(s.metricLabelMap[metric].Labels[label].Values)
+			detail.MatchScore = labelBreakdown.Total() + values
+			labels[label] = detail
+			b.Labels += labelBreakdown.Total()
+			b.Values += values
+		}
+		b.Semantic = result.MetricSimilarities[metric]
+		ranking.Metrics = append(ranking.Metrics, RankedItem{Name: metric, Score: b.Total(), Breakdown: b})
+	}
+
+	sortRanked(ranking.Metrics)
+	sortRanked(ranking.Labels)
+	return ranking
+}
+
+// learnedScores sums the weighted IDF of the learned synonym matches of each
+// metric and label, by the TokenMatch kind. The IDF is over MetricMap or
+// LabelMap, of the names each token was learned for.
+func (s *relevanceScorer) learnedScores(matches []TokenMatch) map[string]map[string]float64 {
+	df := make(map[string]int)
+	for _, m := range matches {
+		if m.Match == MatchLearned {
+			df[m.Kind+"\x00"+m.Token]++
+		}
+	}
+	scores := map[string]map[string]float64{MatchMetric: {}, MatchLabel: {}}
+	for _, m := range matches {
+		if m.Match != MatchLearned {
+			continue
+		}
+		n := len(s.metricMap.AllNames)
+		if m.Kind == MatchLabel {
+			n = len(s.labelMap.AllNames)
+		}
+		scores[m.Kind][m.Name] += m.Weight * idf(n, df[m.Kind+"\x00"+m.Token])
+	}
+	return scores
+}
+
+// sortRanked sorts items by descending score, then by name.
+func sortRanked(items []RankedItem) {
+	sort.Slice(items, func(i, j int) bool {
+		if items[i].Score != items[j].Score {
+			return items[i].Score > items[j].Score
+		}
+		return items[i].Name < items[j].Name
+	})
+}
@@ -0,0 +1,61 @@
+package query_processing
+
+import (
+	"context"
+	"log"
+
+	"github.com/prashantgupta17/nlpromql/llm"
+	"github.com/prashantgupta17/nlpromql/vectorindex"
+	"github.com/tmc/langchaingo/embeddings"
+)
+
+// Defaults for the semantic search of Pipeline.
+const (
+	DefaultSemanticTopK     = 5
+	DefaultSemanticMinScore = 0.3
+)
+
+// EmbeddingRetriever finds the metrics and labels whose embeddings are most
+// similar to the embedding of the query, and records their cosine
+// similarities. The metrics it finds have no labels of their own; a Merger
+// gives them the labels found.
+type EmbeddingRetriever struct {
+	Embedder embeddings.Embedder
+	Index    *vectorindex.Index
+	TopK     int     // Metrics and labels each; DefaultSemanticTopK when 0
+	MinScore float64 // Minimum cosine similarity; DefaultSemanticMinScore when 0
+}
+
+// Retrieve searches the index for matches.Query. Semantic search is best
+// effort: if the query cannot be embedded, the error is logged and the result
+// is empty.
+func (r *EmbeddingRetriever) Retrieve(ctx context.Context, matches PossibleMatches) (*RetrievalResult, error) {
+	result := NewRetrievalResult()
+	if r.Embedder == nil || r.Index == nil || r.Index.Len() == 0 {
+		return result, nil
+	}
+	vector, err := r.Embedder.EmbedQuery(ctx, matches.Query)
+	if err != nil {
+		log.Printf("Semantic retrieval skipped, error embedding query: %v\n", err)
+		return result, nil
+	}
+	topK, minScore := r.TopK, r.MinScore
+	if topK == 0 {
+		topK = DefaultSemanticTopK
+	}
+	if minScore == 0 {
+		minScore = DefaultSemanticMinScore
+	}
+
+	for _, match := range r.Index.Search(vectorindex.KindLabel, vector, topK, minScore) {
+		result.LabelSimilarities[match.Name] = match.Score
+		result.Labels[match.Name] = llm.LabelContextDetail{}
+		result.Matches = append(result.Matches, TokenMatch{Token: matches.Query, Match: MatchEmbedding, Weight: match.Score, Kind: MatchLabel, Name: match.Name})
+	}
+	for _, match := range r.Index.Search(vectorindex.KindMetric, vector, topK, minScore) {
+		result.MetricSimilarities[match.Name] = match.Score
+		result.addMetric(match.Name)
+		result.Matches = append(result.Matches, TokenMatch{Token: matches.Query, Match: MatchEmbedding, Weight: match.Score, Kind: MatchMetric, Name: match.Name})
+	}
+	return result, nil
+}
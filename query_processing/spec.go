@@ -0,0 +1,45 @@
+package query_processing
+
+import (
+	"log"
+
+	"github.com/prashantgupta17/nlpromql/llm"
+	"github.com/prashantgupta17/nlpromql/promql"
+)
+
+// compileSpecs sets the PromQL of every candidate of result that has a spec
+// to the compiled spec, checking names against p.MetricLabelMap. Candidates
+// whose spec does not compile are dropped, as are candidates with neither a
+// spec nor PromQL. Candidates with PromQL but no spec are kept as they are.
+func (p *Pipeline) compileSpecs(result *llm.PromQLResult) {
+	compiled := result.Candidates[:0]
+	for _, candidate := range result.Candidates {
+		if candidate.Spec == nil {
+			if candidate.PromQL != "" {
+				compiled = append(compiled, candidate)
+			}
+			continue
+		}
+		expr, err := promql.Compile(candidate.Spec, p.MetricLabelMap)
+		if err != nil {
+			log.Printf("Dropping candidate with invalid spec %+v: %v\n", *candidate.Spec, err)
+			continue
+		}
+		candidate.PromQL = expr.String()
+		if candidate.MetricLabelPairs == nil {
+			candidate.MetricLabelPairs = specLabelPairs(candidate.Spec)
+		}
+		compiled = append(compiled, candidate)
+	}
+	result.Candidates = compiled
+}
+
+// specLabelPairs returns the metric of spec and the values its matchers use,
+// in the form of PromQLCandidate.MetricLabelPairs.
+func specLabelPairs(spec *promql.QuerySpec) map[string]interface{} {
+	labels := make(map[string]interface{}, len(spec.Matchers))
+	for _, matcher := range spec.Matchers {
+		labels[matcher.Label] = matcher.Value
+	}
+	return map[string]interface{}{spec.Metric: labels}
+}
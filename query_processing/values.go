@@ -0,0 +1,281 @@
+package query_processing
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/prashantgupta17/nlpromql/info_structure"
+	"github.com/prashantgupta17/nlpromql/llm"
+	"github.com/prashantgupta17/nlpromql/matching"
+	"github.com/prashantgupta17/nlpromql/synonyms"
+)
+
+// DefaultValueLimit is how many values of each label are sent to PromQL
+// generation when Pipeline.ValueLimit is 0.
+const DefaultValueLimit = 5
+
+// minValueSimilarity is the trigram similarity to a query token below which
+// a value is ranked by frequency alone.
+const minValueSimilarity = 0.3
+
+// ParseValueLimits parses per-label value limits written as comma-separated
+// label=limit pairs, e.g. "instance=10,le=20".
+func ParseValueLimits(s string) (map[string]int, error) {
+	limits := make(map[string]int)
+	for _, pair := range strings.Split(s, ",") {
+		pair = strings.TrimSpace(pair)
+		if pair == "" {
+			continue
+		}
+		label, value, ok := strings.Cut(pair, "=")
+		if !ok || strings.TrimSpace(label) == "" {
+			return nil, fmt.Errorf("invalid value limit %q, expected label=limit", pair)
+		}
+		limit, err := strconv.Atoi(strings.TrimSpace(value))
+		if err != nil || limit < 0 {
+			return nil, fmt.Errorf("invalid value limit %q: the limit must be a non-negative integer", pair)
+		}
+		limits[strings.TrimSpace(label)] = limit
+	}
+	return limits, nil
+}
+
+// valueSelector picks the values of each retrieved label that are sent to
+// PromQL generation: first the values the user named, then the values most
+// similar to the query, then the values the most metrics have. The values of
+// each label are indexed once, so that a query only scores the values sharing
+// trigrams with it, however many values the label has.
+type valueSelector struct {
+	metricLabelMap info_structure.MetricLabelMap
+	labelValueMap  info_structure.LabelValueMap
+	indexes        map[string]*matching.Index     // Label -> its values
+	frequency      map[string]map[string]int      // Label -> value -> number of metrics with it
+	byFrequency    map[string][]string            // Label -> its values, most frequent first
+	metricValues   map[string]map[string][]string // Metric -> label -> its values, most frequent first
+	limit          int
+	limits         map[string]int // Per label, overriding limit
+}
+
+func newValueSelector(metricLabelMap info_structure.MetricLabelMap, labelValueMap info_structure.LabelValueMap,
+	limit int, limits map[string]int) *valueSelector {
+	if limit == 0 {
+		limit = DefaultValueLimit
+	}
+	frequency := make(map[string]map[string]int, len(labelValueMap))
+	for _, info := range metricLabelMap {
+		for label, labelInfo := range info.Labels {
+			counts := frequency[label]
+			if counts == nil {
+				counts = make(map[string]int, len(labelInfo.Values))
+				frequency[label] = counts
+			}
+			for value := range labelInfo.Values {
+				counts[value]++
+			}
+		}
+	}
+	s := &valueSelector{
+		metricLabelMap: metricLabelMap,
+		labelValueMap:  labelValueMap,
+		indexes:        make(map[string]*matching.Index, len(labelValueMap)),
+		frequency:      frequency,
+		byFrequency:    make(map[string][]string, len(labelValueMap)),
+		metricValues:   make(map[string]map[string][]string, len(metricLabelMap)),
+		limit:          limit,
+		limits:         limits,
+	}
+	for label, info := range labelValueMap {
+		values := s.sortByFrequency(label, info.Values)
+		s.indexes[label] = matching.NewIndex(values)
+		s.byFrequency[label] = values
+	}
+	for metric, info := range metricLabelMap {
+		labels := make(map[string][]string, len(info.Labels))
+		for label, labelInfo := range info.Labels {
+			labels[label] = s.sortByFrequency(label, labelInfo.Values)
+		}
+		s.metricValues[metric] = labels
+	}
+	return s
+}
+
+// sortByFrequency returns values of label sorted by how many metrics have
+// them, then by value.
+func (s *valueSelector) sortByFrequency(label string, values map[string]struct{}) []string {
+	sorted := make([]string, 0, len(values))
+	for value := range values {
+		sorted = append(sorted, value)
+	}
+	counts := s.frequency[label]
+	sort.Slice(sorted, func(i, j int) bool {
+		if counts[sorted[i]] != counts[sorted[j]] {
+			return counts[sorted[i]] > counts[sorted[j]]
+		}
+		return sorted[i] < sorted[j]
+	})
+	return sorted
+}
+
+// limitFor returns how many values of label are sent, not counting values
+// the user named.
+func (s *valueSelector) limitFor(label string) int {
+	if limit, ok := s.limits[label]; ok {
+		return limit
+	}
+	return s.limit
+}
+
+// rankedValue is a value of a label with what it is ranked by.
+type rankedValue struct {
+	value      string
+	named      bool
+	similarity float64
+	frequency  int
+}
+
+// queryValues holds what values are ranked against for one query.
+type queryValues struct {
+	tokens []string        // Value tokens and words of the query
+	named  map[string]bool // Values the user named, exactly or after normalization
+}
+
+// newQueryValues collects the value tokens of result and the words of the
+// query. Value tokens weighing at least matching.ScoreNormalized are values
+// the user named.
+func newQueryValues(matches PossibleMatches, result *RetrievalResult) queryValues {
+	q := queryValues{named: make(map[string]bool)}
+	seen := make(map[string]bool)
+	for _, t := range result.ValueTokens {
+		if t.Weight >= matching.ScoreNormalized {
+			q.named[t.Token] = true
+		}
+		seen[t.Token] = true
+		q.tokens = append(q.tokens, t.Token)
+	}
+	for _, word := range synonyms.SplitName(matches.Query) {
+		// Shorter words are mostly stop words, and share trigrams with too many values.
+		if len(word) >= 3 && !seen[word] {
+			seen[word] = true
+			q.tokens = append(q.tokens, word)
+		}
+	}
+	return q
+}
+
+// rank returns the values of label the user named or that are similar to a
+// token of q, best first. A value equal to a query token after normalization
+// counts as named. Only the values sharing the most trigrams with each token
+// are scored.
+func (s *valueSelector) rank(label string, q queryValues) []rankedValue {
+	values := s.labelValueMap[label].Values
+	ranked := make(map[string]*rankedValue)
+	get := func(value string) *rankedValue {
+		v, ok := ranked[value]
+		if !ok {
+			v = &rankedValue{value: value, frequency: s.frequency[label][value]}
+			ranked[value] = v
+		}
+		return v
+	}
+	for value := range q.named {
+		if _, ok := values[value]; ok {
+			get(value).named = true
+		}
+	}
+	if index := s.indexes[label]; index != nil {
+		for _, token := range q.tokens {
+			for value, similarity := range index.Similarities(token, minValueSimilarity) {
+				v := get(value)
+				v.similarity = max(v.similarity, similarity)
+				if similarity >= 1 {
+					v.named = true
+				}
+			}
+		}
+	}
+
+	sorted := make([]rankedValue, 0, len(ranked))
+	for _, v := range ranked {
+		sorted = append(sorted, *v)
+	}
+	sort.Slice(sorted, func(i, j int) bool {
+		a, b := sorted[i], sorted[j]
+		switch {
+		case a.named != b.named:
+			return a.named
+		case a.similarity != b.similarity:
+			return a.similarity > b.similarity
+		case a.frequency != b.frequency:
+			return a.frequency > b.frequency
+		}
+		return a.value < b.value
+	})
+	return sorted
+}
+
+// pick returns the values of ranked that are in values, or all of them if
+// values is nil: every named value, and the best of the rest up to limit.
+// When ranked has too few, the rest are filled in from byFrequency, the values
+// to choose from, most frequent first.
+func pick(ranked []rankedValue, byFrequency []string, values map[string]struct{}, limit int) []string {
+	picked := []string{}
+	seen := make(map[string]bool)
+	count := 0
+	for _, v := range ranked {
+		if values != nil {
+			if _, ok := values[v.value]; !ok {
+				continue
+			}
+		}
+		if !v.named {
+			if count >= limit {
+				break
+			}
+			count++
+		}
+		picked = append(picked, v.value)
+		seen[v.value] = true
+	}
+	for _, value := range byFrequency {
+		if count >= limit {
+			break
+		}
+		if !seen[value] {
+			picked = append(picked, value)
+			count++
+		}
+	}
+	return picked
+}
+
+// apply replaces the values of every label of relevantMetrics and
+// relevantLabels with those selected for the query. A label of a metric gets
+// the values the metric has.
+func (s *valueSelector) apply(q queryValues, relevantMetrics llm.RelevantMetricsMap, relevantLabels llm.RelevantLabelsMap) {
+	ranked := make(map[string][]rankedValue)
+	rankedFor := func(label string) []rankedValue {
+		if r, ok := ranked[label]; ok {
+			return r
+		}
+		r := s.rank(label, q)
+		ranked[label] = r
+		return r
+	}
+
+	for label, detail := range relevantLabels {
+		detail.Values = pick(rankedFor(label), s.byFrequency[label], nil, s.limitFor(label))
+		relevantLabels[label] = detail
+	}
+	for metric, labels := range relevantMetrics {
+		for label, detail := range labels {
+			values := s.metricLabelMap[metric].Labels[label].Values
+			if values == nil {
+				values = map[string]struct{}{}
+			}
+			detail.Values = pick(rankedFor(label), s.metricValues[metric][label], values, s.limitFor(label))
+			labels[label] = detail
+		}
+	}
+}
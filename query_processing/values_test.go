@@ -0,0 +1,36 @@
+package query_processing_test
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/prashantgupta17/nlpromql/query_processing"
+)
+
+func TestParseValueLimits(t *testing.T) {
+	tests := []struct {
+		name        string
+		input       string
+		expected    map[string]int
+		expectError bool
+	}{
+		{"Empty", "", map[string]int{}, false},
+		{"Pairs", "instance=10, le = 20", map[string]int{"instance": 10, "le": 20}, false},
+		{"Zero", "pod=0", map[string]int{"pod": 0}, false},
+		{"Missing limit", "instance", nil, true},
+		{"Missing label", "=3", nil, true},
+		{"Negative", "instance=-1", nil, true},
+		{"Not a number", "instance=ten", nil, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			limits, err := query_processing.ParseValueLimits(tt.input)
+			if (err != nil) != tt.expectError {
+				t.Fatalf("ParseValueLimits(%q) error = %v, expectError %v", tt.input, err, tt.expectError)
+			}
+			if !tt.expectError && !reflect.DeepEqual(limits, tt.expected) {
+				t.Errorf("ParseValueLimits(%q) = %v, expected %v", tt.input, limits, tt.expected)
+			}
+		})
+	}
+}
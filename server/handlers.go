@@ -1,15 +1,88 @@
 package server
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
 	"io"
 	"net/http"
 	"net/url"
+	"strings"
+	"sync"
 
+	"github.com/prashantgupta17/nlpromql/examples"
+	"github.com/prashantgupta17/nlpromql/info_structure"
+	"github.com/prashantgupta17/nlpromql/llm"
 	"github.com/prashantgupta17/nlpromql/query_processing"
 )
 
+// promQLResponse is the JSON body returned by /v1/promql with verbose=true,
+// and by the done event of /v1/promql/stream. PromQL lists the generated
+// queries in order, as /v1/promql returns them by default; the embedded
+// result adds their scores and any context dropped to fit the token budget.
+// Intent is the structured intent extracted from the query, if any, and
+// Ranking the retrieved metrics and labels with their relevance scores.
+// Explain is only set for requests with explain=true.
+type promQLResponse struct {
+	PromQL []string `json:"promql"`
+	*llm.PromQLResult
+	Intent  *llm.QueryIntent         `json:"intent,omitempty"`
+	Ranking query_processing.Ranking `json:"ranking"`
+	Usage   llm.UsageReport          `json:"usage"`
+	Explain *explanation             `json:"explain,omitempty"`
+}
+
+// explanation shows how a response was produced, to tell whether query
+// analysis, retrieval or generation went wrong: the possible matches
+// extracted by the LLM, how each retrieved metric and label was matched, and
+// the prompts sent to the LLM.
+type explanation struct {
+	PossibleMatches *llm.QueryAnalysis            `json:"possible_matches"`
+	Matches         []query_processing.TokenMatch `json:"matches"`
+	Prompts         []llm.PromptRecord            `json:"prompts"`
+}
+
+func newPromQLResponse(result *query_processing.Result, explain bool) promQLResponse {
+	response := promQLResponse{
+		PromQL:       result.PromQL.Queries(),
+		PromQLResult: result.PromQL,
+		Intent:       result.Intent,
+		Ranking:      result.Ranking,
+		Usage:        result.Usage,
+	}
+	if explain {
+		response.Explain = &explanation{
+			PossibleMatches: result.Analysis,
+			Matches:         result.Matches,
+			Prompts:         result.Prompts,
+		}
+	}
+	return response
+}
+
+// explainRequested reports whether r asks for an explanation of the response
+// with explain=true.
+func explainRequested(r *http.Request) bool {
+	return r.URL.Query().Get("explain") == "true"
+}
+
+// verboseRequested reports whether r asks /v1/promql for a promQLResponse
+// rather than the bare list of queries, with verbose=true or explain=true.
+func verboseRequested(r *http.Request) bool {
+	return r.URL.Query().Get("verbose") == "true" || explainRequested(r)
+}
+
+// requestContext returns the context for a PromQL request, applying the
+// per-request options given as URL parameters.
+func requestContext(r *http.Request) context.Context {
+	ctx := r.Context()
+	// no_cache=true forces fresh LLM calls for this request
+	if r.URL.Query().Get("no_cache") == "true" {
+		ctx = llm.WithCacheBypass(ctx)
+	}
+	return ctx
+}
+
 // handlePromQLQuery handles HTTP requests for PromQL queries.
 func (s *PromQLServer) handlePromQLQuery(w http.ResponseWriter, r *http.Request) {
 	// 1. Get User Query from Request
@@ -19,48 +92,181 @@ func (s *PromQLServer) handlePromQLQuery(w http.ResponseWriter, r *http.Request)
 		return
 	}
 
-	// 2. Process User Query
-	_, relevantMetrics, relevantLabels, relevantHistory, err := query_processing.ProcessUserQuery(
-		s.llmClient, userQuery, s.metricMap, s.labelMap,
-		s.metricLabelMap, s.labelValueMap, s.nlpToMetricMap,
-	)
+	// 2. Process User Query and Generate PromQL Options
+	result, err := s.pipeline.Run(requestContext(r), userQuery, nil)
 	if err != nil {
 		http.Error(w, fmt.Sprintf("Error processing query: %v", err), http.StatusInternalServerError)
 		return
 	}
 
-	// 3. Generate PromQL Options
-	promqlOptions, err := s.llmClient.GetPromQLFromLLM(userQuery, relevantMetrics, relevantLabels, relevantHistory)
+	// 3. Send JSON Response: the list of queries, unless the client asks for
+	// their scores, the ranking, usage and so on.
+	var response interface{} = result.PromQL.Queries()
+	if verboseRequested(r) {
+		response = newPromQLResponse(result, explainRequested(r))
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(response); err != nil {
+		http.Error(w, fmt.Sprintf("Error encoding response: %v", err), http.StatusInternalServerError)
+		return
+	}
+}
+
+// handlePromQLStream handles PromQL requests over Server-Sent Events. One event
+// is sent per pipeline stage (query_analysis, retrieval, candidates), the
+// generation response is streamed as generation events, and the stream ends
+// with a done event carrying the same body as /v1/promql?verbose=true, or an
+// error event.
+func (s *PromQLServer) handlePromQLStream(w http.ResponseWriter, r *http.Request) {
+	userQuery := r.URL.Query().Get("query")
+	if userQuery == "" {
+		http.Error(w, "Missing 'query' parameter", http.StatusBadRequest)
+		return
+	}
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "Streaming not supported", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+
+	var mu sync.Mutex
+	sendEvent := func(event string, data interface{}) {
+		payload, err := json.Marshal(data)
+		if err != nil {
+			payload, _ = json.Marshal(map[string]string{"error": err.Error()})
+		}
+		mu.Lock()
+		defer mu.Unlock()
+		fmt.Fprintf(w, "event: %s\ndata: %s\n\n", event, payload)
+		flusher.Flush()
+	}
+
+	result, err := s.pipeline.Run(requestContext(r), userQuery, sendEvent)
 	if err != nil {
-		http.Error(w, fmt.Sprintf("Error generating PromQL: %v", err), http.StatusInternalServerError)
+		sendEvent("error", map[string]string{"error": err.Error()})
 		return
 	}
+	sendEvent("done", newPromQLResponse(result, explainRequested(r)))
+}
 
-	// 4. Send JSON Response
-	response := promqlOptions
+// handleExamples lists the few-shot examples on GET and adds one on POST. The
+// POST body is a JSON object with "query" and "promql" fields.
+func (s *PromQLServer) handleExamples(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		w.Header().Set("Content-Type", "application/json")
+		if err := json.NewEncoder(w).Encode(s.examples.All()); err != nil {
+			http.Error(w, fmt.Sprintf("Error encoding response: %v", err), http.StatusInternalServerError)
+		}
+	case http.MethodPost:
+		var example examples.Example
+		if err := json.NewDecoder(r.Body).Decode(&example); err != nil {
+			http.Error(w, fmt.Sprintf("Error decoding example: %v", err), http.StatusBadRequest)
+			return
+		}
+		if strings.TrimSpace(example.Query) == "" || strings.TrimSpace(example.PromQL) == "" {
+			http.Error(w, "Example needs both 'query' and 'promql'", http.StatusBadRequest)
+			return
+		}
+		if err := s.examples.Add(example); err != nil {
+			http.Error(w, fmt.Sprintf("Error adding example: %v", err), http.StatusInternalServerError)
+			return
+		}
+		w.WriteHeader(http.StatusCreated)
+	default:
+		w.Header().Set("Allow", "GET, POST")
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
 
+// handleFeedback records a verdict on a generated query in the query history
+// on POST, and learns synonyms from an accepted one. The body is a JSON
+// object with "query", "promql" and "accepted" fields; the response lists the
+// history records and synonyms updated.
+func (s *PromQLServer) handleFeedback(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", "POST")
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	var feedback query_processing.Feedback
+	if err := json.NewDecoder(r.Body).Decode(&feedback); err != nil {
+		http.Error(w, fmt.Sprintf("Error decoding feedback: %v", err), http.StatusBadRequest)
+		return
+	}
+	if strings.TrimSpace(feedback.Query) == "" || strings.TrimSpace(feedback.PromQL) == "" {
+		http.Error(w, "Feedback needs both 'query' and 'promql'", http.StatusBadRequest)
+		return
+	}
+	result, err := s.pipeline.RecordFeedback(requestContext(r), feedback)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Error recording feedback: %v", err), http.StatusUnprocessableEntity)
+		return
+	}
 	w.Header().Set("Content-Type", "application/json")
-	if err := json.NewEncoder(w).Encode(response); err != nil {
+	if err := json.NewEncoder(w).Encode(result); err != nil {
 		http.Error(w, fmt.Sprintf("Error encoding response: %v", err), http.StatusInternalServerError)
-		return
+	}
+}
+
+// synonymRequest names a learned synonym to revert.
+type synonymRequest struct {
+	Kind  string `json:"kind"`
+	Token string `json:"token"`
+	Name  string `json:"name"`
+}
+
+// handleSynonyms lists the synonyms learned from feedback for review on GET,
+// and reverts one on POST. The POST body is a JSON object with the "kind"
+// ("metric" or "label"), "token" and "name" of the synonym.
+func (s *PromQLServer) handleSynonyms(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		w.Header().Set("Content-Type", "application/json")
+		if err := json.NewEncoder(w).Encode(map[string][]info_structure.LearnedSynonym{"synonyms": s.pipeline.Synonyms()}); err != nil {
+			http.Error(w, fmt.Sprintf("Error encoding response: %v", err), http.StatusInternalServerError)
+		}
+	case http.MethodPost:
+		var synonym synonymRequest
+		if err := json.NewDecoder(r.Body).Decode(&synonym); err != nil {
+			http.Error(w, fmt.Sprintf("Error decoding synonym: %v", err), http.StatusBadRequest)
+			return
+		}
+		if err := s.pipeline.RevertSynonym(synonym.Kind, synonym.Token, synonym.Name); err != nil {
+			http.Error(w, fmt.Sprintf("Error reverting synonym: %v", err), http.StatusNotFound)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		w.Header().Set("Allow", "GET, POST")
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
 	}
 }
 
 // handleReverseProxy forwards the request to another URL and returns the response.
 func (s *PromQLServer) handleReverseProxy(w http.ResponseWriter, r *http.Request) {
 	// The URL to which the request should be forwarded
-	targetURL := "http://localhost:9090/api/v1/query" + "?" + r.URL.RawQuery
-	revProxy(targetURL, w, r)
+	targetURL := s.prometheusURL + "/api/v1/query" + "?" + r.URL.RawQuery
+	s.revProxy(targetURL, w, r)
 }
 
 // handleReverseProxy forwards the request to another URL and returns the response.
 func (s *PromQLServer) handleLabelReverseProxy(w http.ResponseWriter, r *http.Request) {
 	// The URL to which the request should be forwarded
-	targetURL := "http://localhost:9090/api/v1/label/__name__/values"
-	revProxy(targetURL, w, r)
+	targetURL := s.prometheusURL + "/api/v1/label/__name__/values"
+	s.revProxy(targetURL, w, r)
 }
 
-func revProxy(targetURL string, w http.ResponseWriter, r *http.Request) {
+// revProxy forwards r to targetURL, on the configured Prometheus, with the
+// client's own headers, and copies back the response. The server's Prometheus
+// credentials replace the client's only when they were passed to
+// NewPromQLServer.
+func (s *PromQLServer) revProxy(targetURL string, w http.ResponseWriter, r *http.Request) {
 	url, err := url.Parse(targetURL)
 	if err != nil {
 		http.Error(w, "Error parsing target URL", http.StatusInternalServerError)
@@ -73,7 +279,10 @@ func revProxy(targetURL string, w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	proxyReq.Header = r.Header
+	proxyReq.Header = r.Header.Clone()
+	if s.prometheusUser != "" {
+		proxyReq.SetBasicAuth(s.prometheusUser, s.prometheusPassword)
+	}
 
 	httpClient := &http.Client{}
 	resp, err := httpClient.Do(proxyReq)
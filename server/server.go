@@ -3,38 +3,47 @@ package server
 import (
 	"fmt"
 	"net/http"
+	"strings"
 
-	"github.com/prashantgupta17/nlpromql/info_structure"
-	"github.com/prashantgupta17/nlpromql/llm"
+	"github.com/prashantgupta17/nlpromql/examples"
+	"github.com/prashantgupta17/nlpromql/query_processing"
 )
 
 type PromQLServer struct {
-	llmClient      llm.LLMClient
-	metricMap      info_structure.MetricMap
-	labelMap       info_structure.LabelMap
-	metricLabelMap info_structure.MetricLabelMap
-	labelValueMap  info_structure.LabelValueMap
-	nlpToMetricMap info_structure.NlpToMetricMap
+	pipeline      *query_processing.Pipeline
+	examples      *examples.Store
+	prometheusURL string // Base URL of the Prometheus that /v1/query and /v1/label/__name__/values proxy to
+	// Basic auth credentials added to proxied requests, which otherwise keep
+	// the client's own Authorization header. Empty unless the operator opts in
+	// with -proxy_prometheus_credentials.
+	prometheusUser     string
+	prometheusPassword string
 }
 
-func NewPromQLServer(llmClient llm.LLMClient, metricMap info_structure.MetricMap, labelMap info_structure.LabelMap,
-	metricLabelMap info_structure.MetricLabelMap, labelValueMap info_structure.LabelValueMap, nlpToMetricMap info_structure.NlpToMetricMap) *PromQLServer {
-
+func NewPromQLServer(pipeline *query_processing.Pipeline, exampleStore *examples.Store, prometheusURL, prometheusUser, prometheusPassword string) *PromQLServer {
 	return &PromQLServer{
-		llmClient:      llmClient,
-		metricMap:      metricMap,
-		labelMap:       labelMap,
-		metricLabelMap: metricLabelMap,
-		labelValueMap:  labelValueMap,
-		nlpToMetricMap: nlpToMetricMap,
+		pipeline:           pipeline,
+		examples:           exampleStore,
+		prometheusURL:      strings.TrimSuffix(prometheusURL, "/"),
+		prometheusUser:     prometheusUser,
+		prometheusPassword: prometheusPassword,
 	}
 }
 
-func (s *PromQLServer) Start(port string) error {
-	http.HandleFunc("/v1/promql", s.handlePromQLQuery)
-	http.HandleFunc("/v1/query", s.handleReverseProxy)
-	http.HandleFunc("/v1/label/__name__/values", s.handleLabelReverseProxy)
+// Handler returns the handler serving the server's endpoints.
+func (s *PromQLServer) Handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/v1/promql", s.handlePromQLQuery)
+	mux.HandleFunc("/v1/promql/stream", s.handlePromQLStream)
+	mux.HandleFunc("/v1/examples", s.handleExamples)
+	mux.HandleFunc("/v1/feedback", s.handleFeedback)
+	mux.HandleFunc("/v1/synonyms", s.handleSynonyms)
+	mux.HandleFunc("/v1/query", s.handleReverseProxy)
+	mux.HandleFunc("/v1/label/__name__/values", s.handleLabelReverseProxy)
+	return mux
+}
 
+func (s *PromQLServer) Start(port string) error {
 	fmt.Printf("Starting server on port %s...\n", port)
-	return http.ListenAndServe(":"+port, nil)
+	return http.ListenAndServe(":"+port, s.Handler())
 }
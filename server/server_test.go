@@ -0,0 +1,186 @@
+package server_test
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/prashantgupta17/nlpromql/llm"
+	"github.com/prashantgupta17/nlpromql/promtest"
+	"github.com/prashantgupta17/nlpromql/query_processing"
+	"github.com/prashantgupta17/nlpromql/server"
+)
+
+// MockLLMClient_ServerTest generates fixed candidates for every query.
+type MockLLMClient_ServerTest struct {
+	Candidates []llm.PromQLCandidate
+}
+
+func (m *MockLLMClient_ServerTest) GetMetricSynonyms(ctx context.Context, metricBatches []map[string]string) (map[string][]string, error) {
+	return map[string][]string{}, nil
+}
+
+func (m *MockLLMClient_ServerTest) GetLabelSynonyms(ctx context.Context, labelBatches [][]string) (map[string][]string, error) {
+	return map[string][]string{}, nil
+}
+
+func (m *MockLLMClient_ServerTest) ProcessUserQuery(ctx context.Context, userQuery string) (*llm.QueryAnalysis, error) {
+	return &llm.QueryAnalysis{MetricNames: []string{"requests"}}, nil
+}
+
+func (m *MockLLMClient_ServerTest) GetPromQLFromLLM(ctx context.Context, userQuery string, relevantMetrics llm.RelevantMetricsMap, relevantLabels llm.RelevantLabelsMap, relevantHistory map[string]interface{}, intent *llm.QueryIntent, metricScores llm.MetricScores) (*llm.PromQLResult, error) {
+	return &llm.PromQLResult{Candidates: m.Candidates}, nil
+}
+
+func (m *MockLLMClient_ServerTest) RepairPromQL(ctx context.Context, userQuery, promql, validationError string) (string, error) {
+	return promql, nil
+}
+
+func TestPromQLServer_ProxyAuth(t *testing.T) {
+	prometheus := promtest.NewServer(promtest.DefaultDataset(time.Now()))
+	defer prometheus.Close()
+	prometheus.Handler.Username, prometheus.Handler.Password = "user", "secret"
+
+	tests := []struct {
+		name       string
+		user       string // Credentials the server was given
+		password   string
+		clientUser string // Credentials the client sent
+		status     int
+	}{
+		{"Server credentials", "user", "secret", "", http.StatusOK},
+		{"Client credentials", "", "", "user", http.StatusOK},
+		{"No credentials", "", "", "", http.StatusUnauthorized},
+		{"Wrong server credentials", "user", "wrong", "", http.StatusUnauthorized},
+		{"Server credentials replace the client's", "user", "wrong", "user", http.StatusUnauthorized},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			api := httptest.NewServer(server.NewPromQLServer(nil, nil, prometheus.URL, tt.user, tt.password).Handler())
+			defer api.Close()
+			for _, path := range []string{"/v1/label/__name__/values", "/v1/query?query=up"} {
+				req, err := http.NewRequest(http.MethodGet, api.URL+path, nil)
+				if err != nil {
+					t.Fatalf("creating GET %s: %v", path, err)
+				}
+				if tt.clientUser != "" {
+					req.SetBasicAuth(tt.clientUser, "secret")
+				}
+				resp, err := http.DefaultClient.Do(req)
+				if err != nil {
+					t.Fatalf("GET %s: %v", path, err)
+				}
+				resp.Body.Close()
+				if resp.StatusCode != tt.status {
+					t.Errorf("GET %s: status %d, expected %d", path, resp.StatusCode, tt.status)
+				}
+			}
+		})
+	}
+}
+
+func TestPromQLServer_PromQL(t *testing.T) {
+	client := &MockLLMClient_ServerTest{Candidates: []llm.PromQLCandidate{
+		{PromQL: "sum by (job) (rate(http_requests_total[5m]))", Score: 0.9},
+		{PromQL: "rate(http_requests_total[5m])", Score: 0.4},
+	}}
+	pipeline := &query_processing.Pipeline{LLMClient: client}
+	api := httptest.NewServer(server.NewPromQLServer(pipeline, nil, "http://localhost:9090", "", "").Handler())
+	defer api.Close()
+	queries := []string{"sum by (job) (rate(http_requests_total[5m]))", "rate(http_requests_total[5m])"}
+
+	get := func(params url.Values, body interface{}) {
+		t.Helper()
+		resp, err := http.Get(api.URL + "/v1/promql?" + params.Encode())
+		if err != nil {
+			t.Fatalf("GET /v1/promql: %v", err)
+		}
+		defer resp.Body.Close()
+		if resp.StatusCode != http.StatusOK {
+			t.Fatalf("status %d, expected %d", resp.StatusCode, http.StatusOK)
+		}
+		if err := json.NewDecoder(resp.Body).Decode(body); err != nil {
+			t.Fatalf("decoding the response to %v: %v", params, err)
+		}
+	}
+
+	// By default the response is the bare list of queries.
+	var list []string
+	get(url.Values{"query": {"requests per job"}}, &list)
+	if !reflect.DeepEqual(list, queries) {
+		t.Errorf("response %q, expected %q", list, queries)
+	}
+
+	for _, params := range []url.Values{
+		{"query": {"requests per job"}, "verbose": {"true"}},
+		{"query": {"requests per job"}, "explain": {"true"}},
+	} {
+		var verbose struct {
+			PromQL     []string              `json:"promql"`
+			Candidates []llm.PromQLCandidate `json:"candidates"`
+			Usage      *llm.UsageReport      `json:"usage"`
+			Explain    json.RawMessage       `json:"explain"`
+		}
+		get(params, &verbose)
+		if !reflect.DeepEqual(verbose.PromQL, queries) || !reflect.DeepEqual(verbose.Candidates, client.Candidates) || verbose.Usage == nil {
+			t.Errorf("unexpected verbose response to %v: %+v", params, verbose)
+		}
+		if explain := params.Get("explain") == "true"; explain != (verbose.Explain != nil) {
+			t.Errorf("expected an explanation only with explain=true, got %s for %v", verbose.Explain, params)
+		}
+	}
+}
+
+func TestPromQLServer_Proxies(t *testing.T) {
+	prometheus := promtest.NewServer(promtest.DefaultDataset(time.Unix(1700000000, 0)))
+	defer prometheus.Close()
+	api := httptest.NewServer(server.NewPromQLServer(nil, nil, prometheus.URL+"/", "", "").Handler())
+	defer api.Close()
+
+	tests := []struct {
+		name     string
+		path     string
+		status   int
+		expected string
+	}{
+		{"Metric names", "/v1/label/__name__/values", http.StatusOK,
+			`{"status":"success","data":["http_requests_total","node_memory_MemAvailable_bytes","node_memory_MemTotal_bytes","up"]}`},
+		{"Query", "/v1/query?" + url.Values{"query": {"sum(up)"}, "time": {"1700000000"}}.Encode(), http.StatusOK,
+			`{"status":"success","data":{"resultType":"vector","result":[{"metric":{},"value":[1700000000,"1"]}]}}`},
+		{"Query error", "/v1/query?" + url.Values{"query": {"sum("}}.Encode(), http.StatusBadRequest, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			resp, err := http.Get(api.URL + tt.path)
+			if err != nil {
+				t.Fatalf("GET %s: %v", tt.path, err)
+			}
+			defer resp.Body.Close()
+			if resp.StatusCode != tt.status {
+				t.Errorf("status %d, expected %d", resp.StatusCode, tt.status)
+			}
+			var got map[string]interface{}
+			if err := json.NewDecoder(resp.Body).Decode(&got); err != nil {
+				t.Fatalf("decoding the response: %v", err)
+			}
+			if tt.expected == "" {
+				if got["errorType"] != "bad_data" {
+					t.Errorf("expected the Prometheus error to be passed on, got %v", got)
+				}
+				return
+			}
+			var expected map[string]interface{}
+			if err := json.Unmarshal([]byte(tt.expected), &expected); err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(got, expected) {
+				t.Errorf("response %v, expected %v", got, expected)
+			}
+		})
+	}
+}
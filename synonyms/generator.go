@@ -0,0 +1,186 @@
+// Package synonyms generates synonyms for metric and label names from the
+// names themselves, so that the information structure can be built without
+// an LLM.
+package synonyms
+
+import (
+	"context"
+	"strings"
+	"unicode"
+
+	"github.com/prashantgupta17/nlpromql/llm"
+)
+
+// DefaultAbbreviations expands abbreviations common in metric and label names.
+var DefaultAbbreviations = map[string][]string{
+	"addr":   {"address"},
+	"alloc":  {"allocated", "allocation"},
+	"avail":  {"available"},
+	"avg":    {"average"},
+	"cnt":    {"count"},
+	"config": {"configuration"},
+	"conn":   {"connection"},
+	"conns":  {"connections"},
+	"cpu":    {"processor"},
+	"db":     {"database"},
+	"dir":    {"directory"},
+	"err":    {"error"},
+	"errs":   {"errors"},
+	"fs":     {"filesystem"},
+	"gc":     {"garbage", "collection"},
+	"io":     {"input", "output"},
+	"iops":   {"operations"},
+	"k8s":    {"kubernetes"},
+	"le":     {"bucket", "bound"},
+	"max":    {"maximum"},
+	"mem":    {"memory"},
+	"min":    {"minimum"},
+	"msg":    {"message"},
+	"msgs":   {"messages"},
+	"net":    {"network"},
+	"ns":     {"namespace"},
+	"num":    {"number"},
+	"ops":    {"operations"},
+	"pct":    {"percent", "percentage"},
+	"pkts":   {"packets"},
+	"proc":   {"process"},
+	"repl":   {"replication"},
+	"req":    {"request"},
+	"reqs":   {"requests"},
+	"resp":   {"response"},
+	"rx":     {"receive", "received"},
+	"sec":    {"seconds"},
+	"svc":    {"service"},
+	"sys":    {"system"},
+	"temp":   {"temperature"},
+	"tx":     {"transmit", "transmitted"},
+	"usr":    {"user"},
+	"util":   {"utilization"},
+	"vm":     {"virtual", "machine"},
+}
+
+// DefaultSuffixes maps the unit and type suffixes of Prometheus metric names
+// to the words a user would use for them.
+var DefaultSuffixes = map[string][]string{
+	"total":   {"count", "counter"},
+	"count":   {"number"},
+	"sum":     {"total"},
+	"bucket":  {"histogram", "distribution"},
+	"seconds": {"duration", "time", "latency"},
+	"bytes":   {"size"},
+	"ratio":   {"percent", "percentage", "fraction"},
+	"percent": {"percentage", "ratio"},
+	"info":    {"information", "metadata"},
+	"created": {"creation", "timestamp"},
+	"celsius": {"temperature"},
+	"joules":  {"energy"},
+	"volts":   {"voltage"},
+	"amperes": {"current"},
+	"hertz":   {"frequency"},
+	"meters":  {"distance"},
+	"grams":   {"weight"},
+	"watts":   {"power"},
+}
+
+// Generator generates synonyms by splitting names on separators and camel
+// case, expanding abbreviations and mapping Prometheus suffixes to words. It
+// implements llm.SynonymGenerator.
+type Generator struct {
+	Abbreviations map[string][]string
+	Suffixes      map[string][]string // Applied to the last word of metric names only
+}
+
+// NewGenerator returns a Generator using DefaultAbbreviations and DefaultSuffixes.
+func NewGenerator() *Generator {
+	return &Generator{Abbreviations: DefaultAbbreviations, Suffixes: DefaultSuffixes}
+}
+
+// GetMetricSynonyms returns the synonyms of every metric in metricBatches.
+// Descriptions are not used.
+func (g *Generator) GetMetricSynonyms(ctx context.Context, metricBatches []map[string]string) (map[string][]string, error) {
+	result := make(map[string][]string)
+	for _, batch := range metricBatches {
+		for metric := range batch {
+			result[metric] = g.Synonyms(metric, true)
+		}
+	}
+	return result, nil
+}
+
+// GetLabelSynonyms returns the synonyms of every label in labelBatches.
+func (g *Generator) GetLabelSynonyms(ctx context.Context, labelBatches [][]string) (map[string][]string, error) {
+	result := make(map[string][]string)
+	for _, batch := range labelBatches {
+		for _, label := range batch {
+			result[label] = g.Synonyms(label, false)
+		}
+	}
+	return result, nil
+}
+
+// Synonyms returns the lower-case words of name and their expansions, without
+// duplicates. If metric is true, the last word is also mapped through
+// Suffixes. The name itself is not included.
+func (g *Generator) Synonyms(name string, metric bool) []string {
+	words := SplitName(name)
+	seen := map[string]bool{strings.ToLower(name): true}
+	var synonyms []string
+	add := func(words ...string) {
+		for _, word := range words {
+			if !seen[word] {
+				seen[word] = true
+				synonyms = append(synonyms, word)
+			}
+		}
+	}
+
+	for i, word := range words {
+		add(word)
+		if singular := singularize(word); singular != word {
+			add(singular)
+		}
+		add(g.Abbreviations[word]...)
+		if metric && i == len(words)-1 {
+			add(g.Suffixes[word]...)
+		}
+	}
+	return synonyms
+}
+
+// SplitName splits a metric or label name into lower-case words at
+// non-alphanumeric characters and camel case boundaries, e.g.
+// "node_memory_MemAvailable_bytes" into node, memory, mem, available, bytes.
+func SplitName(name string) []string {
+	var words []string
+	for _, part := range strings.FieldsFunc(name, func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	}) {
+		runes := []rune(part)
+		start := 0
+		for i := 1; i < len(runes); i++ {
+			prev, cur := runes[i-1], runes[i]
+			// Split before an upper-case letter that follows a lower-case one
+			// ("memAvailable") or that starts a word after an acronym ("HTTPRequests").
+			lowerToUpper := unicode.IsLower(prev) && unicode.IsUpper(cur)
+			acronymEnd := unicode.IsUpper(prev) && unicode.IsUpper(cur) && i+1 < len(runes) && unicode.IsLower(runes[i+1])
+			if lowerToUpper || acronymEnd {
+				words = append(words, strings.ToLower(string(runes[start:i])))
+				start = i
+			}
+		}
+		words = append(words, strings.ToLower(string(runes[start:])))
+	}
+	return words
+}
+
+// singularize strips a plural "s", e.g. "requests" to "request". Short words
+// and words ending in "ss" or "us" are returned unchanged.
+func singularize(word string) string {
+	if len(word) <= 3 || !strings.HasSuffix(word, "s") || strings.HasSuffix(word, "ss") || strings.HasSuffix(word, "us") {
+		return word
+	}
+	return strings.TrimSuffix(word, "s")
+}
+
+// Ensure Generator implements the llm.SynonymGenerator interface.
+var _ llm.SynonymGenerator = (*Generator)(nil)
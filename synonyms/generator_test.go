@@ -0,0 +1,69 @@
+package synonyms_test
+
+import (
+	"context"
+	"reflect"
+	"testing"
+
+	"github.com/prashantgupta17/nlpromql/synonyms"
+)
+
+func TestSplitName(t *testing.T) {
+	tests := []struct {
+		name     string
+		expected []string
+	}{
+		{"http_requests_total", []string{"http", "requests", "total"}},
+		{"node_memory_MemAvailable_bytes", []string{"node", "memory", "mem", "available", "bytes"}},
+		{"HTTPRequestDuration", []string{"http", "request", "duration"}},
+		{"kube_pod_container_status_restarts:rate5m", []string{"kube", "pod", "container", "status", "restarts", "rate5m"}},
+		{"__name__", []string{"name"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := synonyms.SplitName(tt.name); !reflect.DeepEqual(got, tt.expected) {
+				t.Errorf("SplitName(%q) = %v, expected %v", tt.name, got, tt.expected)
+			}
+		})
+	}
+}
+
+func TestGenerator(t *testing.T) {
+	generator := synonyms.NewGenerator()
+
+	metricSynonyms, err := generator.GetMetricSynonyms(context.Background(), []map[string]string{
+		{"node_cpu_seconds_total": "Seconds the CPUs spent in each mode."},
+		{"container_fs_reads_bytes_total": "", "process_resident_memory_bytes": ""},
+	})
+	if err != nil {
+		t.Fatalf("GetMetricSynonyms returned an unexpected error: %v", err)
+	}
+	labelSynonyms, err := generator.GetLabelSynonyms(context.Background(), [][]string{{"ns", "err_code"}})
+	if err != nil {
+		t.Fatalf("GetLabelSynonyms returned an unexpected error: %v", err)
+	}
+
+	tests := []struct {
+		name     string
+		got      []string
+		expected []string
+	}{
+		{"Abbreviation and suffix", metricSynonyms["node_cpu_seconds_total"],
+			[]string{"node", "cpu", "processor", "seconds", "second", "total", "count", "counter"}},
+		{"Suffix only on the last word", metricSynonyms["container_fs_reads_bytes_total"],
+			[]string{"container", "fs", "filesystem", "reads", "read", "bytes", "byte", "total", "count", "counter"}},
+		{"Unit suffix", metricSynonyms["process_resident_memory_bytes"],
+			[]string{"process", "resident", "memory", "bytes", "byte", "size"}},
+		{"Label abbreviation", labelSynonyms["ns"], []string{"namespace"}},
+		{"Label without suffixes", labelSynonyms["err_code"], []string{"err", "error", "code"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if !reflect.DeepEqual(tt.got, tt.expected) {
+				t.Errorf("got %v, expected %v", tt.got, tt.expected)
+			}
+		})
+	}
+}
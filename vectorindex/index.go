@@ -0,0 +1,223 @@
+// Package vectorindex is a small in-process vector index used to find metrics
+// and labels semantically similar to a user query.
+package vectorindex
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+)
+
+// Kind is the kind of item an entry describes.
+type Kind string
+
+const (
+	KindMetric Kind = "metric"
+	KindLabel  Kind = "label"
+)
+
+// Entry is an embedded metric or label.
+type Entry struct {
+	Kind   Kind      `json:"kind"`
+	Name   string    `json:"name"`
+	Text   string    `json:"text"`   // The text that was embedded, e.g. the metric name and HELP text
+	Vector []float32 `json:"vector"` // Normalized to unit length
+}
+
+// Match is an entry found by Search with its cosine similarity to the query.
+type Match struct {
+	Kind  Kind
+	Name  string
+	Score float64
+}
+
+// Index holds the embeddings of metrics and labels. It is safe for concurrent use.
+type Index struct {
+	mu sync.RWMutex
+	// model is the embedding model the vectors were computed with. Vectors of
+	// different models cannot be compared.
+	model   string
+	entries map[Kind]map[string]Entry
+}
+
+type indexJSON struct {
+	Model   string  `json:"model"`
+	Entries []Entry `json:"entries"`
+}
+
+// New returns an empty index for vectors computed with model.
+func New(model string) *Index {
+	return &Index{model: model, entries: make(map[Kind]map[string]Entry)}
+}
+
+// Load reads an index saved by Save. A missing file yields an empty index
+// with no model.
+func Load(path string) (*Index, error) {
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return New(""), nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("error reading vector index: %v", err)
+	}
+	var stored indexJSON
+	if err := json.Unmarshal(data, &stored); err != nil {
+		return nil, fmt.Errorf("error decoding vector index %s: %v", path, err)
+	}
+	index := New(stored.Model)
+	index.Add(stored.Entries...)
+	return index, nil
+}
+
+// Save writes the index to path as JSON, replacing the file atomically.
+func (ix *Index) Save(path string) error {
+	ix.mu.RLock()
+	stored := indexJSON{Model: ix.model, Entries: make([]Entry, 0, ix.lenLocked())}
+	for _, kind := range []Kind{KindMetric, KindLabel} {
+		names := make([]string, 0, len(ix.entries[kind]))
+		for name := range ix.entries[kind] {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			stored.Entries = append(stored.Entries, ix.entries[kind][name])
+		}
+	}
+	ix.mu.RUnlock()
+
+	data, err := json.Marshal(stored)
+	if err != nil {
+		return fmt.Errorf("error encoding vector index: %v", err)
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(path), ".vector_index-*")
+	if err != nil {
+		return fmt.Errorf("error saving vector index: %v", err)
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return fmt.Errorf("error saving vector index: %v", err)
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("error saving vector index: %v", err)
+	}
+	if err := os.Rename(tmp.Name(), path); err != nil {
+		return fmt.Errorf("error saving vector index: %v", err)
+	}
+	return nil
+}
+
+// Model returns the embedding model of the index.
+func (ix *Index) Model() string {
+	ix.mu.RLock()
+	defer ix.mu.RUnlock()
+	return ix.model
+}
+
+// Reset removes every entry and sets the model, for when the vectors must be
+// recomputed with a different embedding model.
+func (ix *Index) Reset(model string) {
+	ix.mu.Lock()
+	defer ix.mu.Unlock()
+	ix.model = model
+	ix.entries = make(map[Kind]map[string]Entry)
+}
+
+// Len returns the number of entries.
+func (ix *Index) Len() int {
+	ix.mu.RLock()
+	defer ix.mu.RUnlock()
+	return ix.lenLocked()
+}
+
+func (ix *Index) lenLocked() int {
+	n := 0
+	for _, entries := range ix.entries {
+		n += len(entries)
+	}
+	return n
+}
+
+// Has reports whether the index has an entry for name of the given kind.
+func (ix *Index) Has(kind Kind, name string) bool {
+	ix.mu.RLock()
+	defer ix.mu.RUnlock()
+	_, ok := ix.entries[kind][name]
+	return ok
+}
+
+// Add adds entries to the index, replacing existing entries of the same kind
+// and name. Vectors are normalized; zero vectors are ignored.
+func (ix *Index) Add(entries ...Entry) {
+	ix.mu.Lock()
+	defer ix.mu.Unlock()
+	for _, entry := range entries {
+		vector, ok := normalize(entry.Vector)
+		if !ok {
+			continue
+		}
+		entry.Vector = vector
+		if ix.entries[entry.Kind] == nil {
+			ix.entries[entry.Kind] = make(map[string]Entry)
+		}
+		ix.entries[entry.Kind][entry.Name] = entry
+	}
+}
+
+// Search returns up to k entries of the given kind most similar to vector,
+// best first, leaving out those with a cosine similarity below minScore.
+func (ix *Index) Search(kind Kind, vector []float32, k int, minScore float64) []Match {
+	query, ok := normalize(vector)
+	if !ok || k <= 0 {
+		return nil
+	}
+	ix.mu.RLock()
+	defer ix.mu.RUnlock()
+
+	var matches []Match
+	for name, entry := range ix.entries[kind] {
+		if len(entry.Vector) != len(query) {
+			continue
+		}
+		score := dot(entry.Vector, query)
+		if score < minScore {
+			continue
+		}
+		matches = append(matches, Match{Kind: kind, Name: name, Score: score})
+	}
+	sort.Slice(matches, func(i, j int) bool {
+		if matches[i].Score != matches[j].Score {
+			return matches[i].Score > matches[j].Score
+		}
+		return matches[i].Name < matches[j].Name
+	})
+	if len(matches) > k {
+		matches = matches[:k]
+	}
+	return matches
+}
+
+func dot(a, b []float32) float64 {
+	var sum float64
+	for i := range a {
+		sum += float64(a[i]) * float64(b[i])
+	}
+	return sum
+}
+
+// normalize returns vector scaled to unit length, or false if it is zero.
+func normalize(vector []float32) ([]float32, bool) {
+	norm := math.Sqrt(dot(vector, vector))
+	if norm == 0 {
+		return nil, false
+	}
+	normalized := make([]float32, len(vector))
+	for i, v := range vector {
+		normalized[i] = float32(float64(v) / norm)
+	}
+	return normalized, true
+}
@@ -0,0 +1,81 @@
+package vectorindex_test
+
+import (
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/prashantgupta17/nlpromql/vectorindex"
+)
+
+func TestIndex_Search(t *testing.T) {
+	index := vectorindex.New("test-model")
+	index.Add(
+		vectorindex.Entry{Kind: vectorindex.KindMetric, Name: "node_memory_bytes", Vector: []float32{1, 0, 0}},
+		vectorindex.Entry{Kind: vectorindex.KindMetric, Name: "node_cpu_seconds_total", Vector: []float32{0, 2, 0}},
+		vectorindex.Entry{Kind: vectorindex.KindMetric, Name: "process_memory_bytes", Vector: []float32{3, 1, 0}},
+		vectorindex.Entry{Kind: vectorindex.KindLabel, Name: "mode", Vector: []float32{1, 0, 0}},
+		vectorindex.Entry{Kind: vectorindex.KindMetric, Name: "zero", Vector: []float32{0, 0, 0}},
+	)
+
+	tests := []struct {
+		name     string
+		kind     vectorindex.Kind
+		vector   []float32
+		k        int
+		minScore float64
+		expected []string
+	}{
+		{"Ranked by cosine similarity", vectorindex.KindMetric, []float32{5, 0, 0}, 3, 0, []string{"node_memory_bytes", "process_memory_bytes", "node_cpu_seconds_total"}},
+		{"Top k", vectorindex.KindMetric, []float32{0, 1, 0}, 1, 0, []string{"node_cpu_seconds_total"}},
+		{"Minimum score", vectorindex.KindMetric, []float32{1, 0, 0}, 3, 0.5, []string{"node_memory_bytes", "process_memory_bytes"}},
+		{"Kinds are separate", vectorindex.KindLabel, []float32{0, 1, 0}, 3, -1, []string{"mode"}},
+		{"Dimension mismatch", vectorindex.KindMetric, []float32{1, 0}, 3, -1, nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var names []string
+			for _, match := range index.Search(tt.kind, tt.vector, tt.k, tt.minScore) {
+				names = append(names, match.Name)
+			}
+			if !reflect.DeepEqual(names, tt.expected) {
+				t.Errorf("Search() = %v, expected %v", names, tt.expected)
+			}
+		})
+	}
+
+	if index.Len() != 4 {
+		t.Errorf("expected the zero vector to be ignored, got %d entries", index.Len())
+	}
+}
+
+func TestIndex_SaveLoad(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "vector_index.json")
+
+	missing, err := vectorindex.Load(path)
+	if err != nil {
+		t.Fatalf("Load of a missing file returned an unexpected error: %v", err)
+	}
+	if missing.Len() != 0 || missing.Model() != "" {
+		t.Errorf("expected an empty index without model, got %d entries and model %q", missing.Len(), missing.Model())
+	}
+
+	index := vectorindex.New("test-model")
+	index.Add(vectorindex.Entry{Kind: vectorindex.KindLabel, Name: "job", Text: "job", Vector: []float32{0, 3, 4}})
+	if err := index.Save(path); err != nil {
+		t.Fatalf("Save returned an unexpected error: %v", err)
+	}
+
+	loaded, err := vectorindex.Load(path)
+	if err != nil {
+		t.Fatalf("Load returned an unexpected error: %v", err)
+	}
+	if loaded.Model() != "test-model" || !loaded.Has(vectorindex.KindLabel, "job") {
+		t.Errorf("loaded index does not match the saved one: model %q, %d entries", loaded.Model(), loaded.Len())
+	}
+	matches := loaded.Search(vectorindex.KindLabel, []float32{0, 3, 4}, 1, 0)
+	if len(matches) != 1 || matches[0].Score < 0.999 {
+		t.Errorf("expected the saved vector to match itself, got %v", matches)
+	}
+}